@@ -0,0 +1,162 @@
+package authorizer
+
+import (
+	"errors"
+	"strings"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/gofrs/uuid"
+)
+
+// Pattern types as numbered by the Kafka protocol. MATCH and ANY only appear
+// in filters.
+const PATTERN_UNKNOWN int8 = 0
+const PATTERN_ANY int8 = 1
+const PATTERN_MATCH int8 = 2
+const PATTERN_LITERAL int8 = 3
+const PATTERN_PREFIXED int8 = 4
+
+// Permission types as numbered by the Kafka protocol
+const PERMISSION_UNKNOWN int8 = 0
+const PERMISSION_ANY int8 = 1
+const PERMISSION_DENY int8 = 2
+const PERMISSION_ALLOW int8 = 3
+
+const WILDCARD_RESOURCE = "*"
+const WILDCARD_PRINCIPAL = "User:*"
+const WILDCARD_HOST = "*"
+
+var ErrInvalidAcl = errors.New("invalid ACL")
+
+// AclBinding allows or denies an operation on the resources matching a
+// pattern to a principal connecting from a host.
+type AclBinding struct {
+	ResourceType   int8
+	ResourceName   string
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+// AclBindingFilter selects ACLs. Nil fields and the ANY values match
+// everything, and PATTERN_MATCH matches the patterns that apply to the
+// resource name.
+type AclBindingFilter struct {
+	ResourceType   int8
+	ResourceName   *string
+	PatternType    int8
+	Principal      *string
+	Host           *string
+	Operation      int8
+	PermissionType int8
+}
+
+func BindingFromRecord(r *metadata.AccessControlEntryRecord) AclBinding {
+	return AclBinding{
+		ResourceType:   r.ResourceType,
+		ResourceName:   r.ResourceName,
+		PatternType:    r.PatternType,
+		Principal:      r.Principal,
+		Host:           r.Host,
+		Operation:      r.Operation,
+		PermissionType: r.PermissionType,
+	}
+}
+
+func (b AclBinding) Record(id uuid.UUID) *metadata.AccessControlEntryRecord {
+	return &metadata.AccessControlEntryRecord{
+		ID:             id,
+		ResourceType:   b.ResourceType,
+		ResourceName:   b.ResourceName,
+		PatternType:    b.PatternType,
+		Principal:      b.Principal,
+		Host:           b.Host,
+		Operation:      b.Operation,
+		PermissionType: b.PermissionType,
+	}
+}
+
+// Validate checks that a binding names one concrete resource pattern,
+// principal, operation and permission.
+func (b AclBinding) Validate() error {
+	if _, ok := RESOURCE_OPERATIONS[b.ResourceType]; !ok {
+		return ErrInvalidAcl
+	}
+	if b.PatternType != PATTERN_LITERAL && b.PatternType != PATTERN_PREFIXED {
+		return ErrInvalidAcl
+	}
+	if b.ResourceName == "" || (b.ResourceType == RESOURCE_CLUSTER && b.ResourceName != CLUSTER_NAME) {
+		return ErrInvalidAcl
+	}
+	if principalType, name, ok := strings.Cut(b.Principal, ":"); !ok || principalType == "" || name == "" {
+		return ErrInvalidAcl
+	}
+	if b.Host == "" {
+		return ErrInvalidAcl
+	}
+	if b.Operation <= OPERATION_ANY || b.Operation > OPERATION_DESCRIBE_TOKENS {
+		return ErrInvalidAcl
+	}
+	if b.PermissionType != PERMISSION_ALLOW && b.PermissionType != PERMISSION_DENY {
+		return ErrInvalidAcl
+	}
+	return nil
+}
+
+// Validate rejects filters with unknown values, which could match nothing.
+func (f AclBindingFilter) Validate() error {
+	if f.ResourceType == RESOURCE_UNKNOWN || f.PatternType == PATTERN_UNKNOWN ||
+		f.Operation == OPERATION_UNKNOWN || f.PermissionType == PERMISSION_UNKNOWN {
+		return ErrInvalidAcl
+	}
+	return nil
+}
+
+func (f AclBindingFilter) matchesPattern(b AclBinding) bool {
+	if f.ResourceType != RESOURCE_ANY && f.ResourceType != b.ResourceType {
+		return false
+	}
+	if f.PatternType != PATTERN_ANY && f.PatternType != PATTERN_MATCH && f.PatternType != b.PatternType {
+		return false
+	}
+	if f.ResourceName == nil {
+		return true
+	}
+	if f.PatternType != PATTERN_MATCH {
+		return *f.ResourceName == b.ResourceName
+	}
+	return patternMatches(b, *f.ResourceName)
+}
+
+func (f AclBindingFilter) Matches(b AclBinding) bool {
+	return f.matchesPattern(b) &&
+		(f.Principal == nil || *f.Principal == b.Principal) &&
+		(f.Host == nil || *f.Host == b.Host) &&
+		(f.Operation == OPERATION_ANY || f.Operation == b.Operation) &&
+		(f.PermissionType == PERMISSION_ANY || f.PermissionType == b.PermissionType)
+}
+
+// patternMatches reports whether a binding's resource pattern applies to a
+// resource name.
+func patternMatches(b AclBinding, resourceName string) bool {
+	switch b.PatternType {
+	case PATTERN_LITERAL:
+		return b.ResourceName == resourceName || b.ResourceName == WILDCARD_RESOURCE
+	case PATTERN_PREFIXED:
+		return strings.HasPrefix(resourceName, b.ResourceName)
+	}
+	return false
+}
+
+// MatchingAcls returns the ACLs in the image that match a filter, by id.
+func MatchingAcls(image *metadata.MetadataImage, filter AclBindingFilter) map[uuid.UUID]AclBinding {
+	matching := map[uuid.UUID]AclBinding{}
+	for id, entry := range image.AccessControlEntries {
+		if binding := BindingFromRecord(entry); filter.Matches(binding) {
+			matching[id] = binding
+		}
+	}
+	return matching
+}
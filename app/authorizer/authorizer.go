@@ -0,0 +1,99 @@
+package authorizer
+
+// Resource types as numbered by the Kafka protocol
+const RESOURCE_UNKNOWN int8 = 0
+const RESOURCE_ANY int8 = 1
+const RESOURCE_TOPIC int8 = 2
+const RESOURCE_GROUP int8 = 3
+const RESOURCE_CLUSTER int8 = 4
+const RESOURCE_TRANSACTIONAL_ID int8 = 5
+const RESOURCE_DELEGATION_TOKEN int8 = 6
+const RESOURCE_USER int8 = 7
+
+// Operations as numbered by the Kafka protocol. An authorized operations
+// bitfield sets bit n for operation n.
+const OPERATION_UNKNOWN int8 = 0
+const OPERATION_ANY int8 = 1
+const OPERATION_ALL int8 = 2
+const OPERATION_READ int8 = 3
+const OPERATION_WRITE int8 = 4
+const OPERATION_CREATE int8 = 5
+const OPERATION_DELETE int8 = 6
+const OPERATION_ALTER int8 = 7
+const OPERATION_DESCRIBE int8 = 8
+const OPERATION_CLUSTER_ACTION int8 = 9
+const OPERATION_DESCRIBE_CONFIGS int8 = 10
+const OPERATION_ALTER_CONFIGS int8 = 11
+const OPERATION_IDEMPOTENT_WRITE int8 = 12
+const OPERATION_CREATE_TOKENS int8 = 13
+const OPERATION_DESCRIBE_TOKENS int8 = 14
+
+// The only cluster resource is named kafka-cluster
+const CLUSTER_NAME = "kafka-cluster"
+
+// Connections that have not authenticated act as the anonymous user
+const ANONYMOUS = "User:ANONYMOUS"
+
+// The operations that apply to each resource type
+var RESOURCE_OPERATIONS = map[int8][]int8{
+	RESOURCE_TOPIC: {OPERATION_READ, OPERATION_WRITE, OPERATION_CREATE, OPERATION_DELETE, OPERATION_ALTER,
+		OPERATION_DESCRIBE, OPERATION_DESCRIBE_CONFIGS, OPERATION_ALTER_CONFIGS},
+	RESOURCE_GROUP: {OPERATION_READ, OPERATION_DELETE, OPERATION_DESCRIBE, OPERATION_DESCRIBE_CONFIGS, OPERATION_ALTER_CONFIGS},
+	RESOURCE_CLUSTER: {OPERATION_CREATE, OPERATION_ALTER, OPERATION_DESCRIBE, OPERATION_CLUSTER_ACTION,
+		OPERATION_DESCRIBE_CONFIGS, OPERATION_ALTER_CONFIGS, OPERATION_IDEMPOTENT_WRITE, OPERATION_CREATE_TOKENS,
+		OPERATION_DESCRIBE_TOKENS},
+	RESOURCE_TRANSACTIONAL_ID: {OPERATION_WRITE, OPERATION_DESCRIBE},
+	RESOURCE_DELEGATION_TOKEN: {OPERATION_DESCRIBE},
+	RESOURCE_USER:             {OPERATION_CREATE_TOKENS, OPERATION_DESCRIBE_TOKENS},
+}
+
+// RequestContext is who sent a request and from where.
+type RequestContext struct {
+	Principal string
+	Host      string
+}
+
+type Action struct {
+	ResourceType int8
+	ResourceName string
+	Operation    int8
+}
+
+// Authorizer decides whether a principal may perform an action.
+type Authorizer interface {
+	Authorize(ctx RequestContext, action Action) bool
+}
+
+// AllowAll authorizes every action, as a broker without an authorizer does.
+type AllowAll struct{}
+
+func (AllowAll) Authorize(ctx RequestContext, action Action) bool {
+	return true
+}
+
+var current Authorizer = AllowAll{}
+
+// Current returns the authorizer of this broker.
+func Current() Authorizer {
+	return current
+}
+
+func SetAuthorizer(a Authorizer) {
+	current = a
+}
+
+func IsAuthorized(ctx RequestContext, operation int8, resourceType int8, resourceName string) bool {
+	return current.Authorize(ctx, Action{ResourceType: resourceType, ResourceName: resourceName, Operation: operation})
+}
+
+// AuthorizedOperations returns the bitfield of operations ctx may perform on
+// a resource.
+func AuthorizedOperations(ctx RequestContext, resourceType int8, resourceName string) int32 {
+	operations := int32(0)
+	for _, operation := range RESOURCE_OPERATIONS[resourceType] {
+		if IsAuthorized(ctx, operation, resourceType, resourceName) {
+			operations |= 1 << operation
+		}
+	}
+	return operations
+}
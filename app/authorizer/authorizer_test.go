@@ -0,0 +1,83 @@
+package authorizer
+
+import (
+	"testing"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/gofrs/uuid"
+)
+
+func publishAcls(t *testing.T, bindings ...AclBinding) {
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	for _, binding := range bindings {
+		id, _ := uuid.NewV4()
+		delta.Replay(binding.Record(id))
+	}
+	metadata.Publish(delta.Apply())
+	t.Cleanup(func() { metadata.Publish(metadata.EmptyImage()) })
+}
+
+func TestStandardAuthorizer(t *testing.T) {
+	publishAcls(t,
+		AclBinding{RESOURCE_TOPIC, "orders", PATTERN_LITERAL, "User:alice", WILDCARD_HOST, OPERATION_READ, PERMISSION_ALLOW},
+		AclBinding{RESOURCE_TOPIC, "payments-", PATTERN_PREFIXED, WILDCARD_PRINCIPAL, WILDCARD_HOST, OPERATION_WRITE, PERMISSION_ALLOW},
+		AclBinding{RESOURCE_TOPIC, "payments-", PATTERN_PREFIXED, "User:mallory", "10.0.0.1", OPERATION_ALL, PERMISSION_DENY},
+		AclBinding{RESOURCE_GROUP, WILDCARD_RESOURCE, PATTERN_LITERAL, "User:alice", WILDCARD_HOST, OPERATION_READ, PERMISSION_ALLOW},
+	)
+	a := NewStandardAuthorizer([]string{"User:admin"}, false)
+
+	cases := []struct {
+		principal string
+		host      string
+		action    Action
+		allowed   bool
+	}{
+		{"User:alice", "10.0.0.2", Action{RESOURCE_TOPIC, "orders", OPERATION_READ}, true},
+		// Reading a topic implies describing it
+		{"User:alice", "10.0.0.2", Action{RESOURCE_TOPIC, "orders", OPERATION_DESCRIBE}, true},
+		{"User:alice", "10.0.0.2", Action{RESOURCE_TOPIC, "orders", OPERATION_WRITE}, false},
+		{"User:bob", "10.0.0.2", Action{RESOURCE_TOPIC, "orders", OPERATION_READ}, false},
+		{"User:bob", "10.0.0.2", Action{RESOURCE_TOPIC, "payments-eu", OPERATION_WRITE}, true},
+		{"User:mallory", "10.0.0.2", Action{RESOURCE_TOPIC, "payments-eu", OPERATION_WRITE}, true},
+		{"User:mallory", "10.0.0.1", Action{RESOURCE_TOPIC, "payments-eu", OPERATION_WRITE}, false},
+		{"User:alice", "10.0.0.2", Action{RESOURCE_GROUP, "any-group", OPERATION_READ}, true},
+		// No ACL covers the invoices topic
+		{"User:alice", "10.0.0.2", Action{RESOURCE_TOPIC, "invoices", OPERATION_READ}, false},
+		{"User:admin", "10.0.0.2", Action{RESOURCE_TOPIC, "orders", OPERATION_ALTER}, true},
+	}
+	for _, c := range cases {
+		if allowed := a.Authorize(RequestContext{Principal: c.principal, Host: c.host}, c.action); allowed != c.allowed {
+			t.Errorf("Expected %s from %s to be allowed %+v: %v, got %v", c.principal, c.host, c.action, c.allowed, allowed)
+		}
+	}
+
+	a.AllowEveryoneIfNoAclFound = true
+	if !a.Authorize(RequestContext{Principal: "User:bob"}, Action{RESOURCE_TOPIC, "invoices", OPERATION_READ}) {
+		t.Fatal("Expected resources without ACLs to be open to everyone")
+	}
+	if a.Authorize(RequestContext{Principal: "User:bob"}, Action{RESOURCE_TOPIC, "orders", OPERATION_READ}) {
+		t.Fatal("Expected resources with ACLs to stay closed to others")
+	}
+}
+
+func TestAclBindingFilter(t *testing.T) {
+	binding := AclBinding{RESOURCE_TOPIC, "payments-", PATTERN_PREFIXED, "User:bob", WILDCARD_HOST, OPERATION_WRITE, PERMISSION_ALLOW}
+	name, other := "payments-eu", "payments-"
+	cases := []struct {
+		filter  AclBindingFilter
+		matches bool
+	}{
+		{AclBindingFilter{RESOURCE_ANY, nil, PATTERN_ANY, nil, nil, OPERATION_ANY, PERMISSION_ANY}, true},
+		{AclBindingFilter{RESOURCE_TOPIC, &name, PATTERN_MATCH, nil, nil, OPERATION_ANY, PERMISSION_ANY}, true},
+		{AclBindingFilter{RESOURCE_TOPIC, &name, PATTERN_ANY, nil, nil, OPERATION_ANY, PERMISSION_ANY}, false},
+		{AclBindingFilter{RESOURCE_TOPIC, &other, PATTERN_PREFIXED, nil, nil, OPERATION_WRITE, PERMISSION_ALLOW}, true},
+		{AclBindingFilter{RESOURCE_TOPIC, &other, PATTERN_LITERAL, nil, nil, OPERATION_ANY, PERMISSION_ANY}, false},
+		{AclBindingFilter{RESOURCE_GROUP, nil, PATTERN_ANY, nil, nil, OPERATION_ANY, PERMISSION_ANY}, false},
+		{AclBindingFilter{RESOURCE_ANY, nil, PATTERN_ANY, nil, nil, OPERATION_ANY, PERMISSION_DENY}, false},
+	}
+	for _, c := range cases {
+		if matches := c.filter.Matches(binding); matches != c.matches {
+			t.Errorf("Expected filter %+v to match: %v, got %v", c.filter, c.matches, matches)
+		}
+	}
+}
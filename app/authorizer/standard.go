@@ -0,0 +1,77 @@
+package authorizer
+
+import (
+	"strings"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+)
+
+// StandardAuthorizer authorizes actions against the ACLs in the metadata
+// image, like Kafka's StandardAuthorizer. A matching DENY always wins over a
+// matching ALLOW, and resources without any ACLs are only open to everyone
+// when AllowEveryoneIfNoAclFound is set.
+type StandardAuthorizer struct {
+	SuperUsers                map[string]bool
+	AllowEveryoneIfNoAclFound bool
+}
+
+func NewStandardAuthorizer(superUsers []string, allowEveryoneIfNoAclFound bool) *StandardAuthorizer {
+	a := &StandardAuthorizer{SuperUsers: map[string]bool{}, AllowEveryoneIfNoAclFound: allowEveryoneIfNoAclFound}
+	for _, superUser := range superUsers {
+		a.SuperUsers[superUser] = true
+	}
+	return a
+}
+
+// implies reports whether an ACL for one operation covers another. Every
+// operation that changes or reads a resource also allows describing it.
+func implies(aclOperation int8, operation int8) bool {
+	if aclOperation == OPERATION_ALL || aclOperation == operation {
+		return true
+	}
+	switch operation {
+	case OPERATION_DESCRIBE:
+		return aclOperation == OPERATION_READ || aclOperation == OPERATION_WRITE ||
+			aclOperation == OPERATION_DELETE || aclOperation == OPERATION_ALTER
+	case OPERATION_DESCRIBE_CONFIGS:
+		return aclOperation == OPERATION_ALTER_CONFIGS
+	}
+	return false
+}
+
+func principalMatches(aclPrincipal string, principal string) bool {
+	return aclPrincipal == principal || (aclPrincipal == WILDCARD_PRINCIPAL && strings.HasPrefix(principal, "User:"))
+}
+
+func (a *StandardAuthorizer) Authorize(ctx RequestContext, action Action) bool {
+	if a.SuperUsers[ctx.Principal] {
+		return true
+	}
+	found, allowed := false, false
+	for _, entry := range metadata.Image().AccessControlEntries {
+		binding := BindingFromRecord(entry)
+		if binding.ResourceType != action.ResourceType || !patternMatches(binding, action.ResourceName) {
+			continue
+		}
+		found = true
+		if !principalMatches(binding.Principal, ctx.Principal) || (binding.Host != WILDCARD_HOST && binding.Host != ctx.Host) {
+			continue
+		}
+		switch binding.PermissionType {
+		case PERMISSION_DENY:
+			if binding.Operation == OPERATION_ALL || binding.Operation == action.Operation {
+				return false
+			}
+		case PERMISSION_ALLOW:
+			allowed = allowed || implies(binding.Operation, action.Operation)
+		}
+	}
+	return allowed || (!found && a.AllowEveryoneIfNoAclFound)
+}
+
+// AclsEnabled reports whether this broker authorizes with ACLs, which the
+// ACL APIs need.
+func AclsEnabled() bool {
+	_, ok := current.(*StandardAuthorizer)
+	return ok
+}
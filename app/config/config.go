@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Properties holds the key=value pairs of a server.properties file
+type Properties map[string]string
+
+var Current = Properties{}
+
+func Load(path string) (Properties, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	props := Properties{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			key, value, _ = strings.Cut(line, ":")
+		}
+		props[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return props, scanner.Err()
+}
+
+func (p Properties) String(key string, defaultValue string) string {
+	if value, ok := p[key]; ok {
+		return value
+	}
+	return defaultValue
+}
+
+func (p Properties) Int64(key string, defaultValue int64) int64 {
+	value, ok := p[key]
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using %d\n", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (p Properties) Bool(key string, defaultValue bool) bool {
+	value, ok := p[key]
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using %t\n", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// List splits a comma separated value, dropping empty entries
+func (p Properties) List(key string) []string {
+	values := []string{}
+	for _, value := range strings.Split(p[key], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
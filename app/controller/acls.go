@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+type DeleteAclsResult struct {
+	ErrorCode int16
+	Deleted   []authorizer.AclBinding
+}
+
+// CreateAcls records each valid binding that does not exist yet, and returns
+// an error code per binding.
+func (c *QuorumController) CreateAcls(bindings []authorizer.AclBinding) []int16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]int16, len(bindings))
+	if !c.isActive() {
+		for i := range results {
+			results[i] = utils.NOT_CONTROLLER
+		}
+		return results
+	}
+	existing := map[authorizer.AclBinding]bool{}
+	for _, entry := range metadata.Image().AccessControlEntries {
+		existing[authorizer.BindingFromRecord(entry)] = true
+	}
+	for i, binding := range bindings {
+		if binding.Validate() != nil {
+			results[i] = utils.INVALID_REQUEST
+			continue
+		}
+		if existing[binding] {
+			continue
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			results[i] = utils.UNKNOWN_SERVER_ERROR
+			continue
+		}
+		if results[i] = c.write(binding.Record(id)); results[i] == utils.NONE {
+			existing[binding] = true
+		}
+	}
+	return results
+}
+
+// DeleteAcls removes the ACLs matching each filter and returns them. An ACL
+// matched by several filters is reported by each of them.
+func (c *QuorumController) DeleteAcls(filters []authorizer.AclBindingFilter) []DeleteAclsResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]DeleteAclsResult, len(filters))
+	if !c.isActive() {
+		for i := range results {
+			results[i].ErrorCode = utils.NOT_CONTROLLER
+		}
+		return results
+	}
+	image := metadata.Image()
+	removed := map[uuid.UUID]bool{}
+	records := []metadata.MetadataRecord{}
+	for i, filter := range filters {
+		if filter.Validate() != nil {
+			results[i].ErrorCode = utils.INVALID_REQUEST
+			continue
+		}
+		results[i].Deleted = []authorizer.AclBinding{}
+		for id, binding := range authorizer.MatchingAcls(image, filter) {
+			results[i].Deleted = append(results[i].Deleted, binding)
+			if !removed[id] {
+				removed[id] = true
+				records = append(records, &metadata.RemoveAccessControlEntryRecord{ID: id})
+			}
+		}
+	}
+	if len(records) == 0 {
+		return results
+	}
+	if errorCode := c.write(records...); errorCode != utils.NONE {
+		for i := range results {
+			if results[i].ErrorCode == utils.NONE {
+				results[i] = DeleteAclsResult{ErrorCode: errorCode}
+			}
+		}
+	}
+	return results
+}
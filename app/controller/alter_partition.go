@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"slices"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+// IsrMember is a replica proposed for the ISR. BrokerEpoch is -1 when the
+// leader does not know it.
+type IsrMember struct {
+	BrokerID    int32
+	BrokerEpoch int64
+}
+
+type AlterPartitionRequestPartition struct {
+	PartitionIndex      int32
+	LeaderEpoch         int32
+	NewIsr              []IsrMember
+	LeaderRecoveryState int8
+	PartitionEpoch      int32
+}
+
+type AlterPartitionRequestTopic struct {
+	TopicID    uuid.UUID
+	Partitions []AlterPartitionRequestPartition
+}
+
+type AlterPartitionRequest struct {
+	BrokerID    int32
+	BrokerEpoch int64
+	Topics      []AlterPartitionRequestTopic
+}
+
+type AlterPartitionResponsePartition struct {
+	PartitionIndex      int32
+	ErrorCode           int16
+	LeaderID            int32
+	LeaderEpoch         int32
+	Isr                 []int32
+	LeaderRecoveryState int8
+	PartitionEpoch      int32
+}
+
+type AlterPartitionResponseTopic struct {
+	TopicID    uuid.UUID
+	Partitions []AlterPartitionResponsePartition
+}
+
+type AlterPartitionResponse struct {
+	ErrorCode int16
+	Topics    []AlterPartitionResponseTopic
+}
+
+// validateIsr checks a proposed ISR against the current partition state.
+// Replicas joining the ISR must be registered, unfenced and, when the leader
+// sent their epoch, still in that broker epoch.
+func validateIsr(image *metadata.MetadataImage, partition *metadata.ClusterTopicPartition, newIsr []IsrMember) int16 {
+	if !slices.ContainsFunc(newIsr, func(m IsrMember) bool { return m.BrokerID == partition.LeaderID }) {
+		return utils.INVALID_REQUEST
+	}
+	for _, member := range newIsr {
+		if !slices.Contains(partition.ReplicaNodeIDs, member.BrokerID) {
+			return utils.INVALID_REQUEST
+		}
+		if slices.Contains(partition.InsyncReplicaNodeIDs, member.BrokerID) {
+			continue
+		}
+		broker, ok := image.Brokers[member.BrokerID]
+		if !ok || broker.Fenced || broker.InControlledShutdown {
+			return utils.INELIGIBLE_REPLICA
+		}
+		if member.BrokerEpoch >= 0 && member.BrokerEpoch != broker.BrokerEpoch {
+			return utils.INELIGIBLE_REPLICA
+		}
+	}
+	return utils.NONE
+}
+
+func partitionResult(partition *metadata.ClusterTopicPartition, errorCode int16) AlterPartitionResponsePartition {
+	return AlterPartitionResponsePartition{
+		PartitionIndex:      partition.PartitionIndex,
+		ErrorCode:           errorCode,
+		LeaderID:            partition.LeaderID,
+		LeaderEpoch:         partition.LeaderEpoch,
+		Isr:                 partition.InsyncReplicaNodeIDs,
+		LeaderRecoveryState: partition.LeaderRecoveryState,
+		PartitionEpoch:      partition.PartitionEpoch,
+	}
+}
+
+// AlterPartition applies the ISR changes proposed by partition leaders. A
+// change is only accepted from the current leader for the partition epoch it
+// last saw, so that concurrent updates cannot overwrite each other.
+func (c *QuorumController) AlterPartition(req AlterPartitionRequest) AlterPartitionResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := AlterPartitionResponse{}
+	if !c.isActive() {
+		resp.ErrorCode = utils.NOT_CONTROLLER
+		return resp
+	}
+	image := metadata.Image()
+	if broker, ok := image.Brokers[req.BrokerID]; !ok || broker.BrokerEpoch != req.BrokerEpoch {
+		resp.ErrorCode = utils.STALE_BROKER_EPOCH
+		return resp
+	}
+
+	for _, topic := range req.Topics {
+		topicResult := AlterPartitionResponseTopic{TopicID: topic.TopicID}
+		clusterTopic, ok := image.TopicById(topic.TopicID)
+		for _, partitionRequest := range topic.Partitions {
+			result := AlterPartitionResponsePartition{PartitionIndex: partitionRequest.PartitionIndex, LeaderID: -1, LeaderEpoch: -1}
+			partition := (*metadata.ClusterTopicPartition)(nil)
+			if ok {
+				partition = clusterTopic.Partition(partitionRequest.PartitionIndex)
+			}
+			switch {
+			case !ok:
+				result.ErrorCode = utils.UNKNOWN_TOPIC_ID
+			case partition == nil:
+				result.ErrorCode = utils.UNKNOWN_TOPIC_OR_PARTITION
+			case partition.LeaderID != req.BrokerID:
+				result.ErrorCode = utils.NOT_LEADER_OR_FOLLOWER
+			case partitionRequest.LeaderEpoch != partition.LeaderEpoch:
+				result.ErrorCode = utils.FENCED_LEADER_EPOCH
+			case partitionRequest.PartitionEpoch != partition.PartitionEpoch:
+				result.ErrorCode = utils.INVALID_UPDATE_VERSION
+			default:
+				result = c.changeIsr(image, topic.TopicID, partition, partitionRequest.NewIsr)
+			}
+			topicResult.Partitions = append(topicResult.Partitions, result)
+		}
+		resp.Topics = append(resp.Topics, topicResult)
+	}
+	return resp
+}
+
+func (c *QuorumController) changeIsr(image *metadata.MetadataImage, topicId uuid.UUID, partition *metadata.ClusterTopicPartition, newIsr []IsrMember) AlterPartitionResponsePartition {
+	if errorCode := validateIsr(image, partition, newIsr); errorCode != utils.NONE {
+		return partitionResult(partition, errorCode)
+	}
+	isr := []int32{}
+	for _, member := range newIsr {
+		isr = append(isr, member.BrokerID)
+	}
+	if slices.Equal(isr, partition.InsyncReplicaNodeIDs) {
+		return partitionResult(partition, utils.NONE)
+	}
+
+	change := metadata.NewPartitionChangeRecord(topicId, partition.PartitionIndex)
+	change.Isr = isr
+	maybeCompleteReassignment(image, partition, change)
+	if errorCode := c.write(change); errorCode != utils.NONE {
+		return partitionResult(partition, errorCode)
+	}
+	if clusterTopic, ok := metadata.Image().TopicById(topicId); ok {
+		if updated := clusterTopic.Partition(partition.PartitionIndex); updated != nil {
+			return partitionResult(updated, utils.NONE)
+		}
+	}
+	return partitionResult(partition, utils.UNKNOWN_TOPIC_OR_PARTITION)
+}
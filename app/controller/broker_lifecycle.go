@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// Channel carries a broker's requests to the controllers.
+type Channel interface {
+	RegisterBroker(destination int32, req BrokerRegistrationRequest) (BrokerRegistrationResponse, error)
+	BrokerHeartbeat(destination int32, req BrokerHeartbeatRequest) (BrokerHeartbeatResponse, error)
+	AlterPartition(destination int32, req AlterPartitionRequest) (AlterPartitionResponse, error)
+}
+
+// BrokerLifecycleManager registers this broker with the active controller
+// and keeps its session alive with heartbeats.
+type BrokerLifecycleManager struct {
+	HeartbeatInterval time.Duration
+	// ActiveController returns the known quorum leader, or raft.NO_LEADER
+	ActiveController func() int32
+
+	mu           sync.Mutex
+	registration BrokerRegistrationRequest
+	channel      Channel
+	controllers  []int32
+	next         int
+	brokerEpoch  int64
+	fenced       bool
+	wantShutDown bool
+	shutDown     bool
+}
+
+func NewBrokerLifecycleManager(registration BrokerRegistrationRequest, channel Channel, controllers []int32) *BrokerLifecycleManager {
+	registration.PreviousBrokerEpoch = -1
+	return &BrokerLifecycleManager{
+		HeartbeatInterval: DefaultConfig().HeartbeatInterval,
+		registration:      registration,
+		channel:           channel,
+		controllers:       controllers,
+		brokerEpoch:       -1,
+		fenced:            true,
+	}
+}
+
+func (m *BrokerLifecycleManager) BrokerEpoch() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.brokerEpoch
+}
+
+func (m *BrokerLifecycleManager) IsFenced() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fenced
+}
+
+// destination is the active controller if it is known, otherwise the
+// controllers are tried in turn.
+func (m *BrokerLifecycleManager) destination() int32 {
+	if m.ActiveController != nil {
+		if leaderId := m.ActiveController(); leaderId != raft.NO_LEADER {
+			return leaderId
+		}
+	}
+	return m.controllers[m.next%len(m.controllers)]
+}
+
+// Poll sends a registration, or a heartbeat once registered.
+func (m *BrokerLifecycleManager) Poll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shutDown {
+		return
+	}
+	destination := m.destination()
+
+	if m.brokerEpoch < 0 {
+		resp, err := m.channel.RegisterBroker(destination, m.registration)
+		if err != nil || resp.ErrorCode == utils.NOT_CONTROLLER {
+			m.next++
+			return
+		}
+		if resp.ErrorCode != utils.NONE {
+			log.Printf("Broker registration failed with error code %d\n", resp.ErrorCode)
+			return
+		}
+		m.brokerEpoch = resp.BrokerEpoch
+		m.registration.PreviousBrokerEpoch = resp.BrokerEpoch
+		log.Printf("Registered with the controller as broker %d in epoch %d\n", m.registration.BrokerID, m.brokerEpoch)
+		return
+	}
+
+	resp, err := m.channel.BrokerHeartbeat(destination, BrokerHeartbeatRequest{
+		BrokerID:              m.registration.BrokerID,
+		BrokerEpoch:           m.brokerEpoch,
+		CurrentMetadataOffset: metadata.LastAppliedOffset(),
+		WantShutDown:          m.wantShutDown,
+	})
+	if err != nil || resp.ErrorCode == utils.NOT_CONTROLLER {
+		m.next++
+		return
+	}
+	switch resp.ErrorCode {
+	case utils.NONE:
+	case utils.STALE_BROKER_EPOCH, utils.BROKER_ID_NOT_REGISTERED:
+		// The registration was lost or replaced, so start over
+		m.brokerEpoch = -1
+		m.fenced = true
+		return
+	default:
+		log.Printf("Broker heartbeat failed with error code %d\n", resp.ErrorCode)
+		return
+	}
+	if m.fenced != resp.IsFenced {
+		log.Printf("Broker %d is fenced: %t\n", m.registration.BrokerID, resp.IsFenced)
+	}
+	m.fenced = resp.IsFenced
+	m.shutDown = resp.ShouldShutDown
+}
+
+// AlterPartition sends ISR changes for the partitions this broker leads to
+// the active controller, in this broker's current epoch.
+func (m *BrokerLifecycleManager) AlterPartition(req AlterPartitionRequest) (AlterPartitionResponse, error) {
+	m.mu.Lock()
+	req.BrokerID, req.BrokerEpoch = m.registration.BrokerID, m.brokerEpoch
+	destination := m.destination()
+	m.mu.Unlock()
+
+	resp, err := m.channel.AlterPartition(destination, req)
+	if err != nil || resp.ErrorCode == utils.NOT_CONTROLLER {
+		m.mu.Lock()
+		m.next++
+		m.mu.Unlock()
+	}
+	return resp, err
+}
+
+// Run sends heartbeats until stop is closed.
+func (m *BrokerLifecycleManager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.HeartbeatInterval)
+	defer ticker.Stop()
+	m.Poll()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.Poll()
+		}
+	}
+}
+
+// ControlledShutdown asks the controller to fence this broker before it
+// stops, and waits up to timeout for it to agree.
+func (m *BrokerLifecycleManager) ControlledShutdown(timeout time.Duration) bool {
+	m.mu.Lock()
+	m.wantShutDown = true
+	m.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		m.mu.Lock()
+		shutDown, registered := m.shutDown, m.brokerEpoch >= 0
+		m.mu.Unlock()
+		if shutDown || !registered || time.Now().After(deadline) {
+			return shutDown
+		}
+		m.Poll()
+		time.Sleep(100 * time.Millisecond)
+	}
+}
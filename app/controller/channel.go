@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// LocalChannel hands requests straight to controllers in the same process.
+type LocalChannel struct {
+	Controllers map[int32]*QuorumController
+}
+
+func (l *LocalChannel) RegisterBroker(destination int32, req BrokerRegistrationRequest) (BrokerRegistrationResponse, error) {
+	c, ok := l.Controllers[destination]
+	if !ok {
+		return BrokerRegistrationResponse{}, raft.ErrUnreachable
+	}
+	return c.RegisterBroker(req), nil
+}
+
+func (l *LocalChannel) BrokerHeartbeat(destination int32, req BrokerHeartbeatRequest) (BrokerHeartbeatResponse, error) {
+	c, ok := l.Controllers[destination]
+	if !ok {
+		return BrokerHeartbeatResponse{}, raft.ErrUnreachable
+	}
+	return c.BrokerHeartbeat(req), nil
+}
+
+func (l *LocalChannel) AlterPartition(destination int32, req AlterPartitionRequest) (AlterPartitionResponse, error) {
+	c, ok := l.Controllers[destination]
+	if !ok {
+		return AlterPartitionResponse{}, raft.ErrUnreachable
+	}
+	return c.AlterPartition(req), nil
+}
+
+// NetworkChannel sends requests to the controllers over the Kafka protocol,
+// sharing the connections of the quorum's transport.
+type NetworkChannel struct {
+	Transport *raft.NetworkTransport
+}
+
+func (n *NetworkChannel) RegisterBroker(destination int32, req BrokerRegistrationRequest) (BrokerRegistrationResponse, error) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, req.BrokerID)
+	utils.WriteCompactString(&body, req.ClusterID)
+	body.Write(req.IncarnationID.Bytes())
+	utils.WriteCompactArrayLength(&body, len(req.Listeners))
+	for _, listener := range req.Listeners {
+		utils.WriteCompactString(&body, listener.Name)
+		utils.WriteCompactString(&body, listener.Host)
+		binary.Write(&body, binary.BigEndian, listener.Port)
+		binary.Write(&body, binary.BigEndian, listener.SecurityProtocol)
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteCompactArrayLength(&body, len(req.Features))
+	for _, feature := range req.Features {
+		utils.WriteCompactString(&body, feature.Name)
+		binary.Write(&body, binary.BigEndian, feature.MinSupportedVersion)
+		binary.Write(&body, binary.BigEndian, feature.MaxSupportedVersion)
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteCompactNullableString(&body, req.Rack)
+	// is_migrating_zk_broker
+	binary.Write(&body, binary.BigEndian, false)
+	utils.WriteUUIDArray(&body, req.LogDirs)
+	binary.Write(&body, binary.BigEndian, req.PreviousBrokerEpoch)
+	utils.WriteTaggedFields(&body)
+
+	data, err := n.Transport.Send(destination, utils.BROKER_REGISTRATION_KEY, utils.BROKER_REGISTRATION, body.Bytes(), n.Transport.RequestTimeout)
+	if err != nil {
+		return BrokerRegistrationResponse{}, err
+	}
+	resp := BrokerRegistrationResponse{}
+	var throttleTimeMs int32
+	fields := []any{&throttleTimeMs, &resp.ErrorCode, &resp.BrokerEpoch}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (n *NetworkChannel) BrokerHeartbeat(destination int32, req BrokerHeartbeatRequest) (BrokerHeartbeatResponse, error) {
+	var body bytes.Buffer
+	fields := []any{req.BrokerID, req.BrokerEpoch, req.CurrentMetadataOffset, req.WantFence, req.WantShutDown}
+	for _, field := range fields {
+		binary.Write(&body, binary.BigEndian, field)
+	}
+	utils.WriteTaggedFields(&body)
+
+	data, err := n.Transport.Send(destination, utils.BROKER_HEARTBEAT_KEY, utils.BROKER_HEARTBEAT, body.Bytes(), n.Transport.RequestTimeout)
+	if err != nil {
+		return BrokerHeartbeatResponse{}, err
+	}
+	resp := BrokerHeartbeatResponse{}
+	var throttleTimeMs int32
+	responseFields := []any{&throttleTimeMs, &resp.ErrorCode, &resp.IsCaughtUp, &resp.IsFenced, &resp.ShouldShutDown}
+	for _, field := range responseFields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (n *NetworkChannel) AlterPartition(destination int32, req AlterPartitionRequest) (AlterPartitionResponse, error) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, req.BrokerID)
+	binary.Write(&body, binary.BigEndian, req.BrokerEpoch)
+	utils.WriteCompactArrayLength(&body, len(req.Topics))
+	for _, topic := range req.Topics {
+		body.Write(topic.TopicID.Bytes())
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			binary.Write(&body, binary.BigEndian, partition.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, partition.LeaderEpoch)
+			utils.WriteCompactArrayLength(&body, len(partition.NewIsr))
+			for _, member := range partition.NewIsr {
+				binary.Write(&body, binary.BigEndian, member.BrokerID)
+				binary.Write(&body, binary.BigEndian, member.BrokerEpoch)
+				utils.WriteTaggedFields(&body)
+			}
+			binary.Write(&body, binary.BigEndian, partition.LeaderRecoveryState)
+			binary.Write(&body, binary.BigEndian, partition.PartitionEpoch)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	data, err := n.Transport.Send(destination, utils.ALTER_PARTITION_KEY, utils.ALTER_PARTITION, body.Bytes(), n.Transport.RequestTimeout)
+	if err != nil {
+		return AlterPartitionResponse{}, err
+	}
+	resp := AlterPartitionResponse{}
+	var throttleTimeMs int32
+	if err := binary.Read(data, binary.BigEndian, &throttleTimeMs); err != nil {
+		return resp, err
+	}
+	if err := binary.Read(data, binary.BigEndian, &resp.ErrorCode); err != nil {
+		return resp, err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return resp, err
+	}
+	for range topicLen {
+		topic := AlterPartitionResponseTopic{}
+		if err := utils.ReadUUID(&topic.TopicID, data); err != nil {
+			return resp, err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return resp, err
+		}
+		for range partitionLen {
+			partition := AlterPartitionResponsePartition{}
+			fields := []any{&partition.PartitionIndex, &partition.ErrorCode, &partition.LeaderID, &partition.LeaderEpoch}
+			for _, field := range fields {
+				if err := binary.Read(data, binary.BigEndian, field); err != nil {
+					return resp, err
+				}
+			}
+			if err := utils.ReadINT32Array(&partition.Isr, data); err != nil {
+				return resp, err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.LeaderRecoveryState); err != nil {
+				return resp, err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.PartitionEpoch); err != nil {
+				return resp, err
+			}
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return resp, err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return resp, err
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp, nil
+}
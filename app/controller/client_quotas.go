@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type ClientQuotaOp struct {
+	Key    string
+	Value  float64
+	Remove bool
+}
+
+type ClientQuotaEntry struct {
+	Entity []metadata.ClientQuotaEntity
+	Ops    []ClientQuotaOp
+}
+
+type ClientQuotaResult struct {
+	Entity       []metadata.ClientQuotaEntity
+	ErrorCode    int16
+	ErrorMessage *string
+}
+
+func validateClientQuotaEntry(entry ClientQuotaEntry) error {
+	if len(entry.Entity) == 0 {
+		return fmt.Errorf("invalid empty client quota entity")
+	}
+	entityTypes := []string{}
+	for _, entity := range entry.Entity {
+		if entity.EntityType != quota.ENTITY_USER && entity.EntityType != quota.ENTITY_CLIENT_ID {
+			return fmt.Errorf("unhandled client quota entity type: %s", entity.EntityType)
+		}
+		if slices.Contains(entityTypes, entity.EntityType) {
+			return fmt.Errorf("duplicate %s entity", entity.EntityType)
+		}
+		if entity.EntityName != nil && *entity.EntityName == "" {
+			return fmt.Errorf("empty %s entity name", entity.EntityType)
+		}
+		entityTypes = append(entityTypes, entity.EntityType)
+	}
+	keys := []string{}
+	for _, op := range entry.Ops {
+		if !slices.Contains(quota.CLIENT_QUOTA_KEYS, op.Key) {
+			return fmt.Errorf("unknown quota key %s", op.Key)
+		}
+		if slices.Contains(keys, op.Key) {
+			return fmt.Errorf("duplicate quota key %s", op.Key)
+		}
+		if !op.Remove && op.Value <= 0 {
+			return fmt.Errorf("quota %s must be positive", op.Key)
+		}
+		keys = append(keys, op.Key)
+	}
+	return nil
+}
+
+// AlterClientQuotas sets and removes the quotas of each entry, and returns a
+// result per entry. Entries are applied independently of each other, and
+// only validated when validateOnly is set.
+func (c *QuorumController) AlterClientQuotas(entries []ClientQuotaEntry, validateOnly bool) []ClientQuotaResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := []ClientQuotaResult{}
+	for _, entry := range entries {
+		result := ClientQuotaResult{Entity: entry.Entity, ErrorCode: utils.NONE}
+		if err := validateClientQuotaEntry(entry); err != nil {
+			message := err.Error()
+			result.ErrorCode = utils.INVALID_REQUEST
+			result.ErrorMessage = &message
+		} else if !c.isActive() {
+			result.ErrorCode = utils.NOT_CONTROLLER
+		} else if !validateOnly {
+			records := []metadata.MetadataRecord{}
+			for _, op := range entry.Ops {
+				records = append(records, &metadata.ClientQuotaRecord{Entity: entry.Entity, Key: op.Key, Value: op.Value, Remove: op.Remove})
+			}
+			if len(records) > 0 {
+				result.ErrorCode = c.write(records...)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
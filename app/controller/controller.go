@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+// Values of BrokerRegistrationChangeRecord.Fenced and InControlledShutdown
+const (
+	CHANGE_TO_TRUE  int8 = 1
+	CHANGE_TO_FALSE int8 = -1
+)
+
+type BrokerRegistrationRequest struct {
+	BrokerID            int32
+	ClusterID           string
+	IncarnationID       uuid.UUID
+	Listeners           []metadata.BrokerEndpoint
+	Features            []metadata.BrokerFeature
+	Rack                *string
+	LogDirs             []uuid.UUID
+	PreviousBrokerEpoch int64
+}
+
+type BrokerRegistrationResponse struct {
+	ErrorCode   int16
+	BrokerEpoch int64
+}
+
+type BrokerHeartbeatRequest struct {
+	BrokerID              int32
+	BrokerEpoch           int64
+	CurrentMetadataOffset int64
+	WantFence             bool
+	WantShutDown          bool
+}
+
+type BrokerHeartbeatResponse struct {
+	ErrorCode      int16
+	IsCaughtUp     bool
+	IsFenced       bool
+	ShouldShutDown bool
+}
+
+type Config struct {
+	ClusterID string
+	// SessionTimeout is broker.session.timeout.ms: a broker that has not
+	// sent a heartbeat for this long is fenced
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	// CommitTimeout bounds how long a request waits for its records to be
+	// committed and applied
+	CommitTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		SessionTimeout:    9000 * time.Millisecond,
+		HeartbeatInterval: 2000 * time.Millisecond,
+		CommitTimeout:     5000 * time.Millisecond,
+	}
+}
+
+// QuorumController handles broker registrations and heartbeats while this
+// node leads the metadata quorum. Broker state lives in the metadata image,
+// only the heartbeat times are kept in memory.
+type QuorumController struct {
+	mu          sync.Mutex
+	config      Config
+	node        *raft.RaftNode
+	leaderEpoch int32
+	heartbeats  map[int32]time.Time
+}
+
+var controller *QuorumController
+
+// Controller returns the controller of this process, or nil if it is not a
+// controller.
+func Controller() *QuorumController {
+	return controller
+}
+
+func Start(config Config, node *raft.RaftNode) *QuorumController {
+	controller = NewQuorumController(config, node)
+	go controller.Run(nil)
+	return controller
+}
+
+func NewQuorumController(config Config, node *raft.RaftNode) *QuorumController {
+	return &QuorumController{config: config, node: node, leaderEpoch: -1}
+}
+
+func (c *QuorumController) isActive() bool {
+	return c.node.Role() == raft.LEADER
+}
+
+// sessions returns the heartbeats received while leading the current epoch.
+// A new leader gives every broker a full session.
+func (c *QuorumController) sessions(now time.Time) map[int32]time.Time {
+	if epoch := c.node.LeaderAndEpoch().LeaderEpoch; epoch != c.leaderEpoch {
+		c.leaderEpoch = epoch
+		c.heartbeats = map[int32]time.Time{}
+		for brokerId := range metadata.Image().Brokers {
+			c.heartbeats[brokerId] = now
+		}
+	}
+	return c.heartbeats
+}
+
+// write appends records and waits until they are committed and applied to
+// the metadata image.
+func (c *QuorumController) write(records ...metadata.MetadataRecord) int16 {
+	offset, err := c.node.Append(records)
+	if err == raft.ErrNotLeader {
+		return utils.NOT_CONTROLLER
+	}
+	if err != nil {
+		log.Printf("Failed to write metadata records: %s\n", err.Error())
+		return utils.UNKNOWN_SERVER_ERROR
+	}
+	deadline := time.Now().Add(c.config.CommitTimeout)
+	if !c.node.WaitForCommit(offset, c.config.CommitTimeout) {
+		return utils.REQUEST_TIMED_OUT
+	}
+	if !metadata.WaitForOffset(offset, time.Until(deadline)) {
+		return utils.REQUEST_TIMED_OUT
+	}
+	return utils.NONE
+}
+
+// RegisterBroker records a broker as fenced under a new broker epoch, the
+// offset of its registration. It is unfenced by a heartbeat once it has
+// caught up with the metadata log.
+func (c *QuorumController) RegisterBroker(req BrokerRegistrationRequest) BrokerRegistrationResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := BrokerRegistrationResponse{BrokerEpoch: -1}
+	if !c.isActive() {
+		resp.ErrorCode = utils.NOT_CONTROLLER
+		return resp
+	}
+	if c.config.ClusterID != "" && req.ClusterID != c.config.ClusterID {
+		resp.ErrorCode = utils.INCONSISTENT_CLUSTER_ID
+		return resp
+	}
+	now := time.Now()
+	sessions := c.sessions(now)
+	// Another process may not take over the id of a broker that is still
+	// heartbeating
+	if existing, ok := metadata.Image().Brokers[req.BrokerID]; ok && existing.IncarnationID != req.IncarnationID &&
+		existing.BrokerEpoch != req.PreviousBrokerEpoch && now.Sub(sessions[req.BrokerID]) < c.config.SessionTimeout {
+		resp.ErrorCode = utils.DUPLICATE_BROKER_REGISTRATION
+		return resp
+	}
+
+	brokerEpoch := c.node.LogEndOffset()
+	resp.ErrorCode = c.write(&metadata.RegisterBrokerRecord{
+		BrokerID:      req.BrokerID,
+		IncarnationID: req.IncarnationID,
+		BrokerEpoch:   brokerEpoch,
+		EndPoints:     req.Listeners,
+		Features:      req.Features,
+		Rack:          req.Rack,
+		Fenced:        true,
+		LogDirs:       req.LogDirs,
+	})
+	if resp.ErrorCode == utils.NONE {
+		sessions[req.BrokerID] = now
+		resp.BrokerEpoch = brokerEpoch
+		log.Printf("Registered broker %d with epoch %d\n", req.BrokerID, brokerEpoch)
+	}
+	return resp
+}
+
+func (c *QuorumController) changeRegistration(broker *metadata.BrokerRegistration, fenced int8, inControlledShutdown int8) int16 {
+	return c.write(&metadata.BrokerRegistrationChangeRecord{
+		BrokerID:             broker.BrokerID,
+		BrokerEpoch:          broker.BrokerEpoch,
+		Fenced:               fenced,
+		InControlledShutdown: inControlledShutdown,
+	})
+}
+
+// BrokerHeartbeat keeps a broker's session alive and moves it in and out of
+// the fenced state.
+func (c *QuorumController) BrokerHeartbeat(req BrokerHeartbeatRequest) BrokerHeartbeatResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := BrokerHeartbeatResponse{IsFenced: true}
+	if !c.isActive() {
+		resp.ErrorCode = utils.NOT_CONTROLLER
+		return resp
+	}
+	broker, ok := metadata.Image().Brokers[req.BrokerID]
+	if !ok {
+		resp.ErrorCode = utils.BROKER_ID_NOT_REGISTERED
+		return resp
+	}
+	if broker.BrokerEpoch != req.BrokerEpoch {
+		resp.ErrorCode = utils.STALE_BROKER_EPOCH
+		return resp
+	}
+	c.sessions(time.Now())[req.BrokerID] = time.Now()
+	resp.IsCaughtUp = req.CurrentMetadataOffset >= broker.BrokerEpoch
+
+	switch {
+	case req.WantShutDown:
+		if !broker.Fenced || !broker.InControlledShutdown {
+			resp.ErrorCode = c.changeRegistration(broker, CHANGE_TO_TRUE, CHANGE_TO_TRUE)
+		}
+		resp.ShouldShutDown = resp.ErrorCode == utils.NONE
+	case req.WantFence:
+		if !broker.Fenced {
+			resp.ErrorCode = c.changeRegistration(broker, CHANGE_TO_TRUE, 0)
+		}
+	case broker.Fenced && resp.IsCaughtUp:
+		resp.ErrorCode = c.changeRegistration(broker, CHANGE_TO_FALSE, 0)
+		resp.IsFenced = resp.ErrorCode != utils.NONE
+		if !resp.IsFenced {
+			log.Printf("Unfenced broker %d\n", req.BrokerID)
+		}
+	default:
+		resp.IsFenced = broker.Fenced
+	}
+	return resp
+}
+
+// FenceStaleBrokers fences the brokers whose session has expired.
+func (c *QuorumController) FenceStaleBrokers(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.isActive() {
+		return
+	}
+	sessions := c.sessions(now)
+	for _, broker := range metadata.Image().LiveBrokers() {
+		lastHeartbeat, ok := sessions[broker.BrokerID]
+		if !ok {
+			sessions[broker.BrokerID] = now
+			continue
+		}
+		if now.Sub(lastHeartbeat) < c.config.SessionTimeout {
+			continue
+		}
+		log.Printf("Fencing broker %d after %s without a heartbeat\n", broker.BrokerID, now.Sub(lastHeartbeat))
+		if errorCode := c.changeRegistration(broker, CHANGE_TO_TRUE, 0); errorCode != utils.NONE {
+			log.Printf("Failed to fence broker %d: error code %d\n", broker.BrokerID, errorCode)
+		}
+	}
+}
+
+// Run checks for expired sessions until stop is closed.
+func (c *QuorumController) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			c.FenceStaleBrokers(now)
+		}
+	}
+}
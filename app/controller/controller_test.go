@@ -0,0 +1,406 @@
+package controller
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/sasl"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+// newTestController runs a single voter quorum with a metadata follower
+// applying what it commits, as a combined broker and controller would.
+func newTestController(t *testing.T) *QuorumController {
+	dir := t.TempDir()
+	network := raft.NewLocalNetwork()
+	node, err := raft.NewRaftNode(raft.Config{
+		NodeID:          1,
+		ClusterID:       "test-cluster",
+		Voters:          []int32{1},
+		Dir:             dir,
+		ElectionTimeout: 100 * time.Millisecond,
+		FetchTimeout:    300 * time.Millisecond,
+		FetchMaxWait:    50 * time.Millisecond,
+		Tick:            10 * time.Millisecond,
+	}, network.Transport(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.Add(node)
+
+	metadata.Publish(metadata.EmptyImage())
+	metadata.CommittedOffset = node.HighWatermark
+	pollInterval := metadata.MetadataPollInterval
+	metadata.MetadataPollInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	go node.Run(stop)
+	go metadata.NewMetadataFollower(dir).Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		time.Sleep(50 * time.Millisecond)
+		node.Close()
+		metadata.CommittedOffset = nil
+		metadata.MetadataPollInterval = pollInterval
+		metadata.Publish(metadata.EmptyImage())
+	})
+
+	waitFor(t, "the node to lead", func() bool { return node.Role() == raft.LEADER })
+	return NewQuorumController(Config{
+		ClusterID:         "test-cluster",
+		SessionTimeout:    300 * time.Millisecond,
+		HeartbeatInterval: 50 * time.Millisecond,
+		CommitTimeout:     5 * time.Second,
+	}, node)
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testRegistration(brokerId int32) BrokerRegistrationRequest {
+	incarnationId, _ := uuid.NewV4()
+	return BrokerRegistrationRequest{
+		BrokerID:            brokerId,
+		ClusterID:           "test-cluster",
+		IncarnationID:       incarnationId,
+		Listeners:           []metadata.BrokerEndpoint{{Name: "PLAINTEXT", Host: "localhost", Port: 9092}},
+		PreviousBrokerEpoch: -1,
+	}
+}
+
+func liveBrokerIds() []int32 {
+	ids := []int32{}
+	for _, broker := range metadata.Image().LiveBrokers() {
+		ids = append(ids, broker.BrokerID)
+	}
+	return ids
+}
+
+func TestRegisteredBrokerIsFencedWhenHeartbeatsLapse(t *testing.T) {
+	c := newTestController(t)
+	stopController := make(chan struct{})
+	defer close(stopController)
+	go c.Run(stopController)
+
+	lifecycle := NewBrokerLifecycleManager(testRegistration(2), &LocalChannel{Controllers: map[int32]*QuorumController{1: c}}, []int32{1})
+	lifecycle.HeartbeatInterval = 50 * time.Millisecond
+	stopBroker := make(chan struct{})
+	go lifecycle.Run(stopBroker)
+
+	waitFor(t, "the broker to be unfenced", func() bool {
+		ids := liveBrokerIds()
+		return !lifecycle.IsFenced() && len(ids) == 1 && ids[0] == 2
+	})
+	broker := metadata.Image().Brokers[2]
+	if broker.BrokerEpoch != lifecycle.BrokerEpoch() || broker.EndPoints[0].Host != "localhost" {
+		t.Fatalf("Unexpected registration %+v for epoch %d", broker, lifecycle.BrokerEpoch())
+	}
+
+	close(stopBroker)
+	waitFor(t, "the broker to be fenced", func() bool {
+		broker, ok := metadata.Image().Brokers[2]
+		return ok && broker.Fenced && len(liveBrokerIds()) == 0
+	})
+}
+
+func TestBrokerRegistrationErrors(t *testing.T) {
+	c := newTestController(t)
+
+	registration := testRegistration(2)
+	resp := c.RegisterBroker(registration)
+	if resp.ErrorCode != utils.NONE || resp.BrokerEpoch < 0 {
+		t.Fatalf("Registration failed: %+v", resp)
+	}
+	brokerEpoch := resp.BrokerEpoch
+
+	heartbeat := c.BrokerHeartbeat(BrokerHeartbeatRequest{BrokerID: 2, BrokerEpoch: brokerEpoch, CurrentMetadataOffset: brokerEpoch - 1})
+	if heartbeat.ErrorCode != utils.NONE || heartbeat.IsCaughtUp || !heartbeat.IsFenced {
+		t.Fatalf("Expected a fenced broker that has not caught up, got %+v", heartbeat)
+	}
+	heartbeat = c.BrokerHeartbeat(BrokerHeartbeatRequest{BrokerID: 2, BrokerEpoch: brokerEpoch, CurrentMetadataOffset: brokerEpoch})
+	if heartbeat.ErrorCode != utils.NONE || !heartbeat.IsCaughtUp || heartbeat.IsFenced {
+		t.Fatalf("Expected an unfenced broker, got %+v", heartbeat)
+	}
+
+	if heartbeat := c.BrokerHeartbeat(BrokerHeartbeatRequest{BrokerID: 2, BrokerEpoch: brokerEpoch - 1}); heartbeat.ErrorCode != utils.STALE_BROKER_EPOCH {
+		t.Fatalf("Expected STALE_BROKER_EPOCH, got %+v", heartbeat)
+	}
+	if heartbeat := c.BrokerHeartbeat(BrokerHeartbeatRequest{BrokerID: 3, BrokerEpoch: brokerEpoch}); heartbeat.ErrorCode != utils.BROKER_ID_NOT_REGISTERED {
+		t.Fatalf("Expected BROKER_ID_NOT_REGISTERED, got %+v", heartbeat)
+	}
+
+	// Another process with the same id while the first is alive
+	if resp := c.RegisterBroker(testRegistration(2)); resp.ErrorCode != utils.DUPLICATE_BROKER_REGISTRATION {
+		t.Fatalf("Expected DUPLICATE_BROKER_REGISTRATION, got %+v", resp)
+	}
+	// The same broker restarting knows its previous epoch
+	restarted := testRegistration(2)
+	restarted.PreviousBrokerEpoch = brokerEpoch
+	if resp := c.RegisterBroker(restarted); resp.ErrorCode != utils.NONE || resp.BrokerEpoch <= brokerEpoch {
+		t.Fatalf("Expected a new epoch for the restarted broker, got %+v", resp)
+	}
+
+	mismatched := testRegistration(4)
+	mismatched.ClusterID = "other-cluster"
+	if resp := c.RegisterBroker(mismatched); resp.ErrorCode != utils.INCONSISTENT_CLUSTER_ID {
+		t.Fatalf("Expected INCONSISTENT_CLUSTER_ID, got %+v", resp)
+	}
+
+	heartbeat = c.BrokerHeartbeat(BrokerHeartbeatRequest{BrokerID: 2, BrokerEpoch: metadata.Image().Brokers[2].BrokerEpoch, CurrentMetadataOffset: metadata.LastAppliedOffset(), WantShutDown: true})
+	if heartbeat.ErrorCode != utils.NONE || !heartbeat.ShouldShutDown || !metadata.Image().Brokers[2].InControlledShutdown {
+		t.Fatalf("Expected a controlled shutdown, got %+v", heartbeat)
+	}
+}
+
+// registerBrokers registers and unfences brokers, as their first heartbeats
+// would.
+func registerBrokers(t *testing.T, c *QuorumController, brokerIds ...int32) {
+	for _, brokerId := range brokerIds {
+		resp := c.RegisterBroker(testRegistration(brokerId))
+		if resp.ErrorCode != utils.NONE {
+			t.Fatalf("Registration of broker %d failed: %+v", brokerId, resp)
+		}
+		heartbeat := c.BrokerHeartbeat(BrokerHeartbeatRequest{BrokerID: brokerId, BrokerEpoch: resp.BrokerEpoch, CurrentMetadataOffset: resp.BrokerEpoch})
+		if heartbeat.ErrorCode != utils.NONE || heartbeat.IsFenced {
+			t.Fatalf("Broker %d was not unfenced: %+v", brokerId, heartbeat)
+		}
+	}
+}
+
+func createPartition(t *testing.T, c *QuorumController, name string, replicas []int32, isr []int32, leader int32) {
+	topicId, _ := uuid.NewV4()
+	if errorCode := c.write(
+		&metadata.TopicRecord{Name: name, TopicID: topicId},
+		&metadata.PartitionRecord{TopicID: topicId, Replicas: replicas, Isr: isr, Leader: leader},
+	); errorCode != utils.NONE {
+		t.Fatalf("Failed to create %s: error code %d", name, errorCode)
+	}
+}
+
+func partitionState(t *testing.T, name string) *metadata.ClusterTopicPartition {
+	topic, ok := metadata.Image().TopicByName(name)
+	if !ok {
+		t.Fatalf("Topic %s does not exist", name)
+	}
+	return topic.Partition(0)
+}
+
+func TestElectLeaders(t *testing.T) {
+	c := newTestController(t)
+	registerBrokers(t, c, 1, 2)
+	createPartition(t, c, "preferred", []int32{1, 2}, []int32{1, 2}, 2)
+	createPartition(t, c, "unclean", []int32{1, 3}, []int32{3}, 3)
+
+	errorCode, results := c.ElectLeaders(PREFERRED_ELECTION, []TopicPartitions{{Topic: "preferred", Partitions: []int32{0, 1}}})
+	expected := []TopicPartitionResults{{Topic: "preferred", Partitions: []PartitionResult{{Partition: 0, ErrorCode: utils.NONE}, {Partition: 1, ErrorCode: utils.UNKNOWN_TOPIC_OR_PARTITION}}}}
+	if errorCode != utils.NONE || !reflect.DeepEqual(results, expected) {
+		t.Fatalf("Expected %+v, got %d %+v", expected, errorCode, results)
+	}
+	if partition := partitionState(t, "preferred"); partition.LeaderID != 1 || partition.LeaderEpoch != 1 {
+		t.Fatalf("Expected broker 1 to lead in epoch 1, got %+v", partition)
+	}
+	_, results = c.ElectLeaders(PREFERRED_ELECTION, []TopicPartitions{{Topic: "preferred", Partitions: []int32{0}}})
+	if results[0].Partitions[0].ErrorCode != utils.ELECTION_NOT_NEEDED {
+		t.Fatalf("Expected ELECTION_NOT_NEEDED, got %+v", results)
+	}
+
+	// Broker 3 never registered, so only an unclean election finds a leader
+	_, results = c.ElectLeaders(PREFERRED_ELECTION, []TopicPartitions{{Topic: "unclean", Partitions: []int32{0}}})
+	if results[0].Partitions[0].ErrorCode != utils.PREFERRED_LEADER_NOT_AVAILABLE {
+		t.Fatalf("Expected PREFERRED_LEADER_NOT_AVAILABLE, got %+v", results)
+	}
+	errorCode, results = c.ElectLeaders(UNCLEAN_ELECTION, nil)
+	expected = []TopicPartitionResults{{Topic: "unclean", Partitions: []PartitionResult{{Partition: 0, ErrorCode: utils.NONE}}}}
+	if errorCode != utils.NONE || !reflect.DeepEqual(results, expected) {
+		t.Fatalf("Expected %+v, got %d %+v", expected, errorCode, results)
+	}
+	if partition := partitionState(t, "unclean"); partition.LeaderID != 1 || !slices.Equal(partition.InsyncReplicaNodeIDs, []int32{1}) {
+		t.Fatalf("Expected broker 1 to lead alone, got %+v", partition)
+	}
+}
+
+func TestPartitionReassignment(t *testing.T) {
+	c := newTestController(t)
+	registerBrokers(t, c, 1, 2, 3)
+	createPartition(t, c, "moving", []int32{1, 2}, []int32{1, 2}, 1)
+
+	if _, results := c.AlterPartitionReassignments([]ReassignableTopic{{Topic: "moving", Partitions: []ReassignablePartition{{Partition: 0, Replicas: []int32{2, 4}}}}}); results[0].Partitions[0].ErrorCode != utils.INVALID_REPLICA_ASSIGNMENT {
+		t.Fatalf("Expected INVALID_REPLICA_ASSIGNMENT, got %+v", results)
+	}
+	if _, results := c.AlterPartitionReassignments([]ReassignableTopic{{Topic: "moving", Partitions: []ReassignablePartition{{Partition: 0}}}}); results[0].Partitions[0].ErrorCode != utils.NO_REASSIGNMENT_IN_PROGRESS {
+		t.Fatalf("Expected NO_REASSIGNMENT_IN_PROGRESS, got %+v", results)
+	}
+
+	// Cancelling goes back to the original replicas
+	c.AlterPartitionReassignments([]ReassignableTopic{{Topic: "moving", Partitions: []ReassignablePartition{{Partition: 0, Replicas: []int32{3}}}}})
+	if partition := partitionState(t, "moving"); !slices.Equal(partition.ReplicaNodeIDs, []int32{3, 1, 2}) || partition.LeaderID != 1 {
+		t.Fatalf("Expected replicas 3, 1, 2 led by 1, got %+v", partition)
+	}
+	c.AlterPartitionReassignments([]ReassignableTopic{{Topic: "moving", Partitions: []ReassignablePartition{{Partition: 0}}}})
+	if partition := partitionState(t, "moving"); !slices.Equal(partition.ReplicaNodeIDs, []int32{1, 2}) || len(partition.AddingReplicaNodeIDs) != 0 || len(partition.RemovingReplicaNodeIDs) != 0 {
+		t.Fatalf("Expected the reassignment to be cancelled, got %+v", partition)
+	}
+
+	errorCode, results := c.AlterPartitionReassignments([]ReassignableTopic{{Topic: "moving", Partitions: []ReassignablePartition{{Partition: 0, Replicas: []int32{2, 3}}}}})
+	if errorCode != utils.NONE || results[0].Partitions[0].ErrorCode != utils.NONE {
+		t.Fatalf("Reassignment failed: %d %+v", errorCode, results)
+	}
+	expected := []OngoingTopicReassignment{{Topic: "moving", Partitions: []OngoingPartitionReassignment{{Partition: 0, Replicas: []int32{2, 3, 1}, AddingReplicas: []int32{3}, RemovingReplicas: []int32{1}}}}}
+	if ongoing := ListPartitionReassignments(metadata.Image(), nil); !reflect.DeepEqual(ongoing, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, ongoing)
+	}
+
+	// The leader adding broker 3 to the ISR completes the reassignment and
+	// hands leadership to the target replicas
+	topic, _ := metadata.Image().TopicByName("moving")
+	partition := topic.Partition(0)
+	resp := c.AlterPartition(AlterPartitionRequest{
+		BrokerID:    1,
+		BrokerEpoch: metadata.Image().Brokers[1].BrokerEpoch,
+		Topics: []AlterPartitionRequestTopic{{TopicID: topic.TopicId, Partitions: []AlterPartitionRequestPartition{{
+			LeaderEpoch:    partition.LeaderEpoch,
+			NewIsr:         []IsrMember{{BrokerID: 1, BrokerEpoch: -1}, {BrokerID: 2, BrokerEpoch: -1}, {BrokerID: 3, BrokerEpoch: -1}},
+			PartitionEpoch: partition.PartitionEpoch,
+		}}}},
+	})
+	if result := resp.Topics[0].Partitions[0]; resp.ErrorCode != utils.NONE || result.ErrorCode != utils.NONE || result.LeaderID != 2 || !slices.Equal(result.Isr, []int32{2, 3}) {
+		t.Fatalf("Expected broker 2 to lead the ISR 2, 3, got %+v", resp)
+	}
+	partition = partitionState(t, "moving")
+	if !slices.Equal(partition.ReplicaNodeIDs, []int32{2, 3}) || len(partition.AddingReplicaNodeIDs) != 0 || len(partition.RemovingReplicaNodeIDs) != 0 {
+		t.Fatalf("Expected the reassignment to complete, got %+v", partition)
+	}
+	if ongoing := ListPartitionReassignments(metadata.Image(), nil); len(ongoing) != 0 {
+		t.Fatalf("Expected no ongoing reassignments, got %+v", ongoing)
+	}
+}
+
+func TestAcls(t *testing.T) {
+	c := newTestController(t)
+
+	read := authorizer.AclBinding{
+		ResourceType:   authorizer.RESOURCE_TOPIC,
+		ResourceName:   "orders",
+		PatternType:    authorizer.PATTERN_LITERAL,
+		Principal:      "User:alice",
+		Host:           authorizer.WILDCARD_HOST,
+		Operation:      authorizer.OPERATION_READ,
+		PermissionType: authorizer.PERMISSION_ALLOW,
+	}
+	write := read
+	write.Operation = authorizer.OPERATION_WRITE
+	invalid := read
+	invalid.Principal = "alice"
+
+	results := c.CreateAcls([]authorizer.AclBinding{read, write, read, invalid})
+	if !slices.Equal(results, []int16{utils.NONE, utils.NONE, utils.NONE, utils.INVALID_REQUEST}) {
+		t.Fatalf("Unexpected results %v", results)
+	}
+	if acls := metadata.Image().AccessControlEntries; len(acls) != 2 {
+		t.Fatalf("Expected the duplicate to be dropped, got %d ACLs", len(acls))
+	}
+
+	topic := "orders"
+	deleted := c.DeleteAcls([]authorizer.AclBindingFilter{
+		{ResourceType: authorizer.RESOURCE_TOPIC, ResourceName: &topic, PatternType: authorizer.PATTERN_LITERAL,
+			Operation: authorizer.OPERATION_READ, PermissionType: authorizer.PERMISSION_ANY},
+		{ResourceType: authorizer.RESOURCE_UNKNOWN, PatternType: authorizer.PATTERN_ANY,
+			Operation: authorizer.OPERATION_ANY, PermissionType: authorizer.PERMISSION_ANY},
+	})
+	expected := []DeleteAclsResult{{ErrorCode: utils.NONE, Deleted: []authorizer.AclBinding{read}}, {ErrorCode: utils.INVALID_REQUEST}}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, deleted)
+	}
+	for _, acl := range metadata.Image().AccessControlEntries {
+		if binding := authorizer.BindingFromRecord(acl); binding != write {
+			t.Fatalf("Expected only the write ACL to remain, got %+v", binding)
+		}
+	}
+}
+
+func TestAlterUserScramCredentials(t *testing.T) {
+	c := newTestController(t)
+
+	upsert := ScramCredentialUpsertion{Name: "alice", Mechanism: sasl.SCRAM_SHA_256, Iterations: 4096, Salt: []byte("salt"), SaltedPassword: []byte("salted")}
+	weak := upsert
+	weak.Name, weak.Iterations = "bob", 100
+	unknown := upsert
+	unknown.Name, unknown.Mechanism = "carol", sasl.SCRAM_UNKNOWN
+
+	results := c.AlterUserScramCredentials(
+		[]ScramCredentialDeletion{{Name: "dave", Mechanism: sasl.SCRAM_SHA_512}},
+		[]ScramCredentialUpsertion{upsert, weak, unknown},
+	)
+	expected := []UserResult{
+		{User: "dave", ErrorCode: utils.RESOURCE_NOT_FOUND},
+		{User: "alice", ErrorCode: utils.NONE},
+		{User: "bob", ErrorCode: utils.UNACCEPTABLE_CREDENTIAL},
+		{User: "carol", ErrorCode: utils.UNSUPPORTED_SASL_MECHANISM},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, results)
+	}
+	if credentials := metadata.Image().ScramCredentials; len(credentials) != 1 {
+		t.Fatalf("Expected only alice's credential, got %d", len(credentials))
+	}
+
+	// Deleting and upserting the same credential in one request is ambiguous
+	results = c.AlterUserScramCredentials([]ScramCredentialDeletion{{Name: "alice", Mechanism: sasl.SCRAM_SHA_256}}, []ScramCredentialUpsertion{upsert})
+	if results[0].ErrorCode != utils.DUPLICATE_RESOURCE {
+		t.Fatalf("Expected a duplicate resource error, got %d", results[0].ErrorCode)
+	}
+	results = c.AlterUserScramCredentials([]ScramCredentialDeletion{{Name: "alice", Mechanism: sasl.SCRAM_SHA_256}}, nil)
+	if results[0].ErrorCode != utils.NONE || len(metadata.Image().ScramCredentials) != 0 {
+		t.Fatalf("Expected alice's credential to be deleted, got %+v", results)
+	}
+}
+
+func TestAlterClientQuotas(t *testing.T) {
+	c := newTestController(t)
+
+	alice := "alice"
+	user := []metadata.ClientQuotaEntity{{EntityType: quota.ENTITY_USER, EntityName: &alice}}
+	clientDefault := []metadata.ClientQuotaEntity{{EntityType: quota.ENTITY_CLIENT_ID}}
+	results := c.AlterClientQuotas([]ClientQuotaEntry{
+		{Entity: user, Ops: []ClientQuotaOp{{Key: quota.PRODUCER_BYTE_RATE, Value: 1024}, {Key: quota.REQUEST_PERCENTAGE, Value: 50}}},
+		{Entity: clientDefault, Ops: []ClientQuotaOp{{Key: "fetch_rate", Value: 1}}},
+		{Entity: []metadata.ClientQuotaEntity{{EntityType: "ip"}}, Ops: []ClientQuotaOp{{Key: quota.CONSUMER_BYTE_RATE, Value: 1}}},
+		{Entity: clientDefault, Ops: []ClientQuotaOp{{Key: quota.CONSUMER_BYTE_RATE, Value: -1}}},
+	}, false)
+	errorCodes := []int16{}
+	for _, result := range results {
+		errorCodes = append(errorCodes, result.ErrorCode)
+	}
+	if expected := []int16{utils.NONE, utils.INVALID_REQUEST, utils.INVALID_REQUEST, utils.INVALID_REQUEST}; !reflect.DeepEqual(errorCodes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, errorCodes)
+	}
+	expected := map[string]map[string]float64{"user=alice": {quota.PRODUCER_BYTE_RATE: 1024, quota.REQUEST_PERCENTAGE: 50}}
+	if quotas := metadata.Image().ClientQuotas; !reflect.DeepEqual(quotas, expected) {
+		t.Fatalf("Expected %v, got %v", expected, quotas)
+	}
+
+	// Validation leaves the quotas as they are
+	results = c.AlterClientQuotas([]ClientQuotaEntry{{Entity: user, Ops: []ClientQuotaOp{{Key: quota.PRODUCER_BYTE_RATE, Remove: true}}}}, true)
+	if results[0].ErrorCode != utils.NONE || !reflect.DeepEqual(metadata.Image().ClientQuotas, expected) {
+		t.Fatalf("Expected only validation, got %+v", results)
+	}
+	c.AlterClientQuotas([]ClientQuotaEntry{{Entity: user, Ops: []ClientQuotaOp{{Key: quota.PRODUCER_BYTE_RATE, Remove: true}}}}, false)
+	expected = map[string]map[string]float64{"user=alice": {quota.REQUEST_PERCENTAGE: 50}}
+	if quotas := metadata.Image().ClientQuotas; !reflect.DeepEqual(quotas, expected) {
+		t.Fatalf("Expected %v, got %v", expected, quotas)
+	}
+}
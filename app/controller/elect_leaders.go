@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"slices"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+const PREFERRED_ELECTION int8 = 0
+const UNCLEAN_ELECTION int8 = 1
+
+// TopicPartitions names partitions of a topic by index.
+type TopicPartitions struct {
+	Topic      string
+	Partitions []int32
+}
+
+type PartitionResult struct {
+	Partition int32
+	ErrorCode int16
+}
+
+type TopicPartitionResults struct {
+	Topic      string
+	Partitions []PartitionResult
+}
+
+// resultRef locates a partition's result in a []TopicPartitionResults.
+type resultRef struct {
+	topic     int
+	partition int
+}
+
+// writeChanges writes the partition changes of a request as one batch, and
+// reports a failed write as the error of every partition that had a change.
+func (c *QuorumController) writeChanges(results []TopicPartitionResults, changes []metadata.MetadataRecord, refs []resultRef) {
+	if len(changes) == 0 {
+		return
+	}
+	if errorCode := c.write(changes...); errorCode != utils.NONE {
+		for _, ref := range refs {
+			results[ref.topic].Partitions[ref.partition].ErrorCode = errorCode
+		}
+	}
+}
+
+// isUsableBroker reports whether a broker may lead partitions or join an ISR.
+func isUsableBroker(image *metadata.MetadataImage, brokerId int32) bool {
+	broker, ok := image.Brokers[brokerId]
+	return ok && !broker.Fenced && !broker.InControlledShutdown
+}
+
+// chooseLeader returns the first usable broker of candidates, or NO_LEADER.
+func chooseLeader(image *metadata.MetadataImage, candidates []int32) int32 {
+	for _, brokerId := range candidates {
+		if isUsableBroker(image, brokerId) {
+			return brokerId
+		}
+	}
+	return metadata.NO_LEADER
+}
+
+// allPartitions lists every partition in the image, for requests that leave
+// their topics null.
+func allPartitions(image *metadata.MetadataImage) []TopicPartitions {
+	topics := []TopicPartitions{}
+	for _, name := range image.TopicNames() {
+		topic, _ := image.TopicByName(name)
+		partitions := TopicPartitions{Topic: name}
+		for _, partition := range topic.Partitions {
+			partitions.Partitions = append(partitions.Partitions, partition.PartitionIndex)
+		}
+		topics = append(topics, partitions)
+	}
+	return topics
+}
+
+// ElectLeaders moves leadership of partitions to their preferred replica, the
+// first of their replicas, or for an unclean election to any live replica
+// once no ISR member is left. Null topics elect every partition and only
+// report the ones that needed an election.
+func (c *QuorumController) ElectLeaders(electionType int8, topics []TopicPartitions) (int16, []TopicPartitionResults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.isActive() {
+		return utils.NOT_CONTROLLER, nil
+	}
+	if electionType != PREFERRED_ELECTION && electionType != UNCLEAN_ELECTION {
+		return utils.INVALID_REQUEST, nil
+	}
+	image := metadata.Image()
+	all := topics == nil
+	if all {
+		topics = allPartitions(image)
+	}
+
+	results := []TopicPartitionResults{}
+	changes := []metadata.MetadataRecord{}
+	refs := []resultRef{}
+	for _, topic := range topics {
+		topicResult := TopicPartitionResults{Topic: topic.Topic}
+		clusterTopic, ok := image.TopicByName(topic.Topic)
+		for _, index := range topic.Partitions {
+			errorCode := utils.UNKNOWN_TOPIC_OR_PARTITION
+			if ok {
+				if partition := clusterTopic.Partition(index); partition != nil {
+					var change *metadata.PartitionChangeRecord
+					change, errorCode = electLeader(image, clusterTopic.TopicId, partition, electionType)
+					if change != nil {
+						changes = append(changes, change)
+						refs = append(refs, resultRef{topic: len(results), partition: len(topicResult.Partitions)})
+					}
+				}
+			}
+			if all && errorCode == utils.ELECTION_NOT_NEEDED {
+				continue
+			}
+			topicResult.Partitions = append(topicResult.Partitions, PartitionResult{Partition: index, ErrorCode: errorCode})
+		}
+		if len(topicResult.Partitions) > 0 {
+			results = append(results, topicResult)
+		}
+	}
+	c.writeChanges(results, changes, refs)
+	return utils.NONE, results
+}
+
+// electLeader returns the change that elects a new leader for partition, or
+// the error code of why there is none.
+func electLeader(image *metadata.MetadataImage, topicId uuid.UUID, partition *metadata.ClusterTopicPartition, electionType int8) (*metadata.PartitionChangeRecord, int16) {
+	change := metadata.NewPartitionChangeRecord(topicId, partition.PartitionIndex)
+	switch electionType {
+	case PREFERRED_ELECTION:
+		if len(partition.ReplicaNodeIDs) == 0 {
+			return nil, utils.PREFERRED_LEADER_NOT_AVAILABLE
+		}
+		preferred := partition.ReplicaNodeIDs[0]
+		if partition.LeaderID == preferred {
+			return nil, utils.ELECTION_NOT_NEEDED
+		}
+		if !slices.Contains(partition.InsyncReplicaNodeIDs, preferred) || !isUsableBroker(image, preferred) {
+			return nil, utils.PREFERRED_LEADER_NOT_AVAILABLE
+		}
+		change.Leader = preferred
+	case UNCLEAN_ELECTION:
+		if partition.LeaderID != metadata.NO_LEADER && isUsableBroker(image, partition.LeaderID) {
+			return nil, utils.ELECTION_NOT_NEEDED
+		}
+		change.Leader = chooseLeader(image, partition.InsyncReplicaNodeIDs)
+		if change.Leader == metadata.NO_LEADER {
+			// Records the new leader has not replicated are lost
+			change.Leader = chooseLeader(image, partition.ReplicaNodeIDs)
+			change.Isr = []int32{change.Leader}
+		}
+		if change.Leader == metadata.NO_LEADER {
+			return nil, utils.ELIGIBLE_LEADERS_NOT_AVAILABLE
+		}
+	}
+	return change, utils.NONE
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"slices"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+type ReassignablePartition struct {
+	Partition int32
+	// Replicas is the target assignment, or nil to cancel the ongoing
+	// reassignment
+	Replicas []int32
+}
+
+type ReassignableTopic struct {
+	Topic      string
+	Partitions []ReassignablePartition
+}
+
+type OngoingPartitionReassignment struct {
+	Partition        int32
+	Replicas         []int32
+	AddingReplicas   []int32
+	RemovingReplicas []int32
+}
+
+type OngoingTopicReassignment struct {
+	Topic      string
+	Partitions []OngoingPartitionReassignment
+}
+
+// without returns the replicas that are not in removed, in order.
+func without(replicas []int32, removed []int32) []int32 {
+	kept := []int32{}
+	for _, replica := range replicas {
+		if !slices.Contains(removed, replica) {
+			kept = append(kept, replica)
+		}
+	}
+	return kept
+}
+
+// intersect returns the replicas that are also in other, in order.
+func intersect(replicas []int32, other []int32) []int32 {
+	kept := []int32{}
+	for _, replica := range replicas {
+		if slices.Contains(other, replica) {
+			kept = append(kept, replica)
+		}
+	}
+	return kept
+}
+
+func isReassigning(partition *metadata.ClusterTopicPartition) bool {
+	return len(partition.AddingReplicaNodeIDs) > 0 || len(partition.RemovingReplicaNodeIDs) > 0
+}
+
+// maybeCompleteReassignment adds the end of a reassignment to change once
+// every adding replica of partition is in the ISR, the one change proposes
+// or the current one. The removing replicas leave the replicas and the ISR,
+// and leadership moves off them.
+func maybeCompleteReassignment(image *metadata.MetadataImage, partition *metadata.ClusterTopicPartition, change *metadata.PartitionChangeRecord) {
+	if !isReassigning(partition) {
+		return
+	}
+	isr := partition.InsyncReplicaNodeIDs
+	if change.Isr != nil {
+		isr = change.Isr
+	}
+	for _, adding := range partition.AddingReplicaNodeIDs {
+		if !slices.Contains(isr, adding) {
+			return
+		}
+	}
+	target := without(partition.ReplicaNodeIDs, partition.RemovingReplicaNodeIDs)
+	targetIsr := intersect(isr, target)
+	leader := partition.LeaderID
+	if !slices.Contains(target, leader) {
+		if leader = chooseLeader(image, targetIsr); leader == metadata.NO_LEADER {
+			return
+		}
+		change.Leader = leader
+	}
+	change.Replicas = target
+	change.Isr = targetIsr
+	change.AddingReplicas = []int32{}
+	change.RemovingReplicas = []int32{}
+}
+
+// validateAssignment checks that a target assignment names distinct,
+// registered brokers.
+func validateAssignment(image *metadata.MetadataImage, replicas []int32) int16 {
+	if len(replicas) == 0 {
+		return utils.INVALID_REPLICA_ASSIGNMENT
+	}
+	for i, replica := range replicas {
+		if _, ok := image.Brokers[replica]; !ok || slices.Contains(replicas[:i], replica) {
+			return utils.INVALID_REPLICA_ASSIGNMENT
+		}
+	}
+	return utils.NONE
+}
+
+// AlterPartitionReassignments starts, replaces or cancels the reassignment
+// of partitions. While one is ongoing a partition's replicas are the target
+// followed by the replicas being removed, and it completes when the adding
+// replicas have joined the ISR.
+func (c *QuorumController) AlterPartitionReassignments(topics []ReassignableTopic) (int16, []TopicPartitionResults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.isActive() {
+		return utils.NOT_CONTROLLER, nil
+	}
+	image := metadata.Image()
+	results := []TopicPartitionResults{}
+	changes := []metadata.MetadataRecord{}
+	refs := []resultRef{}
+	for _, topic := range topics {
+		topicResult := TopicPartitionResults{Topic: topic.Topic}
+		clusterTopic, ok := image.TopicByName(topic.Topic)
+		for _, reassignment := range topic.Partitions {
+			errorCode := utils.UNKNOWN_TOPIC_OR_PARTITION
+			if ok {
+				if partition := clusterTopic.Partition(reassignment.Partition); partition != nil {
+					var change *metadata.PartitionChangeRecord
+					change, errorCode = reassign(image, clusterTopic.TopicId, partition, reassignment.Replicas)
+					if change != nil {
+						changes = append(changes, change)
+						refs = append(refs, resultRef{topic: len(results), partition: len(topicResult.Partitions)})
+					}
+				}
+			}
+			topicResult.Partitions = append(topicResult.Partitions, PartitionResult{Partition: reassignment.Partition, ErrorCode: errorCode})
+		}
+		results = append(results, topicResult)
+	}
+	c.writeChanges(results, changes, refs)
+	return utils.NONE, results
+}
+
+// reassign returns the change that starts, replaces or cancels the
+// reassignment of partition, if one is needed, or the error code of why it
+// cannot be done.
+func reassign(image *metadata.MetadataImage, topicId uuid.UUID, partition *metadata.ClusterTopicPartition, target []int32) (*metadata.PartitionChangeRecord, int16) {
+	change := metadata.NewPartitionChangeRecord(topicId, partition.PartitionIndex)
+	if target == nil {
+		if !isReassigning(partition) {
+			return nil, utils.NO_REASSIGNMENT_IN_PROGRESS
+		}
+		// Go back to the replicas from before the reassignment
+		original := without(partition.ReplicaNodeIDs, partition.AddingReplicaNodeIDs)
+		change.Replicas = original
+		change.Isr = intersect(partition.InsyncReplicaNodeIDs, original)
+		change.AddingReplicas = []int32{}
+		change.RemovingReplicas = []int32{}
+		if !slices.Contains(original, partition.LeaderID) {
+			change.Leader = chooseLeader(image, change.Isr)
+		}
+		return change, utils.NONE
+	}
+
+	if errorCode := validateAssignment(image, target); errorCode != utils.NONE {
+		return nil, errorCode
+	}
+	if !isReassigning(partition) && slices.Equal(target, partition.ReplicaNodeIDs) {
+		return nil, utils.NONE
+	}
+	removing := without(partition.ReplicaNodeIDs, target)
+	adding := without(target, partition.ReplicaNodeIDs)
+	change.Replicas = append(slices.Clone(target), removing...)
+	change.AddingReplicas = adding
+	change.RemovingReplicas = removing
+
+	reassigning := *partition
+	reassigning.ReplicaNodeIDs = change.Replicas
+	reassigning.AddingReplicaNodeIDs = adding
+	reassigning.RemovingReplicaNodeIDs = removing
+	maybeCompleteReassignment(image, &reassigning, change)
+	return change, utils.NONE
+}
+
+// ListPartitionReassignments returns the ongoing reassignments of the named
+// partitions, or of every partition when topics is nil.
+func ListPartitionReassignments(image *metadata.MetadataImage, topics []TopicPartitions) []OngoingTopicReassignment {
+	if topics == nil {
+		topics = allPartitions(image)
+	}
+	results := []OngoingTopicReassignment{}
+	for _, topic := range topics {
+		clusterTopic, ok := image.TopicByName(topic.Topic)
+		if !ok {
+			continue
+		}
+		topicResult := OngoingTopicReassignment{Topic: topic.Topic}
+		for _, index := range topic.Partitions {
+			partition := clusterTopic.Partition(index)
+			if partition == nil || !isReassigning(partition) {
+				continue
+			}
+			topicResult.Partitions = append(topicResult.Partitions, OngoingPartitionReassignment{
+				Partition:        index,
+				Replicas:         partition.ReplicaNodeIDs,
+				AddingReplicas:   partition.AddingReplicaNodeIDs,
+				RemovingReplicas: partition.RemovingReplicaNodeIDs,
+			})
+		}
+		if len(topicResult.Partitions) > 0 {
+			results = append(results, topicResult)
+		}
+	}
+	return results
+}
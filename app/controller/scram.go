@@ -0,0 +1,106 @@
+package controller
+
+import (
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/sasl"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type ScramCredentialDeletion struct {
+	Name      string
+	Mechanism int8
+}
+
+// ScramCredentialUpsertion carries the salted password the client derived,
+// so the password itself never reaches the cluster.
+type ScramCredentialUpsertion struct {
+	Name           string
+	Mechanism      int8
+	Iterations     int32
+	Salt           []byte
+	SaltedPassword []byte
+}
+
+type UserResult struct {
+	User      string
+	ErrorCode int16
+}
+
+// AlterUserScramCredentials deletes and upserts credentials, and returns an
+// error code per user in the order they were named. A user is only altered
+// if all of their changes are valid.
+func (c *QuorumController) AlterUserScramCredentials(deletions []ScramCredentialDeletion, upsertions []ScramCredentialUpsertion) []UserResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	users := []string{}
+	errorCodes := map[string]int16{}
+	records := map[string][]metadata.MetadataRecord{}
+	altered := map[metadata.ScramCredentialKey]bool{}
+	alter := func(name string, mechanism int8, validate func() int16, record metadata.MetadataRecord) {
+		if _, ok := errorCodes[name]; !ok {
+			users = append(users, name)
+			errorCodes[name] = utils.NONE
+		}
+		key := metadata.ScramCredentialKey{Name: name, Mechanism: mechanism}
+		errorCode := validate()
+		if errorCode == utils.NONE && altered[key] {
+			errorCode = utils.DUPLICATE_RESOURCE
+		}
+		altered[key] = true
+		if errorCode != utils.NONE {
+			if errorCodes[name] == utils.NONE {
+				errorCodes[name] = errorCode
+			}
+			return
+		}
+		records[name] = append(records[name], record)
+	}
+
+	image := metadata.Image()
+	validateMechanism := func(name string, mechanism int8) int16 {
+		if name == "" {
+			return utils.UNACCEPTABLE_CREDENTIAL
+		}
+		if sasl.ScramMechanismName(mechanism) == "" {
+			return utils.UNSUPPORTED_SASL_MECHANISM
+		}
+		return utils.NONE
+	}
+	for _, deletion := range deletions {
+		alter(deletion.Name, deletion.Mechanism, func() int16 {
+			if errorCode := validateMechanism(deletion.Name, deletion.Mechanism); errorCode != utils.NONE {
+				return errorCode
+			}
+			if _, ok := image.ScramCredentials[metadata.ScramCredentialKey{Name: deletion.Name, Mechanism: deletion.Mechanism}]; !ok {
+				return utils.RESOURCE_NOT_FOUND
+			}
+			return utils.NONE
+		}, &metadata.RemoveUserScramCredentialRecord{Name: deletion.Name, Mechanism: deletion.Mechanism})
+	}
+	for _, upsertion := range upsertions {
+		alter(upsertion.Name, upsertion.Mechanism, func() int16 {
+			if errorCode := validateMechanism(upsertion.Name, upsertion.Mechanism); errorCode != utils.NONE {
+				return errorCode
+			}
+			if upsertion.Iterations < sasl.SCRAM_MIN_ITERATIONS || upsertion.Iterations > sasl.SCRAM_MAX_ITERATIONS ||
+				len(upsertion.Salt) == 0 || len(upsertion.SaltedPassword) == 0 {
+				return utils.UNACCEPTABLE_CREDENTIAL
+			}
+			return utils.NONE
+		}, sasl.NewScramCredential(upsertion.Name, upsertion.Mechanism, upsertion.Salt, upsertion.SaltedPassword, upsertion.Iterations))
+	}
+
+	results := []UserResult{}
+	for _, user := range users {
+		errorCode := errorCodes[user]
+		if errorCode == utils.NONE && !c.isActive() {
+			errorCode = utils.NOT_CONTROLLER
+		}
+		if errorCode == utils.NONE {
+			errorCode = c.write(records[user]...)
+		}
+		results = append(results, UserResult{User: user, ErrorCode: errorCode})
+	}
+	return results
+}
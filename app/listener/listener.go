@@ -0,0 +1,93 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const PROTOCOL_PLAINTEXT = "PLAINTEXT"
+const PROTOCOL_SSL = "SSL"
+const PROTOCOL_SASL_PLAINTEXT = "SASL_PLAINTEXT"
+const PROTOCOL_SASL_SSL = "SASL_SSL"
+
+// SECURITY_PROTOCOL_IDS number the security protocols as broker registrations
+// do
+var SECURITY_PROTOCOL_IDS = map[string]int16{
+	PROTOCOL_PLAINTEXT:      0,
+	PROTOCOL_SSL:            1,
+	PROTOCOL_SASL_PLAINTEXT: 2,
+	PROTOCOL_SASL_SSL:       3,
+}
+
+// Listener is an entry of listeners, NAME://host:port, with the security
+// protocol its name maps to.
+type Listener struct {
+	Name             string
+	SecurityProtocol string
+	Host             string
+	Port             int
+}
+
+func (l Listener) Address() string {
+	return net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+}
+
+func (l Listener) IsSasl() bool {
+	return l.SecurityProtocol == PROTOCOL_SASL_PLAINTEXT || l.SecurityProtocol == PROTOCOL_SASL_SSL
+}
+
+func (l Listener) IsTls() bool {
+	return l.SecurityProtocol == PROTOCOL_SSL || l.SecurityProtocol == PROTOCOL_SASL_SSL
+}
+
+// ParseProtocolMap reads listener.security.protocol.map, NAME:PROTOCOL
+// pairs. Without one, the protocols map to themselves.
+func ParseProtocolMap(mappings []string) (map[string]string, error) {
+	protocols := map[string]string{}
+	if len(mappings) == 0 {
+		for protocol := range SECURITY_PROTOCOL_IDS {
+			protocols[protocol] = protocol
+		}
+	}
+	for _, mapping := range mappings {
+		name, protocol, ok := strings.Cut(mapping, ":")
+		if _, known := SECURITY_PROTOCOL_IDS[protocol]; !ok || !known {
+			return nil, fmt.Errorf("invalid security protocol mapping %q", mapping)
+		}
+		protocols[name] = protocol
+	}
+	return protocols, nil
+}
+
+// ParseListeners reads listeners entries, whose names must be unique and
+// mapped to a security protocol.
+func ParseListeners(entries []string, protocols map[string]string) ([]Listener, error) {
+	listeners := []Listener{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		name, address, ok := strings.Cut(entry, "://")
+		if !ok {
+			return nil, fmt.Errorf("invalid listener %q", entry)
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener %q: %w", entry, err)
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil || portNumber < 0 || portNumber > 65535 {
+			return nil, fmt.Errorf("invalid port in listener %q", entry)
+		}
+		protocol, ok := protocols[name]
+		if !ok {
+			return nil, fmt.Errorf("no security protocol for listener %s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate listener %s", name)
+		}
+		seen[name] = true
+		listeners = append(listeners, Listener{Name: name, SecurityProtocol: protocol, Host: host, Port: portNumber})
+	}
+	return listeners, nil
+}
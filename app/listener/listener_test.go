@@ -0,0 +1,225 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseListeners(t *testing.T) {
+	protocols, err := ParseProtocolMap([]string{"CLIENT:SASL_SSL", "REPLICATION:PLAINTEXT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners, err := ParseListeners([]string{"CLIENT://:9093", "REPLICATION://10.0.0.1:9094"}, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Listener{
+		{Name: "CLIENT", SecurityProtocol: PROTOCOL_SASL_SSL, Host: "", Port: 9093},
+		{Name: "REPLICATION", SecurityProtocol: PROTOCOL_PLAINTEXT, Host: "10.0.0.1", Port: 9094},
+	}
+	if !reflect.DeepEqual(listeners, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, listeners)
+	}
+	if !listeners[0].IsSasl() || !listeners[0].IsTls() || listeners[1].IsSasl() || listeners[1].IsTls() {
+		t.Fatalf("Unexpected protocols of %+v", listeners)
+	}
+
+	defaults, _ := ParseProtocolMap(nil)
+	for _, invalid := range [][]string{
+		{"CLIENT://:9093"},
+		{"PLAINTEXT://:9092", "PLAINTEXT://:9093"},
+		{"SSL:9093"},
+		{"SSL://:port"},
+	} {
+		if _, err := ParseListeners(invalid, defaults); err == nil {
+			t.Errorf("Expected %v to be invalid", invalid)
+		}
+	}
+	if _, err := ParseProtocolMap([]string{"CLIENT:TLS"}); err == nil {
+		t.Error("Expected an unknown protocol to be invalid")
+	}
+}
+
+func TestPrincipalMapper(t *testing.T) {
+	mapper, err := NewPrincipalMapper(`RULE:^CN=(.*?),OU=ServiceUsers.*$/$1/,RULE:^CN=(.*?),OU=(.*?),O=.*$/$1@$2/L, DEFAULT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"CN=orders,OU=ServiceUsers,O=Example,C=US": "orders",
+		"CN=Alice,OU=Payments,O=Example,C=US":      "alice@payments",
+		"CN=bob,O=Example":                         "CN=bob,O=Example",
+	}
+	for distinguishedName, name := range cases {
+		if mapped := mapper.Name(distinguishedName); mapped != name {
+			t.Errorf("Expected %s to map to %s, got %s", distinguishedName, name, mapped)
+		}
+	}
+	if _, err := NewPrincipalMapper("RULE:(/x/"); err == nil {
+		t.Error("Expected an invalid rule to be rejected")
+	}
+}
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, subject pkix.Name, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		parent, signer = issuer.certificate, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	return &testCertificate{certificate: certificate, key: key}
+}
+
+func (c *testCertificate) write(t *testing.T, certificateFile string, keyFile string) {
+	if err := os.WriteFile(certificateFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.certificate.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	der, _ := x509.MarshalECPrivateKey(c.key)
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.certificate.Raw}, PrivateKey: c.key}
+}
+
+// handshake connects a client to a server using config, and returns the
+// certificate each side saw.
+func handshake(t *testing.T, config *tls.Config, client *tls.Config) (*x509.Certificate, *x509.Certificate, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	servers := make(chan *tls.Conn, 1)
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		servers <- conn.(*tls.Conn)
+		errs <- conn.(*tls.Conn).Handshake()
+	}()
+	tlsClient, err := tls.Dial("tcp", l.Addr().String(), client)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tlsClient.Close()
+	server := <-servers
+	if err := <-errs; err != nil {
+		return nil, nil, err
+	}
+	var clientCertificate *x509.Certificate
+	if certificates := server.ConnectionState().PeerCertificates; len(certificates) > 0 {
+		clientCertificate = certificates[0]
+	}
+	return tlsClient.ConnectionState().PeerCertificates[0], clientCertificate, nil
+}
+
+func TestCertificateStore(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, pkix.Name{CommonName: "test-ca"}, nil)
+	clientCa := newTestCertificate(t, pkix.Name{CommonName: "client-ca"}, nil)
+	config := TlsConfig{
+		CertificateFile: filepath.Join(dir, "broker.crt"),
+		KeyFile:         filepath.Join(dir, "broker.key"),
+		ClientCaFile:    filepath.Join(dir, "clients.crt"),
+		ClientAuth:      CLIENT_AUTH_REQUIRED,
+	}
+	broker := newTestCertificate(t, pkix.Name{CommonName: "localhost"}, ca)
+	broker.write(t, config.CertificateFile, config.KeyFile)
+	clientCa.write(t, config.ClientCaFile, "")
+
+	store, err := NewCertificateStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	client := newTestCertificate(t, pkix.Name{CommonName: "orders", OrganizationalUnit: []string{"ServiceUsers"}}, clientCa)
+	clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{client.tlsCertificate()}}
+
+	serverCertificate, clientCertificate, err := handshake(t, store.TlsConfig(), clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverCertificate.Raw, broker.certificate.Raw) {
+		t.Fatal("Expected the broker's certificate")
+	}
+	mapper, _ := NewPrincipalMapper(`RULE:^CN=(.*?),OU=ServiceUsers$/$1/`)
+	if principal := mapper.Principal(clientCertificate); principal != "User:orders" {
+		t.Fatalf("Expected User:orders, got %s", principal)
+	}
+
+	// Clients without a certificate are turned away when one is required
+	if _, _, err := handshake(t, store.TlsConfig(), &tls.Config{RootCAs: roots, ServerName: "localhost"}); err == nil {
+		t.Fatal("Expected the handshake without a client certificate to fail")
+	}
+
+	// A rotated certificate is served from the next handshake on
+	rotated := newTestCertificate(t, pkix.Name{CommonName: "localhost"}, ca)
+	rotated.write(t, config.CertificateFile, config.KeyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(config.CertificateFile, later, later)
+	os.Chtimes(config.KeyFile, later, later)
+	serverCertificate, _, err = handshake(t, store.TlsConfig(), clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverCertificate.Raw, rotated.certificate.Raw) {
+		t.Fatal("Expected the rotated certificate")
+	}
+
+	// A broken file keeps the previous certificate in use
+	os.WriteFile(config.KeyFile, []byte("not a key"), 0600)
+	os.Chtimes(config.KeyFile, later.Add(time.Minute), later.Add(time.Minute))
+	serverCertificate, _, err = handshake(t, store.TlsConfig(), clientConfig)
+	if err != nil || !bytes.Equal(serverCertificate.Raw, rotated.certificate.Raw) {
+		t.Fatalf("Expected the rotated certificate to stay in use: %v", err)
+	}
+}
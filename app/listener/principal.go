@@ -0,0 +1,81 @@
+package listener
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// principalRule is a rule of ssl.principal.mapping.rules: RULE:pattern/
+// replacement/ with an optional L or U to change case, or DEFAULT.
+type principalRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+	toLower     bool
+	toUpper     bool
+	isDefault   bool
+}
+
+var principalRuleSyntax = regexp.MustCompile(`^\s*(?:RULE:((?:\\.|[^\\/])*)/((?:\\.|[^\\/])*)/([LU]?)|(DEFAULT))\s*(?:,|$)`)
+
+// PrincipalMapper turns the subject of a client certificate into the name of
+// its principal, by the first rule whose pattern matches it.
+type PrincipalMapper struct {
+	rules []principalRule
+}
+
+func NewPrincipalMapper(rules string) (*PrincipalMapper, error) {
+	mapper := &PrincipalMapper{}
+	if strings.TrimSpace(rules) == "" {
+		rules = "DEFAULT"
+	}
+	for rest := rules; strings.TrimSpace(rest) != ""; {
+		match := principalRuleSyntax.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("invalid principal mapping rule %q", rest)
+		}
+		rest = rest[len(match[0]):]
+		if match[4] != "" {
+			mapper.rules = append(mapper.rules, principalRule{isDefault: true})
+			continue
+		}
+		pattern, err := regexp.Compile("^(?:" + strings.ReplaceAll(match[1], `\/`, "/") + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid principal mapping rule %q: %w", match[0], err)
+		}
+		mapper.rules = append(mapper.rules, principalRule{
+			pattern:     pattern,
+			replacement: strings.ReplaceAll(match[2], `\/`, "/"),
+			toLower:     match[3] == "L",
+			toUpper:     match[3] == "U",
+		})
+	}
+	return mapper, nil
+}
+
+// Name maps a distinguished name, as RFC 2253 writes it. A name no rule
+// matches is kept as it is.
+func (m *PrincipalMapper) Name(distinguishedName string) string {
+	for _, rule := range m.rules {
+		if rule.isDefault {
+			return distinguishedName
+		}
+		if !rule.pattern.MatchString(distinguishedName) {
+			continue
+		}
+		name := rule.pattern.ReplaceAllString(distinguishedName, rule.replacement)
+		if rule.toLower {
+			name = strings.ToLower(name)
+		} else if rule.toUpper {
+			name = strings.ToUpper(name)
+		}
+		return name
+	}
+	return distinguishedName
+}
+
+// Principal is the principal of a client that presented certificate.
+func (m *PrincipalMapper) Principal(certificate *x509.Certificate) string {
+	return "User:" + m.Name(certificate.Subject.String())
+}
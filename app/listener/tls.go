@@ -0,0 +1,117 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const CLIENT_AUTH_NONE = "none"
+const CLIENT_AUTH_REQUESTED = "requested"
+const CLIENT_AUTH_REQUIRED = "required"
+
+type TlsConfig struct {
+	CertificateFile string
+	KeyFile         string
+	// ClientCaFile verifies client certificates for mTLS, when ClientAuth asks
+	// for them
+	ClientCaFile string
+	ClientAuth   string
+}
+
+// CertificateStore serves the certificate, key and client CA of a TLS
+// listener. Files are checked on every handshake and reloaded once they
+// change, so certificates can be rotated without a restart.
+type CertificateStore struct {
+	config TlsConfig
+
+	mu       sync.Mutex
+	modTimes [3]time.Time
+	current  *tls.Config
+}
+
+func NewCertificateStore(config TlsConfig) (*CertificateStore, error) {
+	switch config.ClientAuth {
+	case "", CLIENT_AUTH_NONE, CLIENT_AUTH_REQUESTED, CLIENT_AUTH_REQUIRED:
+	default:
+		return nil, fmt.Errorf("invalid client auth %q", config.ClientAuth)
+	}
+	if config.ClientAuth != "" && config.ClientAuth != CLIENT_AUTH_NONE && config.ClientCaFile == "" {
+		return nil, fmt.Errorf("client auth %s needs a client CA", config.ClientAuth)
+	}
+	s := &CertificateStore{config: config}
+	if err := s.reload(s.fileModTimes()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// TlsConfig is the configuration for listeners to serve, which resolves the
+// current files per connection.
+func (s *CertificateStore) TlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.Current(), nil
+		},
+	}
+}
+
+// Current is the configuration of the current files. A change that fails to
+// load is logged and the previous files kept.
+func (s *CertificateStore) Current() *tls.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if modTimes := s.fileModTimes(); modTimes != s.modTimes {
+		if err := s.reload(modTimes); err != nil {
+			log.Printf("Failed to reload %s: %s\n", s.config.CertificateFile, err.Error())
+			s.modTimes = modTimes
+		}
+	}
+	return s.current
+}
+
+func (s *CertificateStore) fileModTimes() [3]time.Time {
+	modTimes := [3]time.Time{}
+	for i, file := range []string{s.config.CertificateFile, s.config.KeyFile, s.config.ClientCaFile} {
+		if info, err := os.Stat(file); file != "" && err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (s *CertificateStore) reload(modTimes [3]time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(s.config.CertificateFile, s.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if s.config.ClientCaFile != "" {
+		pem, err := os.ReadFile(s.config.ClientCaFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", s.config.ClientCaFile)
+		}
+		config.ClientCAs = pool
+	}
+	switch s.config.ClientAuth {
+	case CLIENT_AUTH_REQUESTED:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case CLIENT_AUTH_REQUIRED:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	s.current = config
+	s.modTimes = modTimes
+	return nil
+}
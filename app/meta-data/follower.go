@@ -0,0 +1,175 @@
+package metadata
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+)
+
+var MetadataPollInterval = 500 * time.Millisecond
+
+// CommittedOffset, when set, limits the follower to the records the Raft
+// quorum has committed, i.e. those before the returned offset.
+var CommittedOffset func() int64
+
+// MetadataSubscriber is called after each published image with the image it
+// replaced and what changed between the two.
+type MetadataSubscriber func(previous *MetadataImage, image *MetadataImage, changes MetadataChanges)
+
+var subscribers []MetadataSubscriber
+var subscribersLock sync.Mutex
+
+func Subscribe(subscriber MetadataSubscriber) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	subscribers = append(subscribers, subscriber)
+}
+
+func notifySubscribers(previous *MetadataImage, image *MetadataImage, changes MetadataChanges) {
+	subscribersLock.Lock()
+	current := append([]MetadataSubscriber{}, subscribers...)
+	subscribersLock.Unlock()
+
+	for _, subscriber := range current {
+		subscriber(previous, image, changes)
+	}
+}
+
+// MetadataFollower tails the __cluster_metadata log written by the
+// controller and publishes each new batch. It starts from the newest
+// snapshot, and goes back to it when the log it was reading is truncated.
+type MetadataFollower struct {
+	dir        string
+	loader     *MetadataLoader
+	nextOffset int64
+	segment    int64
+	position   int64
+	// Snapshotter, if set, snapshots and truncates the log as it grows
+	Snapshotter *MetadataSnapshotter
+}
+
+func NewMetadataFollower(dir string) *MetadataFollower {
+	return &MetadataFollower{dir: dir, segment: -1}
+}
+
+func (f *MetadataFollower) NextOffset() int64 {
+	return f.nextOffset
+}
+
+func (f *MetadataFollower) loadSnapshot(snapshot *SnapshotID) error {
+	f.loader = NewMetadataLoader(EmptyImage())
+	f.nextOffset = 0
+	f.segment = -1
+	f.position = 0
+	if snapshot == nil {
+		return nil
+	}
+	data, err := os.ReadFile(SnapshotFileName(f.dir, *snapshot))
+	if err != nil {
+		return err
+	}
+	batches, err := record.DecodeBatches(data)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		f.loader.HandleBatch(batch, 0)
+	}
+	f.nextOffset = snapshot.EndOffset
+	f.loader.delta.SetOffset(snapshot.EndOffset-1, snapshot.Epoch)
+	return nil
+}
+
+// readSegment replays the complete batches appended to a segment since the
+// last poll, returning how many bytes they took.
+func (f *MetadataFollower) readSegment(baseOffset int64) (int64, error) {
+	if baseOffset != f.segment {
+		f.segment = baseOffset
+		f.position = 0
+	}
+	file, err := os.Open(filepath.Join(f.dir, fmt.Sprintf("%020d%s", baseOffset, METADATA_LOG_SUFFIX)))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(f.position, io.SeekStart); err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+
+	batches, err := record.DecodeBatches(data)
+	read := int64(0)
+	for _, batch := range batches {
+		if CommittedOffset != nil && batch.LastOffset() >= CommittedOffset() {
+			break
+		}
+		f.loader.HandleBatch(batch, f.nextOffset)
+		f.nextOffset = max(f.nextOffset, batch.NextOffset())
+		read += int64(batch.SizeInBytes())
+	}
+	f.position += read
+	return read, err
+}
+
+// Poll applies whatever was written since the last call and publishes it.
+func (f *MetadataFollower) Poll() error {
+	snapshot, segments, err := listMetadataFiles(f.dir)
+	if err != nil {
+		return err
+	}
+
+	// Start over from the snapshot on the first poll, or once the records
+	// still to be read have been truncated away
+	truncated := len(segments) > 0 && segments[0] > f.nextOffset
+	if f.loader == nil || (truncated && snapshot != nil && snapshot.EndOffset > f.nextOffset) {
+		if err := f.loadSnapshot(snapshot); err != nil {
+			return err
+		}
+	}
+
+	previousOffset := f.loader.delta.offset
+	appliedBytes := int64(0)
+	for i, baseOffset := range segments {
+		if i+1 < len(segments) && segments[i+1] <= f.nextOffset {
+			continue
+		}
+		read, err := f.readSegment(baseOffset)
+		appliedBytes += read
+		if err != nil {
+			return err
+		}
+	}
+
+	image := f.loader.delta.image
+	if f.loader.delta.offset != previousOffset || !f.loader.delta.Changes().IsEmpty() || Image() != image {
+		image = f.loader.Publish()
+	}
+	if f.Snapshotter != nil {
+		f.Snapshotter.MaybeSnapshot(image, appliedBytes, time.Now())
+	}
+	return nil
+}
+
+func (f *MetadataFollower) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(MetadataPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := f.Poll(); err != nil {
+				log.Printf("Failed to follow metadata log: %s\n", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package metadata
+
+import (
+	"maps"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// MetadataImage is an immutable view of the cluster metadata as of Offset.
+// Readers share it without locking, so none of its maps or the topics and
+// brokers they point to may be modified once published.
+type MetadataImage struct {
+	Offset               int64
+	Epoch                int32
+	topicsByName         topicMap[string]
+	topicsById           topicMap[uuid.UUID]
+	Brokers              map[int32]*BrokerRegistration
+	Features             map[string]int16
+	Configs              map[ConfigResource]map[string]string
+	AccessControlEntries map[uuid.UUID]*AccessControlEntryRecord
+	ClientQuotas         map[string]map[string]float64
+	ScramCredentials     map[ScramCredentialKey]*UserScramCredentialRecord
+	NextProducerID       int64
+}
+
+// ScramCredentialKey identifies the credential of a user for one SCRAM
+// mechanism.
+type ScramCredentialKey struct {
+	Name      string
+	Mechanism int8
+}
+
+func EmptyImage() *MetadataImage {
+	return &MetadataImage{
+		Offset:               -1,
+		Epoch:                -1,
+		topicsByName:         newTopicMap(hashTopicName),
+		topicsById:           newTopicMap(hashTopicId),
+		Brokers:              map[int32]*BrokerRegistration{},
+		Features:             map[string]int16{},
+		Configs:              map[ConfigResource]map[string]string{},
+		AccessControlEntries: map[uuid.UUID]*AccessControlEntryRecord{},
+		ClientQuotas:         map[string]map[string]float64{},
+		ScramCredentials:     map[ScramCredentialKey]*UserScramCredentialRecord{},
+	}
+}
+
+var currentImage atomic.Pointer[MetadataImage]
+
+func init() {
+	currentImage.Store(EmptyImage())
+}
+
+// Image returns the latest published image. Callers should fetch it once per
+// request so that every lookup sees the same version.
+func Image() *MetadataImage {
+	return currentImage.Load()
+}
+
+// published is closed, and replaced, whenever an image is published.
+var published = make(chan struct{})
+var publishedLock sync.Mutex
+
+func Publish(image *MetadataImage) {
+	currentImage.Store(image)
+	publishedLock.Lock()
+	close(published)
+	published = make(chan struct{})
+	publishedLock.Unlock()
+}
+
+// WaitForOffset waits up to timeout for an image that includes the record at
+// offset to be published, and reports whether one was.
+func WaitForOffset(offset int64, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		publishedLock.Lock()
+		next := published
+		publishedLock.Unlock()
+		if Image().Offset >= offset {
+			return true
+		}
+		select {
+		case <-next:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+func (img *MetadataImage) TopicByName(name string) (*ClusterTopic, bool) {
+	return img.topicsByName.get(name)
+}
+
+func (img *MetadataImage) TopicById(topicId uuid.UUID) (*ClusterTopic, bool) {
+	return img.topicsById.get(topicId)
+}
+
+func (img *MetadataImage) TopicCount() int {
+	return img.topicsByName.len()
+}
+
+// TopicNames returns every topic name in sorted order.
+func (img *MetadataImage) TopicNames() []string {
+	names := make([]string, 0, img.topicsByName.len())
+	img.topicsByName.each(func(name string, _ *ClusterTopic) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+// LiveBrokers returns the registered brokers that are not fenced, by id.
+func (img *MetadataImage) LiveBrokers() []*BrokerRegistration {
+	brokers := []*BrokerRegistration{}
+	for _, broker := range img.Brokers {
+		if !broker.Fenced {
+			brokers = append(brokers, broker)
+		}
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].BrokerID < brokers[j].BrokerID })
+	return brokers
+}
+
+type TopicIdPartition struct {
+	TopicId   uuid.UUID
+	Partition int32
+}
+
+// MetadataChanges lists what a delta touched, for subscribers that only
+// care about part of the image.
+type MetadataChanges struct {
+	Topics           map[uuid.UUID]bool
+	Partitions       map[TopicIdPartition]bool
+	Configs          map[ConfigResource]bool
+	Brokers          map[int32]bool
+	Features         bool
+	Acls             bool
+	ClientQuotas     bool
+	ScramCredentials bool
+}
+
+func (c MetadataChanges) IsEmpty() bool {
+	return len(c.Topics) == 0 && len(c.Partitions) == 0 && len(c.Configs) == 0 && len(c.Brokers) == 0 && !c.Features && !c.Acls && !c.ClientQuotas && !c.ScramCredentials
+}
+
+// MetadataDelta collects changes on top of an image. Only the topics and
+// maps that change are copied, the rest is shared with the base image.
+type MetadataDelta struct {
+	image          *MetadataImage
+	offset         int64
+	epoch          int32
+	changedTopics  map[uuid.UUID]*ClusterTopic
+	removedTopics  map[uuid.UUID]string
+	brokers        map[int32]*BrokerRegistration
+	features       map[string]int16
+	configs        map[ConfigResource]map[string]string
+	acls           map[uuid.UUID]*AccessControlEntryRecord
+	clientQuotas   map[string]map[string]float64
+	scram          map[ScramCredentialKey]*UserScramCredentialRecord
+	nextProducerID int64
+	changes        MetadataChanges
+}
+
+func NewMetadataDelta(image *MetadataImage) *MetadataDelta {
+	return &MetadataDelta{
+		image:          image,
+		offset:         image.Offset,
+		epoch:          image.Epoch,
+		changedTopics:  map[uuid.UUID]*ClusterTopic{},
+		removedTopics:  map[uuid.UUID]string{},
+		nextProducerID: image.NextProducerID,
+		changes: MetadataChanges{
+			Topics:     map[uuid.UUID]bool{},
+			Partitions: map[TopicIdPartition]bool{},
+			Configs:    map[ConfigResource]bool{},
+			Brokers:    map[int32]bool{},
+		},
+	}
+}
+
+func (d *MetadataDelta) Changes() MetadataChanges {
+	return d.changes
+}
+
+func (d *MetadataDelta) SetOffset(offset int64, epoch int32) {
+	d.offset = offset
+	d.epoch = epoch
+}
+
+// topic returns a copy of a topic that is safe to change, or nil if the
+// topic does not exist.
+func (d *MetadataDelta) topic(topicId uuid.UUID) *ClusterTopic {
+	if topic, ok := d.changedTopics[topicId]; ok {
+		return topic
+	}
+	if _, removed := d.removedTopics[topicId]; removed {
+		return nil
+	}
+	base, ok := d.image.topicsById.get(topicId)
+	if !ok {
+		return nil
+	}
+	topic := *base
+	topic.Partitions = append([]ClusterTopicPartition{}, base.Partitions...)
+	d.changedTopics[topicId] = &topic
+	return &topic
+}
+
+func (d *MetadataDelta) broker(brokerId int32) *BrokerRegistration {
+	if d.brokers == nil {
+		d.brokers = maps.Clone(d.image.Brokers)
+	}
+	d.changes.Brokers[brokerId] = true
+	base, ok := d.brokers[brokerId]
+	if !ok {
+		return nil
+	}
+	broker := *base
+	d.brokers[brokerId] = &broker
+	return &broker
+}
+
+func (d *MetadataDelta) Replay(rec MetadataRecord) {
+	switch r := rec.(type) {
+	case *RegisterBrokerRecord:
+		if d.brokers == nil {
+			d.brokers = maps.Clone(d.image.Brokers)
+		}
+		d.changes.Brokers[r.BrokerID] = true
+		d.brokers[r.BrokerID] = &BrokerRegistration{
+			BrokerID:             r.BrokerID,
+			BrokerEpoch:          r.BrokerEpoch,
+			IncarnationID:        r.IncarnationID,
+			EndPoints:            r.EndPoints,
+			Rack:                 r.Rack,
+			Fenced:               r.Fenced,
+			InControlledShutdown: r.InControlledShutdown,
+		}
+	case *UnregisterBrokerRecord:
+		if d.brokers == nil {
+			d.brokers = maps.Clone(d.image.Brokers)
+		}
+		d.changes.Brokers[r.BrokerID] = true
+		delete(d.brokers, r.BrokerID)
+	case *FenceBrokerRecord:
+		if broker := d.broker(r.ID); broker != nil && broker.BrokerEpoch == r.Epoch {
+			broker.Fenced = true
+		}
+	case *UnfenceBrokerRecord:
+		if broker := d.broker(r.ID); broker != nil && broker.BrokerEpoch == r.Epoch {
+			broker.Fenced = false
+		}
+	case *BrokerRegistrationChangeRecord:
+		broker := d.broker(r.BrokerID)
+		if broker == nil || broker.BrokerEpoch != r.BrokerEpoch {
+			return
+		}
+		if r.Fenced != 0 {
+			broker.Fenced = r.Fenced > 0
+		}
+		if r.InControlledShutdown != 0 {
+			broker.InControlledShutdown = r.InControlledShutdown > 0
+		}
+	case *TopicRecord:
+		d.changes.Topics[r.TopicID] = true
+		delete(d.removedTopics, r.TopicID)
+		d.changedTopics[r.TopicID] = &ClusterTopic{Name: r.Name, TopicId: r.TopicID}
+	case *RemoveTopicRecord:
+		topic := d.topic(r.TopicID)
+		if topic == nil {
+			return
+		}
+		d.changes.Topics[r.TopicID] = true
+		delete(d.changedTopics, r.TopicID)
+		d.removedTopics[r.TopicID] = topic.Name
+		d.replayConfig(ConfigResource{Type: CONFIG_RESOURCE_TOPIC, Name: topic.Name}, "", nil)
+	case *PartitionRecord:
+		topic := d.topic(r.TopicID)
+		if topic == nil {
+			return
+		}
+		d.changes.Topics[r.TopicID] = true
+		d.changes.Partitions[TopicIdPartition{TopicId: r.TopicID, Partition: r.PartitionID}] = true
+		topic.setPartition(ClusterTopicPartition{
+			PartitionIndex:                        r.PartitionID,
+			LeaderID:                              r.Leader,
+			LeaderEpoch:                           r.LeaderEpoch,
+			PartitionEpoch:                        r.PartitionEpoch,
+			LeaderRecoveryState:                   r.LeaderRecoveryState,
+			ReplicaNodeIDs:                        r.Replicas,
+			InsyncReplicaNodeIDs:                  r.Isr,
+			RemovingReplicaNodeIDs:                r.RemovingReplicas,
+			AddingReplicaNodeIDs:                  r.AddingReplicas,
+			EligibleLeaderReplicaNodeIDs:          r.EligibleLeaderReplicas,
+			LastKnownEligibleLeaderReplicaNodeIDs: r.LastKnownElr,
+			Directories:                           r.Directories,
+		})
+	case *PartitionChangeRecord:
+		topic := d.topic(r.TopicID)
+		if topic == nil {
+			return
+		}
+		if partition := topic.Partition(r.PartitionID); partition != nil {
+			d.changes.Topics[r.TopicID] = true
+			d.changes.Partitions[TopicIdPartition{TopicId: r.TopicID, Partition: r.PartitionID}] = true
+			partition.applyChange(r)
+		}
+	case *ConfigRecord:
+		d.replayConfig(ConfigResource{Type: r.ResourceType, Name: r.ResourceName}, r.Name, r.Value)
+	case *FeatureLevelRecord:
+		if d.features == nil {
+			d.features = maps.Clone(d.image.Features)
+		}
+		d.changes.Features = true
+		// Level 0 removes the feature
+		if r.FeatureLevel == 0 {
+			delete(d.features, r.Name)
+		} else {
+			d.features[r.Name] = r.FeatureLevel
+		}
+	case *ProducerIdsRecord:
+		d.nextProducerID = r.NextProducerID
+	case *AccessControlEntryRecord:
+		if d.acls == nil {
+			d.acls = maps.Clone(d.image.AccessControlEntries)
+		}
+		d.changes.Acls = true
+		d.acls[r.ID] = r
+	case *RemoveAccessControlEntryRecord:
+		if d.acls == nil {
+			d.acls = maps.Clone(d.image.AccessControlEntries)
+		}
+		d.changes.Acls = true
+		delete(d.acls, r.ID)
+	case *UserScramCredentialRecord:
+		if d.scram == nil {
+			d.scram = maps.Clone(d.image.ScramCredentials)
+		}
+		d.changes.ScramCredentials = true
+		d.scram[ScramCredentialKey{Name: r.Name, Mechanism: r.Mechanism}] = r
+	case *RemoveUserScramCredentialRecord:
+		if d.scram == nil {
+			d.scram = maps.Clone(d.image.ScramCredentials)
+		}
+		d.changes.ScramCredentials = true
+		delete(d.scram, ScramCredentialKey{Name: r.Name, Mechanism: r.Mechanism})
+	case *ClientQuotaRecord:
+		if d.clientQuotas == nil {
+			d.clientQuotas = maps.Clone(d.image.ClientQuotas)
+		}
+		d.changes.ClientQuotas = true
+		key := ClientQuotaEntityKey(r.Entity)
+		quotas := maps.Clone(d.clientQuotas[key])
+		if quotas == nil {
+			quotas = map[string]float64{}
+		}
+		if r.Remove {
+			delete(quotas, r.Key)
+		} else {
+			quotas[r.Key] = r.Value
+		}
+		if len(quotas) == 0 {
+			delete(d.clientQuotas, key)
+		} else {
+			d.clientQuotas[key] = quotas
+		}
+	}
+}
+
+// replayConfig sets or, for a nil value, removes a config. An empty name
+// removes every config of the resource.
+func (d *MetadataDelta) replayConfig(resource ConfigResource, name string, value *string) {
+	if d.configs == nil {
+		d.configs = maps.Clone(d.image.Configs)
+	}
+	d.changes.Configs[resource] = true
+	if name == "" {
+		delete(d.configs, resource)
+		return
+	}
+	configs := maps.Clone(d.configs[resource])
+	if configs == nil {
+		configs = map[string]string{}
+	}
+	if value == nil {
+		delete(configs, name)
+	} else {
+		configs[name] = *value
+	}
+	if len(configs) == 0 {
+		delete(d.configs, resource)
+	} else {
+		d.configs[resource] = configs
+	}
+}
+
+// Apply builds the next image. The delta must not be used afterwards.
+func (d *MetadataDelta) Apply() *MetadataImage {
+	image := *d.image
+	image.Offset = d.offset
+	image.Epoch = d.epoch
+	image.NextProducerID = d.nextProducerID
+
+	if len(d.changedTopics) > 0 || len(d.removedTopics) > 0 {
+		byName := d.image.topicsByName.builder()
+		byId := d.image.topicsById.builder()
+		for topicId, name := range d.removedTopics {
+			byId.delete(topicId)
+			if topic, ok := byName.get(name); ok && topic.TopicId == topicId {
+				byName.delete(name)
+			}
+		}
+		for topicId, topic := range d.changedTopics {
+			// A topic recreated under an existing name replaces the old one
+			if previous, ok := byName.get(topic.Name); ok && previous.TopicId != topicId {
+				byId.delete(previous.TopicId)
+			}
+			byName.set(topic.Name, topic)
+			byId.set(topicId, topic)
+		}
+		image.topicsByName = byName.build()
+		image.topicsById = byId.build()
+	}
+	if d.brokers != nil {
+		image.Brokers = d.brokers
+	}
+	if d.features != nil {
+		image.Features = d.features
+	}
+	if d.configs != nil {
+		image.Configs = d.configs
+	}
+	if d.acls != nil {
+		image.AccessControlEntries = d.acls
+	}
+	if d.clientQuotas != nil {
+		image.ClientQuotas = d.clientQuotas
+	}
+	if d.scram != nil {
+		image.ScramCredentials = d.scram
+	}
+	return &image
+}
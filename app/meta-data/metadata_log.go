@@ -0,0 +1,187 @@
+package metadata
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+)
+
+const CLUSTER_METADATA_TOPIC = "__cluster_metadata"
+const METADATA_LOG_SUFFIX = ".log"
+const SNAPSHOT_SUFFIX = ".checkpoint"
+
+// MetadataLogDir is the parent of the __cluster_metadata-0 directory, set
+// from metadata.log.dir or the first of log.dirs.
+var MetadataLogDir = "/tmp/kraft-combined-logs"
+
+type SnapshotID struct {
+	EndOffset int64
+	Epoch     int32
+}
+
+func MetadataPartitionDir() string {
+	return filepath.Join(MetadataLogDir, CLUSTER_METADATA_TOPIC+"-0")
+}
+
+func SnapshotFileName(dir string, id SnapshotID) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d-%010d%s", id.EndOffset, id.Epoch, SNAPSHOT_SUFFIX))
+}
+
+func parseSnapshotFileName(name string) (SnapshotID, bool) {
+	if !strings.HasSuffix(name, SNAPSHOT_SUFFIX) {
+		return SnapshotID{}, false
+	}
+	offset, epoch, ok := strings.Cut(strings.TrimSuffix(name, SNAPSHOT_SUFFIX), "-")
+	if !ok {
+		return SnapshotID{}, false
+	}
+	endOffset, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return SnapshotID{}, false
+	}
+	snapshotEpoch, err := strconv.ParseInt(epoch, 10, 32)
+	if err != nil {
+		return SnapshotID{}, false
+	}
+	return SnapshotID{EndOffset: endOffset, Epoch: int32(snapshotEpoch)}, true
+}
+
+// LastAppliedOffset is the offset of the last metadata record in the
+// published image, and LastAppliedEpoch the leader epoch it was written in.
+func LastAppliedOffset() int64 {
+	return Image().Offset
+}
+
+func LastAppliedEpoch() int32 {
+	return Image().Epoch
+}
+
+// listMetadataFiles returns the newest snapshot, if any, and the log segments
+// by base offset.
+func listMetadataFiles(dir string) (*SnapshotID, []int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var latest *SnapshotID
+	segments := []int64{}
+	for _, entry := range entries {
+		if id, ok := parseSnapshotFileName(entry.Name()); ok {
+			if latest == nil || id.EndOffset > latest.EndOffset {
+				latest = &id
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), METADATA_LOG_SUFFIX) {
+			continue
+		}
+		baseOffset, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), METADATA_LOG_SUFFIX), 10, 64)
+		if err == nil {
+			segments = append(segments, baseOffset)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return latest, segments, nil
+}
+
+// LatestSnapshot returns the newest snapshot in dir, or nil if there is none.
+func LatestSnapshot(dir string) (*SnapshotID, error) {
+	snapshot, _, err := listMetadataFiles(dir)
+	return snapshot, err
+}
+
+// MetadataLoader replays metadata batches into a delta. Records between a
+// BeginTransactionRecord and its EndTransactionRecord are held back so that
+// the whole transaction is applied at once.
+type MetadataLoader struct {
+	delta         *MetadataDelta
+	pending       []MetadataRecord
+	inTransaction bool
+}
+
+func NewMetadataLoader(image *MetadataImage) *MetadataLoader {
+	return &MetadataLoader{delta: NewMetadataDelta(image)}
+}
+
+func (l *MetadataLoader) replay(rec MetadataRecord) {
+	switch rec.(type) {
+	case *BeginTransactionRecord:
+		l.inTransaction = true
+		l.pending = nil
+	case *EndTransactionRecord:
+		l.inTransaction = false
+		for _, pending := range l.pending {
+			l.delta.Replay(pending)
+		}
+		l.pending = nil
+	case *AbortTransactionRecord:
+		l.inTransaction = false
+		l.pending = nil
+	default:
+		if l.inTransaction {
+			l.pending = append(l.pending, rec)
+		} else {
+			l.delta.Replay(rec)
+		}
+	}
+}
+
+// HandleBatch replays the records of a batch at or after startOffset.
+func (l *MetadataLoader) HandleBatch(batch *record.RecordBatch, startOffset int64) {
+	if batch.LastOffset() < startOffset {
+		return
+	}
+	if !batch.IsControl() {
+		for _, rec := range batch.Records {
+			offset := batch.BaseOffset + int64(rec.OffsetDelta)
+			if offset < startOffset {
+				continue
+			}
+			metadataRecord, err := DecodeMetadataRecord(rec.Value)
+			if err != nil {
+				log.Printf("Skipping metadata record at offset %d: %s\n", offset, err.Error())
+				continue
+			}
+			l.replay(metadataRecord)
+		}
+	}
+	if !l.inTransaction {
+		l.delta.SetOffset(batch.LastOffset(), batch.PartitionLeaderEpoch)
+	}
+}
+
+func (l *MetadataLoader) handleBatches(data []byte, startOffset int64) error {
+	batches, err := record.DecodeBatches(data)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		l.HandleBatch(batch, startOffset)
+	}
+	return nil
+}
+
+// Publish makes the changes replayed so far visible, tells the subscribers
+// what changed and starts a new delta.
+func (l *MetadataLoader) Publish() *MetadataImage {
+	previous := l.delta.image
+	image := l.delta.Apply()
+	Publish(image)
+	if changes := l.delta.Changes(); !changes.IsEmpty() {
+		notifySubscribers(previous, image, changes)
+	}
+	l.delta = NewMetadataDelta(image)
+	return image
+}
+
+// LoadMetadataLog rebuilds the metadata image from the newest snapshot and
+// every log segment after it, and publishes it.
+func LoadMetadataLog(dir string) error {
+	return NewMetadataFollower(dir).Poll()
+}
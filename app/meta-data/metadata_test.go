@@ -0,0 +1,402 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/gofrs/uuid"
+)
+
+func resetMetadata() {
+	Publish(EmptyImage())
+}
+
+func TestMetadataRecordRoundTrip(t *testing.T) {
+	topicId := uuid.Must(uuid.NewV4())
+	rack := "rack-1"
+	user := "alice"
+	value := "1000"
+	change := NewPartitionChangeRecord(topicId, 1)
+	change.Isr = []int32{1, 2}
+	change.Leader = 2
+
+	records := []MetadataRecord{
+		&RegisterBrokerRecord{
+			BrokerID:      1,
+			IncarnationID: uuid.Must(uuid.NewV4()),
+			BrokerEpoch:   7,
+			EndPoints:     []BrokerEndpoint{{Name: "PLAINTEXT", Host: "localhost", Port: 9092}},
+			Features:      []BrokerFeature{{Name: "metadata.version", MinSupportedVersion: 1, MaxSupportedVersion: 20}},
+			Rack:          &rack,
+			Fenced:        true,
+			LogDirs:       []uuid.UUID{uuid.Must(uuid.NewV4())},
+		},
+		&UnregisterBrokerRecord{BrokerID: 1, BrokerEpoch: 7},
+		&TopicRecord{Name: "foo", TopicID: topicId},
+		&PartitionRecord{TopicID: topicId, Replicas: []int32{1}, Isr: []int32{1}, RemovingReplicas: []int32{}, AddingReplicas: []int32{}, Leader: 1, LeaderRecoveryState: 1, Directories: []uuid.UUID{}, EligibleLeaderReplicas: []int32{2}},
+		&ConfigRecord{ResourceType: CONFIG_RESOURCE_TOPIC, ResourceName: "foo", Name: "retention.ms", Value: &value},
+		change,
+		&AccessControlEntryRecord{ID: uuid.Must(uuid.NewV4()), ResourceType: 2, ResourceName: "foo", PatternType: 3, Principal: "User:alice", Host: "*", Operation: 3, PermissionType: 3},
+		&RemoveAccessControlEntryRecord{ID: topicId},
+		&UserScramCredentialRecord{Name: "alice", Mechanism: 1, Salt: []byte("salt"), StoredKey: []byte("stored"), ServerKey: []byte("server"), Iterations: 4096},
+		&RemoveUserScramCredentialRecord{Name: "alice", Mechanism: 1},
+		&FenceBrokerRecord{ID: 1, Epoch: 7},
+		&UnfenceBrokerRecord{ID: 1, Epoch: 7},
+		&RemoveTopicRecord{TopicID: topicId},
+		&FeatureLevelRecord{Name: "metadata.version", FeatureLevel: 20},
+		&ClientQuotaRecord{Entity: []ClientQuotaEntity{{EntityType: "user", EntityName: &user}, {EntityType: "client-id"}}, Key: "producer_byte_rate", Value: 1024},
+		&ProducerIdsRecord{BrokerID: 1, BrokerEpoch: 7, NextProducerID: 5000},
+		&BrokerRegistrationChangeRecord{BrokerID: 1, BrokerEpoch: 7, Fenced: -1, LogDirs: []uuid.UUID{topicId}},
+		&NoOpRecord{},
+		&BeginTransactionRecord{Name: &user},
+		&EndTransactionRecord{},
+		&AbortTransactionRecord{},
+	}
+	for _, rec := range records {
+		decoded, err := DecodeMetadataRecord(EncodeMetadataRecord(rec))
+		if err != nil {
+			t.Errorf("Failed to decode %T: %v", rec, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, rec) {
+			t.Errorf("Round trip mismatch for %T:\n%+v\n%+v", rec, rec, decoded)
+		}
+	}
+
+	if _, err := DecodeMetadataRecord([]byte{1, 99, 0}); err != ErrUnknownMetadataRecord {
+		t.Errorf("Expected an unknown record type error, got %v", err)
+	}
+}
+
+func TestDecodePartitionRecordV1(t *testing.T) {
+	topicId := uuid.Must(uuid.NewV4())
+	directory := uuid.Must(uuid.NewV4())
+
+	// A PartitionRecord as written by a 3.x controller
+	var value bytes.Buffer
+	value.Write([]byte{1, 3, 1})
+	binary.Write(&value, binary.BigEndian, int32(0))
+	value.Write(topicId.Bytes())
+	value.Write([]byte{2, 0, 0, 0, 1}) // replicas
+	value.Write([]byte{2, 0, 0, 0, 1}) // isr
+	value.Write([]byte{1, 1})          // removing and adding replicas
+	binary.Write(&value, binary.BigEndian, int32(1))
+	binary.Write(&value, binary.BigEndian, int32(0))
+	binary.Write(&value, binary.BigEndian, int32(0))
+	value.Write([]byte{2})
+	value.Write(directory.Bytes())
+	value.Write([]byte{0})
+
+	rec, err := DecodeMetadataRecord(value.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	partition := rec.(*PartitionRecord)
+	if partition.TopicID != topicId || partition.Leader != 1 || !reflect.DeepEqual(partition.Isr, []int32{1}) || !reflect.DeepEqual(partition.Directories, []uuid.UUID{directory}) {
+		t.Errorf("Unexpected partition record %+v", partition)
+	}
+}
+
+func TestApplyRecords(t *testing.T) {
+	resetMetadata()
+	defer resetMetadata()
+	topicId := uuid.Must(uuid.NewV4())
+	loader := NewMetadataLoader(Image())
+	replay := func(records ...MetadataRecord) *MetadataImage {
+		for _, rec := range records {
+			loader.replay(rec)
+		}
+		return loader.Publish()
+	}
+
+	change := NewPartitionChangeRecord(topicId, 1)
+	change.Leader = 2
+	change.Isr = []int32{2}
+	before := replay(
+		&TopicRecord{Name: "foo", TopicID: topicId},
+		&PartitionRecord{PartitionID: 1, TopicID: topicId, Replicas: []int32{1, 2}, Isr: []int32{1, 2}, Leader: 1},
+		&PartitionRecord{PartitionID: 0, TopicID: topicId, Replicas: []int32{1}, Isr: []int32{1}, Leader: 1},
+	)
+	after := replay(change)
+
+	foo := GetClusterTopic("foo")
+	if len(foo.Partitions) != 2 || foo.Partitions[0].PartitionIndex != 0 {
+		t.Fatalf("Expected partitions 0 and 1 in order, got %+v", foo.Partitions)
+	}
+	partition := foo.Partitions[1]
+	if partition.LeaderID != 2 || partition.LeaderEpoch != 1 || partition.PartitionEpoch != 1 || !reflect.DeepEqual(partition.InsyncReplicaNodeIDs, []int32{2}) || !reflect.DeepEqual(partition.ReplicaNodeIDs, []int32{1, 2}) {
+		t.Errorf("Unexpected partition after change %+v", partition)
+	}
+	// Published images never change
+	if old, _ := before.TopicById(topicId); old.Partitions[1].LeaderID != 1 {
+		t.Errorf("The change leaked into the previous image")
+	}
+	if byId, _ := after.TopicById(topicId); byId != foo {
+		t.Errorf("Expected the name and ID indexes to share the topic")
+	}
+
+	// An aborted transaction leaves no trace, a committed one applies at its end
+	replay(&BeginTransactionRecord{}, &RemoveTopicRecord{TopicID: topicId}, &AbortTransactionRecord{})
+	if GetClusterTopic("foo").ErrorCode != 0 {
+		t.Fatalf("Aborted removal was applied")
+	}
+	replay(&BeginTransactionRecord{}, &RemoveTopicRecord{TopicID: topicId})
+	if GetClusterTopic("foo").ErrorCode != 0 {
+		t.Fatalf("Removal applied before the transaction ended")
+	}
+	replay(&EndTransactionRecord{})
+	if _, clusterTopic := GetClusterTopicById(topicId); GetClusterTopic("foo").ErrorCode != 3 || clusterTopic.ErrorCode != 100 || Image().TopicCount() != 0 {
+		t.Errorf("Expected foo to be removed")
+	}
+
+	replay(&RegisterBrokerRecord{BrokerID: 1, BrokerEpoch: 5, Fenced: true})
+	fenced := Image()
+	replay(&BrokerRegistrationChangeRecord{BrokerID: 1, BrokerEpoch: 5, Fenced: -1})
+	if Image().Brokers[1].Fenced || !fenced.Brokers[1].Fenced {
+		t.Errorf("Expected broker 1 to be unfenced in the new image only")
+	}
+	replay(&UnregisterBrokerRecord{BrokerID: 1, BrokerEpoch: 5})
+	if _, ok := Image().Brokers[1]; ok {
+		t.Errorf("Expected broker 1 to be unregistered")
+	}
+}
+
+func metadataBatch(t *testing.T, baseOffset int64, epoch int32, records ...MetadataRecord) []byte {
+	values := []record.Record{}
+	for _, rec := range records {
+		values = append(values, record.Record{Value: EncodeMetadataRecord(rec)})
+	}
+	batch := record.NewRecordBatch(values)
+	batch.BaseOffset = baseOffset
+	batch.PartitionLeaderEpoch = epoch
+	raw, err := batch.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode batch: %v", err)
+	}
+	return raw
+}
+
+func TestLoadMetadataLog(t *testing.T) {
+	resetMetadata()
+	defer resetMetadata()
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale := &TopicRecord{Name: "stale", TopicID: uuid.Must(uuid.NewV4())}
+	foo := &TopicRecord{Name: "foo", TopicID: uuid.Must(uuid.NewV4())}
+	bar := &TopicRecord{Name: "bar", TopicID: uuid.Must(uuid.NewV4())}
+
+	// Offsets 0-2 are covered by the snapshot, which only knows about foo
+	write("00000000000000000000.log", metadataBatch(t, 0, 1, stale, &NoOpRecord{}))
+	write("00000000000000000002.log", append(metadataBatch(t, 2, 1, &NoOpRecord{}), metadataBatch(t, 3, 2, bar, &PartitionRecord{TopicID: bar.TopicID, Leader: 1})...))
+	write("00000000000000000003-0000000001.checkpoint", metadataBatch(t, 0, 1, foo))
+	write("00000000000000000001-0000000001.checkpoint", metadataBatch(t, 0, 1, stale))
+
+	if err := LoadMetadataLog(dir); err != nil {
+		t.Fatalf("Failed to load metadata log: %v", err)
+	}
+	if GetClusterTopic("stale").ErrorCode == 0 || GetClusterTopic("foo").ErrorCode != 0 {
+		t.Errorf("Expected only the newest snapshot to be loaded, got %v", Image().TopicNames())
+	}
+	if clusterTopic := GetClusterTopic("bar"); clusterTopic.ErrorCode != 0 || len(clusterTopic.Partitions) != 1 {
+		t.Errorf("Expected bar with one partition from the log, got %+v", clusterTopic)
+	}
+	if LastAppliedOffset() != 4 || LastAppliedEpoch() != 2 {
+		t.Errorf("Expected last applied offset 4 in epoch 2, got %d in %d", LastAppliedOffset(), LastAppliedEpoch())
+	}
+}
+
+func TestMetadataFollower(t *testing.T) {
+	resetMetadata()
+	defer resetMetadata()
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "00000000000000000000.log")
+	appendBatch := func(path string, data []byte) {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	notified := []MetadataChanges{}
+	Subscribe(func(previous, image *MetadataImage, changes MetadataChanges) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, changes)
+	})
+	lastChanges := func() MetadataChanges {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(notified) == 0 {
+			t.Fatal("Expected subscribers to be notified")
+		}
+		return notified[len(notified)-1]
+	}
+
+	foo := &TopicRecord{Name: "foo", TopicID: uuid.Must(uuid.NewV4())}
+	appendBatch(segment, metadataBatch(t, 0, 1, foo, &PartitionRecord{TopicID: foo.TopicID, Leader: 1, Replicas: []int32{1}, Isr: []int32{1}}))
+	follower := NewMetadataFollower(dir)
+	if err := follower.Poll(); err != nil {
+		t.Fatalf("Failed to poll metadata log: %v", err)
+	}
+	if GetClusterTopic("foo").ErrorCode != 0 || !lastChanges().Topics[foo.TopicID] {
+		t.Fatalf("Expected foo to be published with a topic change, got %v", Image().TopicNames())
+	}
+
+	// Records appended to the same segment are picked up by the next poll
+	change := NewPartitionChangeRecord(foo.TopicID, 0)
+	change.Leader = 2
+	appendBatch(segment, metadataBatch(t, 2, 1, change))
+	if err := follower.Poll(); err != nil {
+		t.Fatalf("Failed to poll metadata log: %v", err)
+	}
+	if leader := GetClusterTopic("foo").Partitions[0].LeaderID; leader != 2 {
+		t.Errorf("Expected leader 2 after the partition change, got %d", leader)
+	}
+	if changes := lastChanges(); !changes.Partitions[TopicIdPartition{foo.TopicID, 0}] || len(changes.Brokers) != 0 {
+		t.Errorf("Expected a partition change and no broker changes, got %+v", changes)
+	}
+
+	// As are records in a newly rolled segment
+	bar := &TopicRecord{Name: "bar", TopicID: uuid.Must(uuid.NewV4())}
+	appendBatch(filepath.Join(dir, "00000000000000000003.log"), metadataBatch(t, 3, 2, bar))
+	if err := follower.Poll(); err != nil {
+		t.Fatalf("Failed to poll metadata log: %v", err)
+	}
+	if GetClusterTopic("bar").ErrorCode != 0 || LastAppliedOffset() != 3 || LastAppliedEpoch() != 2 {
+		t.Errorf("Expected bar at offset 3 in epoch 2, got %v at %d in %d", Image().TopicNames(), LastAppliedOffset(), LastAppliedEpoch())
+	}
+	if follower.NextOffset() != 4 {
+		t.Errorf("Expected to read from offset 4 next, got %d", follower.NextOffset())
+	}
+}
+
+func TestMetadataSnapshot(t *testing.T) {
+	resetMetadata()
+	defer resetMetadata()
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	foo := &TopicRecord{Name: "foo", TopicID: uuid.Must(uuid.NewV4())}
+	retention := "1000"
+	client := "app"
+	write("00000000000000000000.log", metadataBatch(t, 0, 1,
+		&FeatureLevelRecord{Name: "metadata.version", FeatureLevel: 20},
+		&RegisterBrokerRecord{BrokerID: 1, BrokerEpoch: 5, EndPoints: []BrokerEndpoint{{Name: "PLAINTEXT", Host: "localhost", Port: 9092}}},
+		foo,
+		&PartitionRecord{TopicID: foo.TopicID, Leader: 1, Replicas: []int32{1}, Isr: []int32{1}, LeaderEpoch: 3},
+		&ConfigRecord{ResourceType: CONFIG_RESOURCE_TOPIC, ResourceName: "foo", Name: "retention.ms", Value: &retention},
+		&ClientQuotaRecord{Entity: []ClientQuotaEntity{{EntityType: "client-id", EntityName: &client}, {EntityType: "user"}}, Key: "producer_byte_rate", Value: 1024},
+		&ProducerIdsRecord{BrokerID: 1, BrokerEpoch: 5, NextProducerID: 2000},
+	))
+	write("00000000000000000007.log", metadataBatch(t, 7, 2, &NoOpRecord{}))
+
+	follower := NewMetadataFollower(dir)
+	follower.Snapshotter = NewMetadataSnapshotter(dir, 1, 0)
+	if err := follower.Poll(); err != nil {
+		t.Fatalf("Failed to poll metadata log: %v", err)
+	}
+	expected := Image()
+
+	// The snapshot covers both segments, but the active one is kept
+	snapshot, segments, err := listMetadataFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == nil || *snapshot != (SnapshotID{EndOffset: 8, Epoch: 2}) {
+		t.Fatalf("Expected a snapshot ending at offset 8 in epoch 2, got %+v", snapshot)
+	}
+	if len(segments) != 1 || segments[0] != 7 {
+		t.Errorf("Expected only the active segment to be kept, got %v", segments)
+	}
+
+	data, err := os.ReadFile(SnapshotFileName(dir, *snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err := record.DecodeBatches(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := batches[0], batches[len(batches)-1]
+	if header, _ := record.ControlType(first.Records[0]); !first.IsControl() || header != record.CONTROL_SNAPSHOT_HEADER {
+		t.Errorf("Expected the snapshot to start with a header, got %+v", first)
+	}
+	if footer, _ := record.ControlType(last.Records[0]); !last.IsControl() || footer != record.CONTROL_SNAPSHOT_FOOTER {
+		t.Errorf("Expected the snapshot to end with a footer, got %+v", last)
+	}
+
+	resetMetadata()
+	if err := LoadMetadataLog(dir); err != nil {
+		t.Fatalf("Failed to load metadata log: %v", err)
+	}
+	loaded := Image()
+	if !reflect.DeepEqual(ImageRecords(loaded), ImageRecords(expected)) {
+		t.Errorf("Expected the snapshot to rebuild the image, got %+v", ImageRecords(loaded))
+	}
+	if loaded.Offset != 7 || loaded.Epoch != 2 {
+		t.Errorf("Expected offset 7 in epoch 2, got %d in %d", loaded.Offset, loaded.Epoch)
+	}
+}
+
+func imageWithTopics(count int) *MetadataImage {
+	delta := NewMetadataDelta(EmptyImage())
+	for i := range count {
+		topicId := uuid.Must(uuid.NewV4())
+		delta.Replay(&TopicRecord{Name: fmt.Sprintf("topic-%d", i), TopicID: topicId})
+		for partition := range int32(3) {
+			delta.Replay(&PartitionRecord{PartitionID: partition, TopicID: topicId, Replicas: []int32{1}, Isr: []int32{1}, Leader: 1})
+		}
+	}
+	return delta.Apply()
+}
+
+func BenchmarkBuildImage10kTopics(b *testing.B) {
+	for range b.N {
+		imageWithTopics(10000)
+	}
+}
+
+func BenchmarkPublishPartitionChange10kTopics(b *testing.B) {
+	image := imageWithTopics(10000)
+	topic, _ := image.TopicByName("topic-5000")
+	b.ResetTimer()
+	for i := range b.N {
+		delta := NewMetadataDelta(image)
+		change := NewPartitionChangeRecord(topic.TopicId, int32(i%3))
+		change.Leader = 1
+		delta.Replay(change)
+		image = delta.Apply()
+	}
+}
+
+func BenchmarkLookupTopic10kTopics(b *testing.B) {
+	Publish(imageWithTopics(10000))
+	defer resetMetadata()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			GetClusterTopic(fmt.Sprintf("topic-%d", i%10000))
+			i++
+		}
+	})
+}
@@ -1,154 +1,168 @@
 package metadata
 
 import (
-	"bytes"
-	"encoding/binary"
 	"log"
-	"os"
+	"sort"
+	"strings"
 
 	"github.com/gofrs/uuid"
 )
 
-const Feature_LEVEL_RECORD int = 12
-const TOPIC_RECORD int = 2
-const PARTISION_RECORD int = 3
+const CONFIG_RESOURCE_TOPIC int8 = 2
+const CONFIG_RESOURCE_BROKER int8 = 4
 
 type ClusterTopicPartition struct {
 	ErrorCode                             int16 // 0 indicates NO_ERROR
 	PartitionIndex                        int32
 	LeaderID                              int32
 	LeaderEpoch                           int32
+	PartitionEpoch                        int32
+	LeaderRecoveryState                   int8
 	ReplicaNodeIDs                        []int32
 	InsyncReplicaNodeIDs                  []int32
+	RemovingReplicaNodeIDs                []int32
+	AddingReplicaNodeIDs                  []int32
 	EligibleLeaderReplicaNodeIDs          []int32
 	LastKnownEligibleLeaderReplicaNodeIDs []int32
 	OfflineReplicaNodeIDs                 []int32
+	Directories                           []uuid.UUID
 }
 
 type ClusterTopic struct {
 	ErrorCode  int16
+	Name       string
 	TopicId    uuid.UUID
 	Partitions []ClusterTopicPartition
 }
 
-var ClusterTopics map[string]*ClusterTopic = map[string]*ClusterTopic{}
+type BrokerRegistration struct {
+	BrokerID             int32
+	BrokerEpoch          int64
+	IncarnationID        uuid.UUID
+	EndPoints            []BrokerEndpoint
+	Rack                 *string
+	Fenced               bool
+	InControlledShutdown bool
+}
+
+type ConfigResource struct {
+	Type int8
+	Name string
+}
 
+// SetClusterTopics loads the cluster metadata and keeps following the
+// metadata log in the background so the image stays current.
 func SetClusterTopics() error {
-	path := "/tmp/kraft-combined-logs/__cluster_metadata-0/00000000000000000000.log"
-	file, err := os.ReadFile(path)
-	if err != nil {
-		log.Println("Failed to Read Log File.")
+	follower := NewMetadataFollower(MetadataPartitionDir())
+	follower.Snapshotter = NewMetadataSnapshotter(MetadataPartitionDir(), MaxBytesBetweenSnapshots, MaxSnapshotInterval)
+	if err := follower.Poll(); err != nil {
+		log.Println("Failed to Read Metadata Log.")
 		return err
 	}
-	reader := bytes.NewBuffer(file)
-
-	partitions := []struct {
-		TopicID uuid.UUID
-		ClusterTopicPartition
-	}{}
-
-	for {
-		_ = reader.Next(8 + 4 + 4 + 1 + 4 + 2 + 4 + 8 + 8 + 8 + 2 + 4)
+	log.Printf("Loaded cluster metadata up to offset %d in epoch %d\n", LastAppliedOffset(), LastAppliedEpoch())
+	go follower.Run(nil)
+	return nil
+}
 
-		if reader.Len() == 0 {
-			break
+// ClientQuotaEntityKey identifies a quota entity, e.g. "client-id=app,user=alice".
+// A nil name is the default entity of its type.
+func ClientQuotaEntityKey(entity []ClientQuotaEntity) string {
+	parts := make([]string, 0, len(entity))
+	for _, e := range entity {
+		name := "<default>"
+		if e.EntityName != nil {
+			name = *e.EntityName
 		}
+		parts = append(parts, e.EntityType+"="+name)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
 
-		var recordLength int32
-
-		_ = binary.Read(reader, binary.BigEndian, &recordLength)
-
-		for range recordLength {
-			size, _ := binary.ReadVarint(reader)
-			recordBuf := bytes.NewBuffer(reader.Next(int(size)))
-			_ = recordBuf.Next(1)
-			_, _ = binary.ReadVarint(recordBuf)
-			_, _ = binary.ReadVarint(recordBuf)
-			keyLength, _ := binary.ReadVarint(recordBuf)
-
-			if keyLength > 0 {
-				_ = recordBuf.Next(int(keyLength))
-			}
-
-			valueLength, _ := binary.ReadVarint(recordBuf)
-			valueBuf := bytes.NewBuffer(recordBuf.Next(int(valueLength)))
-
-			_ = valueBuf.Next(1)
-
-			var recordType int8
-			_ = binary.Read(valueBuf, binary.BigEndian, &recordType)
-			_ = valueBuf.Next(1)
-
-			switch recordType {
-
-			case int8(TOPIC_RECORD):
-				topicNameLegth, _ := binary.ReadUvarint(valueBuf)
-				topicName, topicId := make([]byte, topicNameLegth-1), make([]byte, 16)
-				_ = binary.Read(valueBuf, binary.BigEndian, &topicName)
-				_ = binary.Read(valueBuf, binary.BigEndian, &topicId)
-				id, _ := uuid.FromBytes(topicId)
-
-				ClusterTopics[string(topicName)] = &ClusterTopic{TopicId: id}
-
-			case int8(PARTISION_RECORD):
-
-				var partition ClusterTopicPartition
-				_ = binary.Read(valueBuf, binary.BigEndian, &partition.PartitionIndex)
-
-				topicId := make([]byte, 16)
-
-				_ = binary.Read(valueBuf, binary.BigEndian, &topicId)
-				id, _ := uuid.FromBytes(topicId)
-
-				replicaNodeIDsLenth, _ := binary.ReadUvarint(valueBuf)
-				partition.ReplicaNodeIDs = make([]int32, replicaNodeIDsLenth)
-				for i := range replicaNodeIDsLenth {
-					_ = binary.Read(valueBuf, binary.BigEndian, &partition.ReplicaNodeIDs[i])
-				}
-
-				insyncReplicaNodeIDsLenth, _ := binary.ReadUvarint(valueBuf)
-				partition.InsyncReplicaNodeIDs = make([]int32, insyncReplicaNodeIDsLenth)
-				for i := range insyncReplicaNodeIDsLenth {
-					_ = binary.Read(valueBuf, binary.BigEndian, &partition.InsyncReplicaNodeIDs[i])
-				}
-
-				_, _ = binary.ReadUvarint(valueBuf)
-				_, _ = binary.ReadUvarint(valueBuf)
-
-				_ = binary.Read(valueBuf, binary.BigEndian, &partition.LeaderID)
-				_ = binary.Read(valueBuf, binary.BigEndian, &partition.LeaderEpoch)
-
-				partitions = append(partitions, struct {
-					TopicID uuid.UUID
-					ClusterTopicPartition
-				}{
-
-					TopicID: id, ClusterTopicPartition: partition,
-				})
-
-			}
+// ParseClientQuotaEntityKey reverses ClientQuotaEntityKey.
+func ParseClientQuotaEntityKey(key string) []ClientQuotaEntity {
+	entity := []ClientQuotaEntity{}
+	for _, part := range strings.Split(key, ",") {
+		entityType, name, _ := strings.Cut(part, "=")
+		if name == "<default>" {
+			entity = append(entity, ClientQuotaEntity{EntityType: entityType})
+			continue
 		}
+		entity = append(entity, ClientQuotaEntity{EntityType: entityType, EntityName: &name})
 	}
-setPartition:
-	for i := range partitions {
-		for topicName := range ClusterTopics {
-			if ClusterTopics[topicName].TopicId == partitions[i].TopicID {
-				ClusterTopics[topicName].Partitions = append(ClusterTopics[topicName].Partitions, partitions[i].ClusterTopicPartition)
-				continue setPartition
-			}
-		}
+	return entity
+}
 
+// Partition finds a partition by index. Partitions of a published image must
+// not be modified.
+func (t *ClusterTopic) Partition(partitionIndex int32) *ClusterTopicPartition {
+	for i := range t.Partitions {
+		if t.Partitions[i].PartitionIndex == partitionIndex {
+			return &t.Partitions[i]
+		}
 	}
-
 	return nil
+}
 
+// setPartition replaces the partition with the same index, keeping the
+// partitions ordered by index.
+func (t *ClusterTopic) setPartition(partition ClusterTopicPartition) {
+	if existing := t.Partition(partition.PartitionIndex); existing != nil {
+		*existing = partition
+		return
+	}
+	t.Partitions = append(t.Partitions, partition)
+	sort.Slice(t.Partitions, func(i, j int) bool { return t.Partitions[i].PartitionIndex < t.Partitions[j].PartitionIndex })
+}
+
+// applyChange merges a PartitionChangeRecord. A new leader bumps the leader
+// epoch, and every change bumps the partition epoch.
+func (p *ClusterTopicPartition) applyChange(change *PartitionChangeRecord) {
+	if change.Isr != nil {
+		p.InsyncReplicaNodeIDs = change.Isr
+	}
+	if change.Leader != NO_LEADER_CHANGE {
+		p.LeaderID = change.Leader
+		p.LeaderEpoch++
+	}
+	if change.Replicas != nil {
+		p.ReplicaNodeIDs = change.Replicas
+	}
+	if change.RemovingReplicas != nil {
+		p.RemovingReplicaNodeIDs = change.RemovingReplicas
+	}
+	if change.AddingReplicas != nil {
+		p.AddingReplicaNodeIDs = change.AddingReplicas
+	}
+	if change.LeaderRecoveryState != NO_LEADER_RECOVERY_CHANGE {
+		p.LeaderRecoveryState = change.LeaderRecoveryState
+	}
+	if change.Directories != nil {
+		p.Directories = change.Directories
+	}
+	if change.EligibleLeaderReplicas != nil {
+		p.EligibleLeaderReplicaNodeIDs = change.EligibleLeaderReplicas
+	}
+	if change.LastKnownElr != nil {
+		p.LastKnownEligibleLeaderReplicaNodeIDs = change.LastKnownElr
+	}
+	p.PartitionEpoch++
 }
 
 func GetClusterTopic(topic string) *ClusterTopic {
-	clusterTopic, ok := ClusterTopics[topic]
+	clusterTopic, ok := Image().TopicByName(topic)
 	if ok {
 		return clusterTopic
 	}
 
 	return &ClusterTopic{ErrorCode: 3}
 }
+
+func GetClusterTopicById(topicId uuid.UUID) (string, *ClusterTopic) {
+	clusterTopic, ok := Image().TopicById(topicId)
+	if ok {
+		return clusterTopic.Name, clusterTopic
+	}
+
+	return "", &ClusterTopic{ErrorCode: 100}
+}
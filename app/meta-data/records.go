@@ -0,0 +1,642 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+const METADATA_RECORD_FRAME_VERSION = 1
+
+const REGISTER_BROKER_RECORD int16 = 0
+const UNREGISTER_BROKER_RECORD int16 = 1
+const TOPIC_RECORD int16 = 2
+const PARTITION_RECORD int16 = 3
+const CONFIG_RECORD int16 = 4
+const PARTITION_CHANGE_RECORD int16 = 5
+const ACCESS_CONTROL_ENTRY_RECORD int16 = 6
+const FENCE_BROKER_RECORD int16 = 7
+const UNFENCE_BROKER_RECORD int16 = 8
+const REMOVE_TOPIC_RECORD int16 = 9
+const USER_SCRAM_CREDENTIAL_RECORD int16 = 11
+const FEATURE_LEVEL_RECORD int16 = 12
+const CLIENT_QUOTA_RECORD int16 = 14
+const PRODUCER_IDS_RECORD int16 = 15
+const REMOVE_ACCESS_CONTROL_ENTRY_RECORD int16 = 16
+const BROKER_REGISTRATION_CHANGE_RECORD int16 = 17
+const NO_OP_RECORD int16 = 20
+const REMOVE_USER_SCRAM_CREDENTIAL_RECORD int16 = 22
+const BEGIN_TRANSACTION_RECORD int16 = 23
+const END_TRANSACTION_RECORD int16 = 24
+const ABORT_TRANSACTION_RECORD int16 = 25
+
+// Sentinels used by PartitionChangeRecord for fields that did not change
+const NO_LEADER_CHANGE int32 = -2
+const NO_LEADER int32 = -1
+const NO_LEADER_RECOVERY_CHANGE int8 = -1
+
+var (
+	ErrUnknownMetadataRecord      = errors.New("unknown metadata record type")
+	ErrUnsupportedMetadataVersion = errors.New("unsupported metadata record version")
+	ErrUnsupportedFrameVersion    = errors.New("unsupported metadata record frame version")
+)
+
+// MetadataRecord is one of the records written to __cluster_metadata. Each
+// lists pointers to its fields in wire order for a version, and separately
+// the fields sent as tagged fields.
+type MetadataRecord interface {
+	ApiKey() int16
+	fields(version int16) []any
+	taggedFields(version int16) map[uint64]any
+}
+
+// metadataStruct is an element of an array nested in a record
+type metadataStruct interface {
+	fields() []any
+}
+
+type BrokerEndpoint struct {
+	Name             string
+	Host             string
+	Port             uint16
+	SecurityProtocol int16
+}
+
+func (e *BrokerEndpoint) fields() []any {
+	return []any{&e.Name, &e.Host, &e.Port, &e.SecurityProtocol}
+}
+
+type BrokerFeature struct {
+	Name                string
+	MinSupportedVersion int16
+	MaxSupportedVersion int16
+}
+
+func (f *BrokerFeature) fields() []any {
+	return []any{&f.Name, &f.MinSupportedVersion, &f.MaxSupportedVersion}
+}
+
+type ClientQuotaEntity struct {
+	EntityType string
+	EntityName *string
+}
+
+func (e *ClientQuotaEntity) fields() []any {
+	return []any{&e.EntityType, &e.EntityName}
+}
+
+type RegisterBrokerRecord struct {
+	BrokerID             int32
+	IsMigratingZkBroker  bool
+	IncarnationID        uuid.UUID
+	BrokerEpoch          int64
+	EndPoints            []BrokerEndpoint
+	Features             []BrokerFeature
+	Rack                 *string
+	Fenced               bool
+	InControlledShutdown bool
+	LogDirs              []uuid.UUID
+}
+
+func (r *RegisterBrokerRecord) ApiKey() int16 { return REGISTER_BROKER_RECORD }
+func (r *RegisterBrokerRecord) fields(version int16) []any {
+	fields := []any{&r.BrokerID}
+	if version >= 2 {
+		fields = append(fields, &r.IsMigratingZkBroker)
+	}
+	fields = append(fields, &r.IncarnationID, &r.BrokerEpoch, &r.EndPoints, &r.Features, &r.Rack, &r.Fenced)
+	if version >= 1 {
+		fields = append(fields, &r.InControlledShutdown)
+	}
+	if version >= 3 {
+		fields = append(fields, &r.LogDirs)
+	}
+	return fields
+}
+func (r *RegisterBrokerRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type UnregisterBrokerRecord struct {
+	BrokerID    int32
+	BrokerEpoch int64
+}
+
+func (r *UnregisterBrokerRecord) ApiKey() int16 { return UNREGISTER_BROKER_RECORD }
+func (r *UnregisterBrokerRecord) fields(version int16) []any {
+	return []any{&r.BrokerID, &r.BrokerEpoch}
+}
+func (r *UnregisterBrokerRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type TopicRecord struct {
+	Name    string
+	TopicID uuid.UUID
+}
+
+func (r *TopicRecord) ApiKey() int16                             { return TOPIC_RECORD }
+func (r *TopicRecord) fields(version int16) []any                { return []any{&r.Name, &r.TopicID} }
+func (r *TopicRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type PartitionRecord struct {
+	PartitionID            int32
+	TopicID                uuid.UUID
+	Replicas               []int32
+	Isr                    []int32
+	RemovingReplicas       []int32
+	AddingReplicas         []int32
+	Leader                 int32
+	LeaderRecoveryState    int8
+	LeaderEpoch            int32
+	PartitionEpoch         int32
+	Directories            []uuid.UUID
+	EligibleLeaderReplicas []int32
+	LastKnownElr           []int32
+}
+
+func (r *PartitionRecord) ApiKey() int16 { return PARTITION_RECORD }
+func (r *PartitionRecord) fields(version int16) []any {
+	fields := []any{&r.PartitionID, &r.TopicID, &r.Replicas, &r.Isr, &r.RemovingReplicas, &r.AddingReplicas, &r.Leader, &r.LeaderEpoch, &r.PartitionEpoch}
+	if version >= 1 {
+		fields = append(fields, &r.Directories)
+	}
+	return fields
+}
+func (r *PartitionRecord) taggedFields(version int16) map[uint64]any {
+	tagged := map[uint64]any{0: &r.LeaderRecoveryState}
+	if version >= 2 {
+		tagged[1] = &r.EligibleLeaderReplicas
+		tagged[2] = &r.LastKnownElr
+	}
+	return tagged
+}
+
+type ConfigRecord struct {
+	ResourceType int8
+	ResourceName string
+	Name         string
+	Value        *string
+}
+
+func (r *ConfigRecord) ApiKey() int16 { return CONFIG_RECORD }
+func (r *ConfigRecord) fields(version int16) []any {
+	return []any{&r.ResourceType, &r.ResourceName, &r.Name, &r.Value}
+}
+func (r *ConfigRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+// PartitionChangeRecord only carries the fields that changed. Nil slices and
+// the NO_*_CHANGE sentinels leave the partition's value as it was.
+type PartitionChangeRecord struct {
+	PartitionID            int32
+	TopicID                uuid.UUID
+	Isr                    []int32
+	Leader                 int32
+	Replicas               []int32
+	RemovingReplicas       []int32
+	AddingReplicas         []int32
+	LeaderRecoveryState    int8
+	Directories            []uuid.UUID
+	EligibleLeaderReplicas []int32
+	LastKnownElr           []int32
+}
+
+func NewPartitionChangeRecord(topicId uuid.UUID, partitionId int32) *PartitionChangeRecord {
+	return &PartitionChangeRecord{
+		PartitionID:         partitionId,
+		TopicID:             topicId,
+		Leader:              NO_LEADER_CHANGE,
+		LeaderRecoveryState: NO_LEADER_RECOVERY_CHANGE,
+	}
+}
+
+func (r *PartitionChangeRecord) ApiKey() int16 { return PARTITION_CHANGE_RECORD }
+func (r *PartitionChangeRecord) fields(version int16) []any {
+	return []any{&r.PartitionID, &r.TopicID}
+}
+func (r *PartitionChangeRecord) taggedFields(version int16) map[uint64]any {
+	tagged := map[uint64]any{
+		0: &r.Isr,
+		1: &r.Leader,
+		2: &r.Replicas,
+		3: &r.RemovingReplicas,
+		4: &r.AddingReplicas,
+		5: &r.LeaderRecoveryState,
+	}
+	if version >= 1 {
+		tagged[8] = &r.Directories
+	}
+	if version >= 2 {
+		tagged[6] = &r.EligibleLeaderReplicas
+		tagged[7] = &r.LastKnownElr
+	}
+	return tagged
+}
+
+type AccessControlEntryRecord struct {
+	ID             uuid.UUID
+	ResourceType   int8
+	ResourceName   string
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+func (r *AccessControlEntryRecord) ApiKey() int16 { return ACCESS_CONTROL_ENTRY_RECORD }
+func (r *AccessControlEntryRecord) fields(version int16) []any {
+	return []any{&r.ID, &r.ResourceType, &r.ResourceName, &r.PatternType, &r.Principal, &r.Host, &r.Operation, &r.PermissionType}
+}
+func (r *AccessControlEntryRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type RemoveAccessControlEntryRecord struct {
+	ID uuid.UUID
+}
+
+func (r *RemoveAccessControlEntryRecord) ApiKey() int16                             { return REMOVE_ACCESS_CONTROL_ENTRY_RECORD }
+func (r *RemoveAccessControlEntryRecord) fields(version int16) []any                { return []any{&r.ID} }
+func (r *RemoveAccessControlEntryRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type FenceBrokerRecord struct {
+	ID    int32
+	Epoch int64
+}
+
+func (r *FenceBrokerRecord) ApiKey() int16                             { return FENCE_BROKER_RECORD }
+func (r *FenceBrokerRecord) fields(version int16) []any                { return []any{&r.ID, &r.Epoch} }
+func (r *FenceBrokerRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type UnfenceBrokerRecord struct {
+	ID    int32
+	Epoch int64
+}
+
+func (r *UnfenceBrokerRecord) ApiKey() int16                             { return UNFENCE_BROKER_RECORD }
+func (r *UnfenceBrokerRecord) fields(version int16) []any                { return []any{&r.ID, &r.Epoch} }
+func (r *UnfenceBrokerRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type RemoveTopicRecord struct {
+	TopicID uuid.UUID
+}
+
+func (r *RemoveTopicRecord) ApiKey() int16                             { return REMOVE_TOPIC_RECORD }
+func (r *RemoveTopicRecord) fields(version int16) []any                { return []any{&r.TopicID} }
+func (r *RemoveTopicRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+// UserScramCredentialRecord holds what a SCRAM server needs to authenticate
+// a user with one mechanism, never the password itself.
+type UserScramCredentialRecord struct {
+	Name       string
+	Mechanism  int8
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+	Iterations int32
+}
+
+func (r *UserScramCredentialRecord) ApiKey() int16 { return USER_SCRAM_CREDENTIAL_RECORD }
+func (r *UserScramCredentialRecord) fields(version int16) []any {
+	return []any{&r.Name, &r.Mechanism, &r.Salt, &r.StoredKey, &r.ServerKey, &r.Iterations}
+}
+func (r *UserScramCredentialRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type RemoveUserScramCredentialRecord struct {
+	Name      string
+	Mechanism int8
+}
+
+func (r *RemoveUserScramCredentialRecord) ApiKey() int16 { return REMOVE_USER_SCRAM_CREDENTIAL_RECORD }
+func (r *RemoveUserScramCredentialRecord) fields(version int16) []any {
+	return []any{&r.Name, &r.Mechanism}
+}
+func (r *RemoveUserScramCredentialRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type FeatureLevelRecord struct {
+	Name         string
+	FeatureLevel int16
+}
+
+func (r *FeatureLevelRecord) ApiKey() int16                             { return FEATURE_LEVEL_RECORD }
+func (r *FeatureLevelRecord) fields(version int16) []any                { return []any{&r.Name, &r.FeatureLevel} }
+func (r *FeatureLevelRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type ClientQuotaRecord struct {
+	Entity []ClientQuotaEntity
+	Key    string
+	Value  float64
+	Remove bool
+}
+
+func (r *ClientQuotaRecord) ApiKey() int16 { return CLIENT_QUOTA_RECORD }
+func (r *ClientQuotaRecord) fields(version int16) []any {
+	return []any{&r.Entity, &r.Key, &r.Value, &r.Remove}
+}
+func (r *ClientQuotaRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type ProducerIdsRecord struct {
+	BrokerID       int32
+	BrokerEpoch    int64
+	NextProducerID int64
+}
+
+func (r *ProducerIdsRecord) ApiKey() int16 { return PRODUCER_IDS_RECORD }
+func (r *ProducerIdsRecord) fields(version int16) []any {
+	return []any{&r.BrokerID, &r.BrokerEpoch, &r.NextProducerID}
+}
+func (r *ProducerIdsRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+// BrokerRegistrationChangeRecord uses 1 for true, -1 for false and 0 for no
+// change in its Fenced and InControlledShutdown fields.
+type BrokerRegistrationChangeRecord struct {
+	BrokerID             int32
+	BrokerEpoch          int64
+	Fenced               int8
+	InControlledShutdown int8
+	LogDirs              []uuid.UUID
+}
+
+func (r *BrokerRegistrationChangeRecord) ApiKey() int16 { return BROKER_REGISTRATION_CHANGE_RECORD }
+func (r *BrokerRegistrationChangeRecord) fields(version int16) []any {
+	return []any{&r.BrokerID, &r.BrokerEpoch}
+}
+func (r *BrokerRegistrationChangeRecord) taggedFields(version int16) map[uint64]any {
+	tagged := map[uint64]any{0: &r.Fenced}
+	if version >= 1 {
+		tagged[1] = &r.InControlledShutdown
+	}
+	if version >= 2 {
+		tagged[2] = &r.LogDirs
+	}
+	return tagged
+}
+
+type NoOpRecord struct{}
+
+func (r *NoOpRecord) ApiKey() int16                             { return NO_OP_RECORD }
+func (r *NoOpRecord) fields(version int16) []any                { return nil }
+func (r *NoOpRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type BeginTransactionRecord struct {
+	Name *string
+}
+
+func (r *BeginTransactionRecord) ApiKey() int16              { return BEGIN_TRANSACTION_RECORD }
+func (r *BeginTransactionRecord) fields(version int16) []any { return nil }
+func (r *BeginTransactionRecord) taggedFields(version int16) map[uint64]any {
+	return map[uint64]any{0: &r.Name}
+}
+
+type EndTransactionRecord struct{}
+
+func (r *EndTransactionRecord) ApiKey() int16                             { return END_TRANSACTION_RECORD }
+func (r *EndTransactionRecord) fields(version int16) []any                { return nil }
+func (r *EndTransactionRecord) taggedFields(version int16) map[uint64]any { return nil }
+
+type AbortTransactionRecord struct {
+	Reason *string
+}
+
+func (r *AbortTransactionRecord) ApiKey() int16              { return ABORT_TRANSACTION_RECORD }
+func (r *AbortTransactionRecord) fields(version int16) []any { return nil }
+func (r *AbortTransactionRecord) taggedFields(version int16) map[uint64]any {
+	return map[uint64]any{0: &r.Reason}
+}
+
+// METADATA_RECORD_VERSIONS is the highest version understood for each record
+// type. Records are always written at that version.
+var METADATA_RECORD_VERSIONS = map[int16]int16{
+	REGISTER_BROKER_RECORD:              3,
+	UNREGISTER_BROKER_RECORD:            0,
+	TOPIC_RECORD:                        0,
+	PARTITION_RECORD:                    2,
+	CONFIG_RECORD:                       0,
+	PARTITION_CHANGE_RECORD:             2,
+	ACCESS_CONTROL_ENTRY_RECORD:         0,
+	FENCE_BROKER_RECORD:                 0,
+	UNFENCE_BROKER_RECORD:               0,
+	REMOVE_TOPIC_RECORD:                 0,
+	USER_SCRAM_CREDENTIAL_RECORD:        0,
+	FEATURE_LEVEL_RECORD:                0,
+	CLIENT_QUOTA_RECORD:                 0,
+	PRODUCER_IDS_RECORD:                 0,
+	REMOVE_ACCESS_CONTROL_ENTRY_RECORD:  0,
+	BROKER_REGISTRATION_CHANGE_RECORD:   2,
+	NO_OP_RECORD:                        0,
+	REMOVE_USER_SCRAM_CREDENTIAL_RECORD: 0,
+	BEGIN_TRANSACTION_RECORD:            0,
+	END_TRANSACTION_RECORD:              0,
+	ABORT_TRANSACTION_RECORD:            0,
+}
+
+// newMetadataRecord returns an empty record of a type with its schema
+// defaults applied.
+func newMetadataRecord(apiKey int16) (MetadataRecord, error) {
+	switch apiKey {
+	case REGISTER_BROKER_RECORD:
+		return &RegisterBrokerRecord{Fenced: true}, nil
+	case UNREGISTER_BROKER_RECORD:
+		return &UnregisterBrokerRecord{}, nil
+	case TOPIC_RECORD:
+		return &TopicRecord{}, nil
+	case PARTITION_RECORD:
+		return &PartitionRecord{Leader: NO_LEADER}, nil
+	case CONFIG_RECORD:
+		return &ConfigRecord{}, nil
+	case PARTITION_CHANGE_RECORD:
+		return NewPartitionChangeRecord(uuid.Nil, 0), nil
+	case ACCESS_CONTROL_ENTRY_RECORD:
+		return &AccessControlEntryRecord{}, nil
+	case FENCE_BROKER_RECORD:
+		return &FenceBrokerRecord{}, nil
+	case UNFENCE_BROKER_RECORD:
+		return &UnfenceBrokerRecord{}, nil
+	case REMOVE_TOPIC_RECORD:
+		return &RemoveTopicRecord{}, nil
+	case USER_SCRAM_CREDENTIAL_RECORD:
+		return &UserScramCredentialRecord{}, nil
+	case FEATURE_LEVEL_RECORD:
+		return &FeatureLevelRecord{}, nil
+	case CLIENT_QUOTA_RECORD:
+		return &ClientQuotaRecord{}, nil
+	case PRODUCER_IDS_RECORD:
+		return &ProducerIdsRecord{}, nil
+	case REMOVE_ACCESS_CONTROL_ENTRY_RECORD:
+		return &RemoveAccessControlEntryRecord{}, nil
+	case BROKER_REGISTRATION_CHANGE_RECORD:
+		return &BrokerRegistrationChangeRecord{}, nil
+	case NO_OP_RECORD:
+		return &NoOpRecord{}, nil
+	case REMOVE_USER_SCRAM_CREDENTIAL_RECORD:
+		return &RemoveUserScramCredentialRecord{}, nil
+	case BEGIN_TRANSACTION_RECORD:
+		return &BeginTransactionRecord{}, nil
+	case END_TRANSACTION_RECORD:
+		return &EndTransactionRecord{}, nil
+	case ABORT_TRANSACTION_RECORD:
+		return &AbortTransactionRecord{}, nil
+	}
+	return nil, ErrUnknownMetadataRecord
+}
+
+// DecodeMetadataRecord parses a record value: the frame version, the record
+// type and version as unsigned varints, then the flexible encoded fields.
+func DecodeMetadataRecord(value []byte) (MetadataRecord, error) {
+	data := bytes.NewBuffer(value)
+
+	var frameVersion, apiKey, version uint64
+	if err := utils.ReadUVARINT(&frameVersion, data); err != nil {
+		return nil, err
+	}
+	if frameVersion != METADATA_RECORD_FRAME_VERSION {
+		return nil, ErrUnsupportedFrameVersion
+	}
+	if err := utils.ReadUVARINT(&apiKey, data); err != nil {
+		return nil, err
+	}
+	if err := utils.ReadUVARINT(&version, data); err != nil {
+		return nil, err
+	}
+
+	rec, err := newMetadataRecord(int16(apiKey))
+	if err != nil {
+		return nil, err
+	}
+	if int16(version) > METADATA_RECORD_VERSIONS[rec.ApiKey()] {
+		return nil, ErrUnsupportedMetadataVersion
+	}
+	for _, field := range rec.fields(int16(version)) {
+		if err := readField(field, data); err != nil {
+			return nil, err
+		}
+	}
+
+	taggedFields, err := utils.ReadTaggedFields(data)
+	if err != nil {
+		return nil, err
+	}
+	known := rec.taggedFields(int16(version))
+	for tag, raw := range taggedFields {
+		if field, ok := known[tag]; ok {
+			if err := readField(field, bytes.NewBuffer(raw)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rec, nil
+}
+
+// EncodeMetadataRecord writes a record at its highest version. Tagged fields
+// are only written when they differ from their default.
+func EncodeMetadataRecord(rec MetadataRecord) []byte {
+	version := METADATA_RECORD_VERSIONS[rec.ApiKey()]
+
+	var data bytes.Buffer
+	utils.WriteUVARINT(&data, METADATA_RECORD_FRAME_VERSION)
+	utils.WriteUVARINT(&data, uint64(rec.ApiKey()))
+	utils.WriteUVARINT(&data, uint64(version))
+	for _, field := range rec.fields(version) {
+		writeField(&data, field)
+	}
+
+	defaults, _ := newMetadataRecord(rec.ApiKey())
+	defaultFields := defaults.taggedFields(version)
+	tags := []uint64{}
+	for tag, field := range rec.taggedFields(version) {
+		if !reflect.DeepEqual(reflect.ValueOf(field).Elem().Interface(), reflect.ValueOf(defaultFields[tag]).Elem().Interface()) {
+			tags = append(tags, tag)
+		}
+	}
+	// Tagged fields must be written in increasing tag order
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	utils.WriteUVARINT(&data, uint64(len(tags)))
+	taggedFields := rec.taggedFields(version)
+	for _, tag := range tags {
+		var value bytes.Buffer
+		writeField(&value, taggedFields[tag])
+		utils.WriteUVARINT(&data, tag)
+		utils.WriteUVARINT(&data, uint64(value.Len()))
+		data.Write(value.Bytes())
+	}
+	return data.Bytes()
+}
+
+func readField(field any, data *bytes.Buffer) error {
+	switch f := field.(type) {
+	case *string:
+		return utils.ReadCompactString(f, data)
+	case **string:
+		return utils.ReadCompactNullableString(f, data)
+	case *uuid.UUID:
+		return utils.ReadUUID(f, data)
+	case *[]byte:
+		return utils.ReadCompactBytes(f, data)
+	case *[]int32:
+		return utils.ReadINT32Array(f, data)
+	case *[]uuid.UUID:
+		return utils.ReadUUIDArray(f, data)
+	case *int8, *int16, *int32, *int64, *uint16, *bool, *float64:
+		return binary.Read(data, binary.BigEndian, f)
+	}
+
+	// Arrays of nested structs
+	slice := reflect.ValueOf(field).Elem()
+	var length int
+	if err := utils.ReadCompactArrayLength(&length, data); err != nil {
+		return err
+	}
+	elements := reflect.MakeSlice(slice.Type(), max(length, 0), max(length, 0))
+	for i := range max(length, 0) {
+		element := elements.Index(i).Addr().Interface().(metadataStruct)
+		for _, nested := range element.fields() {
+			if err := readField(nested, data); err != nil {
+				return err
+			}
+		}
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+	slice.Set(elements)
+	return nil
+}
+
+func writeField(data *bytes.Buffer, field any) {
+	switch f := field.(type) {
+	case *string:
+		utils.WriteCompactString(data, *f)
+		return
+	case **string:
+		utils.WriteCompactNullableString(data, *f)
+		return
+	case *uuid.UUID:
+		data.Write(f.Bytes())
+		return
+	case *[]byte:
+		utils.WriteCompactBytes(data, *f)
+		return
+	case *[]int32:
+		utils.WriteINT32Array(data, *f)
+		return
+	case *[]uuid.UUID:
+		utils.WriteCompactArrayLength(data, len(*f))
+		for _, id := range *f {
+			data.Write(id.Bytes())
+		}
+		return
+	case *int8, *int16, *int32, *int64, *uint16, *bool, *float64:
+		binary.Write(data, binary.BigEndian, f)
+		return
+	}
+
+	slice := reflect.ValueOf(field).Elem()
+	utils.WriteCompactArrayLength(data, slice.Len())
+	for i := range slice.Len() {
+		element := slice.Index(i).Addr().Interface().(metadataStruct)
+		for _, nested := range element.fields() {
+			writeField(data, nested)
+		}
+		utils.WriteTaggedFields(data)
+	}
+}
@@ -0,0 +1,291 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const SNAPSHOT_RECORD_VERSION int16 = 0
+const SNAPSHOT_BATCH_RECORDS = 1000
+
+// Defaults of metadata.log.max.record.bytes.between.snapshots and
+// metadata.log.max.snapshot.interval.ms
+var MaxBytesBetweenSnapshots int64 = 20 * 1024 * 1024
+var MaxSnapshotInterval = time.Hour
+
+// TruncateLog removes what a new snapshot makes redundant. It is replaced
+// when the log is owned by the Raft quorum rather than just read.
+var TruncateLog = TruncateMetadataLog
+
+// ImageRecords returns the records that rebuild the image when replayed on
+// an empty one.
+func ImageRecords(image *MetadataImage) []MetadataRecord {
+	records := []MetadataRecord{}
+
+	features := make([]string, 0, len(image.Features))
+	for name := range image.Features {
+		features = append(features, name)
+	}
+	sort.Strings(features)
+	for _, name := range features {
+		records = append(records, &FeatureLevelRecord{Name: name, FeatureLevel: image.Features[name]})
+	}
+
+	brokerIds := make([]int32, 0, len(image.Brokers))
+	for brokerId := range image.Brokers {
+		brokerIds = append(brokerIds, brokerId)
+	}
+	sort.Slice(brokerIds, func(i, j int) bool { return brokerIds[i] < brokerIds[j] })
+	for _, brokerId := range brokerIds {
+		broker := image.Brokers[brokerId]
+		registration := &RegisterBrokerRecord{
+			BrokerID:             broker.BrokerID,
+			BrokerEpoch:          broker.BrokerEpoch,
+			IncarnationID:        broker.IncarnationID,
+			EndPoints:            broker.EndPoints,
+			Rack:                 broker.Rack,
+			Fenced:               broker.Fenced,
+			InControlledShutdown: broker.InControlledShutdown,
+		}
+		records = append(records, registration)
+	}
+
+	for _, name := range image.TopicNames() {
+		topic, _ := image.TopicByName(name)
+		records = append(records, &TopicRecord{Name: topic.Name, TopicID: topic.TopicId})
+		for _, partition := range topic.Partitions {
+			partitionRecord := &PartitionRecord{
+				PartitionID:            partition.PartitionIndex,
+				TopicID:                topic.TopicId,
+				Replicas:               partition.ReplicaNodeIDs,
+				Isr:                    partition.InsyncReplicaNodeIDs,
+				RemovingReplicas:       partition.RemovingReplicaNodeIDs,
+				AddingReplicas:         partition.AddingReplicaNodeIDs,
+				Leader:                 partition.LeaderID,
+				LeaderRecoveryState:    partition.LeaderRecoveryState,
+				LeaderEpoch:            partition.LeaderEpoch,
+				PartitionEpoch:         partition.PartitionEpoch,
+				Directories:            partition.Directories,
+				EligibleLeaderReplicas: partition.EligibleLeaderReplicaNodeIDs,
+				LastKnownElr:           partition.LastKnownEligibleLeaderReplicaNodeIDs,
+			}
+			records = append(records, partitionRecord)
+		}
+	}
+
+	resources := make([]ConfigResource, 0, len(image.Configs))
+	for resource := range image.Configs {
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Type != resources[j].Type {
+			return resources[i].Type < resources[j].Type
+		}
+		return resources[i].Name < resources[j].Name
+	})
+	for _, resource := range resources {
+		configs := image.Configs[resource]
+		names := make([]string, 0, len(configs))
+		for name := range configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := configs[name]
+			records = append(records, &ConfigRecord{ResourceType: resource.Type, ResourceName: resource.Name, Name: name, Value: &value})
+		}
+	}
+
+	entities := make([]string, 0, len(image.ClientQuotas))
+	for key := range image.ClientQuotas {
+		entities = append(entities, key)
+	}
+	sort.Strings(entities)
+	for _, key := range entities {
+		quotas := image.ClientQuotas[key]
+		names := make([]string, 0, len(quotas))
+		for name := range quotas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			records = append(records, &ClientQuotaRecord{Entity: ParseClientQuotaEntityKey(key), Key: name, Value: quotas[name]})
+		}
+	}
+
+	acls := make([]*AccessControlEntryRecord, 0, len(image.AccessControlEntries))
+	for _, acl := range image.AccessControlEntries {
+		acls = append(acls, acl)
+	}
+	sort.Slice(acls, func(i, j int) bool { return acls[i].ID.String() < acls[j].ID.String() })
+	for _, acl := range acls {
+		records = append(records, acl)
+	}
+
+	credentials := make([]*UserScramCredentialRecord, 0, len(image.ScramCredentials))
+	for _, credential := range image.ScramCredentials {
+		credentials = append(credentials, credential)
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		if credentials[i].Name != credentials[j].Name {
+			return credentials[i].Name < credentials[j].Name
+		}
+		return credentials[i].Mechanism < credentials[j].Mechanism
+	})
+	for _, credential := range credentials {
+		records = append(records, credential)
+	}
+
+	if image.NextProducerID > 0 {
+		records = append(records, &ProducerIdsRecord{BrokerID: -1, BrokerEpoch: -1, NextProducerID: image.NextProducerID})
+	}
+	return records
+}
+
+func snapshotHeader(lastContainedLogTimestamp int64) []byte {
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, SNAPSHOT_RECORD_VERSION)
+	binary.Write(&value, binary.BigEndian, lastContainedLogTimestamp)
+	utils.WriteTaggedFields(&value)
+	return value.Bytes()
+}
+
+func snapshotFooter() []byte {
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, SNAPSHOT_RECORD_VERSION)
+	utils.WriteTaggedFields(&value)
+	return value.Bytes()
+}
+
+// encodeSnapshot lays out a snapshot the way KRaft does: a SnapshotHeader
+// control batch, the records in batches starting at offset 0, and a
+// SnapshotFooter control batch.
+func encodeSnapshot(image *MetadataImage, timestamp int64) ([]byte, error) {
+	batches := []*record.RecordBatch{record.NewControlBatch(record.CONTROL_SNAPSHOT_HEADER, snapshotHeader(timestamp), timestamp)}
+	records := ImageRecords(image)
+	for start := 0; start < len(records); start += SNAPSHOT_BATCH_RECORDS {
+		values := []record.Record{}
+		for _, rec := range records[start:min(start+SNAPSHOT_BATCH_RECORDS, len(records))] {
+			values = append(values, record.Record{Value: EncodeMetadataRecord(rec)})
+		}
+		batch := record.NewRecordBatch(values)
+		batch.BaseTimestamp = timestamp
+		batch.MaxTimestamp = timestamp
+		batches = append(batches, batch)
+	}
+	batches = append(batches, record.NewControlBatch(record.CONTROL_SNAPSHOT_FOOTER, snapshotFooter(), timestamp))
+
+	var data bytes.Buffer
+	offset := int64(0)
+	for _, batch := range batches {
+		batch.BaseOffset = offset
+		batch.PartitionLeaderEpoch = image.Epoch
+		raw, err := batch.Encode()
+		if err != nil {
+			return nil, err
+		}
+		data.Write(raw)
+		offset = batch.NextOffset()
+	}
+	return data.Bytes(), nil
+}
+
+// WriteSnapshot writes the image as the snapshot ending after its offset.
+// The file is renamed into place so readers never see a partial snapshot.
+func WriteSnapshot(dir string, image *MetadataImage, timestamp int64) (SnapshotID, error) {
+	id := SnapshotID{EndOffset: image.Offset + 1, Epoch: image.Epoch}
+	data, err := encodeSnapshot(image, timestamp)
+	if err != nil {
+		return id, err
+	}
+	path := SnapshotFileName(dir, id)
+	if err := os.WriteFile(path+".part", data, 0o644); err != nil {
+		return id, err
+	}
+	return id, os.Rename(path+".part", path)
+}
+
+// TruncateMetadataLog deletes the segments whose records all come before the
+// snapshot, and the snapshots older than it.
+func TruncateMetadataLog(dir string, snapshot SnapshotID) error {
+	_, segments, err := listMetadataFiles(dir)
+	if err != nil {
+		return err
+	}
+	for i, baseOffset := range segments {
+		if i+1 >= len(segments) || segments[i+1] > snapshot.EndOffset {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, fmt.Sprintf("%020d%s", baseOffset, METADATA_LOG_SUFFIX))); err != nil {
+			return err
+		}
+	}
+	return DeleteSnapshotsBefore(dir, snapshot)
+}
+
+func DeleteSnapshotsBefore(dir string, snapshot SnapshotID) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if id, ok := parseSnapshotFileName(entry.Name()); ok && id.EndOffset < snapshot.EndOffset {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MetadataSnapshotter snapshots the published image once enough metadata
+// bytes have been applied or enough time has passed since the last
+// snapshot, and then truncates the log behind it.
+type MetadataSnapshotter struct {
+	dir          string
+	maxBytes     int64
+	maxInterval  time.Duration
+	bytes        int64
+	lastSnapshot time.Time
+	lastOffset   int64
+}
+
+func NewMetadataSnapshotter(dir string, maxBytes int64, maxInterval time.Duration) *MetadataSnapshotter {
+	return &MetadataSnapshotter{dir: dir, maxBytes: maxBytes, maxInterval: maxInterval, lastSnapshot: time.Now(), lastOffset: -1}
+}
+
+// MaybeSnapshot is called after every poll with the bytes of metadata log
+// applied by it.
+func (s *MetadataSnapshotter) MaybeSnapshot(image *MetadataImage, appliedBytes int64, now time.Time) {
+	s.bytes += appliedBytes
+	if image.Offset <= s.lastOffset {
+		return
+	}
+	bytesDue := s.maxBytes > 0 && s.bytes >= s.maxBytes
+	timeDue := s.maxInterval > 0 && now.Sub(s.lastSnapshot) >= s.maxInterval
+	if !bytesDue && !timeDue {
+		return
+	}
+
+	id, err := WriteSnapshot(s.dir, image, now.UnixMilli())
+	if err != nil {
+		log.Printf("Failed to write metadata snapshot: %s\n", err.Error())
+		return
+	}
+	s.bytes = 0
+	s.lastSnapshot = now
+	s.lastOffset = image.Offset
+	log.Printf("Wrote metadata snapshot at offset %d in epoch %d\n", id.EndOffset, id.Epoch)
+	if err := TruncateLog(s.dir, id); err != nil {
+		log.Printf("Failed to truncate metadata log: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"hash/maphash"
+	"maps"
+
+	"github.com/gofrs/uuid"
+)
+
+const TOPIC_MAP_SHARDS = 256
+
+var topicMapSeed = maphash.MakeSeed()
+
+func hashTopicName(name string) uint64 {
+	return maphash.String(topicMapSeed, name)
+}
+
+func hashTopicId(topicId uuid.UUID) uint64 {
+	return maphash.Bytes(topicMapSeed, topicId[:])
+}
+
+// topicMap indexes the topics of an image. It is split into shards that
+// images share, so applying a delta only copies the shards it changes
+// rather than every topic.
+type topicMap[K comparable] struct {
+	shards [TOPIC_MAP_SHARDS]map[K]*ClusterTopic
+	count  int
+	hash   func(K) uint64
+}
+
+func newTopicMap[K comparable](hash func(K) uint64) topicMap[K] {
+	return topicMap[K]{hash: hash}
+}
+
+func (m *topicMap[K]) shard(key K) int {
+	return int(m.hash(key) % TOPIC_MAP_SHARDS)
+}
+
+func (m *topicMap[K]) get(key K) (*ClusterTopic, bool) {
+	topic, ok := m.shards[m.shard(key)][key]
+	return topic, ok
+}
+
+func (m *topicMap[K]) len() int {
+	return m.count
+}
+
+func (m *topicMap[K]) each(fn func(K, *ClusterTopic)) {
+	for _, shard := range m.shards {
+		for key, topic := range shard {
+			fn(key, topic)
+		}
+	}
+}
+
+// topicMapBuilder changes a copy of a topicMap, copying each shard the
+// first time it is written.
+type topicMapBuilder[K comparable] struct {
+	m      topicMap[K]
+	copied [TOPIC_MAP_SHARDS]bool
+}
+
+func (m *topicMap[K]) builder() *topicMapBuilder[K] {
+	return &topicMapBuilder[K]{m: *m}
+}
+
+func (b *topicMapBuilder[K]) get(key K) (*ClusterTopic, bool) {
+	return b.m.get(key)
+}
+
+func (b *topicMapBuilder[K]) writable(key K) map[K]*ClusterTopic {
+	i := b.m.shard(key)
+	if !b.copied[i] {
+		b.m.shards[i] = maps.Clone(b.m.shards[i])
+		if b.m.shards[i] == nil {
+			b.m.shards[i] = map[K]*ClusterTopic{}
+		}
+		b.copied[i] = true
+	}
+	return b.m.shards[i]
+}
+
+func (b *topicMapBuilder[K]) set(key K, topic *ClusterTopic) {
+	shard := b.writable(key)
+	if _, ok := shard[key]; !ok {
+		b.m.count++
+	}
+	shard[key] = topic
+}
+
+func (b *topicMapBuilder[K]) delete(key K) {
+	if _, ok := b.m.get(key); !ok {
+		return
+	}
+	delete(b.writable(key), key)
+	b.m.count--
+}
+
+func (b *topicMapBuilder[K]) build() topicMap[K] {
+	return b.m
+}
@@ -0,0 +1,37 @@
+package producer
+
+import (
+	"math"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// InitProducerId serves non-transactional producers. A producer that passes
+// its current ID and epoch gets the epoch bumped, so that it can reset its
+// sequence numbers without losing its identity (KIP-360). The epoch must be
+// the one the producer last wrote to this broker's partitions with; a
+// producer this broker has no state for is given a new ID.
+func InitProducerId(producerId int64, producerEpoch int16) (int64, int16, int16) {
+	if producerId != record.NO_PRODUCER_ID {
+		currentEpoch, ok := storage.ProducerEpoch(producerId)
+		switch {
+		case ok && producerEpoch < currentEpoch:
+			return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.PRODUCER_FENCED
+		case ok && producerEpoch > currentEpoch:
+			return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.INVALID_PRODUCER_EPOCH
+		case ok && producerEpoch < math.MaxInt16-1:
+			return producerId, producerEpoch + 1, utils.NONE
+		}
+	}
+	manager, err := DefaultProducerIdManager()
+	if err != nil {
+		return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.UNKNOWN_SERVER_ERROR
+	}
+	id, err := manager.GenerateProducerId()
+	if err != nil {
+		return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.UNKNOWN_SERVER_ERROR
+	}
+	return id, 0, utils.NONE
+}
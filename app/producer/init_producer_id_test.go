@@ -0,0 +1,71 @@
+package producer
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func TestInitProducerId(t *testing.T) {
+	logDir := storage.LogDir
+	storage.LogDir = t.TempDir()
+	t.Cleanup(func() {
+		storage.CloseAll()
+		storage.LogDir = logDir
+	})
+
+	l, err := storage.GetLog("foo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := record.NewRecordBatch([]record.Record{{Value: []byte("value")}})
+	batch.ProducerID, batch.ProducerEpoch, batch.BaseSequence = 1000, 2, 0
+	raw, _ := batch.Encode()
+	if _, err := l.AppendAsLeader(raw, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, epoch, errorCode := InitProducerId(1000, 2); id != 1000 || epoch != 3 || errorCode != utils.NONE {
+		t.Errorf("Expected the epoch to be bumped, got %d %d %d", id, epoch, errorCode)
+	}
+	if _, _, errorCode := InitProducerId(1000, 1); errorCode != utils.PRODUCER_FENCED {
+		t.Errorf("Expected an older epoch to be fenced, got %d", errorCode)
+	}
+	if _, _, errorCode := InitProducerId(1000, 5); errorCode != utils.INVALID_PRODUCER_EPOCH {
+		t.Errorf("Expected a newer epoch to be invalid, got %d", errorCode)
+	}
+	// Producers the broker has no state for cannot claim their ID
+	if id, epoch, errorCode := InitProducerId(2000, 0); id == 2000 || epoch != 0 || errorCode != utils.NONE {
+		t.Errorf("Expected a new producer ID, got %d %d %d", id, epoch, errorCode)
+	}
+}
+
+func TestInitProducerIdAfterRestart(t *testing.T) {
+	logDir := storage.LogDir
+	storage.LogDir = t.TempDir()
+	t.Cleanup(func() {
+		storage.CloseAll()
+		storage.LogDir = logDir
+	})
+
+	l, err := storage.GetLog("foo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := record.NewRecordBatch([]record.Record{{Value: []byte("value")}})
+	batch.ProducerID, batch.ProducerEpoch, batch.BaseSequence = 1000, 2, 0
+	raw, _ := batch.Encode()
+	if _, err := l.AppendAsLeader(raw, 0); err != nil {
+		t.Fatal(err)
+	}
+	storage.CloseAll()
+
+	if err := storage.LoadLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if id, epoch, errorCode := InitProducerId(1000, 2); id != 1000 || epoch != 3 || errorCode != utils.NONE {
+		t.Errorf("Expected the epoch to be bumped after a restart, got %d %d %d", id, epoch, errorCode)
+	}
+}
@@ -0,0 +1,93 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+)
+
+const PRODUCER_ID_BLOCK_SIZE int64 = 1000
+const PRODUCER_ID_BLOCK_FILE = "producer-id-block"
+
+var ErrProducerIdsExhausted = errors.New("producer ids exhausted")
+
+// ProducerIdManager hands out producer IDs from blocks. The end of the current
+// block is persisted before any ID in it is used, so IDs are never reused
+// across restarts.
+type ProducerIdManager struct {
+	mu             sync.Mutex
+	path           string
+	nextProducerId int64
+	blockEnd       int64
+}
+
+var defaultManager *ProducerIdManager
+var defaultManagerLock sync.Mutex
+
+func NewProducerIdManager(path string) (*ProducerIdManager, error) {
+	m := &ProducerIdManager{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) == 8 {
+		m.nextProducerId = int64(binary.BigEndian.Uint64(data))
+		m.blockEnd = m.nextProducerId
+	}
+	return m, nil
+}
+
+// DefaultProducerIdManager is the manager of the broker's log directory. An
+// error loading it is returned rather than starting over from ID 0, which
+// would hand out IDs again, and loading is retried on the next call.
+func DefaultProducerIdManager() (*ProducerIdManager, error) {
+	defaultManagerLock.Lock()
+	defer defaultManagerLock.Unlock()
+	if defaultManager == nil {
+		manager, err := NewProducerIdManager(filepath.Join(storage.LogDir, PRODUCER_ID_BLOCK_FILE))
+		if err != nil {
+			return nil, err
+		}
+		defaultManager = manager
+	}
+	return defaultManager, nil
+}
+
+func (m *ProducerIdManager) GenerateProducerId() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nextProducerId >= m.blockEnd {
+		if m.blockEnd > (1<<63-1)-PRODUCER_ID_BLOCK_SIZE {
+			return -1, ErrProducerIdsExhausted
+		}
+		if err := m.persistBlockEnd(m.blockEnd + PRODUCER_ID_BLOCK_SIZE); err != nil {
+			return -1, err
+		}
+		m.nextProducerId = m.blockEnd
+		m.blockEnd += PRODUCER_ID_BLOCK_SIZE
+	}
+	id := m.nextProducerId
+	m.nextProducerId++
+	return id, nil
+}
+
+func (m *ProducerIdManager) persistBlockEnd(blockEnd int64) error {
+	if m.path == "" {
+		return nil
+	}
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, blockEnd)
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path+".tmp", data.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(m.path+".tmp", m.path)
+}
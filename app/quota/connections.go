@@ -0,0 +1,191 @@
+package quota
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ConnectionConfig struct {
+	// MaxConnections bounds the connections of the broker across listeners.
+	// Accepting waits for a connection to close once it is reached.
+	MaxConnections int
+	// MaxConnectionsPerIp bounds the connections from one address, unless
+	// MaxConnectionsPerIpOverrides sets another bound for it. Connections
+	// over it are closed.
+	MaxConnectionsPerIp          int
+	MaxConnectionsPerIpOverrides map[string]int
+	// MaxConnectionCreationRate bounds the connections accepted per second
+	// across the broker, and MaxConnectionCreationRatePerIp those from one
+	// address. Accepting is delayed while either is exceeded.
+	MaxConnectionCreationRate      float64
+	MaxConnectionCreationRatePerIp float64
+	Window                         Config
+}
+
+func DefaultConnectionConfig() ConnectionConfig {
+	return ConnectionConfig{
+		MaxConnections:                 math.MaxInt32,
+		MaxConnectionsPerIp:            math.MaxInt32,
+		MaxConnectionCreationRate:      math.MaxInt32,
+		MaxConnectionCreationRatePerIp: math.MaxInt32,
+		Window:                         DefaultConfig(),
+	}
+}
+
+// ParseConnectionOverrides reads max.connections.per.ip.overrides entries of
+// the form host:count.
+func ParseConnectionOverrides(entries []string) (map[string]int, error) {
+	overrides := map[string]int{}
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid connection override %q", entry)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid connection override %q", entry)
+		}
+		overrides[strings.TrimSpace(entry[:i])] = count
+	}
+	return overrides, nil
+}
+
+type ConnectionMetrics struct {
+	Active    int
+	Accepted  int64
+	Rejected  int64
+	Throttled int64
+}
+
+// ConnectionQuotas counts the connections of the broker and of each address.
+// Connections on an exempt listener, as the inter-broker listener is, count
+// toward the per address bound only.
+type ConnectionQuotas struct {
+	config ConnectionConfig
+	Now    func() time.Time
+
+	mu        sync.Mutex
+	released  *sync.Cond
+	total     int
+	perIp     map[string]int
+	rate      *Rate
+	ipRates   map[string]*Rate
+	lastSweep time.Time
+	metrics   ConnectionMetrics
+	sleep     func(time.Duration)
+}
+
+func NewConnectionQuotas(config ConnectionConfig) *ConnectionQuotas {
+	q := &ConnectionQuotas{
+		config:  config,
+		Now:     time.Now,
+		perIp:   map[string]int{},
+		rate:    NewRate(config.Window),
+		ipRates: map[string]*Rate{},
+		sleep:   time.Sleep,
+	}
+	q.released = sync.NewCond(&q.mu)
+	return q
+}
+
+var Connections = NewConnectionQuotas(DefaultConnectionConfig())
+
+func (q *ConnectionQuotas) maxConnectionsPerIp(ip string) int {
+	if bound, ok := q.config.MaxConnectionsPerIpOverrides[ip]; ok {
+		return bound
+	}
+	return q.config.MaxConnectionsPerIp
+}
+
+// Accept admits a connection from ip, after delaying it for the creation
+// rates and waiting for a free connection unless exempt. It returns an error
+// when the connection must be closed instead. Only connections within the
+// bound of their address count toward the creation rates, and the
+// connection holds its place in that bound while it is delayed.
+func (q *ConnectionQuotas) Accept(ip string, exempt bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if bound := q.maxConnectionsPerIp(ip); q.perIp[ip] >= bound {
+		q.metrics.Rejected++
+		return fmt.Errorf("too many connections from %s, the limit is %d", ip, bound)
+	}
+	q.perIp[ip]++
+
+	throttled := false
+	if !exempt {
+		if throttle := q.recordCreation(ip); throttle > 0 {
+			throttled = true
+			q.mu.Unlock()
+			q.sleep(throttle)
+			q.mu.Lock()
+		}
+		for q.total >= q.config.MaxConnections {
+			throttled = true
+			q.released.Wait()
+		}
+		q.total++
+	}
+	if throttled {
+		q.metrics.Throttled++
+	}
+	q.metrics.Accepted++
+	return nil
+}
+
+// recordCreation meters a new connection from ip against the creation rates
+// and returns how long it must be delayed for the most exceeded of them.
+func (q *ConnectionQuotas) recordCreation(ip string) time.Duration {
+	now := q.Now()
+	q.sweep(now)
+	rate, ok := q.ipRates[ip]
+	if !ok {
+		rate = NewRate(q.config.Window)
+		q.ipRates[ip] = rate
+	}
+	rate.Record(1, now)
+	q.rate.Record(1, now)
+	return max(
+		rate.ThrottleTime(q.config.MaxConnectionCreationRatePerIp, now),
+		q.rate.ThrottleTime(q.config.MaxConnectionCreationRate, now),
+	)
+}
+
+func (q *ConnectionQuotas) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < time.Minute {
+		return
+	}
+	q.lastSweep = now
+	for ip, rate := range q.ipRates {
+		if now.Sub(rate.lastRecord) > INACTIVE_RATE_EXPIRY {
+			delete(q.ipRates, ip)
+		}
+	}
+}
+
+// Release frees the connection of an accepted connection once it closes.
+func (q *ConnectionQuotas) Release(ip string, exempt bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.perIp[ip]--; q.perIp[ip] <= 0 {
+		delete(q.perIp, ip)
+	}
+	if !exempt {
+		q.total--
+		q.released.Signal()
+	}
+}
+
+func (q *ConnectionQuotas) Metrics() ConnectionMetrics {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	metrics := q.metrics
+	for _, count := range q.perIp {
+		metrics.Active += count
+	}
+	return metrics
+}
@@ -0,0 +1,144 @@
+package quota
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+)
+
+const ENTITY_USER = "user"
+const ENTITY_CLIENT_ID = "client-id"
+
+const PRODUCER_BYTE_RATE = "producer_byte_rate"
+const CONSUMER_BYTE_RATE = "consumer_byte_rate"
+const REQUEST_PERCENTAGE = "request_percentage"
+
+// CLIENT_QUOTA_KEYS are the quotas of user and client-id entities
+var CLIENT_QUOTA_KEYS = []string{PRODUCER_BYTE_RATE, CONSUMER_BYTE_RATE, REQUEST_PERCENTAGE}
+
+// Rates of clients that have been idle this long are dropped
+const INACTIVE_RATE_EXPIRY = time.Hour
+
+type Config struct {
+	NumSamples   int
+	SampleWindow time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{NumSamples: 11, SampleWindow: time.Second}
+}
+
+// Client is who a request is metered for: the name of its principal, and the
+// client id it sent.
+type Client struct {
+	User     string
+	ClientId string
+}
+
+func NewClient(principal string, clientId string) Client {
+	_, user, _ := strings.Cut(principal, ":")
+	return Client{User: user, ClientId: clientId}
+}
+
+type rateKey struct {
+	quotaType string
+	entity    string
+}
+
+// QuotaManager meters clients against the quotas in the metadata image.
+// Clients share a rate when the same quota entity applies to them, as every
+// client id of a user does when the quota is on the user.
+type QuotaManager struct {
+	config Config
+	Now    func() time.Time
+
+	mu        sync.Mutex
+	rates     map[rateKey]*Rate
+	lastSweep time.Time
+}
+
+func NewQuotaManager(config Config) *QuotaManager {
+	return &QuotaManager{config: config, Now: time.Now, rates: map[rateKey]*Rate{}}
+}
+
+var Current = NewQuotaManager(DefaultConfig())
+
+func entityKey(user *string, userDefault bool, clientId *string, clientDefault bool) string {
+	entity := []metadata.ClientQuotaEntity{}
+	if user != nil || userDefault {
+		entity = append(entity, metadata.ClientQuotaEntity{EntityType: ENTITY_USER, EntityName: user})
+	}
+	if clientId != nil || clientDefault {
+		entity = append(entity, metadata.ClientQuotaEntity{EntityType: ENTITY_CLIENT_ID, EntityName: clientId})
+	}
+	return metadata.ClientQuotaEntityKey(entity)
+}
+
+// Lookup finds the quota of a client from the most specific entity that sets
+// it, in Kafka's order of precedence, and returns that entity's key.
+func Lookup(quotas map[string]map[string]float64, quotaType string, client Client) (string, float64, bool) {
+	user, clientId := &client.User, &client.ClientId
+	for _, key := range []string{
+		entityKey(user, false, clientId, false),
+		entityKey(user, false, nil, true),
+		entityKey(user, false, nil, false),
+		entityKey(nil, true, clientId, false),
+		entityKey(nil, true, nil, true),
+		entityKey(nil, true, nil, false),
+		entityKey(nil, false, clientId, false),
+		entityKey(nil, false, nil, true),
+	} {
+		if bound, ok := quotas[key][quotaType]; ok {
+			return key, bound, true
+		}
+	}
+	return "", 0, false
+}
+
+// Record meters value against the client's quota of quotaType, and returns
+// how long the client should be throttled for.
+func (m *QuotaManager) Record(quotaType string, client Client, value float64) time.Duration {
+	return m.measure(quotaType, client, value, true)
+}
+
+// ThrottleTime is how long the client should be throttled for its use of
+// quotaType so far.
+func (m *QuotaManager) ThrottleTime(quotaType string, client Client) time.Duration {
+	return m.measure(quotaType, client, 0, false)
+}
+
+func (m *QuotaManager) measure(quotaType string, client Client, value float64, record bool) time.Duration {
+	entity, bound, ok := Lookup(metadata.Image().ClientQuotas, quotaType, client)
+	if !ok {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.Now()
+	m.sweep(now)
+
+	key := rateKey{quotaType: quotaType, entity: entity}
+	rate, ok := m.rates[key]
+	if !ok {
+		rate = NewRate(m.config)
+		m.rates[key] = rate
+	}
+	if record {
+		rate.Record(value, now)
+	}
+	return rate.ThrottleTime(bound, now)
+}
+
+func (m *QuotaManager) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, rate := range m.rates {
+		if now.Sub(rate.lastRecord) > INACTIVE_RATE_EXPIRY {
+			delete(m.rates, key)
+		}
+	}
+}
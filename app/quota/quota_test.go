@@ -0,0 +1,195 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+)
+
+func TestLookup(t *testing.T) {
+	quotas := map[string]map[string]float64{
+		"user=alice":                    {PRODUCER_BYTE_RATE: 100},
+		"client-id=producer,user=alice": {PRODUCER_BYTE_RATE: 200},
+		"user=<default>":                {PRODUCER_BYTE_RATE: 300},
+		"client-id=producer":            {PRODUCER_BYTE_RATE: 400, CONSUMER_BYTE_RATE: 500},
+	}
+	cases := []struct {
+		client Client
+		entity string
+		bound  float64
+	}{
+		{Client{User: "alice", ClientId: "producer"}, "client-id=producer,user=alice", 200},
+		{Client{User: "alice", ClientId: "other"}, "user=alice", 100},
+		{Client{User: "bob", ClientId: "producer"}, "user=<default>", 300},
+	}
+	for _, c := range cases {
+		entity, bound, ok := Lookup(quotas, PRODUCER_BYTE_RATE, c.client)
+		if !ok || entity != c.entity || bound != c.bound {
+			t.Errorf("Expected %+v to get %v from %s, got %v from %s", c.client, c.bound, c.entity, bound, entity)
+		}
+	}
+	if entity, bound, _ := Lookup(quotas, CONSUMER_BYTE_RATE, Client{User: "alice", ClientId: "producer"}); entity != "client-id=producer" || bound != 500 {
+		t.Errorf("Expected the client id's consumer quota, got %v from %s", bound, entity)
+	}
+	if _, _, ok := Lookup(quotas, REQUEST_PERCENTAGE, Client{User: "alice", ClientId: "producer"}); ok {
+		t.Error("Expected no request quota")
+	}
+}
+
+func TestQuotaManager(t *testing.T) {
+	user := "alice"
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	delta.Replay(&metadata.ClientQuotaRecord{Entity: []metadata.ClientQuotaEntity{{EntityType: ENTITY_USER, EntityName: &user}}, Key: PRODUCER_BYTE_RATE, Value: 1000})
+	metadata.Publish(delta.Apply())
+	t.Cleanup(func() { metadata.Publish(metadata.EmptyImage()) })
+
+	now := time.Unix(1000, 0)
+	m := NewQuotaManager(Config{NumSamples: 11, SampleWindow: time.Second})
+	m.Now = func() time.Time { return now }
+
+	alice := NewClient("User:alice", "producer")
+	// 10KB over the 10s the rate is measured over is within 1000B/s
+	if throttle := m.Record(PRODUCER_BYTE_RATE, alice, 10000); throttle != 0 {
+		t.Fatalf("Expected no throttle, got %v", throttle)
+	}
+	// Doubling it must wait as long again for the rate to fall back
+	if throttle := m.Record(PRODUCER_BYTE_RATE, alice, 10000); throttle != 10*time.Second {
+		t.Fatalf("Expected a 10s throttle, got %v", throttle)
+	}
+	// Other client ids of the user share the quota
+	if throttle := m.ThrottleTime(PRODUCER_BYTE_RATE, NewClient("User:alice", "other")); throttle != 10*time.Second {
+		t.Fatalf("Expected the user's throttle, got %v", throttle)
+	}
+	if throttle := m.Record(PRODUCER_BYTE_RATE, NewClient("User:bob", "producer"), 1e9); throttle != 0 {
+		t.Fatalf("Expected users without a quota to go unthrottled, got %v", throttle)
+	}
+
+	// The throttle is capped at the span the rate is measured over
+	m.Record(PRODUCER_BYTE_RATE, alice, 1e6)
+	if throttle := m.ThrottleTime(PRODUCER_BYTE_RATE, alice); throttle != 11*time.Second {
+		t.Fatalf("Expected the throttle to be capped at 11s, got %v", throttle)
+	}
+	// Old samples expire once their windows pass
+	now = now.Add(12 * time.Second)
+	if throttle := m.ThrottleTime(PRODUCER_BYTE_RATE, alice); throttle != 0 {
+		t.Fatalf("Expected the samples to expire, got %v", throttle)
+	}
+}
+
+func TestConnectionQuotas(t *testing.T) {
+	config := DefaultConnectionConfig()
+	config.MaxConnections = 2
+	config.MaxConnectionsPerIp = 1
+	config.MaxConnectionsPerIpOverrides = map[string]int{"10.0.0.1": 2}
+	q := NewConnectionQuotas(config)
+
+	if err := q.Accept("10.0.0.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Accept("10.0.0.2", false); err != nil {
+		t.Fatal(err)
+	}
+	// The address is at its bound, so the connection is closed
+	if err := q.Accept("10.0.0.2", true); err == nil {
+		t.Fatal("Expected the second connection from 10.0.0.2 to be rejected")
+	}
+
+	// The broker is at its bound, so accepting waits for a connection to close
+	accepted := make(chan error)
+	go func() { accepted <- q.Accept("10.0.0.1", false) }()
+	select {
+	case <-accepted:
+		t.Fatal("Expected accepting to wait for a free connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.Release("10.0.0.2", false)
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ConnectionMetrics{Active: 2, Accepted: 3, Rejected: 1, Throttled: 1}
+	if metrics := q.Metrics(); metrics != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, metrics)
+	}
+}
+
+func TestConnectionCreationRate(t *testing.T) {
+	config := DefaultConnectionConfig()
+	config.MaxConnectionCreationRate = 1
+	q := NewConnectionQuotas(config)
+	now := time.Unix(1000, 0)
+	q.Now = func() time.Time { return now }
+	slept := time.Duration(0)
+	q.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	// 10 connections are within 1 per second over the 10s measured
+	for range 10 {
+		q.Accept("10.0.0.1", false)
+	}
+	if slept != 0 {
+		t.Fatalf("Expected no delay, got %v", slept)
+	}
+	q.Accept("10.0.0.1", false)
+	if slept != time.Second {
+		t.Fatalf("Expected a 1s delay, got %v", slept)
+	}
+	// Exempt listeners are not delayed
+	q.Accept("10.0.0.1", true)
+	if slept != time.Second || q.Metrics().Throttled != 1 {
+		t.Fatalf("Expected exempt connections to be accepted at once, got %v", q.Metrics())
+	}
+
+	if _, err := ParseConnectionOverrides([]string{"10.0.0.1:5", "broker-1:10", "::1:3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseConnectionOverrides([]string{"10.0.0.1"}); err == nil {
+		t.Fatal("Expected an override without a count to be invalid")
+	}
+}
+
+func TestConnectionCreationRatePerIp(t *testing.T) {
+	config := DefaultConnectionConfig()
+	config.MaxConnectionsPerIp = 10
+	config.MaxConnectionCreationRatePerIp = 1
+	q := NewConnectionQuotas(config)
+	now := time.Unix(1000, 0)
+	q.Now = func() time.Time { return now }
+	slept := time.Duration(0)
+	q.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	for range 10 {
+		q.Accept("10.0.0.1", false)
+	}
+	// Connections over the bound of the address are closed without counting
+	// toward its rate
+	for range 5 {
+		if err := q.Accept("10.0.0.1", false); err == nil {
+			t.Fatal("Expected connections over the bound of the address to be rejected")
+		}
+	}
+	if slept != 0 {
+		t.Fatalf("Expected no delay, got %v", slept)
+	}
+	q.Release("10.0.0.1", false)
+	q.Accept("10.0.0.1", false)
+	if slept != time.Second {
+		t.Fatalf("Expected a 1s delay, got %v", slept)
+	}
+	// Other addresses have rates of their own
+	q.Accept("10.0.0.2", false)
+	if slept != time.Second {
+		t.Fatalf("Expected no delay for another address, got %v", slept)
+	}
+
+	expected := ConnectionMetrics{Active: 11, Accepted: 12, Rejected: 5, Throttled: 1}
+	if metrics := q.Metrics(); metrics != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, metrics)
+	}
+}
@@ -0,0 +1,71 @@
+package quota
+
+import "time"
+
+type sample struct {
+	start time.Time
+	value float64
+}
+
+// Rate measures the rate of recorded values per second over the last
+// NumSamples windows of SampleWindow each.
+type Rate struct {
+	config     Config
+	samples    []sample
+	lastRecord time.Time
+}
+
+func NewRate(config Config) *Rate {
+	return &Rate{config: config}
+}
+
+func (r *Rate) Record(value float64, now time.Time) {
+	r.expire(now)
+	if len(r.samples) == 0 || now.Sub(r.samples[len(r.samples)-1].start) >= r.config.SampleWindow {
+		r.samples = append(r.samples, sample{start: now})
+	}
+	r.samples[len(r.samples)-1].value += value
+	r.lastRecord = now
+}
+
+// expire drops the samples that ended before the oldest window that is kept.
+func (r *Rate) expire(now time.Time) {
+	horizon := now.Add(-time.Duration(r.config.NumSamples) * r.config.SampleWindow)
+	expired := 0
+	for expired < len(r.samples) && !r.samples[expired].start.After(horizon) {
+		expired++
+	}
+	r.samples = r.samples[expired:]
+}
+
+// windowSize is the span the rate is averaged over. It is at least all but
+// one of the windows, so a burst on a new rate is not read as a far higher
+// rate than it is.
+func (r *Rate) windowSize(now time.Time) time.Duration {
+	minimum := time.Duration(r.config.NumSamples-1) * r.config.SampleWindow
+	if len(r.samples) == 0 {
+		return minimum
+	}
+	return max(now.Sub(r.samples[0].start), minimum)
+}
+
+func (r *Rate) Measure(now time.Time) float64 {
+	r.expire(now)
+	total := 0.0
+	for _, sample := range r.samples {
+		total += sample.value
+	}
+	return total / r.windowSize(now).Seconds()
+}
+
+// ThrottleTime is how long the client must wait for its rate to fall back
+// to bound, capped at the span the rate is measured over.
+func (r *Rate) ThrottleTime(bound float64, now time.Time) time.Duration {
+	rate := r.Measure(now)
+	if bound <= 0 || rate <= bound {
+		return 0
+	}
+	windowSize := r.windowSize(now)
+	throttle := time.Duration((rate - bound) / bound * float64(windowSize))
+	return min(throttle, time.Duration(r.config.NumSamples)*r.config.SampleWindow)
+}
@@ -0,0 +1,103 @@
+package raft
+
+import (
+	"sync"
+	"time"
+)
+
+// LocalNetwork connects nodes in the same process, for tests. Links can be
+// cut to simulate partitions.
+type LocalNetwork struct {
+	mu           sync.Mutex
+	nodes        map[int32]*RaftNode
+	disconnected map[int32]bool
+}
+
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{nodes: map[int32]*RaftNode{}, disconnected: map[int32]bool{}}
+}
+
+func (network *LocalNetwork) Add(n *RaftNode) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.nodes[n.config.NodeID] = n
+}
+
+// Disconnect drops every request to or from the node until it is connected
+// again.
+func (network *LocalNetwork) Disconnect(nodeId int32) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.disconnected[nodeId] = true
+}
+
+func (network *LocalNetwork) Connect(nodeId int32) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	delete(network.disconnected, nodeId)
+}
+
+// Transport returns the transport a node uses to send requests.
+func (network *LocalNetwork) Transport(source int32) Transport {
+	return &localTransport{network: network, source: source}
+}
+
+func (network *LocalNetwork) route(source int32, destination int32) (*RaftNode, error) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	n, ok := network.nodes[destination]
+	if !ok || network.disconnected[source] || network.disconnected[destination] {
+		return nil, ErrUnreachable
+	}
+	return n, nil
+}
+
+type localTransport struct {
+	network *LocalNetwork
+	source  int32
+}
+
+func (t *localTransport) Vote(destination int32, req VoteRequest) (VoteResponse, error) {
+	n, err := t.network.route(t.source, destination)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	return n.HandleVote(req), nil
+}
+
+func (t *localTransport) BeginQuorumEpoch(destination int32, req BeginQuorumEpochRequest) (QuorumEpochResponse, error) {
+	n, err := t.network.route(t.source, destination)
+	if err != nil {
+		return QuorumEpochResponse{}, err
+	}
+	return n.HandleBeginQuorumEpoch(req), nil
+}
+
+func (t *localTransport) EndQuorumEpoch(destination int32, req EndQuorumEpochRequest) (QuorumEpochResponse, error) {
+	n, err := t.network.route(t.source, destination)
+	if err != nil {
+		return QuorumEpochResponse{}, err
+	}
+	return n.HandleEndQuorumEpoch(req), nil
+}
+
+func (t *localTransport) Fetch(destination int32, req FetchRequest, maxWait time.Duration) (FetchResponse, error) {
+	n, err := t.network.route(t.source, destination)
+	if err != nil {
+		return FetchResponse{}, err
+	}
+	resp := n.HandleFetch(req, maxWait)
+	// The response is lost if the link was cut while the fetch waited
+	if _, err := t.network.route(t.source, destination); err != nil {
+		return FetchResponse{}, err
+	}
+	return resp, nil
+}
+
+func (t *localTransport) FetchSnapshot(destination int32, req FetchSnapshotRequest) (FetchSnapshotResponse, error) {
+	n, err := t.network.route(t.source, destination)
+	if err != nil {
+		return FetchSnapshotResponse{}, err
+	}
+	return n.HandleFetchSnapshot(req), nil
+}
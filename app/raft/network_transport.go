@@ -0,0 +1,485 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const FETCH_REQUEST_VERSION = 16
+
+type connectionKey struct {
+	destination int32
+	apiKey      uint16
+}
+
+// connection carries one request at a time, so each API gets its own and a
+// long fetch does not hold up votes.
+type connection struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NetworkTransport sends the quorum's requests to the other controllers over
+// the Kafka protocol.
+type NetworkTransport struct {
+	NodeID         int32
+	DialTimeout    time.Duration
+	RequestTimeout time.Duration
+
+	addresses     map[int32]string
+	mu            sync.Mutex
+	connections   map[connectionKey]*connection
+	correlationID atomic.Int32
+}
+
+func NewNetworkTransport(nodeId int32, addresses map[int32]string) *NetworkTransport {
+	return &NetworkTransport{
+		NodeID:         nodeId,
+		DialTimeout:    time.Second,
+		RequestTimeout: 2 * time.Second,
+		addresses:      addresses,
+		connections:    map[connectionKey]*connection{},
+	}
+}
+
+// SetAddress points a destination at a new address, dropping connections to
+// the old one.
+func (t *NetworkTransport) SetAddress(destination int32, address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.addresses[destination] == address {
+		return
+	}
+	t.addresses[destination] = address
+	for key, c := range t.connections {
+		if key.destination == destination {
+			delete(t.connections, key)
+			go c.close()
+		}
+	}
+}
+
+func (c *connection) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (t *NetworkTransport) connection(destination int32, apiKey uint16) *connection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := connectionKey{destination: destination, apiKey: apiKey}
+	c, ok := t.connections[key]
+	if !ok {
+		c = &connection{}
+		t.connections[key] = c
+	}
+	return c
+}
+
+// Send frames a request body behind a request header and returns the body
+// of the response. The connection is dropped on any error.
+func (t *NetworkTransport) Send(destination int32, apiKey uint16, apiVersion uint16, body []byte, timeout time.Duration) (*bytes.Buffer, error) {
+	t.mu.Lock()
+	address, ok := t.addresses[destination]
+	t.mu.Unlock()
+	if !ok {
+		return nil, ErrUnreachable
+	}
+	flexible := utils.IsFlexible(apiKey, apiVersion)
+	correlationId := t.correlationID.Add(1)
+
+	var request bytes.Buffer
+	binary.Write(&request, binary.BigEndian, apiKey)
+	binary.Write(&request, binary.BigEndian, apiVersion)
+	binary.Write(&request, binary.BigEndian, correlationId)
+	utils.WriteString(&request, fmt.Sprintf("raft-client-%d", t.NodeID))
+	if flexible {
+		utils.WriteTaggedFields(&request)
+	}
+	request.Write(body)
+
+	c := t.connection(destination, apiKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response, err := c.roundTrip(address, request.Bytes(), t.DialTimeout, timeout)
+	if err != nil {
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+		return nil, err
+	}
+
+	var responseCorrelationId int32
+	if err := binary.Read(response, binary.BigEndian, &responseCorrelationId); err != nil {
+		return nil, err
+	}
+	if responseCorrelationId != correlationId {
+		c.conn.Close()
+		c.conn = nil
+		return nil, fmt.Errorf("expected correlation id %d, got %d", correlationId, responseCorrelationId)
+	}
+	if flexible {
+		if err := utils.SkipTaggedFields(response); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (c *connection) roundTrip(address string, request []byte, dialTimeout time.Duration, timeout time.Duration) (*bytes.Buffer, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", address, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.BigEndian, int32(len(request)))
+	frame.Write(request)
+	if _, err := c.conn.Write(frame.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(c.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(response), nil
+}
+
+func readLeaderAndEpoch(leader *LeaderAndEpoch, data *bytes.Buffer) error {
+	if err := binary.Read(data, binary.BigEndian, &leader.LeaderID); err != nil {
+		return err
+	}
+	return binary.Read(data, binary.BigEndian, &leader.LeaderEpoch)
+}
+
+func writeSnapshotID(data *bytes.Buffer, snapshotId metadata.SnapshotID) {
+	binary.Write(data, binary.BigEndian, snapshotId.EndOffset)
+	binary.Write(data, binary.BigEndian, snapshotId.Epoch)
+	utils.WriteTaggedFields(data)
+}
+
+func readSnapshotID(snapshotId *metadata.SnapshotID, data *bytes.Buffer) error {
+	if err := binary.Read(data, binary.BigEndian, &snapshotId.EndOffset); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &snapshotId.Epoch); err != nil {
+		return err
+	}
+	return utils.SkipTaggedFields(data)
+}
+
+// clusterIdField encodes the cluster_id tagged field of Fetch and
+// FetchSnapshot requests.
+func clusterIdField(clusterId string) []byte {
+	var field bytes.Buffer
+	utils.WriteCompactString(&field, clusterId)
+	return field.Bytes()
+}
+
+// readFirstPartition steps into the only topic and partition of a flexible
+// response, up to the fields after the partition index.
+func readFirstPartition(data *bytes.Buffer, topicId bool) error {
+	var topicLen, partitionLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	if topicLen != 1 {
+		return fmt.Errorf("expected 1 topic, got %d", topicLen)
+	}
+	if topicId {
+		data.Next(16)
+	} else {
+		var name string
+		if err := utils.ReadCompactString(&name, data); err != nil {
+			return err
+		}
+	}
+	if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+		return err
+	}
+	if partitionLen != 1 {
+		return fmt.Errorf("expected 1 partition, got %d", partitionLen)
+	}
+	var partitionIndex int32
+	return binary.Read(data, binary.BigEndian, &partitionIndex)
+}
+
+func (t *NetworkTransport) Vote(destination int32, req VoteRequest) (VoteResponse, error) {
+	var body bytes.Buffer
+	utils.WriteCompactString(&body, req.ClusterID)
+	utils.WriteCompactArrayLength(&body, 1)
+	utils.WriteCompactString(&body, metadata.CLUSTER_METADATA_TOPIC)
+	utils.WriteCompactArrayLength(&body, 1)
+	binary.Write(&body, binary.BigEndian, METADATA_PARTITION)
+	binary.Write(&body, binary.BigEndian, req.CandidateEpoch)
+	binary.Write(&body, binary.BigEndian, req.CandidateID)
+	binary.Write(&body, binary.BigEndian, req.LastEpoch)
+	binary.Write(&body, binary.BigEndian, req.LastEpochEndOffset)
+	utils.WriteTaggedFields(&body)
+	utils.WriteTaggedFields(&body)
+	utils.WriteTaggedFields(&body)
+
+	data, err := t.Send(destination, utils.VOTE_KEY, utils.VOTE, body.Bytes(), t.RequestTimeout)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	resp := VoteResponse{LeaderID: NO_LEADER, LeaderEpoch: -1}
+	if err := binary.Read(data, binary.BigEndian, &resp.ErrorCode); err != nil {
+		return resp, err
+	}
+	if resp.ErrorCode != utils.NONE {
+		return resp, nil
+	}
+	if err := readFirstPartition(data, false); err != nil {
+		return resp, err
+	}
+	fields := []any{&resp.ErrorCode, &resp.LeaderID, &resp.LeaderEpoch, &resp.VoteGranted}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (t *NetworkTransport) sendQuorumEpoch(destination int32, apiKey uint16, clusterId string, leaderId int32, leaderEpoch int32, successors []int32) (QuorumEpochResponse, error) {
+	var body bytes.Buffer
+	utils.WriteNullableString(&body, &clusterId)
+	binary.Write(&body, binary.BigEndian, int32(1))
+	utils.WriteString(&body, metadata.CLUSTER_METADATA_TOPIC)
+	binary.Write(&body, binary.BigEndian, int32(1))
+	binary.Write(&body, binary.BigEndian, METADATA_PARTITION)
+	binary.Write(&body, binary.BigEndian, leaderId)
+	binary.Write(&body, binary.BigEndian, leaderEpoch)
+	if apiKey == utils.END_QUORUM_EPOCH_KEY {
+		binary.Write(&body, binary.BigEndian, int32(len(successors)))
+		for _, successor := range successors {
+			binary.Write(&body, binary.BigEndian, successor)
+		}
+	}
+
+	data, err := t.Send(destination, apiKey, 0, body.Bytes(), t.RequestTimeout)
+	if err != nil {
+		return QuorumEpochResponse{}, err
+	}
+	resp := QuorumEpochResponse{LeaderID: NO_LEADER, LeaderEpoch: -1}
+	if err := binary.Read(data, binary.BigEndian, &resp.ErrorCode); err != nil {
+		return resp, err
+	}
+	if resp.ErrorCode != utils.NONE {
+		return resp, nil
+	}
+	var topicLen, partitionLen int
+	var topicName string
+	var partitionIndex int32
+	if err := utils.ReadArrayLength(&topicLen, data); err != nil || topicLen != 1 {
+		return resp, fmt.Errorf("expected 1 topic")
+	}
+	if err := utils.ReadString(&topicName, data); err != nil {
+		return resp, err
+	}
+	if err := utils.ReadArrayLength(&partitionLen, data); err != nil || partitionLen != 1 {
+		return resp, fmt.Errorf("expected 1 partition")
+	}
+	fields := []any{&partitionIndex, &resp.ErrorCode, &resp.LeaderID, &resp.LeaderEpoch}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (t *NetworkTransport) BeginQuorumEpoch(destination int32, req BeginQuorumEpochRequest) (QuorumEpochResponse, error) {
+	return t.sendQuorumEpoch(destination, utils.BEGIN_QUORUM_EPOCH_KEY, req.ClusterID, req.LeaderID, req.LeaderEpoch, nil)
+}
+
+func (t *NetworkTransport) EndQuorumEpoch(destination int32, req EndQuorumEpochRequest) (QuorumEpochResponse, error) {
+	return t.sendQuorumEpoch(destination, utils.END_QUORUM_EPOCH_KEY, req.ClusterID, req.LeaderID, req.LeaderEpoch, req.PreferredSuccessors)
+}
+
+func (t *NetworkTransport) Fetch(destination int32, req FetchRequest, maxWait time.Duration) (FetchResponse, error) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int32(maxWait.Milliseconds()))
+	// min_bytes
+	binary.Write(&body, binary.BigEndian, int32(1))
+	binary.Write(&body, binary.BigEndian, req.MaxBytes)
+	binary.Write(&body, binary.BigEndian, int8(0))
+	// No fetch session
+	binary.Write(&body, binary.BigEndian, int32(0))
+	binary.Write(&body, binary.BigEndian, int32(-1))
+	utils.WriteCompactArrayLength(&body, 1)
+	body.Write(METADATA_TOPIC_ID.Bytes())
+	utils.WriteCompactArrayLength(&body, 1)
+	binary.Write(&body, binary.BigEndian, METADATA_PARTITION)
+	binary.Write(&body, binary.BigEndian, req.CurrentLeaderEpoch)
+	binary.Write(&body, binary.BigEndian, req.FetchOffset)
+	binary.Write(&body, binary.BigEndian, req.LastFetchedEpoch)
+	// log_start_offset
+	binary.Write(&body, binary.BigEndian, int64(-1))
+	binary.Write(&body, binary.BigEndian, req.MaxBytes)
+	utils.WriteTaggedFields(&body)
+	utils.WriteTaggedFields(&body)
+	// forgotten_topics_data
+	utils.WriteCompactArrayLength(&body, 0)
+	// rack_id
+	utils.WriteCompactString(&body, "")
+	var replicaState bytes.Buffer
+	binary.Write(&replicaState, binary.BigEndian, req.ReplicaID)
+	binary.Write(&replicaState, binary.BigEndian, int64(-1))
+	utils.WriteTaggedFields(&replicaState)
+	utils.WriteTaggedFieldValues(&body, map[uint64][]byte{0: clusterIdField(req.ClusterID), 1: replicaState.Bytes()})
+
+	data, err := t.Send(destination, utils.FETCH_KEY, FETCH_REQUEST_VERSION, body.Bytes(), maxWait+t.RequestTimeout)
+	if err != nil {
+		return FetchResponse{}, err
+	}
+	resp := FetchResponse{CurrentLeader: LeaderAndEpoch{LeaderID: NO_LEADER, LeaderEpoch: -1}}
+	var throttleTimeMs, sessionId int32
+	fields := []any{&throttleTimeMs, &resp.ErrorCode, &sessionId}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return resp, err
+		}
+	}
+	if resp.ErrorCode != utils.NONE {
+		return resp, nil
+	}
+	if err := readFirstPartition(data, true); err != nil {
+		return resp, err
+	}
+	var lastStableOffset int64
+	fields = []any{&resp.ErrorCode, &resp.HighWatermark, &lastStableOffset, &resp.LogStartOffset}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return resp, err
+		}
+	}
+	var abortedLen int
+	if err := utils.ReadCompactArrayLength(&abortedLen, data); err != nil {
+		return resp, err
+	}
+	for range abortedLen {
+		data.Next(16)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return resp, err
+		}
+	}
+	var preferredReadReplica int32
+	if err := binary.Read(data, binary.BigEndian, &preferredReadReplica); err != nil {
+		return resp, err
+	}
+	if err := utils.ReadCompactBytes(&resp.Records, data); err != nil {
+		return resp, err
+	}
+
+	taggedFields, err := utils.ReadTaggedFields(data)
+	if err != nil {
+		return resp, err
+	}
+	if value, ok := taggedFields[0]; ok {
+		field := bytes.NewBuffer(value)
+		resp.DivergingEpoch = &OffsetAndEpoch{}
+		if err := binary.Read(field, binary.BigEndian, &resp.DivergingEpoch.Epoch); err != nil {
+			return resp, err
+		}
+		if err := binary.Read(field, binary.BigEndian, &resp.DivergingEpoch.Offset); err != nil {
+			return resp, err
+		}
+	}
+	if value, ok := taggedFields[1]; ok {
+		if err := readLeaderAndEpoch(&resp.CurrentLeader, bytes.NewBuffer(value)); err != nil {
+			return resp, err
+		}
+	}
+	if value, ok := taggedFields[2]; ok {
+		resp.SnapshotID = &metadata.SnapshotID{}
+		if err := readSnapshotID(resp.SnapshotID, bytes.NewBuffer(value)); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (t *NetworkTransport) FetchSnapshot(destination int32, req FetchSnapshotRequest) (FetchSnapshotResponse, error) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, req.ReplicaID)
+	binary.Write(&body, binary.BigEndian, req.MaxBytes)
+	utils.WriteCompactArrayLength(&body, 1)
+	utils.WriteCompactString(&body, metadata.CLUSTER_METADATA_TOPIC)
+	utils.WriteCompactArrayLength(&body, 1)
+	binary.Write(&body, binary.BigEndian, METADATA_PARTITION)
+	binary.Write(&body, binary.BigEndian, req.CurrentLeaderEpoch)
+	writeSnapshotID(&body, req.SnapshotID)
+	binary.Write(&body, binary.BigEndian, req.Position)
+	utils.WriteTaggedFields(&body)
+	utils.WriteTaggedFields(&body)
+	utils.WriteTaggedFieldValues(&body, map[uint64][]byte{0: clusterIdField(req.ClusterID)})
+
+	data, err := t.Send(destination, utils.FETCH_SNAPSHOT_KEY, utils.FETCH_SNAPSHOT, body.Bytes(), t.RequestTimeout)
+	if err != nil {
+		return FetchSnapshotResponse{}, err
+	}
+	resp := FetchSnapshotResponse{CurrentLeader: LeaderAndEpoch{LeaderID: NO_LEADER, LeaderEpoch: -1}}
+	var throttleTimeMs int32
+	if err := binary.Read(data, binary.BigEndian, &throttleTimeMs); err != nil {
+		return resp, err
+	}
+	if err := binary.Read(data, binary.BigEndian, &resp.ErrorCode); err != nil {
+		return resp, err
+	}
+	if resp.ErrorCode != utils.NONE {
+		return resp, nil
+	}
+	if err := readFirstPartition(data, false); err != nil {
+		return resp, err
+	}
+	if err := binary.Read(data, binary.BigEndian, &resp.ErrorCode); err != nil {
+		return resp, err
+	}
+	if err := readSnapshotID(&resp.SnapshotID, data); err != nil {
+		return resp, err
+	}
+	if err := binary.Read(data, binary.BigEndian, &resp.Size); err != nil {
+		return resp, err
+	}
+	if err := binary.Read(data, binary.BigEndian, &resp.Position); err != nil {
+		return resp, err
+	}
+	if err := utils.ReadCompactBytes(&resp.Records, data); err != nil {
+		return resp, err
+	}
+	taggedFields, err := utils.ReadTaggedFields(data)
+	if err != nil {
+		return resp, err
+	}
+	if value, ok := taggedFields[0]; ok {
+		if err := readLeaderAndEpoch(&resp.CurrentLeader, bytes.NewBuffer(value)); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
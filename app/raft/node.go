@@ -0,0 +1,978 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const FETCH_MAX_BYTES = 1024 * 1024
+const LEADER_CHANGE_MESSAGE_VERSION int16 = 0
+
+type ReplicaState struct {
+	ReplicaID             int32
+	LogEndOffset          int64
+	LastFetchTimestamp    int64
+	LastCaughtUpTimestamp int64
+}
+
+type QuorumInfo struct {
+	LeaderID      int32
+	LeaderEpoch   int32
+	HighWatermark int64
+	Voters        []ReplicaState
+	Observers     []ReplicaState
+}
+
+// RaftNode replicates the __cluster_metadata log with the other controllers
+// following KIP-595: voters elect a leader with Vote requests, and followers
+// pull records from it with Fetch. Records are committed, and move the high
+// watermark, once a majority of voters have them.
+type RaftNode struct {
+	mu            sync.Mutex
+	config        Config
+	transport     Transport
+	log           *storage.Log
+	state         QuorumState
+	role          Role
+	highWatermark int64
+	// changed is closed and replaced whenever the log end offset or the
+	// high watermark moves
+	changed chan struct{}
+
+	electionDeadline time.Time
+	lastFetch        time.Time
+	votes            map[int32]bool
+	leaderSince      time.Time
+	epochStartOffset int64
+	replicas         map[int32]*ReplicaState
+	unacknowledged   map[int32]bool
+	nextObserverPoll int
+}
+
+var node *RaftNode
+
+// Node returns the quorum this process takes part in, or nil if it is not a
+// controller.
+func Node() *RaftNode {
+	return node
+}
+
+// Start runs the node in the background and makes the metadata follower
+// only apply records it has committed.
+func Start(config Config, transport Transport) (*RaftNode, error) {
+	n, err := NewRaftNode(config, transport)
+	if err != nil {
+		return nil, err
+	}
+	node = n
+	metadata.CommittedOffset = n.HighWatermark
+	metadata.TruncateLog = func(dir string, snapshot metadata.SnapshotID) error {
+		return n.DeleteBefore(snapshot)
+	}
+	go n.Run(nil)
+	return n, nil
+}
+
+func NewRaftNode(config Config, transport Transport) (*RaftNode, error) {
+	l, err := storage.OpenLog(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := readQuorumState(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if state.ClusterID != "" && config.ClusterID != "" && state.ClusterID != config.ClusterID {
+		return nil, fmt.Errorf("quorum state belongs to cluster %s, not %s", state.ClusterID, config.ClusterID)
+	}
+	state.ClusterID = config.ClusterID
+	state.CurrentVoters = []quorumVoter{}
+	for _, voter := range config.Voters {
+		state.CurrentVoters = append(state.CurrentVoters, quorumVoter{VoterID: voter})
+	}
+
+	n := &RaftNode{
+		config:        config,
+		transport:     transport,
+		log:           l,
+		state:         state,
+		highWatermark: l.LogStartOffset(),
+		changed:       make(chan struct{}),
+	}
+	switch {
+	case state.LeaderID == config.NodeID:
+		// A restarted leader cannot lead the same epoch again
+		if err := n.becomeUnattached(state.LeaderEpoch); err != nil {
+			return nil, err
+		}
+		err = n.transition(state.LeaderEpoch, NO_LEADER, config.NodeID)
+	case state.LeaderID != NO_LEADER:
+		err = n.becomeFollower(state.LeaderEpoch, state.LeaderID)
+	default:
+		err = n.becomeUnattached(state.LeaderEpoch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *RaftNode) Close() error {
+	return n.log.Close()
+}
+
+func (n *RaftNode) isVoter(nodeId int32) bool {
+	return slices.Contains(n.config.Voters, nodeId)
+}
+
+func (n *RaftNode) majority() int {
+	return len(n.config.Voters)/2 + 1
+}
+
+func (n *RaftNode) randomElectionTimeout() time.Duration {
+	return n.config.ElectionTimeout + rand.N(n.config.ElectionTimeout)
+}
+
+func (n *RaftNode) signal() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// transition moves to a new epoch, leader or vote once it is persisted. The
+// node keeps its state when the write fails, as acting on a vote that a
+// restart forgets could elect two leaders in one epoch.
+func (n *RaftNode) transition(epoch int32, leaderId int32, votedId int32) error {
+	state := n.state
+	state.LeaderEpoch = epoch
+	state.LeaderID = leaderId
+	state.VotedID = votedId
+	if err := writeQuorumState(n.config.Dir, state); err != nil {
+		log.Printf("Failed to write quorum state: %s\n", err.Error())
+		return err
+	}
+	n.state = state
+	return nil
+}
+
+// becomeUnattached waits for a leader in epoch, keeping the vote if the
+// epoch does not change.
+func (n *RaftNode) becomeUnattached(epoch int32) error {
+	votedId := NO_VOTE
+	if epoch == n.state.LeaderEpoch {
+		votedId = n.state.VotedID
+	}
+	if err := n.transition(epoch, NO_LEADER, votedId); err != nil {
+		return err
+	}
+	n.role = UNATTACHED
+	n.replicas = nil
+	n.electionDeadline = time.Now().Add(n.randomElectionTimeout())
+	return nil
+}
+
+func (n *RaftNode) becomeFollower(epoch int32, leaderId int32) error {
+	votedId := NO_VOTE
+	if epoch == n.state.LeaderEpoch {
+		votedId = n.state.VotedID
+	}
+	if err := n.transition(epoch, leaderId, votedId); err != nil {
+		return err
+	}
+	n.role = FOLLOWER
+	n.replicas = nil
+	n.lastFetch = time.Now()
+	log.Printf("Node %d is following %d in epoch %d\n", n.config.NodeID, leaderId, epoch)
+	return nil
+}
+
+func (n *RaftNode) becomeCandidate() {
+	if err := n.transition(n.state.LeaderEpoch+1, NO_LEADER, n.config.NodeID); err != nil {
+		return
+	}
+	n.role = CANDIDATE
+	n.replicas = nil
+	n.votes = map[int32]bool{n.config.NodeID: true}
+	n.electionDeadline = time.Now().Add(n.randomElectionTimeout())
+	n.maybeBecomeLeader()
+}
+
+func (n *RaftNode) maybeBecomeLeader() {
+	granted := 0
+	for _, vote := range n.votes {
+		if vote {
+			granted++
+		}
+	}
+	if granted >= n.majority() {
+		n.becomeLeader()
+	}
+}
+
+func (n *RaftNode) becomeLeader() {
+	if err := n.transition(n.state.LeaderEpoch, n.config.NodeID, n.state.VotedID); err != nil {
+		return
+	}
+	n.role = LEADER
+	n.leaderSince = time.Now()
+	n.epochStartOffset = n.log.LogEndOffset()
+	n.replicas = map[int32]*ReplicaState{}
+	n.unacknowledged = map[int32]bool{}
+	for _, voter := range n.config.Voters {
+		if voter != n.config.NodeID {
+			n.replicas[voter] = &ReplicaState{ReplicaID: voter, LogEndOffset: -1, LastFetchTimestamp: -1, LastCaughtUpTimestamp: -1}
+			n.unacknowledged[voter] = true
+		}
+	}
+	log.Printf("Node %d became leader in epoch %d\n", n.config.NodeID, n.state.LeaderEpoch)
+
+	// The new epoch starts with a LeaderChange record. Nothing before it is
+	// committed in this epoch until it is.
+	timestamp := time.Now().UnixMilli()
+	if err := n.appendBatch(record.NewControlBatch(record.CONTROL_LEADER_CHANGE, n.leaderChangeMessage(), timestamp)); err != nil {
+		log.Printf("Failed to write leader change: %s\n", err.Error())
+	}
+}
+
+func (n *RaftNode) leaderChangeMessage() []byte {
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, LEADER_CHANGE_MESSAGE_VERSION)
+	binary.Write(&value, binary.BigEndian, n.config.NodeID)
+	for _, voters := range [][]int32{n.config.Voters, {}} {
+		utils.WriteCompactArrayLength(&value, len(voters))
+		for _, voter := range voters {
+			binary.Write(&value, binary.BigEndian, voter)
+			utils.WriteTaggedFields(&value)
+		}
+	}
+	utils.WriteTaggedFields(&value)
+	return value.Bytes()
+}
+
+// appendBatch writes a batch at the end of the log in the current epoch.
+func (n *RaftNode) appendBatch(batch *record.RecordBatch) error {
+	batch.BaseOffset = n.log.LogEndOffset()
+	batch.PartitionLeaderEpoch = n.state.LeaderEpoch
+	raw, err := batch.Encode()
+	if err != nil {
+		return err
+	}
+	if err := n.log.AppendAsFollower(raw); err != nil {
+		return err
+	}
+	n.signal()
+	n.maybeAdvanceHighWatermark()
+	return nil
+}
+
+// Append writes metadata records as the leader and returns the offset of the
+// last one. They are only applied once the high watermark passes it.
+func (n *RaftNode) Append(records []metadata.MetadataRecord) (int64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != LEADER {
+		return -1, ErrNotLeader
+	}
+	values := []record.Record{}
+	for _, rec := range records {
+		values = append(values, record.Record{Value: metadata.EncodeMetadataRecord(rec)})
+	}
+	batch := record.NewRecordBatch(values)
+	batch.BaseTimestamp = time.Now().UnixMilli()
+	batch.MaxTimestamp = batch.BaseTimestamp
+	if err := n.appendBatch(batch); err != nil {
+		return -1, err
+	}
+	return batch.LastOffset(), nil
+}
+
+// maybeAdvanceHighWatermark moves the high watermark to the largest offset a
+// majority of voters have, once that includes the start of this epoch.
+func (n *RaftNode) maybeAdvanceHighWatermark() {
+	if n.role != LEADER {
+		return
+	}
+	endOffsets := []int64{}
+	for _, voter := range n.config.Voters {
+		if voter == n.config.NodeID {
+			endOffsets = append(endOffsets, n.log.LogEndOffset())
+		} else if replica, ok := n.replicas[voter]; ok {
+			endOffsets = append(endOffsets, replica.LogEndOffset)
+		}
+	}
+	sort.Slice(endOffsets, func(i, j int) bool { return endOffsets[i] > endOffsets[j] })
+	highWatermark := endOffsets[len(n.config.Voters)/2]
+	if highWatermark > n.epochStartOffset && highWatermark > n.highWatermark {
+		n.highWatermark = highWatermark
+		n.signal()
+	}
+}
+
+func (n *RaftNode) HighWatermark() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.highWatermark
+}
+
+func (n *RaftNode) LeaderAndEpoch() LeaderAndEpoch {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderAndEpoch()
+}
+
+func (n *RaftNode) leaderAndEpoch() LeaderAndEpoch {
+	return LeaderAndEpoch{LeaderID: n.state.LeaderID, LeaderEpoch: n.state.LeaderEpoch}
+}
+
+func (n *RaftNode) Role() Role {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role
+}
+
+func (n *RaftNode) LogEndOffset() int64 {
+	return n.log.LogEndOffset()
+}
+
+// WaitForCommit waits until the record at offset is committed.
+func (n *RaftNode) WaitForCommit(offset int64, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		n.mu.Lock()
+		committed, changed := n.highWatermark > offset, n.changed
+		n.mu.Unlock()
+		if committed {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// logEnd returns the log end offset and the epoch of the last record, which
+// comes from the snapshot when the log is empty after one.
+func (n *RaftNode) logEnd() (int64, int32) {
+	endOffset, epoch := n.log.LogEndOffset(), n.log.LastEpoch()
+	if epoch < 0 {
+		epoch = 0
+		if snapshot, _ := metadata.LatestSnapshot(n.config.Dir); snapshot != nil {
+			epoch = snapshot.Epoch
+		}
+	}
+	return endOffset, epoch
+}
+
+func (n *RaftNode) endOffsetForEpoch(epoch int32) (int32, int64) {
+	foundEpoch, endOffset := n.log.EndOffsetForEpoch(epoch)
+	if foundEpoch < 0 {
+		if snapshot, _ := metadata.LatestSnapshot(n.config.Dir); snapshot != nil && epoch >= snapshot.Epoch {
+			return snapshot.Epoch, snapshot.EndOffset
+		}
+	}
+	return foundEpoch, endOffset
+}
+
+func (n *RaftNode) checkClusterID(clusterId string) int16 {
+	if clusterId != "" && n.config.ClusterID != "" && clusterId != n.config.ClusterID {
+		return utils.INCONSISTENT_CLUSTER_ID
+	}
+	return utils.NONE
+}
+
+// Run polls until stop is closed, resting for a tick whenever there is
+// nothing to do.
+func (n *RaftNode) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if n.Poll() {
+			select {
+			case <-stop:
+				return
+			case <-time.After(n.config.Tick):
+			}
+		}
+	}
+}
+
+// Poll takes the next step for the node's role and reports whether it was
+// idle. Requests to other nodes are sent without holding the lock.
+func (n *RaftNode) Poll() bool {
+	n.mu.Lock()
+	now := time.Now()
+	switch n.role {
+	case UNATTACHED:
+		if !n.isVoter(n.config.NodeID) {
+			// Observers learn who leads from any voter
+			destination := n.config.Voters[n.nextObserverPoll%len(n.config.Voters)]
+			n.nextObserverPoll++
+			n.mu.Unlock()
+			return n.fetch(destination)
+		}
+		if now.After(n.electionDeadline) || len(n.config.Voters) == 1 {
+			n.becomeCandidate()
+		}
+		n.mu.Unlock()
+		return true
+
+	case CANDIDATE:
+		if now.After(n.electionDeadline) {
+			n.becomeCandidate()
+		}
+		if n.role != CANDIDATE {
+			n.mu.Unlock()
+			return true
+		}
+		endOffset, lastEpoch := n.logEnd()
+		req := VoteRequest{
+			ClusterID:          n.config.ClusterID,
+			CandidateEpoch:     n.state.LeaderEpoch,
+			CandidateID:        n.config.NodeID,
+			LastEpoch:          lastEpoch,
+			LastEpochEndOffset: endOffset,
+		}
+		destinations := []int32{}
+		for _, voter := range n.config.Voters {
+			if _, responded := n.votes[voter]; !responded {
+				destinations = append(destinations, voter)
+			}
+		}
+		n.mu.Unlock()
+		n.requestVotes(req, destinations)
+		return true
+
+	case LEADER:
+		if n.lostQuorum(now) {
+			req, destinations := n.resign()
+			n.mu.Unlock()
+			n.sendEndQuorumEpoch(req, destinations)
+			return true
+		}
+		req := BeginQuorumEpochRequest{ClusterID: n.config.ClusterID, LeaderID: n.config.NodeID, LeaderEpoch: n.state.LeaderEpoch}
+		destinations := []int32{}
+		for voter := range n.unacknowledged {
+			destinations = append(destinations, voter)
+		}
+		n.mu.Unlock()
+		n.sendBeginQuorumEpoch(req, destinations)
+		return true
+
+	case FOLLOWER:
+		if now.Sub(n.lastFetch) > n.config.FetchTimeout {
+			if n.isVoter(n.config.NodeID) {
+				n.becomeCandidate()
+			} else {
+				n.becomeUnattached(n.state.LeaderEpoch)
+			}
+			n.mu.Unlock()
+			return false
+		}
+		leaderId := n.state.LeaderID
+		n.mu.Unlock()
+		return n.fetch(leaderId)
+	}
+	n.mu.Unlock()
+	return true
+}
+
+func (n *RaftNode) requestVotes(req VoteRequest, destinations []int32) {
+	var wg sync.WaitGroup
+	for _, destination := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := n.transport.Vote(destination, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.maybeFollowNewerEpoch(resp.LeaderID, resp.LeaderEpoch) {
+				return
+			}
+			if n.role != CANDIDATE || n.state.LeaderEpoch != req.CandidateEpoch || resp.ErrorCode != utils.NONE {
+				return
+			}
+			n.votes[destination] = resp.VoteGranted
+			n.maybeBecomeLeader()
+		}()
+	}
+	wg.Wait()
+}
+
+// maybeFollowNewerEpoch moves to an epoch learnt from another node's
+// response, following its leader if it has one.
+func (n *RaftNode) maybeFollowNewerEpoch(leaderId int32, epoch int32) bool {
+	if epoch <= n.state.LeaderEpoch {
+		return false
+	}
+	if leaderId != NO_LEADER && leaderId != n.config.NodeID {
+		n.becomeFollower(epoch, leaderId)
+	} else {
+		n.becomeUnattached(epoch)
+	}
+	return true
+}
+
+func (n *RaftNode) sendBeginQuorumEpoch(req BeginQuorumEpochRequest, destinations []int32) {
+	var wg sync.WaitGroup
+	for _, destination := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := n.transport.BeginQuorumEpoch(destination, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.maybeFollowNewerEpoch(resp.LeaderID, resp.LeaderEpoch) {
+				return
+			}
+			if n.role == LEADER && n.state.LeaderEpoch == req.LeaderEpoch && resp.ErrorCode == utils.NONE {
+				delete(n.unacknowledged, destination)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// lostQuorum reports whether a majority of voters stopped fetching, in which
+// case the leader may be partitioned away and must step down.
+func (n *RaftNode) lostQuorum(now time.Time) bool {
+	timeout := n.config.FetchTimeout * 3 / 2
+	if len(n.config.Voters) == 1 || now.Sub(n.leaderSince) < timeout {
+		return false
+	}
+	live := 1
+	for _, replica := range n.replicas {
+		if n.isVoter(replica.ReplicaID) && now.UnixMilli()-replica.LastFetchTimestamp < timeout.Milliseconds() {
+			live++
+		}
+	}
+	return live < n.majority()
+}
+
+// resign gives up leadership of the current epoch. The other voters are
+// told, most caught up first, so that one of them can start an election
+// right away.
+func (n *RaftNode) resign() (EndQuorumEpochRequest, []int32) {
+	successors := []int32{}
+	for _, voter := range n.config.Voters {
+		if voter != n.config.NodeID {
+			successors = append(successors, voter)
+		}
+	}
+	sort.SliceStable(successors, func(i, j int) bool {
+		return n.replicas[successors[i]].LogEndOffset > n.replicas[successors[j]].LogEndOffset
+	})
+	req := EndQuorumEpochRequest{
+		ClusterID:           n.config.ClusterID,
+		LeaderID:            n.config.NodeID,
+		LeaderEpoch:         n.state.LeaderEpoch,
+		PreferredSuccessors: successors,
+	}
+	log.Printf("Node %d resigned as leader of epoch %d\n", n.config.NodeID, n.state.LeaderEpoch)
+	if err := n.becomeUnattached(n.state.LeaderEpoch); err != nil {
+		// Stepping down is safe unpersisted, as a restarted leader never
+		// leads its epoch again
+		n.role = UNATTACHED
+		n.replicas = nil
+	}
+	return req, successors
+}
+
+// Resign steps down if this node leads the quorum, e.g. before shutting down.
+func (n *RaftNode) Resign() {
+	n.mu.Lock()
+	if n.role != LEADER {
+		n.mu.Unlock()
+		return
+	}
+	req, destinations := n.resign()
+	n.mu.Unlock()
+	n.sendEndQuorumEpoch(req, destinations)
+}
+
+func (n *RaftNode) sendEndQuorumEpoch(req EndQuorumEpochRequest, destinations []int32) {
+	var wg sync.WaitGroup
+	for _, destination := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.transport.EndQuorumEpoch(destination, req)
+		}()
+	}
+	wg.Wait()
+}
+
+// fetch pulls the next records from destination and reports whether there
+// were none.
+func (n *RaftNode) fetch(destination int32) bool {
+	n.mu.Lock()
+	epoch := n.state.LeaderEpoch
+	endOffset, lastEpoch := n.logEnd()
+	req := FetchRequest{
+		ClusterID:          n.config.ClusterID,
+		ReplicaID:          n.config.NodeID,
+		CurrentLeaderEpoch: epoch,
+		FetchOffset:        endOffset,
+		LastFetchedEpoch:   lastEpoch,
+		MaxBytes:           FETCH_MAX_BYTES,
+	}
+	n.mu.Unlock()
+
+	resp, err := n.transport.Fetch(destination, req, n.config.FetchMaxWait)
+	if err != nil {
+		return true
+	}
+	if resp.ErrorCode == utils.NONE && resp.SnapshotID != nil {
+		if err := n.fetchSnapshot(destination, epoch, *resp.SnapshotID); err != nil {
+			log.Printf("Failed to fetch snapshot %+v: %s\n", *resp.SnapshotID, err.Error())
+			return true
+		}
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.maybeFollowNewerEpoch(resp.CurrentLeader.LeaderID, resp.CurrentLeader.LeaderEpoch) || n.state.LeaderEpoch != epoch {
+		return false
+	}
+	if n.role == UNATTACHED && resp.CurrentLeader.LeaderID != NO_LEADER {
+		n.becomeFollower(epoch, resp.CurrentLeader.LeaderID)
+		return false
+	}
+	if n.role != FOLLOWER || resp.ErrorCode != utils.NONE {
+		return true
+	}
+	n.lastFetch = time.Now()
+
+	if diverging := resp.DivergingEpoch; diverging != nil {
+		// Truncate to where both logs last agree
+		truncateOffset := diverging.Offset
+		if ownEpoch, ownEndOffset := n.log.EndOffsetForEpoch(diverging.Epoch); ownEpoch == diverging.Epoch {
+			truncateOffset = min(truncateOffset, ownEndOffset)
+		} else {
+			truncateOffset = ownEndOffset
+		}
+		truncateOffset = max(truncateOffset, n.log.LogStartOffset())
+		log.Printf("Node %d truncating metadata log to %d after diverging at epoch %d\n", n.config.NodeID, truncateOffset, diverging.Epoch)
+		if err := n.log.TruncateTo(truncateOffset); err != nil {
+			log.Printf("Failed to truncate metadata log: %s\n", err.Error())
+		}
+		return false
+	}
+
+	if len(resp.Records) > 0 {
+		if err := n.log.AppendAsFollower(resp.Records); err != nil {
+			log.Printf("Failed to append fetched metadata records: %s\n", err.Error())
+			return true
+		}
+		n.signal()
+	}
+	if highWatermark := min(resp.HighWatermark, n.log.LogEndOffset()); highWatermark > n.highWatermark {
+		n.highWatermark = highWatermark
+		n.signal()
+	}
+	return len(resp.Records) == 0
+}
+
+// fetchSnapshot downloads a snapshot from the leader and restarts the log
+// after it.
+func (n *RaftNode) fetchSnapshot(destination int32, epoch int32, snapshotId metadata.SnapshotID) error {
+	data := []byte{}
+	for {
+		resp, err := n.transport.FetchSnapshot(destination, FetchSnapshotRequest{
+			ClusterID:          n.config.ClusterID,
+			ReplicaID:          n.config.NodeID,
+			CurrentLeaderEpoch: epoch,
+			SnapshotID:         snapshotId,
+			Position:           int64(len(data)),
+			MaxBytes:           FETCH_MAX_BYTES,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.ErrorCode != utils.NONE {
+			return fmt.Errorf("error code %d", resp.ErrorCode)
+		}
+		data = append(data, resp.Records...)
+		if int64(len(data)) >= resp.Size {
+			break
+		}
+		if len(resp.Records) == 0 {
+			return errors.New("empty snapshot chunk")
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != FOLLOWER || n.state.LeaderEpoch != epoch {
+		return nil
+	}
+	path := metadata.SnapshotFileName(n.config.Dir, snapshotId)
+	if err := os.WriteFile(path+".part", data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".part", path); err != nil {
+		return err
+	}
+	if err := n.log.TruncateFullyAndStartAt(snapshotId.EndOffset); err != nil {
+		return err
+	}
+	n.highWatermark = max(n.highWatermark, snapshotId.EndOffset)
+	n.lastFetch = time.Now()
+	n.signal()
+	log.Printf("Node %d loaded metadata snapshot at offset %d\n", n.config.NodeID, snapshotId.EndOffset)
+	return nil
+}
+
+// DeleteBefore drops the log segments and snapshots a new snapshot replaces.
+func (n *RaftNode) DeleteBefore(snapshot metadata.SnapshotID) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.log.DeleteSegmentsBefore(min(snapshot.EndOffset, n.highWatermark)); err != nil {
+		return err
+	}
+	return metadata.DeleteSnapshotsBefore(n.config.Dir, snapshot)
+}
+
+func (n *RaftNode) HandleVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := VoteResponse{ErrorCode: n.checkClusterID(req.ClusterID)}
+	if resp.ErrorCode == utils.NONE && !n.isVoter(req.CandidateID) {
+		resp.ErrorCode = utils.INCONSISTENT_VOTER_SET
+	}
+	if resp.ErrorCode == utils.NONE {
+		if req.CandidateEpoch > n.state.LeaderEpoch {
+			n.becomeUnattached(req.CandidateEpoch)
+		}
+		endOffset, lastEpoch := n.logEnd()
+		upToDate := req.LastEpoch > lastEpoch || (req.LastEpoch == lastEpoch && req.LastEpochEndOffset >= endOffset)
+		canVote := n.state.VotedID == NO_VOTE || n.state.VotedID == req.CandidateID
+		if req.CandidateEpoch == n.state.LeaderEpoch && n.role == UNATTACHED && canVote && upToDate &&
+			n.transition(n.state.LeaderEpoch, NO_LEADER, req.CandidateID) == nil {
+			n.electionDeadline = time.Now().Add(n.randomElectionTimeout())
+			resp.VoteGranted = true
+		}
+	}
+	resp.LeaderID, resp.LeaderEpoch = n.state.LeaderID, n.state.LeaderEpoch
+	return resp
+}
+
+func (n *RaftNode) HandleBeginQuorumEpoch(req BeginQuorumEpochRequest) QuorumEpochResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := QuorumEpochResponse{ErrorCode: n.checkClusterID(req.ClusterID)}
+	if resp.ErrorCode == utils.NONE && req.LeaderEpoch < n.state.LeaderEpoch {
+		resp.ErrorCode = utils.FENCED_LEADER_EPOCH
+	}
+	if resp.ErrorCode == utils.NONE && (n.role != FOLLOWER || n.state.LeaderEpoch != req.LeaderEpoch || n.state.LeaderID != req.LeaderID) {
+		n.becomeFollower(req.LeaderEpoch, req.LeaderID)
+	}
+	resp.LeaderID, resp.LeaderEpoch = n.state.LeaderID, n.state.LeaderEpoch
+	return resp
+}
+
+func (n *RaftNode) HandleEndQuorumEpoch(req EndQuorumEpochRequest) QuorumEpochResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := QuorumEpochResponse{ErrorCode: n.checkClusterID(req.ClusterID)}
+	if resp.ErrorCode == utils.NONE && req.LeaderEpoch < n.state.LeaderEpoch {
+		resp.ErrorCode = utils.FENCED_LEADER_EPOCH
+	}
+	if resp.ErrorCode == utils.NONE && (req.LeaderEpoch > n.state.LeaderEpoch || n.state.LeaderID == req.LeaderID) {
+		n.becomeUnattached(req.LeaderEpoch)
+		// The preferred successors stand for election in order
+		if position := slices.Index(req.PreferredSuccessors, n.config.NodeID); position >= 0 {
+			n.electionDeadline = time.Now().Add(time.Duration(position) * n.config.ElectionTimeout / 2)
+		}
+	}
+	resp.LeaderID, resp.LeaderEpoch = n.state.LeaderID, n.state.LeaderEpoch
+	return resp
+}
+
+// validateLeader checks that this node leads the epoch the request was sent
+// for.
+func (n *RaftNode) validateLeader(clusterId string, epoch int32) int16 {
+	if errorCode := n.checkClusterID(clusterId); errorCode != utils.NONE {
+		return errorCode
+	}
+	if epoch < n.state.LeaderEpoch {
+		return utils.FENCED_LEADER_EPOCH
+	}
+	if epoch > n.state.LeaderEpoch {
+		return utils.UNKNOWN_LEADER_EPOCH
+	}
+	if n.role != LEADER {
+		return utils.NOT_LEADER_OR_FOLLOWER
+	}
+	return utils.NONE
+}
+
+// HandleFetch serves records to followers and observers. A fetch with
+// nothing to return waits up to maxWait for new records or a new high
+// watermark.
+func (n *RaftNode) HandleFetch(req FetchRequest, maxWait time.Duration) FetchResponse {
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	resp, changed := n.handleFetch(req)
+	for resp.ErrorCode == utils.NONE && resp.DivergingEpoch == nil && resp.SnapshotID == nil && len(resp.Records) == 0 {
+		select {
+		case <-changed:
+		case <-timer.C:
+			return resp
+		}
+		var next FetchResponse
+		next, changed = n.handleFetch(req)
+		if next.HighWatermark != resp.HighWatermark {
+			return next
+		}
+		resp = next
+	}
+	return resp
+}
+
+func (n *RaftNode) handleFetch(req FetchRequest) (FetchResponse, chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := FetchResponse{HighWatermark: -1, LogStartOffset: -1}
+	resp.ErrorCode = n.validateLeader(req.ClusterID, req.CurrentLeaderEpoch)
+	resp.CurrentLeader = n.leaderAndEpoch()
+	if resp.ErrorCode != utils.NONE {
+		return resp, n.changed
+	}
+
+	snapshot, _ := metadata.LatestSnapshot(n.config.Dir)
+	logStartOffset := n.log.LogStartOffset()
+	if req.FetchOffset < logStartOffset && snapshot != nil {
+		resp.SnapshotID = snapshot
+		return resp, n.changed
+	}
+	if req.FetchOffset != 0 || req.LastFetchedEpoch != 0 {
+		epoch, endOffset := n.endOffsetForEpoch(req.LastFetchedEpoch)
+		if epoch != req.LastFetchedEpoch || endOffset < req.FetchOffset {
+			if epoch < 0 && snapshot != nil {
+				resp.SnapshotID = snapshot
+			} else {
+				resp.DivergingEpoch = &OffsetAndEpoch{Offset: max(endOffset, 0), Epoch: epoch}
+			}
+			return resp, n.changed
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	endOffset := n.log.LogEndOffset()
+	replica, ok := n.replicas[req.ReplicaID]
+	if !ok {
+		replica = &ReplicaState{ReplicaID: req.ReplicaID, LastCaughtUpTimestamp: -1}
+		n.replicas[req.ReplicaID] = replica
+	}
+	replica.LogEndOffset = req.FetchOffset
+	replica.LastFetchTimestamp = now
+	if req.FetchOffset >= endOffset {
+		replica.LastCaughtUpTimestamp = now
+	}
+	delete(n.unacknowledged, req.ReplicaID)
+	n.maybeAdvanceHighWatermark()
+
+	records, err := n.log.Read(req.FetchOffset, endOffset, int(req.MaxBytes))
+	if err != nil {
+		resp.ErrorCode = utils.OFFSET_OUT_OF_RANGE
+		return resp, n.changed
+	}
+	resp.Records = records
+	resp.HighWatermark = n.highWatermark
+	resp.LogStartOffset = logStartOffset
+	return resp, n.changed
+}
+
+func (n *RaftNode) HandleFetchSnapshot(req FetchSnapshotRequest) FetchSnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := FetchSnapshotResponse{SnapshotID: req.SnapshotID, Position: req.Position}
+	resp.ErrorCode = n.validateLeader(req.ClusterID, req.CurrentLeaderEpoch)
+	resp.CurrentLeader = n.leaderAndEpoch()
+	if resp.ErrorCode != utils.NONE {
+		return resp
+	}
+
+	file, err := os.Open(metadata.SnapshotFileName(n.config.Dir, req.SnapshotID))
+	if err != nil {
+		resp.ErrorCode = utils.SNAPSHOT_NOT_FOUND
+		return resp
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		resp.ErrorCode = utils.UNKNOWN_SERVER_ERROR
+		return resp
+	}
+	resp.Size = info.Size()
+	if req.Position < 0 || req.Position > resp.Size {
+		resp.ErrorCode = utils.POSITION_OUT_OF_RANGE
+		return resp
+	}
+	chunk := make([]byte, min(int64(req.MaxBytes), resp.Size-req.Position))
+	if _, err := file.ReadAt(chunk, req.Position); err != nil && err != io.EOF {
+		resp.ErrorCode = utils.UNKNOWN_SERVER_ERROR
+		return resp
+	}
+	resp.Records = chunk
+	return resp
+}
+
+// DescribeQuorum reports the leader's view of every replica. Only the leader
+// can answer.
+func (n *RaftNode) DescribeQuorum() (QuorumInfo, int16) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	info := QuorumInfo{LeaderID: n.state.LeaderID, LeaderEpoch: n.state.LeaderEpoch, HighWatermark: n.highWatermark}
+	if n.role != LEADER {
+		return info, utils.NOT_LEADER_OR_FOLLOWER
+	}
+	now := time.Now().UnixMilli()
+	for _, voter := range n.config.Voters {
+		if voter == n.config.NodeID {
+			info.Voters = append(info.Voters, ReplicaState{ReplicaID: voter, LogEndOffset: n.log.LogEndOffset(), LastFetchTimestamp: now, LastCaughtUpTimestamp: now})
+		} else {
+			info.Voters = append(info.Voters, *n.replicas[voter])
+		}
+	}
+	for _, replica := range n.replicas {
+		if !n.isVoter(replica.ReplicaID) {
+			info.Observers = append(info.Observers, *replica)
+		}
+	}
+	sort.Slice(info.Observers, func(i, j int) bool { return info.Observers[i].ReplicaID < info.Observers[j].ReplicaID })
+	return info, utils.NONE
+}
@@ -0,0 +1,72 @@
+package raft
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const QUORUM_STATE_FILE = "quorum-state"
+
+type Role int8
+
+const (
+	UNATTACHED Role = iota
+	CANDIDATE
+	FOLLOWER
+	LEADER
+)
+
+func (r Role) String() string {
+	switch r {
+	case UNATTACHED:
+		return "Unattached"
+	case CANDIDATE:
+		return "Candidate"
+	case FOLLOWER:
+		return "Follower"
+	case LEADER:
+		return "Leader"
+	}
+	return "Unknown"
+}
+
+type quorumVoter struct {
+	VoterID int32 `json:"voterId"`
+}
+
+// QuorumState is what a node must remember across restarts so that it never
+// votes twice in an epoch, stored as the quorum-state file KRaft writes.
+type QuorumState struct {
+	ClusterID     string        `json:"clusterId"`
+	LeaderID      int32         `json:"leaderId"`
+	LeaderEpoch   int32         `json:"leaderEpoch"`
+	VotedID       int32         `json:"votedId"`
+	AppliedOffset int64         `json:"appliedOffset"`
+	CurrentVoters []quorumVoter `json:"currentVoters"`
+	DataVersion   int           `json:"data_version"`
+}
+
+func readQuorumState(dir string) (QuorumState, error) {
+	state := QuorumState{LeaderID: NO_LEADER, VotedID: NO_VOTE}
+	data, err := os.ReadFile(filepath.Join(dir, QUORUM_STATE_FILE))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+func writeQuorumState(dir string, state QuorumState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, QUORUM_STATE_FILE)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
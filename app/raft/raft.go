@@ -0,0 +1,165 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/gofrs/uuid"
+)
+
+const METADATA_PARTITION int32 = 0
+
+// METADATA_TOPIC_ID is the fixed ID of __cluster_metadata
+var METADATA_TOPIC_ID = uuid.FromBytesOrNil([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+
+const NO_LEADER int32 = -1
+const NO_VOTE int32 = -1
+
+var (
+	ErrNotLeader   = errors.New("this node is not the quorum leader")
+	ErrUnreachable = errors.New("node is unreachable")
+)
+
+type Config struct {
+	NodeID          int32
+	ClusterID       string
+	Voters          []int32
+	Dir             string
+	ElectionTimeout time.Duration
+	FetchTimeout    time.Duration
+	// FetchMaxWait is how long the leader holds a fetch that has no new
+	// records, and Tick how long an idle node waits between polls
+	FetchMaxWait time.Duration
+	Tick         time.Duration
+}
+
+func DefaultConfig(nodeId int32, voters []int32) Config {
+	return Config{
+		NodeID:          nodeId,
+		Voters:          voters,
+		Dir:             metadata.MetadataPartitionDir(),
+		ElectionTimeout: 1000 * time.Millisecond,
+		FetchTimeout:    2000 * time.Millisecond,
+		FetchMaxWait:    500 * time.Millisecond,
+		Tick:            50 * time.Millisecond,
+	}
+}
+
+// ParseVoters reads controller.quorum.voters, e.g. "1@host1:9093,2@host2:9093".
+func ParseVoters(value string) (map[int32]string, error) {
+	voters := map[int32]string{}
+	for _, voter := range strings.Split(value, ",") {
+		voter = strings.TrimSpace(voter)
+		if voter == "" {
+			continue
+		}
+		id, address, ok := strings.Cut(voter, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid voter %q", voter)
+		}
+		nodeId, err := strconv.ParseInt(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid voter id in %q", voter)
+		}
+		voters[int32(nodeId)] = address
+	}
+	return voters, nil
+}
+
+type OffsetAndEpoch struct {
+	Offset int64
+	Epoch  int32
+}
+
+type LeaderAndEpoch struct {
+	LeaderID    int32
+	LeaderEpoch int32
+}
+
+type VoteRequest struct {
+	ClusterID          string
+	CandidateEpoch     int32
+	CandidateID        int32
+	LastEpoch          int32
+	LastEpochEndOffset int64
+}
+
+type VoteResponse struct {
+	ErrorCode   int16
+	LeaderID    int32
+	LeaderEpoch int32
+	VoteGranted bool
+}
+
+type BeginQuorumEpochRequest struct {
+	ClusterID   string
+	LeaderID    int32
+	LeaderEpoch int32
+}
+
+type EndQuorumEpochRequest struct {
+	ClusterID           string
+	LeaderID            int32
+	LeaderEpoch         int32
+	PreferredSuccessors []int32
+}
+
+// QuorumEpochResponse answers both BeginQuorumEpoch and EndQuorumEpoch.
+type QuorumEpochResponse struct {
+	ErrorCode   int16
+	LeaderID    int32
+	LeaderEpoch int32
+}
+
+type FetchRequest struct {
+	ClusterID          string
+	ReplicaID          int32
+	CurrentLeaderEpoch int32
+	FetchOffset        int64
+	LastFetchedEpoch   int32
+	MaxBytes           int32
+}
+
+// FetchResponse carries either records, the epoch where the follower's log
+// diverged from the leader's, or the snapshot it has to fetch first.
+type FetchResponse struct {
+	ErrorCode      int16
+	CurrentLeader  LeaderAndEpoch
+	HighWatermark  int64
+	LogStartOffset int64
+	DivergingEpoch *OffsetAndEpoch
+	SnapshotID     *metadata.SnapshotID
+	Records        []byte
+}
+
+type FetchSnapshotRequest struct {
+	ClusterID          string
+	ReplicaID          int32
+	CurrentLeaderEpoch int32
+	SnapshotID         metadata.SnapshotID
+	Position           int64
+	MaxBytes           int32
+}
+
+type FetchSnapshotResponse struct {
+	ErrorCode     int16
+	CurrentLeader LeaderAndEpoch
+	SnapshotID    metadata.SnapshotID
+	Size          int64
+	Position      int64
+	Records       []byte
+}
+
+// Transport sends the quorum's requests to other nodes. An error means no
+// response was received.
+type Transport interface {
+	Vote(destination int32, req VoteRequest) (VoteResponse, error)
+	BeginQuorumEpoch(destination int32, req BeginQuorumEpochRequest) (QuorumEpochResponse, error)
+	EndQuorumEpoch(destination int32, req EndQuorumEpochRequest) (QuorumEpochResponse, error)
+	Fetch(destination int32, req FetchRequest, maxWait time.Duration) (FetchResponse, error)
+	FetchSnapshot(destination int32, req FetchSnapshotRequest) (FetchSnapshotResponse, error)
+}
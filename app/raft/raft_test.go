@@ -0,0 +1,273 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/gofrs/uuid"
+)
+
+type testCluster struct {
+	network *LocalNetwork
+	nodes   map[int32]*RaftNode
+	stop    chan struct{}
+}
+
+func newTestCluster(t *testing.T, voters []int32) *testCluster {
+	cluster := &testCluster{network: NewLocalNetwork(), nodes: map[int32]*RaftNode{}, stop: make(chan struct{})}
+	for _, nodeId := range voters {
+		config := Config{
+			NodeID:          nodeId,
+			ClusterID:       "test-cluster",
+			Voters:          voters,
+			Dir:             t.TempDir(),
+			ElectionTimeout: 100 * time.Millisecond,
+			FetchTimeout:    300 * time.Millisecond,
+			FetchMaxWait:    50 * time.Millisecond,
+			Tick:            10 * time.Millisecond,
+		}
+		n, err := NewRaftNode(config, cluster.network.Transport(nodeId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cluster.network.Add(n)
+		cluster.nodes[nodeId] = n
+	}
+	for _, n := range cluster.nodes {
+		go n.Run(cluster.stop)
+	}
+	t.Cleanup(func() {
+		close(cluster.stop)
+		time.Sleep(100 * time.Millisecond)
+		for _, n := range cluster.nodes {
+			n.Close()
+		}
+	})
+	return cluster
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// leader returns the only leader that every connected node agrees on.
+func (c *testCluster) leader(exclude ...int32) *RaftNode {
+	var leader *RaftNode
+	for nodeId, n := range c.nodes {
+		if slices.Contains(exclude, nodeId) {
+			continue
+		}
+		if n.Role() == LEADER {
+			if leader != nil {
+				return nil
+			}
+			leader = n
+		}
+	}
+	if leader == nil {
+		return nil
+	}
+	for nodeId, n := range c.nodes {
+		if !slices.Contains(exclude, nodeId) && n.LeaderAndEpoch() != leader.LeaderAndEpoch() {
+			return nil
+		}
+	}
+	return leader
+}
+
+func topicRecord(name string) []metadata.MetadataRecord {
+	return []metadata.MetadataRecord{&metadata.TopicRecord{Name: name, TopicID: uuid.Must(uuid.NewV4())}}
+}
+
+func readLog(t *testing.T, n *RaftNode) []byte {
+	data, err := n.log.Read(n.log.LogStartOffset(), n.log.LogEndOffset(), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestElectLeaderAndReplicate(t *testing.T) {
+	cluster := newTestCluster(t, []int32{1, 2, 3})
+	waitFor(t, "a leader", func() bool { return cluster.leader() != nil })
+	leader := cluster.leader()
+
+	offset, err := leader.Append(topicRecord("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leader.WaitForCommit(offset, 5*time.Second) {
+		t.Fatalf("Record at %d was not committed", offset)
+	}
+	waitFor(t, "followers to catch up", func() bool {
+		for _, n := range cluster.nodes {
+			if n.HighWatermark() <= offset {
+				return false
+			}
+		}
+		return true
+	})
+	for _, n := range cluster.nodes {
+		if !bytes.Equal(readLog(t, n), readLog(t, leader)) {
+			t.Errorf("Log of node %d differs from the leader's", n.config.NodeID)
+		}
+	}
+
+	info, errorCode := leader.DescribeQuorum()
+	if errorCode != 0 || len(info.Voters) != 3 || info.HighWatermark != offset+1 {
+		t.Errorf("Unexpected quorum description %+v (error %d)", info, errorCode)
+	}
+	for _, n := range cluster.nodes {
+		if n != leader {
+			if _, err := n.Append(topicRecord("bar")); err != ErrNotLeader {
+				t.Errorf("Append on follower %d returned %v", n.config.NodeID, err)
+			}
+		}
+	}
+}
+
+func TestFailoverTruncatesUncommittedRecords(t *testing.T) {
+	cluster := newTestCluster(t, []int32{1, 2, 3})
+	waitFor(t, "a leader", func() bool { return cluster.leader() != nil })
+	oldLeader := cluster.leader()
+	oldEpoch := oldLeader.LeaderAndEpoch().LeaderEpoch
+
+	cluster.network.Disconnect(oldLeader.config.NodeID)
+	uncommitted, err := oldLeader.Append(topicRecord("lost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldLeader.WaitForCommit(uncommitted, 200*time.Millisecond) {
+		t.Fatal("Partitioned leader committed a record")
+	}
+
+	waitFor(t, "a new leader", func() bool {
+		leader := cluster.leader(oldLeader.config.NodeID)
+		return leader != nil && leader.LeaderAndEpoch().LeaderEpoch > oldEpoch
+	})
+	newLeader := cluster.leader(oldLeader.config.NodeID)
+	offset, err := newLeader.Append(topicRecord("kept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newLeader.WaitForCommit(offset, 5*time.Second) {
+		t.Fatalf("Record at %d was not committed", offset)
+	}
+
+	cluster.network.Connect(oldLeader.config.NodeID)
+	waitFor(t, "the old leader to rejoin", func() bool {
+		leader := cluster.leader()
+		return leader != nil && bytes.Equal(readLog(t, oldLeader), readLog(t, leader)) && oldLeader.HighWatermark() == leader.HighWatermark()
+	})
+	if bytes.Contains(readLog(t, oldLeader), []byte("lost")) {
+		t.Error("Uncommitted record survived on the old leader")
+	}
+}
+
+func TestCatchUpFromSnapshot(t *testing.T) {
+	segmentBytes := storage.SegmentBytes
+	storage.SegmentBytes = 256
+	t.Cleanup(func() { storage.SegmentBytes = segmentBytes })
+
+	cluster := newTestCluster(t, []int32{1, 2, 3})
+	waitFor(t, "a leader", func() bool { return cluster.leader() != nil })
+	leader := cluster.leader()
+	var lagging *RaftNode
+	for _, n := range cluster.nodes {
+		if n != leader {
+			lagging = n
+			break
+		}
+	}
+
+	cluster.network.Disconnect(lagging.config.NodeID)
+	var offset int64
+	for i := 0; i < 20; i++ {
+		var err error
+		if offset, err = leader.Append(topicRecord(fmt.Sprintf("topic-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !leader.WaitForCommit(offset, 5*time.Second) {
+		t.Fatalf("Record at %d was not committed", offset)
+	}
+
+	// The nodes that have every record snapshot them and drop the log
+	image := metadata.EmptyImage()
+	image.Offset = offset
+	image.Epoch = leader.LeaderAndEpoch().LeaderEpoch
+	var snapshotId metadata.SnapshotID
+	for _, n := range cluster.nodes {
+		if n == lagging {
+			continue
+		}
+		waitFor(t, "the record to commit everywhere", func() bool { return n.HighWatermark() > offset })
+		id, err := metadata.WriteSnapshot(n.config.Dir, image, time.Now().UnixMilli())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.DeleteBefore(id); err != nil {
+			t.Fatal(err)
+		}
+		if n.log.LogStartOffset() == 0 {
+			t.Fatalf("Node %d kept its whole log", n.config.NodeID)
+		}
+		snapshotId = id
+	}
+
+	cluster.network.Connect(lagging.config.NodeID)
+	waitFor(t, "the lagging node to catch up", func() bool {
+		leader := cluster.leader()
+		return leader != nil && lagging.HighWatermark() == leader.HighWatermark() && lagging.LogEndOffset() == leader.LogEndOffset()
+	})
+	if _, err := os.Stat(metadata.SnapshotFileName(lagging.config.Dir, snapshotId)); err != nil {
+		t.Errorf("Lagging node did not fetch the snapshot: %v", err)
+	}
+	if lagging.log.LogStartOffset() != snapshotId.EndOffset {
+		t.Errorf("Expected the log to start at %d, got %d", snapshotId.EndOffset, lagging.log.LogStartOffset())
+	}
+}
+
+func TestVoteIsOnlyGrantedOncePersisted(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig(1, []int32{1, 2, 3})
+	config.Dir = dir
+	n, err := NewRaftNode(config, NewLocalNetwork().Transport(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// A directory in the way of the state file makes every write fail
+	blocker := filepath.Join(dir, QUORUM_STATE_FILE+".tmp")
+	if err := os.Mkdir(blocker, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	req := VoteRequest{CandidateEpoch: 1, CandidateID: 2}
+	if resp := n.HandleVote(req); resp.VoteGranted || resp.LeaderEpoch != 0 {
+		t.Fatalf("Expected no vote and no new epoch without a persisted state, got %+v", resp)
+	}
+
+	os.Remove(blocker)
+	if resp := n.HandleVote(req); !resp.VoteGranted {
+		t.Fatalf("Expected the vote once the state is written, got %+v", resp)
+	}
+	state, err := readQuorumState(dir)
+	if err != nil || state.LeaderEpoch != 1 || state.VotedID != 2 {
+		t.Fatalf("Expected the vote for 2 in epoch 1 to be persisted, got %+v, %v", state, err)
+	}
+}
@@ -0,0 +1,178 @@
+package record
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+type Codec int8
+
+const (
+	COMPRESSION_NONE   Codec = 0
+	COMPRESSION_GZIP   Codec = 1
+	COMPRESSION_SNAPPY Codec = 2
+	COMPRESSION_LZ4    Codec = 3
+	COMPRESSION_ZSTD   Codec = 4
+)
+
+var ErrUnsupportedCompression = errors.New("unsupported compression codec")
+
+// Java clients frame snappy data the way xerial's SnappyOutputStream does:
+// a magic header followed by length prefixed snappy blocks.
+var xerialHeader = []byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0x00}
+
+const xerialBlockSize = 32 * 1024
+
+// MAX_DECOMPRESSED_SIZE bounds the records of a batch once decompressed, at
+// the maximum request size, so a small batch cannot expand without limit
+const MAX_DECOMPRESSED_SIZE = 100 * 1024 * 1024
+
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MAX_DECOMPRESSED_SIZE))
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+func (c Codec) String() string {
+	switch c {
+	case COMPRESSION_NONE:
+		return "none"
+	case COMPRESSION_GZIP:
+		return "gzip"
+	case COMPRESSION_SNAPPY:
+		return "snappy"
+	case COMPRESSION_LZ4:
+		return "lz4"
+	case COMPRESSION_ZSTD:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown(%d)", int8(c))
+}
+
+func Compress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case COMPRESSION_NONE:
+		return data, nil
+	case COMPRESSION_GZIP:
+		var out bytes.Buffer
+		writer := gzip.NewWriter(&out)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case COMPRESSION_SNAPPY:
+		return xerialEncode(data), nil
+	case COMPRESSION_LZ4:
+		var out bytes.Buffer
+		writer := lz4.NewWriter(&out)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case COMPRESSION_ZSTD:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, ErrUnsupportedCompression
+}
+
+func Decompress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case COMPRESSION_NONE:
+		return data, nil
+	case COMPRESSION_GZIP:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrCorruptRecord
+		}
+		defer reader.Close()
+		return readLimited(reader)
+	case COMPRESSION_SNAPPY:
+		return xerialDecode(data)
+	case COMPRESSION_LZ4:
+		return readLimited(lz4.NewReader(bytes.NewReader(data)))
+	case COMPRESSION_ZSTD:
+		out, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, ErrCorruptRecord
+		}
+		return out, nil
+	}
+	return nil, ErrUnsupportedCompression
+}
+
+// readLimited decompresses all of reader, failing once it exceeds
+// MAX_DECOMPRESSED_SIZE.
+func readLimited(reader io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(reader, MAX_DECOMPRESSED_SIZE+1))
+	if err != nil || len(out) > MAX_DECOMPRESSED_SIZE {
+		return nil, ErrCorruptRecord
+	}
+	return out, nil
+}
+
+// snappyDecode decodes a snappy block no larger than limit.
+func snappyDecode(data []byte, limit int) ([]byte, error) {
+	if size, err := snappy.DecodedLen(data); err != nil || size > limit {
+		return nil, ErrCorruptRecord
+	}
+	out, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, ErrCorruptRecord
+	}
+	return out, nil
+}
+
+func xerialEncode(data []byte) []byte {
+	var out bytes.Buffer
+	out.Write(xerialHeader)
+	binary.Write(&out, binary.BigEndian, int32(1)) // version
+	binary.Write(&out, binary.BigEndian, int32(1)) // compatible version
+	for len(data) > 0 {
+		size := min(len(data), xerialBlockSize)
+		block := snappy.Encode(nil, data[:size])
+		binary.Write(&out, binary.BigEndian, int32(len(block)))
+		out.Write(block)
+		data = data[size:]
+	}
+	return out.Bytes()
+}
+
+// xerialDecode accepts both framed and raw snappy blocks, since non-Java
+// clients commonly send the latter.
+func xerialDecode(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, xerialHeader) {
+		return snappyDecode(data, MAX_DECOMPRESSED_SIZE)
+	}
+	if len(data) < len(xerialHeader)+8 {
+		return nil, ErrCorruptRecord
+	}
+
+	reader := bytes.NewBuffer(data[len(xerialHeader)+8:])
+	var out []byte
+	for reader.Len() > 0 {
+		var size int32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return nil, ErrCorruptRecord
+		}
+		if size < 0 || int(size) > reader.Len() {
+			return nil, ErrCorruptRecord
+		}
+		block, err := snappyDecode(reader.Next(int(size)), MAX_DECOMPRESSED_SIZE-len(out))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, block...)
+	}
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+type ControlRecordType int16
+
+const (
+	CONTROL_ABORT           ControlRecordType = 0
+	CONTROL_COMMIT          ControlRecordType = 1
+	CONTROL_LEADER_CHANGE   ControlRecordType = 2
+	CONTROL_SNAPSHOT_HEADER ControlRecordType = 3
+	CONTROL_SNAPSHOT_FOOTER ControlRecordType = 4
+)
+
+const CONTROL_RECORD_VERSION int16 = 0
+
+// NewEndTxnMarker builds the control batch that ends a producer's transaction
+// in a partition.
+func NewEndTxnMarker(producerId int64, producerEpoch int16, coordinatorEpoch int32, commit bool, timestamp int64) *RecordBatch {
+	controlType := CONTROL_ABORT
+	if commit {
+		controlType = CONTROL_COMMIT
+	}
+
+	var key bytes.Buffer
+	binary.Write(&key, binary.BigEndian, CONTROL_RECORD_VERSION)
+	binary.Write(&key, binary.BigEndian, controlType)
+
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, CONTROL_RECORD_VERSION)
+	binary.Write(&value, binary.BigEndian, coordinatorEpoch)
+
+	batch := NewRecordBatch([]Record{{Key: key.Bytes(), Value: value.Bytes()}})
+	batch.Attributes = CONTROL_FLAG_MASK | TRANSACTIONAL_FLAG_MASK
+	batch.ProducerID = producerId
+	batch.ProducerEpoch = producerEpoch
+	batch.BaseTimestamp = timestamp
+	batch.MaxTimestamp = timestamp
+	return batch
+}
+
+// DecodeEndTxnMarker returns the type and coordinator epoch of a control
+// record.
+func DecodeEndTxnMarker(rec Record) (ControlRecordType, int32, error) {
+	var version int16
+	var controlType ControlRecordType
+	var coordinatorEpoch int32
+
+	key := bytes.NewBuffer(rec.Key)
+	if err := binary.Read(key, binary.BigEndian, &version); err != nil {
+		return 0, 0, ErrCorruptRecord
+	}
+	if err := binary.Read(key, binary.BigEndian, &controlType); err != nil {
+		return 0, 0, ErrCorruptRecord
+	}
+	value := bytes.NewBuffer(rec.Value)
+	if err := binary.Read(value, binary.BigEndian, &version); err != nil {
+		return 0, 0, ErrCorruptRecord
+	}
+	if err := binary.Read(value, binary.BigEndian, &coordinatorEpoch); err != nil {
+		return 0, 0, ErrCorruptRecord
+	}
+	return controlType, coordinatorEpoch, nil
+}
+
+// NewControlBatch wraps an already encoded control record value, as used by
+// KRaft for snapshot headers and footers and leader changes.
+func NewControlBatch(controlType ControlRecordType, value []byte, timestamp int64) *RecordBatch {
+	var key bytes.Buffer
+	binary.Write(&key, binary.BigEndian, CONTROL_RECORD_VERSION)
+	binary.Write(&key, binary.BigEndian, controlType)
+
+	batch := NewRecordBatch([]Record{{Key: key.Bytes(), Value: value}})
+	batch.Attributes = CONTROL_FLAG_MASK
+	batch.BaseTimestamp = timestamp
+	batch.MaxTimestamp = timestamp
+	return batch
+}
+
+// ControlType reads the type from the key of a control record.
+func ControlType(rec Record) (ControlRecordType, error) {
+	var version int16
+	var controlType ControlRecordType
+	key := bytes.NewBuffer(rec.Key)
+	if err := binary.Read(key, binary.BigEndian, &version); err != nil {
+		return 0, ErrCorruptRecord
+	}
+	if err := binary.Read(key, binary.BigEndian, &controlType); err != nil {
+		return 0, ErrCorruptRecord
+	}
+	return controlType, nil
+}
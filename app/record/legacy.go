@@ -0,0 +1,209 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const LEGACY_COMPRESSION_CODEC_MASK int8 = 0x07
+const LEGACY_TIMESTAMP_TYPE_MASK int8 = 0x08
+const NO_TIMESTAMP int64 = -1
+
+// Message is a single entry of a legacy (magic 0 or 1) MessageSet.
+type Message struct {
+	Offset     int64
+	Magic      int8
+	Attributes int8
+	Timestamp  int64
+	Key        []byte
+	Value      []byte
+}
+
+func (m Message) Compression() Codec {
+	return Codec(m.Attributes & LEGACY_COMPRESSION_CODEC_MASK)
+}
+
+// DecodeMessageSet decodes a v0/v1 MessageSet, unwrapping compressed wrapper
+// messages into their inner messages with absolute offsets.
+func DecodeMessageSet(data []byte) ([]Message, error) {
+	reader := bytes.NewBuffer(data)
+	messages := []Message{}
+	for reader.Len() >= LOG_OVERHEAD {
+		raw := reader.Bytes()
+		size := int32(binary.BigEndian.Uint32(raw[8:12]))
+		if size < 0 {
+			return messages, ErrCorruptRecord
+		}
+		if int(size)+LOG_OVERHEAD > len(raw) {
+			break
+		}
+		msg, err := decodeMessage(reader.Next(LOG_OVERHEAD + int(size)))
+		if err != nil {
+			return messages, err
+		}
+		if msg.Compression() == COMPRESSION_NONE {
+			messages = append(messages, msg)
+			continue
+		}
+
+		inner, err := Decompress(msg.Compression(), msg.Value)
+		if err != nil {
+			return messages, err
+		}
+		innerMessages, err := DecodeMessageSet(inner)
+		if err != nil {
+			return messages, err
+		}
+		// v1 wrappers carry the absolute offset of the last inner message and
+		// inner offsets are relative to the first one.
+		if msg.Magic == MAGIC_V1 && len(innerMessages) > 0 {
+			lastRelative := innerMessages[len(innerMessages)-1].Offset
+			for i := range innerMessages {
+				innerMessages[i].Offset = msg.Offset - lastRelative + innerMessages[i].Offset
+			}
+		}
+		messages = append(messages, innerMessages...)
+	}
+	return messages, nil
+}
+
+func decodeMessage(raw []byte) (Message, error) {
+	msg := Message{Timestamp: NO_TIMESTAMP}
+	reader := bytes.NewBuffer(raw)
+	var size int32
+	var crc uint32
+	binary.Read(reader, binary.BigEndian, &msg.Offset)
+	binary.Read(reader, binary.BigEndian, &size)
+	if err := binary.Read(reader, binary.BigEndian, &crc); err != nil {
+		return msg, ErrCorruptRecord
+	}
+	if crc32.ChecksumIEEE(reader.Bytes()) != crc {
+		return msg, ErrCrcMismatch
+	}
+	if err := binary.Read(reader, binary.BigEndian, &msg.Magic); err != nil {
+		return msg, ErrCorruptRecord
+	}
+	if msg.Magic != MAGIC_V0 && msg.Magic != MAGIC_V1 {
+		return msg, ErrUnsupportedMagic
+	}
+	if err := binary.Read(reader, binary.BigEndian, &msg.Attributes); err != nil {
+		return msg, ErrCorruptRecord
+	}
+	if msg.Magic == MAGIC_V1 {
+		if err := binary.Read(reader, binary.BigEndian, &msg.Timestamp); err != nil {
+			return msg, ErrCorruptRecord
+		}
+	}
+	var err error
+	if msg.Key, err = readInt32Bytes(reader); err != nil {
+		return msg, err
+	}
+	if msg.Value, err = readInt32Bytes(reader); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func readInt32Bytes(data *bytes.Buffer) ([]byte, error) {
+	var length int32
+	if err := binary.Read(data, binary.BigEndian, &length); err != nil {
+		return nil, ErrCorruptRecord
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	if int(length) > data.Len() {
+		return nil, ErrCorruptRecord
+	}
+	value := make([]byte, length)
+	copy(value, data.Next(int(length)))
+	return value, nil
+}
+
+func writeInt32Bytes(out *bytes.Buffer, value []byte) {
+	if value == nil {
+		binary.Write(out, binary.BigEndian, int32(-1))
+		return
+	}
+	binary.Write(out, binary.BigEndian, int32(len(value)))
+	out.Write(value)
+}
+
+// EncodeMessageSet writes messages in the given magic. Values are written as
+// is, so a wrapper message must already hold its compressed inner set.
+func EncodeMessageSet(messages []Message, magic int8) []byte {
+	var out bytes.Buffer
+	for _, msg := range messages {
+		var body bytes.Buffer
+		binary.Write(&body, binary.BigEndian, magic)
+		attributes := msg.Attributes
+		if magic == MAGIC_V0 {
+			attributes &^= LEGACY_TIMESTAMP_TYPE_MASK
+		}
+		binary.Write(&body, binary.BigEndian, attributes)
+		if magic == MAGIC_V1 {
+			binary.Write(&body, binary.BigEndian, msg.Timestamp)
+		}
+		writeInt32Bytes(&body, msg.Key)
+		writeInt32Bytes(&body, msg.Value)
+
+		binary.Write(&out, binary.BigEndian, msg.Offset)
+		binary.Write(&out, binary.BigEndian, int32(4+body.Len()))
+		binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(body.Bytes()))
+		out.Write(body.Bytes())
+	}
+	return out.Bytes()
+}
+
+// DownConvert rewrites v2 batches as a legacy MessageSet for clients that
+// cannot read v2. Headers have no legacy equivalent and control batches are
+// not visible to such clients, so both are dropped.
+func DownConvert(batches []*RecordBatch, magic int8) []byte {
+	messages := []Message{}
+	for _, batch := range batches {
+		if batch.IsControl() {
+			continue
+		}
+		logAppendTime := batch.Attributes&TIMESTAMP_TYPE_MASK != 0
+		for _, rec := range batch.Records {
+			msg := Message{
+				Offset:    batch.BaseOffset + int64(rec.OffsetDelta),
+				Magic:     magic,
+				Timestamp: batch.BaseTimestamp + rec.TimestampDelta,
+				Key:       rec.Key,
+				Value:     rec.Value,
+			}
+			if logAppendTime {
+				msg.Attributes |= LEGACY_TIMESTAMP_TYPE_MASK
+				msg.Timestamp = batch.MaxTimestamp
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return EncodeMessageSet(messages, magic)
+}
+
+// UpConvert turns decoded legacy messages into a single v2 batch.
+func UpConvert(messages []Message) *RecordBatch {
+	batch := NewRecordBatch(nil)
+	if len(messages) == 0 {
+		return batch
+	}
+	records := make([]Record, 0, len(messages))
+	batch.BaseOffset = messages[0].Offset
+	batch.BaseTimestamp = messages[0].Timestamp
+	batch.MaxTimestamp = NO_TIMESTAMP
+	for _, msg := range messages {
+		batch.MaxTimestamp = max(batch.MaxTimestamp, msg.Timestamp)
+		records = append(records, Record{
+			TimestampDelta: msg.Timestamp - batch.BaseTimestamp,
+			OffsetDelta:    int32(msg.Offset - batch.BaseOffset),
+			Key:            msg.Key,
+			Value:          msg.Value,
+		})
+	}
+	batch.Records = records
+	batch.LastOffsetDelta = records[len(records)-1].OffsetDelta
+	return batch
+}
@@ -0,0 +1,392 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	MAGIC_V0 int8 = 0
+	MAGIC_V1 int8 = 1
+	MAGIC_V2 int8 = 2
+)
+
+const (
+	COMPRESSION_CODEC_MASK   int16 = 0x07
+	TIMESTAMP_TYPE_MASK      int16 = 0x08
+	TRANSACTIONAL_FLAG_MASK  int16 = 0x10
+	CONTROL_FLAG_MASK        int16 = 0x20
+	DELETE_HORIZON_FLAG_MASK int16 = 0x40
+)
+
+const NO_PRODUCER_ID int64 = -1
+const NO_PRODUCER_EPOCH int16 = -1
+const NO_SEQUENCE int32 = -1
+
+// Size of everything in a v2 batch before the records, and the offset of the
+// fields that matter when only the header has been read.
+const BATCH_HEADER_SIZE = 8 + 4 + 4 + 1 + 4 + 2 + 4 + 8 + 8 + 8 + 2 + 4 + 4
+const LOG_OVERHEAD = 8 + 4
+
+// MIN_RECORD_SIZE is the smallest encoding of a record: a one byte varint
+// for each of length, attributes, timestamp and offset deltas, key and value
+// lengths, and header count
+const MIN_RECORD_SIZE = 7
+const MAGIC_OFFSET = 8 + 4 + 4
+const CRC_OFFSET = MAGIC_OFFSET + 1
+const ATTRIBUTES_OFFSET = CRC_OFFSET + 4
+
+var (
+	ErrCorruptRecord    = errors.New("corrupt record")
+	ErrCrcMismatch      = errors.New("record batch crc mismatch")
+	ErrUnsupportedMagic = errors.New("unsupported record batch magic")
+	ErrTruncatedBatch   = errors.New("truncated record batch")
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+type Record struct {
+	Attributes     int8
+	TimestampDelta int64
+	OffsetDelta    int32
+	Key            []byte
+	Value          []byte
+	Headers        []Header
+}
+
+type RecordBatch struct {
+	BaseOffset           int64
+	BatchLength          int32
+	PartitionLeaderEpoch int32
+	Magic                int8
+	CRC                  uint32
+	Attributes           int16
+	LastOffsetDelta      int32
+	BaseTimestamp        int64
+	MaxTimestamp         int64
+	ProducerID           int64
+	ProducerEpoch        int16
+	BaseSequence         int32
+	Records              []Record
+}
+
+func NewRecordBatch(records []Record) *RecordBatch {
+	batch := &RecordBatch{
+		Magic:         MAGIC_V2,
+		ProducerID:    NO_PRODUCER_ID,
+		ProducerEpoch: NO_PRODUCER_EPOCH,
+		BaseSequence:  NO_SEQUENCE,
+		Records:       records,
+	}
+	for i := range batch.Records {
+		batch.Records[i].OffsetDelta = int32(i)
+	}
+	if len(records) > 0 {
+		batch.LastOffsetDelta = int32(len(records) - 1)
+	}
+	return batch
+}
+
+func (b *RecordBatch) Compression() Codec {
+	return Codec(b.Attributes & COMPRESSION_CODEC_MASK)
+}
+
+func (b *RecordBatch) SetCompression(codec Codec) {
+	b.Attributes = (b.Attributes &^ COMPRESSION_CODEC_MASK) | int16(codec)
+}
+
+func (b *RecordBatch) IsTransactional() bool {
+	return b.Attributes&TRANSACTIONAL_FLAG_MASK != 0
+}
+
+func (b *RecordBatch) IsControl() bool {
+	return b.Attributes&CONTROL_FLAG_MASK != 0
+}
+
+func (b *RecordBatch) HasProducerID() bool {
+	return b.ProducerID > NO_PRODUCER_ID
+}
+
+func (b *RecordBatch) LastOffset() int64 {
+	return b.BaseOffset + int64(b.LastOffsetDelta)
+}
+
+func (b *RecordBatch) NextOffset() int64 {
+	return b.LastOffset() + 1
+}
+
+func (b *RecordBatch) LastSequence() int32 {
+	if b.BaseSequence == NO_SEQUENCE {
+		return NO_SEQUENCE
+	}
+	return int32((int64(b.BaseSequence) + int64(b.LastOffsetDelta)) % (1 << 31))
+}
+
+// SizeInBytes is the full on-disk size including offset and length prefix.
+func (b *RecordBatch) SizeInBytes() int {
+	return LOG_OVERHEAD + int(b.BatchLength)
+}
+
+// DecodeBatch reads one v2 batch from data, validating its CRC32C and
+// decompressing the records.
+func DecodeBatch(data *bytes.Buffer) (*RecordBatch, error) {
+	if data.Len() < BATCH_HEADER_SIZE {
+		return nil, ErrTruncatedBatch
+	}
+	raw := data.Bytes()
+	batchLength := int32(binary.BigEndian.Uint32(raw[8:12]))
+	if batchLength < BATCH_HEADER_SIZE-LOG_OVERHEAD {
+		return nil, ErrCorruptRecord
+	}
+	if int(batchLength)+LOG_OVERHEAD > len(raw) {
+		return nil, ErrTruncatedBatch
+	}
+	if magic := int8(raw[MAGIC_OFFSET]); magic != MAGIC_V2 {
+		return nil, ErrUnsupportedMagic
+	}
+	batchBytes := data.Next(LOG_OVERHEAD + int(batchLength))
+
+	batch := &RecordBatch{}
+	if err := batch.decodeHeader(bytes.NewBuffer(batchBytes)); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(batchBytes[ATTRIBUTES_OFFSET:], crc32c) != batch.CRC {
+		return nil, ErrCrcMismatch
+	}
+
+	recordCount := int32(binary.BigEndian.Uint32(batchBytes[BATCH_HEADER_SIZE-4 : BATCH_HEADER_SIZE]))
+	if recordCount < 0 {
+		return nil, ErrCorruptRecord
+	}
+
+	recordData, err := Decompress(batch.Compression(), batchBytes[BATCH_HEADER_SIZE:])
+	if err != nil {
+		return nil, err
+	}
+	// Every record takes at least MIN_RECORD_SIZE bytes, which bounds the
+	// count a batch can hold before any of them are read
+	if int64(recordCount) > int64(len(recordData)/MIN_RECORD_SIZE) {
+		return nil, ErrCorruptRecord
+	}
+	reader := bytes.NewBuffer(recordData)
+	batch.Records = []Record{}
+	for range recordCount {
+		rec, err := decodeRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		batch.Records = append(batch.Records, rec)
+	}
+	return batch, nil
+}
+
+func (b *RecordBatch) decodeHeader(data *bytes.Buffer) error {
+	fields := []any{
+		&b.BaseOffset, &b.BatchLength, &b.PartitionLeaderEpoch, &b.Magic, &b.CRC,
+		&b.Attributes, &b.LastOffsetDelta, &b.BaseTimestamp, &b.MaxTimestamp,
+		&b.ProducerID, &b.ProducerEpoch, &b.BaseSequence,
+	}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return ErrTruncatedBatch
+		}
+	}
+	return nil
+}
+
+// DecodeBatchHeader reads only the fixed header of a v2 batch without
+// touching the records, which is enough for offset and producer bookkeeping.
+func DecodeBatchHeader(raw []byte) (*RecordBatch, error) {
+	if len(raw) < BATCH_HEADER_SIZE {
+		return nil, ErrTruncatedBatch
+	}
+	if magic := int8(raw[MAGIC_OFFSET]); magic != MAGIC_V2 {
+		return nil, ErrUnsupportedMagic
+	}
+	batch := &RecordBatch{}
+	if err := batch.decodeHeader(bytes.NewBuffer(raw[:BATCH_HEADER_SIZE])); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// DecodeBatches decodes every complete batch in data. A partial batch at the
+// end is ignored, as Fetch responses are allowed to end with one.
+func DecodeBatches(data []byte) ([]*RecordBatch, error) {
+	reader := bytes.NewBuffer(data)
+	batches := []*RecordBatch{}
+	for reader.Len() > 0 {
+		batch, err := DecodeBatch(reader)
+		if err == ErrTruncatedBatch {
+			break
+		}
+		if err != nil {
+			return batches, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+func decodeRecord(data *bytes.Buffer) (Record, error) {
+	rec := Record{}
+	length, err := readVarint(data)
+	if err != nil {
+		return rec, err
+	}
+	if length < 0 || int(length) > data.Len() {
+		return rec, ErrCorruptRecord
+	}
+	body := bytes.NewBuffer(data.Next(int(length)))
+
+	if err := binary.Read(body, binary.BigEndian, &rec.Attributes); err != nil {
+		return rec, ErrCorruptRecord
+	}
+	if rec.TimestampDelta, err = readVarint(body); err != nil {
+		return rec, err
+	}
+	offsetDelta, err := readVarint(body)
+	if err != nil {
+		return rec, err
+	}
+	rec.OffsetDelta = int32(offsetDelta)
+	if rec.Key, err = readVarintBytes(body); err != nil {
+		return rec, err
+	}
+	if rec.Value, err = readVarintBytes(body); err != nil {
+		return rec, err
+	}
+
+	headerCount, err := readVarint(body)
+	if err != nil {
+		return rec, err
+	}
+	if headerCount < 0 || int(headerCount) > body.Len() {
+		return rec, ErrCorruptRecord
+	}
+	for range headerCount {
+		key, err := readVarintBytes(body)
+		if err != nil {
+			return rec, err
+		}
+		if key == nil {
+			return rec, ErrCorruptRecord
+		}
+		value, err := readVarintBytes(body)
+		if err != nil {
+			return rec, err
+		}
+		rec.Headers = append(rec.Headers, Header{Key: string(key), Value: value})
+	}
+	if body.Len() != 0 {
+		return rec, ErrCorruptRecord
+	}
+	return rec, nil
+}
+
+func readVarint(data *bytes.Buffer) (int64, error) {
+	value, err := binary.ReadVarint(data)
+	if err != nil {
+		return 0, ErrCorruptRecord
+	}
+	return value, nil
+}
+
+func readVarintBytes(data *bytes.Buffer) ([]byte, error) {
+	length, err := readVarint(data)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	if int(length) > data.Len() {
+		return nil, ErrCorruptRecord
+	}
+	value := make([]byte, length)
+	copy(value, data.Next(int(length)))
+	return value, nil
+}
+
+// Encode serializes the batch, compressing the records with the codec in its
+// attributes and filling in BatchLength and CRC.
+func (b *RecordBatch) Encode() ([]byte, error) {
+	var records bytes.Buffer
+	for _, rec := range b.Records {
+		encodeRecord(&records, rec)
+	}
+	recordData, err := Compress(b.Compression(), records.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	b.Magic = MAGIC_V2
+	b.BatchLength = int32(BATCH_HEADER_SIZE - LOG_OVERHEAD + len(recordData))
+
+	var out bytes.Buffer
+	out.Grow(BATCH_HEADER_SIZE + len(recordData))
+	binary.Write(&out, binary.BigEndian, b.BaseOffset)
+	binary.Write(&out, binary.BigEndian, b.BatchLength)
+	binary.Write(&out, binary.BigEndian, b.PartitionLeaderEpoch)
+	binary.Write(&out, binary.BigEndian, b.Magic)
+	binary.Write(&out, binary.BigEndian, uint32(0))
+	binary.Write(&out, binary.BigEndian, b.Attributes)
+	binary.Write(&out, binary.BigEndian, b.LastOffsetDelta)
+	binary.Write(&out, binary.BigEndian, b.BaseTimestamp)
+	binary.Write(&out, binary.BigEndian, b.MaxTimestamp)
+	binary.Write(&out, binary.BigEndian, b.ProducerID)
+	binary.Write(&out, binary.BigEndian, b.ProducerEpoch)
+	binary.Write(&out, binary.BigEndian, b.BaseSequence)
+	binary.Write(&out, binary.BigEndian, int32(len(b.Records)))
+	out.Write(recordData)
+
+	raw := out.Bytes()
+	b.CRC = crc32.Checksum(raw[ATTRIBUTES_OFFSET:], crc32c)
+	binary.BigEndian.PutUint32(raw[CRC_OFFSET:], b.CRC)
+	return raw, nil
+}
+
+func encodeRecord(out *bytes.Buffer, rec Record) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, rec.Attributes)
+	body.Write(binary.AppendVarint(nil, rec.TimestampDelta))
+	body.Write(binary.AppendVarint(nil, int64(rec.OffsetDelta)))
+	writeVarintBytes(&body, rec.Key)
+	writeVarintBytes(&body, rec.Value)
+	body.Write(binary.AppendVarint(nil, int64(len(rec.Headers))))
+	for _, header := range rec.Headers {
+		writeVarintBytes(&body, []byte(header.Key))
+		writeVarintBytes(&body, header.Value)
+	}
+
+	out.Write(binary.AppendVarint(nil, int64(body.Len())))
+	out.Write(body.Bytes())
+}
+
+func writeVarintBytes(out *bytes.Buffer, value []byte) {
+	if value == nil {
+		out.Write(binary.AppendVarint(nil, -1))
+		return
+	}
+	out.Write(binary.AppendVarint(nil, int64(len(value))))
+	out.Write(value)
+}
+
+// SetBaseOffset rewrites the base offset of an already encoded batch. The
+// offset is outside the CRC so the checksum stays valid.
+func SetBaseOffset(raw []byte, baseOffset int64) {
+	binary.BigEndian.PutUint64(raw[0:8], uint64(baseOffset))
+}
+
+// SetPartitionLeaderEpoch rewrites the leader epoch of an encoded batch,
+// which like the base offset is not covered by the CRC.
+func SetPartitionLeaderEpoch(raw []byte, epoch int32) {
+	binary.BigEndian.PutUint32(raw[12:16], uint32(epoch))
+}
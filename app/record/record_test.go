@@ -0,0 +1,128 @@
+package record
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func testRecords() []Record {
+	return []Record{
+		{TimestampDelta: 0, Key: []byte("key-0"), Value: []byte("value-0")},
+		{TimestampDelta: 5, Key: nil, Value: bytes.Repeat([]byte("v"), 500)},
+		{TimestampDelta: 300, Key: []byte("key-2"), Value: nil, Headers: []Header{
+			{Key: "trace-id", Value: []byte("abc")},
+			{Key: "empty", Value: nil},
+		}},
+	}
+}
+
+func TestRecordBatchRoundTrip(t *testing.T) {
+	codecs := []Codec{COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_SNAPPY, COMPRESSION_LZ4, COMPRESSION_ZSTD}
+	for _, codec := range codecs {
+		batch := NewRecordBatch(testRecords())
+		batch.BaseOffset = 42
+		batch.BaseTimestamp = 1700000000000
+		batch.MaxTimestamp = 1700000000300
+		batch.ProducerID = 7
+		batch.ProducerEpoch = 1
+		batch.BaseSequence = 10
+		batch.SetCompression(codec)
+
+		raw, err := batch.Encode()
+		if err != nil {
+			t.Fatalf("%s: failed to encode batch: %v", codec, err)
+		}
+
+		decoded, err := DecodeBatch(bytes.NewBuffer(raw))
+		if err != nil {
+			t.Fatalf("%s: failed to decode batch: %v", codec, err)
+		}
+		if decoded.Compression() != codec || decoded.BaseOffset != 42 || decoded.LastOffset() != 44 {
+			t.Errorf("%s: batch header does not match", codec)
+		}
+		if decoded.ProducerID != 7 || decoded.LastSequence() != 12 {
+			t.Errorf("%s: producer fields do not match", codec)
+		}
+		if len(decoded.Records) != 3 {
+			t.Fatalf("%s: expected 3 records, got %d", codec, len(decoded.Records))
+		}
+		for i, rec := range testRecords() {
+			got := decoded.Records[i]
+			if !bytes.Equal(got.Key, rec.Key) || !bytes.Equal(got.Value, rec.Value) ||
+				(got.Key == nil) != (rec.Key == nil) || got.OffsetDelta != int32(i) ||
+				got.TimestampDelta != rec.TimestampDelta || len(got.Headers) != len(rec.Headers) {
+				t.Errorf("%s: record %d does not match", codec, i)
+			}
+		}
+		if decoded.Records[2].Headers[0].Key != "trace-id" || decoded.Records[2].Headers[1].Value != nil {
+			t.Errorf("%s: headers do not match", codec)
+		}
+	}
+}
+
+func TestRecordBatchCrcMismatch(t *testing.T) {
+	raw, _ := NewRecordBatch(testRecords()).Encode()
+	raw[len(raw)-1] ^= 0xff
+
+	if _, err := DecodeBatch(bytes.NewBuffer(raw)); err != ErrCrcMismatch {
+		t.Errorf("expected crc mismatch, got %v", err)
+	}
+
+	// Offsets are outside the CRC and can be rewritten in place.
+	raw, _ = NewRecordBatch(testRecords()).Encode()
+	SetBaseOffset(raw, 100)
+	batches, err := DecodeBatches(append(raw, raw[:20]...))
+	if err != nil || len(batches) != 1 || batches[0].BaseOffset != 100 {
+		t.Errorf("expected one batch at offset 100, got %v %v", batches, err)
+	}
+}
+
+func TestRecordBatchBounds(t *testing.T) {
+	// A record count the batch cannot hold is rejected before allocating
+	raw, _ := NewRecordBatch(testRecords()).Encode()
+	binary.BigEndian.PutUint32(raw[BATCH_HEADER_SIZE-4:], 0x7fffffff)
+	binary.BigEndian.PutUint32(raw[CRC_OFFSET:], crc32.Checksum(raw[ATTRIBUTES_OFFSET:], crc32c))
+	if _, err := DecodeBatch(bytes.NewBuffer(raw)); err != ErrCorruptRecord {
+		t.Errorf("expected an oversized record count to be corrupt, got %v", err)
+	}
+
+	// Decompression stops at MAX_DECOMPRESSED_SIZE
+	var bomb bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&bomb, gzip.BestSpeed)
+	writer.Write(make([]byte, MAX_DECOMPRESSED_SIZE+1))
+	writer.Close()
+	if _, err := Decompress(COMPRESSION_GZIP, bomb.Bytes()); err != ErrCorruptRecord {
+		t.Errorf("expected decompression past the limit to fail, got %v", err)
+	}
+}
+
+func TestDownConvert(t *testing.T) {
+	batch := NewRecordBatch(testRecords())
+	batch.BaseOffset = 5
+	batch.BaseTimestamp = 1000
+
+	for _, magic := range []int8{MAGIC_V0, MAGIC_V1} {
+		messages, err := DecodeMessageSet(DownConvert([]*RecordBatch{batch}, magic))
+		if err != nil {
+			t.Fatalf("magic %d: failed to decode message set: %v", magic, err)
+		}
+		if len(messages) != 3 || messages[2].Offset != 7 || string(messages[0].Value) != "value-0" {
+			t.Errorf("magic %d: messages do not match", magic)
+		}
+		if magic == MAGIC_V1 && messages[2].Timestamp != 1300 {
+			t.Errorf("expected timestamp 1300, got %d", messages[2].Timestamp)
+		}
+	}
+
+	// A v1 gzip wrapper carries the offset of its last inner message.
+	inner := EncodeMessageSet([]Message{{Offset: 0, Value: []byte("a")}, {Offset: 1, Value: []byte("b")}}, MAGIC_V1)
+	compressed, _ := Compress(COMPRESSION_GZIP, inner)
+	wrapper := EncodeMessageSet([]Message{{Offset: 11, Attributes: int8(COMPRESSION_GZIP), Value: compressed}}, MAGIC_V1)
+	messages, err := DecodeMessageSet(wrapper)
+	if err != nil || len(messages) != 2 || messages[0].Offset != 10 || messages[1].Offset != 11 {
+		t.Errorf("wrapper not unwrapped correctly: %v %v", messages, err)
+	}
+}
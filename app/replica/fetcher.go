@@ -0,0 +1,166 @@
+package replica
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+type FetchPartition struct {
+	TopicID            uuid.UUID
+	Partition          int32
+	CurrentLeaderEpoch int32
+	FetchOffset        int64
+	LastFetchedEpoch   int32
+	LogStartOffset     int64
+	MaxBytes           int32
+}
+
+type FetchRequest struct {
+	ReplicaID    int32
+	ReplicaEpoch int64
+	MaxWait      time.Duration
+	MinBytes     int32
+	MaxBytes     int32
+	Partitions   []FetchPartition
+}
+
+// EpochEndOffset is where a leader epoch ends in a log.
+type EpochEndOffset struct {
+	Epoch     int32
+	EndOffset int64
+}
+
+type FetchPartitionData struct {
+	TopicID        uuid.UUID
+	Partition      int32
+	ErrorCode      int16
+	HighWatermark  int64
+	LogStartOffset int64
+	// DivergingEpoch is set when the follower's log no longer matches the
+	// leader's at its fetch offset
+	DivergingEpoch *EpochEndOffset
+	Records        []byte
+}
+
+// FetchClient sends a follower's fetches to the leader's broker.
+type FetchClient interface {
+	Fetch(leader int32, req FetchRequest) ([]FetchPartitionData, error)
+}
+
+// ReplicaFetcher copies the partitions led by one broker into this broker's
+// logs.
+type ReplicaFetcher struct {
+	leader  int32
+	manager *ReplicaManager
+
+	mu         sync.Mutex
+	partitions map[metadata.TopicIdPartition]*Partition
+	stop       chan struct{}
+}
+
+func newReplicaFetcher(leader int32, manager *ReplicaManager) *ReplicaFetcher {
+	return &ReplicaFetcher{
+		leader:     leader,
+		manager:    manager,
+		partitions: map[metadata.TopicIdPartition]*Partition{},
+		stop:       make(chan struct{}),
+	}
+}
+
+func (f *ReplicaFetcher) add(partition *Partition) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partitions[metadata.TopicIdPartition{TopicId: partition.TopicID, Partition: partition.Index}] = partition
+}
+
+// remove stops fetching a partition and reports whether any are left.
+func (f *ReplicaFetcher) remove(key metadata.TopicIdPartition) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.partitions, key)
+	return len(f.partitions) > 0
+}
+
+func (f *ReplicaFetcher) request() FetchRequest {
+	config := f.manager.config
+	req := FetchRequest{
+		ReplicaID:    config.BrokerID,
+		ReplicaEpoch: -1,
+		MaxWait:      config.FetchMaxWait,
+		MinBytes:     config.FetchMinBytes,
+		MaxBytes:     config.FetchMaxBytes,
+	}
+	if f.manager.BrokerEpoch != nil {
+		req.ReplicaEpoch = f.manager.BrokerEpoch()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, partition := range f.partitions {
+		if fetchPartition, ok := partition.fetchPartition(config.FetchMaxBytes); ok {
+			req.Partitions = append(req.Partitions, fetchPartition)
+		}
+	}
+	return req
+}
+
+func (f *ReplicaFetcher) partition(topicId uuid.UUID, index int32) *Partition {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.partitions[metadata.TopicIdPartition{TopicId: topicId, Partition: index}]
+}
+
+// fetch sends one fetch to the leader and appends what it returns. It
+// reports whether the fetch failed, so that the next one backs off.
+func (f *ReplicaFetcher) fetch() bool {
+	req := f.request()
+	if len(req.Partitions) == 0 {
+		return true
+	}
+	results, err := f.manager.client.Fetch(f.leader, req)
+	if err != nil {
+		log.Printf("Failed to fetch from broker %d: %s\n", f.leader, err.Error())
+		return true
+	}
+
+	failed := false
+	for _, data := range results {
+		partition := f.partition(data.TopicID, data.Partition)
+		if partition == nil {
+			continue
+		}
+		if data.ErrorCode != utils.NONE {
+			// Errors such as FENCED_LEADER_EPOCH clear up once this broker
+			// has the latest metadata
+			failed = true
+			continue
+		}
+		if err := partition.AppendFromLeader(f.leader, data); err != nil {
+			log.Printf("Failed to append records fetched for %s-%d: %s\n", partition.Topic, partition.Index, err.Error())
+			failed = true
+		}
+	}
+	return failed
+}
+
+func (f *ReplicaFetcher) run() {
+	for {
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+		if f.fetch() {
+			select {
+			case <-f.stop:
+				return
+			case <-time.After(f.manager.config.FetchBackoff):
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package replica
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+const FETCH_POLL_INTERVAL = 10 * time.Millisecond
+
+type Config struct {
+	BrokerID int32
+	// ReplicaLagTimeMax is replica.lag.time.max.ms: a follower that has
+	// not caught up for this long leaves the ISR
+	ReplicaLagTimeMax time.Duration
+	FetchMaxWait      time.Duration
+	FetchMinBytes     int32
+	FetchMaxBytes     int32
+	FetchBackoff      time.Duration
+	// MinInsyncReplicas is the broker's min.insync.replicas, which topics
+	// may override
+	MinInsyncReplicas int
+}
+
+func DefaultConfig(brokerId int32) Config {
+	return Config{
+		BrokerID:          brokerId,
+		ReplicaLagTimeMax: 30000 * time.Millisecond,
+		FetchMaxWait:      500 * time.Millisecond,
+		FetchMinBytes:     1,
+		FetchMaxBytes:     10 * 1024 * 1024,
+		FetchBackoff:      1000 * time.Millisecond,
+		MinInsyncReplicas: 1,
+	}
+}
+
+// ReplicaManager follows the metadata image to lead or follow each partition
+// assigned to this broker.
+type ReplicaManager struct {
+	config Config
+	client FetchClient
+	// OpenLog opens the log of a hosted partition
+	OpenLog func(topic string, partition int32) (*storage.Log, error)
+	// AlterPartition sends ISR changes to the active controller
+	AlterPartition func(req controller.AlterPartitionRequest) (controller.AlterPartitionResponse, error)
+	// BrokerEpoch is sent with fetches, so the leader can tell a restarted
+	// follower apart
+	BrokerEpoch func() int64
+
+	mu         sync.Mutex
+	partitions map[metadata.TopicIdPartition]*Partition
+	fetchers   map[int32]*ReplicaFetcher
+}
+
+var manager *ReplicaManager
+
+// Manager returns the replica manager of this broker, or nil when partitions
+// are not replicated.
+func Manager() *ReplicaManager {
+	return manager
+}
+
+// Start makes m the replica manager of this broker and has it follow the
+// metadata image.
+func Start(m *ReplicaManager) {
+	manager = m
+	manager.ApplyImage(metadata.Image())
+	metadata.Subscribe(func(previous *metadata.MetadataImage, image *metadata.MetadataImage, changes metadata.MetadataChanges) {
+		if len(changes.Topics) > 0 || len(changes.Partitions) > 0 {
+			manager.ApplyImage(image)
+		}
+	})
+	go manager.Run(nil)
+}
+
+func NewReplicaManager(config Config, client FetchClient) *ReplicaManager {
+	return &ReplicaManager{
+		config:     config,
+		client:     client,
+		OpenLog:    storage.GetLog,
+		partitions: map[metadata.TopicIdPartition]*Partition{},
+		fetchers:   map[int32]*ReplicaFetcher{},
+	}
+}
+
+func (m *ReplicaManager) Partition(topicId uuid.UUID, index int32) *Partition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.partitions[metadata.TopicIdPartition{TopicId: topicId, Partition: index}]
+}
+
+// MinInsyncReplicas is the topic's min.insync.replicas, or the broker's.
+func (m *ReplicaManager) MinInsyncReplicas(image *metadata.MetadataImage, topic string) int {
+	configs := image.Configs[metadata.ConfigResource{Type: metadata.CONFIG_RESOURCE_TOPIC, Name: topic}]
+	if value, ok := configs["min.insync.replicas"]; ok {
+		if minIsr, err := strconv.Atoi(value); err == nil {
+			return minIsr
+		}
+	}
+	return m.config.MinInsyncReplicas
+}
+
+func (m *ReplicaManager) stopFetching(key metadata.TopicIdPartition) {
+	for leader, fetcher := range m.fetchers {
+		if !fetcher.remove(key) {
+			close(fetcher.stop)
+			delete(m.fetchers, leader)
+		}
+	}
+}
+
+func (m *ReplicaManager) startFetching(partition *Partition, leader int32) {
+	key := metadata.TopicIdPartition{TopicId: partition.TopicID, Partition: partition.Index}
+	for fetcherLeader, fetcher := range m.fetchers {
+		if fetcherLeader != leader && !fetcher.remove(key) {
+			close(fetcher.stop)
+			delete(m.fetchers, fetcherLeader)
+		}
+	}
+	fetcher, ok := m.fetchers[leader]
+	if !ok {
+		fetcher = newReplicaFetcher(leader, m)
+		m.fetchers[leader] = fetcher
+		go fetcher.run()
+	}
+	fetcher.add(partition)
+}
+
+// ApplyImage makes this broker the leader or a follower of every partition
+// assigned to it, and stops replicating the ones that no longer are.
+func (m *ReplicaManager) ApplyImage(image *metadata.MetadataImage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	assigned := map[metadata.TopicIdPartition]bool{}
+	for _, name := range image.TopicNames() {
+		topic, _ := image.TopicByName(name)
+		for i := range topic.Partitions {
+			state := &topic.Partitions[i]
+			if !slices.Contains(state.ReplicaNodeIDs, m.config.BrokerID) {
+				continue
+			}
+			key := metadata.TopicIdPartition{TopicId: topic.TopicId, Partition: state.PartitionIndex}
+			partition, ok := m.partitions[key]
+			if !ok {
+				partitionLog, err := m.OpenLog(name, state.PartitionIndex)
+				if err != nil {
+					log.Printf("Failed to open log for %s-%d: %s\n", name, state.PartitionIndex, err.Error())
+					continue
+				}
+				partition = newPartition(name, topic.TopicId, state.PartitionIndex, partitionLog, m.config.BrokerID)
+				partition.alterPartition = m.AlterPartition
+				m.partitions[key] = partition
+			}
+			assigned[key] = true
+
+			partition.update(state, now)
+			if state.LeaderID == m.config.BrokerID || state.LeaderID == metadata.NO_LEADER {
+				m.stopFetching(key)
+			} else {
+				m.startFetching(partition, state.LeaderID)
+			}
+		}
+	}
+	for key := range m.partitions {
+		if !assigned[key] {
+			m.stopFetching(key)
+			delete(m.partitions, key)
+		}
+	}
+}
+
+// HandleFetch serves a follower's fetch, waiting up to its max wait for
+// min bytes of records unless a partition fails or diverged.
+func (m *ReplicaManager) HandleFetch(req FetchRequest) []FetchPartitionData {
+	deadline := time.Now().Add(req.MaxWait)
+	for {
+		results, size, failed := m.readAsLeader(req)
+		if failed || size >= int(req.MinBytes) || !time.Now().Before(deadline) {
+			return results
+		}
+		time.Sleep(FETCH_POLL_INTERVAL)
+	}
+}
+
+func (m *ReplicaManager) readAsLeader(req FetchRequest) ([]FetchPartitionData, int, bool) {
+	results := []FetchPartitionData{}
+	remaining := int(req.MaxBytes)
+	failed := false
+	now := time.Now()
+	for _, fetchPartition := range req.Partitions {
+		partition := m.Partition(fetchPartition.TopicID, fetchPartition.Partition)
+		if partition == nil {
+			results = append(results, FetchPartitionData{TopicID: fetchPartition.TopicID, Partition: fetchPartition.Partition, ErrorCode: utils.NOT_LEADER_OR_FOLLOWER, HighWatermark: -1, LogStartOffset: -1})
+			failed = true
+			continue
+		}
+		data := partition.ReadAsLeader(req.ReplicaID, fetchPartition, min(remaining, int(fetchPartition.MaxBytes)), now)
+		remaining -= len(data.Records)
+		failed = failed || data.ErrorCode != utils.NONE || data.DivergingEpoch != nil
+		results = append(results, data)
+	}
+	return results, int(req.MaxBytes) - remaining, failed
+}
+
+// Run checks for followers that fell out of the ISR until stop is closed.
+func (m *ReplicaManager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.config.ReplicaLagTimeMax / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.MaybeShrinkIsr(now)
+		}
+	}
+}
+
+func (m *ReplicaManager) MaybeShrinkIsr(now time.Time) {
+	m.mu.Lock()
+	partitions := make([]*Partition, 0, len(m.partitions))
+	for _, partition := range m.partitions {
+		partitions = append(partitions, partition)
+	}
+	m.mu.Unlock()
+
+	for _, partition := range partitions {
+		partition.MaybeShrinkIsr(now, m.config.ReplicaLagTimeMax)
+	}
+}
+
+// Close stops every fetcher.
+func (m *ReplicaManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for leader, fetcher := range m.fetchers {
+		close(fetcher.stop)
+		delete(m.fetchers, leader)
+	}
+}
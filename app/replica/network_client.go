@@ -0,0 +1,161 @@
+package replica
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strconv"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// NetworkFetchClient fetches from leaders over the Kafka protocol, at their
+// endpoint for ListenerName, or the first they registered without one.
+type NetworkFetchClient struct {
+	Transport    *raft.NetworkTransport
+	ListenerName string
+}
+
+func NewNetworkFetchClient(brokerId int32) *NetworkFetchClient {
+	return &NetworkFetchClient{Transport: raft.NewNetworkTransport(brokerId, map[int32]string{})}
+}
+
+func (n *NetworkFetchClient) Fetch(leader int32, req FetchRequest) ([]FetchPartitionData, error) {
+	broker, ok := metadata.Image().Brokers[leader]
+	if !ok {
+		return nil, raft.ErrUnreachable
+	}
+	index := slices.IndexFunc(broker.EndPoints, func(endpoint metadata.BrokerEndpoint) bool {
+		return endpoint.Name == n.ListenerName || n.ListenerName == ""
+	})
+	if index < 0 {
+		return nil, raft.ErrUnreachable
+	}
+	endpoint := broker.EndPoints[index]
+	n.Transport.SetAddress(leader, net.JoinHostPort(endpoint.Host, strconv.Itoa(int(endpoint.Port))))
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int32(req.MaxWait.Milliseconds()))
+	binary.Write(&body, binary.BigEndian, req.MinBytes)
+	binary.Write(&body, binary.BigEndian, req.MaxBytes)
+	// isolation_level, followers read uncommitted records
+	binary.Write(&body, binary.BigEndian, int8(0))
+	// No fetch session
+	binary.Write(&body, binary.BigEndian, int32(0))
+	binary.Write(&body, binary.BigEndian, int32(-1))
+	utils.WriteCompactArrayLength(&body, len(req.Partitions))
+	for _, partition := range req.Partitions {
+		body.Write(partition.TopicID.Bytes())
+		utils.WriteCompactArrayLength(&body, 1)
+		binary.Write(&body, binary.BigEndian, partition.Partition)
+		binary.Write(&body, binary.BigEndian, partition.CurrentLeaderEpoch)
+		binary.Write(&body, binary.BigEndian, partition.FetchOffset)
+		binary.Write(&body, binary.BigEndian, partition.LastFetchedEpoch)
+		binary.Write(&body, binary.BigEndian, partition.LogStartOffset)
+		binary.Write(&body, binary.BigEndian, partition.MaxBytes)
+		utils.WriteTaggedFields(&body)
+		utils.WriteTaggedFields(&body)
+	}
+	// forgotten_topics_data
+	utils.WriteCompactArrayLength(&body, 0)
+	// rack_id
+	utils.WriteCompactString(&body, "")
+	var replicaState bytes.Buffer
+	binary.Write(&replicaState, binary.BigEndian, req.ReplicaID)
+	binary.Write(&replicaState, binary.BigEndian, req.ReplicaEpoch)
+	utils.WriteTaggedFields(&replicaState)
+	utils.WriteTaggedFieldValues(&body, map[uint64][]byte{1: replicaState.Bytes()})
+
+	data, err := n.Transport.Send(leader, utils.FETCH_KEY, raft.FETCH_REQUEST_VERSION, body.Bytes(), req.MaxWait+n.Transport.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var throttleTimeMs, sessionId int32
+	var errorCode int16
+	fields := []any{&throttleTimeMs, &errorCode, &sessionId}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
+	results := []FetchPartitionData{}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return nil, err
+	}
+	for range topicLen {
+		result := FetchPartitionData{}
+		if err := utils.ReadUUID(&result.TopicID, data); err != nil {
+			return nil, err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return nil, err
+		}
+		for range partitionLen {
+			var lastStableOffset int64
+			fields := []any{&result.Partition, &result.ErrorCode, &result.HighWatermark, &lastStableOffset, &result.LogStartOffset}
+			for _, field := range fields {
+				if err := binary.Read(data, binary.BigEndian, field); err != nil {
+					return nil, err
+				}
+			}
+			var abortedLen int
+			if err := utils.ReadCompactArrayLength(&abortedLen, data); err != nil {
+				return nil, err
+			}
+			for range abortedLen {
+				data.Next(16)
+				if err := utils.SkipTaggedFields(data); err != nil {
+					return nil, err
+				}
+			}
+			var preferredReadReplica int32
+			if err := binary.Read(data, binary.BigEndian, &preferredReadReplica); err != nil {
+				return nil, err
+			}
+			if err := utils.ReadCompactBytes(&result.Records, data); err != nil {
+				return nil, err
+			}
+			// diverging_epoch is tagged field 0
+			taggedFields, err := utils.ReadTaggedFields(data)
+			if err != nil {
+				return nil, err
+			}
+			result.DivergingEpoch = nil
+			if value, ok := taggedFields[0]; ok {
+				field := bytes.NewBuffer(value)
+				result.DivergingEpoch = &EpochEndOffset{}
+				if err := binary.Read(field, binary.BigEndian, &result.DivergingEpoch.Epoch); err != nil {
+					return nil, err
+				}
+				if err := binary.Read(field, binary.BigEndian, &result.DivergingEpoch.EndOffset); err != nil {
+					return nil, err
+				}
+			}
+			results = append(results, result)
+		}
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// LocalFetchClient hands fetches straight to the replica managers of other
+// brokers in the same process.
+type LocalFetchClient struct {
+	Managers map[int32]*ReplicaManager
+}
+
+func (l *LocalFetchClient) Fetch(leader int32, req FetchRequest) ([]FetchPartitionData, error) {
+	m, ok := l.Managers[leader]
+	if !ok {
+		return nil, raft.ErrUnreachable
+	}
+	return m.HandleFetch(req), nil
+}
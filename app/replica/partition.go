@@ -0,0 +1,509 @@
+package replica
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+var (
+	ErrNotLeader                    = errors.New("this broker is not the leader of the partition")
+	ErrNotEnoughReplicas            = errors.New("the ISR is smaller than min.insync.replicas")
+	ErrNotEnoughReplicasAfterAppend = errors.New("the ISR shrank below min.insync.replicas after the append")
+	ErrRequestTimedOut              = errors.New("timed out waiting for the ISR to replicate the records")
+)
+
+// ReplicaState is what a leader knows about a follower from its fetches.
+type ReplicaState struct {
+	LogEndOffset  int64
+	LastFetchTime time.Time
+	// LastCaughtUpTime is the last time the follower had every record the
+	// leader had
+	LastCaughtUpTime time.Time
+	// leaderLogEndOffset is the leader's log end offset at the last fetch
+	leaderLogEndOffset int64
+}
+
+// Partition is the replication state of a partition hosted by this broker,
+// as its leader or as one of its followers.
+type Partition struct {
+	Topic   string
+	TopicID uuid.UUID
+	Index   int32
+	Log     *storage.Log
+
+	mu             sync.Mutex
+	brokerID       int32
+	leaderID       int32
+	leaderEpoch    int32
+	partitionEpoch int32
+	replicas       []int32
+	isr            []int32
+	// pendingIsr is the ISR proposed to the controller that it has not
+	// answered yet, nil when there is none
+	pendingIsr             []int32
+	followers              map[int32]*ReplicaState
+	leaderEpochStartOffset int64
+	// changed is closed and replaced when the high watermark or the ISR
+	// changes
+	changed        chan struct{}
+	alterPartition func(req controller.AlterPartitionRequest) (controller.AlterPartitionResponse, error)
+}
+
+func newPartition(topic string, topicId uuid.UUID, index int32, partitionLog *storage.Log, brokerId int32) *Partition {
+	return &Partition{
+		Topic:          topic,
+		TopicID:        topicId,
+		Index:          index,
+		Log:            partitionLog,
+		brokerID:       brokerId,
+		leaderID:       metadata.NO_LEADER,
+		leaderEpoch:    -1,
+		partitionEpoch: -1,
+		changed:        make(chan struct{}),
+	}
+}
+
+func (p *Partition) signal() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *Partition) isLeader() bool {
+	return p.leaderID == p.brokerID
+}
+
+func (p *Partition) IsLeader() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.isLeader()
+}
+
+func (p *Partition) LeaderAndEpoch() (int32, int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.leaderID, p.leaderEpoch
+}
+
+func (p *Partition) Isr() []int32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.isr)
+}
+
+// update applies the partition state from the metadata image. States older
+// than the one already applied, as an AlterPartition response may be, are
+// ignored. It reports whether the leader epoch changed.
+func (p *Partition) update(state *metadata.ClusterTopicPartition, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state.LeaderEpoch < p.leaderEpoch || (state.LeaderEpoch == p.leaderEpoch && state.PartitionEpoch <= p.partitionEpoch) {
+		return false
+	}
+	newEpoch := state.LeaderEpoch != p.leaderEpoch
+	p.leaderID = state.LeaderID
+	p.leaderEpoch = state.LeaderEpoch
+	p.partitionEpoch = state.PartitionEpoch
+	p.replicas = state.ReplicaNodeIDs
+	p.isr = state.InsyncReplicaNodeIDs
+	p.pendingIsr = nil
+
+	if p.isLeader() {
+		if newEpoch {
+			p.leaderEpochStartOffset = p.Log.LogEndOffset()
+			p.followers = map[int32]*ReplicaState{}
+			// Followers that fetch with an older epoch learn where this one
+			// starts even before anything is written in it
+			if err := p.Log.AssignEpochStartOffset(p.leaderEpoch, p.leaderEpochStartOffset); err != nil {
+				log.Printf("Failed to checkpoint leader epoch of %s-%d: %s\n", p.Topic, p.Index, err.Error())
+			}
+		}
+		for _, replicaId := range p.replicas {
+			if _, ok := p.followers[replicaId]; !ok && replicaId != p.brokerID {
+				// Replicas in the ISR get a full lag time to fetch from the
+				// new leader before they are removed
+				replica := &ReplicaState{LogEndOffset: -1, leaderLogEndOffset: -1}
+				if slices.Contains(p.isr, replicaId) {
+					replica.LastCaughtUpTime = now
+				}
+				p.followers[replicaId] = replica
+			}
+		}
+		for replicaId := range p.followers {
+			if !slices.Contains(p.replicas, replicaId) {
+				delete(p.followers, replicaId)
+			}
+		}
+		p.Log.SetReplicated(len(p.replicas) > 1)
+		p.maybeIncrementHighWatermark()
+	} else {
+		// Records the new leader does not have are truncated when its fetch
+		// responses report a diverging epoch
+		p.followers = nil
+		p.Log.SetReplicated(true)
+	}
+	p.signal()
+	return newEpoch
+}
+
+// maximalIsr includes the replicas a pending AlterPartition would add, so
+// that the high watermark waits for them as soon as they are proposed.
+func (p *Partition) maximalIsr() []int32 {
+	isr := slices.Clone(p.isr)
+	for _, replicaId := range p.pendingIsr {
+		if !slices.Contains(isr, replicaId) {
+			isr = append(isr, replicaId)
+		}
+	}
+	return isr
+}
+
+// maybeIncrementHighWatermark moves the high watermark up to the smallest log
+// end offset in the ISR.
+func (p *Partition) maybeIncrementHighWatermark() {
+	if !p.isLeader() {
+		return
+	}
+	highWatermark := p.Log.LogEndOffset()
+	for _, replicaId := range p.maximalIsr() {
+		if replicaId == p.brokerID {
+			continue
+		}
+		replica, ok := p.followers[replicaId]
+		if !ok {
+			return
+		}
+		highWatermark = min(highWatermark, replica.LogEndOffset)
+	}
+	if highWatermark > p.Log.HighWatermark() {
+		p.Log.SetHighWatermark(highWatermark)
+		p.signal()
+	}
+}
+
+// CheckLeaderEpoch compares the leader epoch a client knows, -1 when it
+// does not care, with the partition's.
+func CheckLeaderEpoch(currentLeaderEpoch int32, leaderEpoch int32) int16 {
+	switch {
+	case currentLeaderEpoch < 0:
+		return utils.NONE
+	case currentLeaderEpoch < leaderEpoch:
+		return utils.FENCED_LEADER_EPOCH
+	case currentLeaderEpoch > leaderEpoch:
+		return utils.UNKNOWN_LEADER_EPOCH
+	}
+	return utils.NONE
+}
+
+// AppendAsLeader writes a producer's records. With acks=all the ISR must
+// have at least minIsr replicas, see WaitForAcks.
+func (p *Partition) AppendAsLeader(raw []byte, acks int16, minIsr int) (storage.LogAppendInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.isLeader() {
+		return storage.LogAppendInfo{FirstOffset: -1, LastOffset: -1, LogStartOffset: -1}, ErrNotLeader
+	}
+	if acks == -1 && len(p.isr) < minIsr {
+		return storage.LogAppendInfo{FirstOffset: -1, LastOffset: -1, LogStartOffset: -1}, ErrNotEnoughReplicas
+	}
+	info, err := p.Log.AppendAsLeader(raw, p.leaderEpoch)
+	if err == nil {
+		p.maybeIncrementHighWatermark()
+		p.signal()
+	}
+	return info, err
+}
+
+// AppendControlAsLeader writes the marker that ends a producer's
+// transaction.
+func (p *Partition) AppendControlAsLeader(producerId int64, producerEpoch int16, coordinatorEpoch int32, commit bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.isLeader() {
+		return ErrNotLeader
+	}
+	err := p.Log.AppendControl(producerId, producerEpoch, coordinatorEpoch, commit, p.leaderEpoch)
+	if err == nil {
+		p.maybeIncrementHighWatermark()
+		p.signal()
+	}
+	return err
+}
+
+// WaitForAcks waits until the ISR has every record before offset.
+func (p *Partition) WaitForAcks(offset int64, minIsr int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		p.mu.Lock()
+		leader, isrSize, changed := p.isLeader(), len(p.isr), p.changed
+		highWatermark := p.Log.HighWatermark()
+		p.mu.Unlock()
+
+		if !leader {
+			return ErrNotLeader
+		}
+		if highWatermark >= offset {
+			if isrSize < minIsr {
+				return ErrNotEnoughReplicasAfterAppend
+			}
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return ErrRequestTimedOut
+		}
+	}
+}
+
+// ReadAsLeader serves a follower's fetch up to the log end offset, and
+// records how far the follower has got.
+func (p *Partition) ReadAsLeader(replicaId int32, partition FetchPartition, maxBytes int, now time.Time) FetchPartitionData {
+	data := FetchPartitionData{TopicID: p.TopicID, Partition: p.Index, HighWatermark: -1, LogStartOffset: -1}
+
+	p.mu.Lock()
+	if !p.isLeader() {
+		p.mu.Unlock()
+		data.ErrorCode = utils.NOT_LEADER_OR_FOLLOWER
+		return data
+	}
+	if data.ErrorCode = CheckLeaderEpoch(partition.CurrentLeaderEpoch, p.leaderEpoch); data.ErrorCode != utils.NONE {
+		p.mu.Unlock()
+		return data
+	}
+	logEndOffset := p.Log.LogEndOffset()
+	if diverging := divergingEpoch(p.Log, partition); diverging != nil {
+		data.DivergingEpoch = diverging
+		data.HighWatermark = p.Log.HighWatermark()
+		data.LogStartOffset = p.Log.LogStartOffset()
+		p.mu.Unlock()
+		return data
+	}
+	if partition.FetchOffset > logEndOffset || partition.FetchOffset < p.Log.LogStartOffset() {
+		p.mu.Unlock()
+		data.ErrorCode = utils.OFFSET_OUT_OF_RANGE
+		return data
+	}
+	p.updateFollowerFetchState(replicaId, partition.FetchOffset, logEndOffset, now)
+	data.HighWatermark = p.Log.HighWatermark()
+	data.LogStartOffset = p.Log.LogStartOffset()
+	p.mu.Unlock()
+
+	if maxBytes <= 0 || partition.FetchOffset == logEndOffset {
+		return data
+	}
+	records, err := p.Log.Read(partition.FetchOffset, logEndOffset, maxBytes)
+	if err != nil {
+		data.ErrorCode = utils.OFFSET_OUT_OF_RANGE
+		return data
+	}
+	data.Records = records
+	return data
+}
+
+func (p *Partition) updateFollowerFetchState(replicaId int32, fetchOffset int64, logEndOffset int64, now time.Time) {
+	replica, ok := p.followers[replicaId]
+	if !ok {
+		return
+	}
+	// A follower that fetched everything the leader had at its previous
+	// fetch was caught up at that time
+	if fetchOffset >= logEndOffset {
+		replica.LastCaughtUpTime = now
+	} else if fetchOffset >= replica.leaderLogEndOffset && replica.LastFetchTime.After(replica.LastCaughtUpTime) {
+		replica.LastCaughtUpTime = replica.LastFetchTime
+	}
+	replica.LogEndOffset = fetchOffset
+	replica.leaderLogEndOffset = logEndOffset
+	replica.LastFetchTime = now
+
+	p.maybeExpandIsr(replicaId)
+	p.maybeIncrementHighWatermark()
+}
+
+// maybeExpandIsr adds a follower back to the ISR once it has caught up to
+// the high watermark within the current leader epoch.
+func (p *Partition) maybeExpandIsr(replicaId int32) {
+	if p.pendingIsr != nil || slices.Contains(p.isr, replicaId) {
+		return
+	}
+	replica := p.followers[replicaId]
+	if replica.LogEndOffset < p.Log.HighWatermark() || replica.LogEndOffset < p.leaderEpochStartOffset {
+		return
+	}
+	log.Printf("Adding broker %d to the ISR of %s-%d\n", replicaId, p.Topic, p.Index)
+	p.proposeIsr(append(slices.Clone(p.isr), replicaId))
+}
+
+// MaybeShrinkIsr removes the followers that have not caught up within
+// maxLag.
+func (p *Partition) MaybeShrinkIsr(now time.Time, maxLag time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.isLeader() || p.pendingIsr != nil {
+		return
+	}
+	newIsr := []int32{}
+	for _, replicaId := range p.isr {
+		if replica, ok := p.followers[replicaId]; ok && now.Sub(replica.LastCaughtUpTime) > maxLag {
+			log.Printf("Removing broker %d from the ISR of %s-%d, it last caught up %s ago\n", replicaId, p.Topic, p.Index, now.Sub(replica.LastCaughtUpTime))
+			continue
+		}
+		newIsr = append(newIsr, replicaId)
+	}
+	if len(newIsr) < len(p.isr) {
+		p.proposeIsr(newIsr)
+	}
+}
+
+// proposeIsr sends an ISR change to the controller. Only one change is in
+// flight at a time.
+func (p *Partition) proposeIsr(newIsr []int32) {
+	if p.alterPartition == nil {
+		return
+	}
+	p.pendingIsr = newIsr
+	image := metadata.Image()
+	request := controller.AlterPartitionRequestPartition{
+		PartitionIndex: p.Index,
+		LeaderEpoch:    p.leaderEpoch,
+		PartitionEpoch: p.partitionEpoch,
+	}
+	for _, brokerId := range newIsr {
+		member := controller.IsrMember{BrokerID: brokerId, BrokerEpoch: -1}
+		if broker, ok := image.Brokers[brokerId]; ok {
+			member.BrokerEpoch = broker.BrokerEpoch
+		}
+		request.NewIsr = append(request.NewIsr, member)
+	}
+	go p.sendAlterPartition(request)
+}
+
+func (p *Partition) sendAlterPartition(request controller.AlterPartitionRequestPartition) {
+	resp, err := p.alterPartition(controller.AlterPartitionRequest{
+		Topics: []controller.AlterPartitionRequestTopic{{TopicID: p.TopicID, Partitions: []controller.AlterPartitionRequestPartition{request}}},
+	})
+	if err == nil && resp.ErrorCode == utils.NONE && (len(resp.Topics) != 1 || len(resp.Topics[0].Partitions) != 1) {
+		err = errors.New("unexpected AlterPartition response")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The metadata moved on while the request was in flight
+	if p.leaderEpoch != request.LeaderEpoch || p.partitionEpoch != request.PartitionEpoch {
+		return
+	}
+	p.pendingIsr = nil
+	if err != nil {
+		log.Printf("Failed to alter the ISR of %s-%d: %s\n", p.Topic, p.Index, err.Error())
+		return
+	}
+	if resp.ErrorCode != utils.NONE {
+		log.Printf("Failed to alter the ISR of %s-%d: error code %d\n", p.Topic, p.Index, resp.ErrorCode)
+		return
+	}
+	result := resp.Topics[0].Partitions[0]
+	if result.ErrorCode != utils.NONE {
+		log.Printf("Failed to alter the ISR of %s-%d: error code %d\n", p.Topic, p.Index, result.ErrorCode)
+		return
+	}
+	if result.LeaderEpoch == p.leaderEpoch && result.PartitionEpoch > p.partitionEpoch {
+		p.isr = result.Isr
+		p.partitionEpoch = result.PartitionEpoch
+	}
+	p.maybeIncrementHighWatermark()
+	p.signal()
+}
+
+// divergingEpoch checks that a fetch continues the log: the fetcher's last
+// epoch must be in the log and end no earlier than its fetch offset.
+// Otherwise it returns where that epoch ends, to truncate to.
+func divergingEpoch(partitionLog *storage.Log, partition FetchPartition) *EpochEndOffset {
+	if partition.LastFetchedEpoch < 0 {
+		return nil
+	}
+	epoch, endOffset := partitionLog.EndOffsetForEpoch(partition.LastFetchedEpoch)
+	if epoch == partition.LastFetchedEpoch && endOffset >= partition.FetchOffset {
+		return nil
+	}
+	return &EpochEndOffset{Epoch: epoch, EndOffset: endOffset}
+}
+
+// LastOffsetForLeaderEpoch answers OffsetForLeaderEpoch: the largest epoch
+// not above leaderEpoch and the offset where it ends.
+func (p *Partition) LastOffsetForLeaderEpoch(currentLeaderEpoch int32, leaderEpoch int32) (int16, EpochEndOffset) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := EpochEndOffset{Epoch: -1, EndOffset: -1}
+	if !p.isLeader() {
+		return utils.NOT_LEADER_OR_FOLLOWER, result
+	}
+	if errorCode := CheckLeaderEpoch(currentLeaderEpoch, p.leaderEpoch); errorCode != utils.NONE {
+		return errorCode, result
+	}
+	result.Epoch, result.EndOffset = p.Log.EndOffsetForEpoch(leaderEpoch)
+	return utils.NONE, result
+}
+
+// truncateToDivergence truncates a follower's log to where it last agrees
+// with the leader. When the follower does not have the leader's epoch it
+// truncates to the end of its own epoch before it, and the next fetch checks
+// again from there.
+func (p *Partition) truncateToDivergence(diverging *EpochEndOffset) error {
+	truncateOffset := diverging.EndOffset
+	if ownEpoch, ownEndOffset := p.Log.EndOffsetForEpoch(diverging.Epoch); ownEpoch == diverging.Epoch {
+		truncateOffset = min(truncateOffset, ownEndOffset)
+	} else {
+		truncateOffset = ownEndOffset
+	}
+	truncateOffset = max(truncateOffset, p.Log.LogStartOffset())
+	log.Printf("Truncating %s-%d to %d after diverging from the leader at epoch %d\n", p.Topic, p.Index, truncateOffset, diverging.Epoch)
+	return p.Log.TruncateTo(truncateOffset)
+}
+
+// AppendFromLeader writes what a follower fetched from leader and takes on
+// its high watermark.
+func (p *Partition) AppendFromLeader(leader int32, data FetchPartitionData) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.leaderID != leader || p.isLeader() {
+		return nil
+	}
+	if data.DivergingEpoch != nil {
+		return p.truncateToDivergence(data.DivergingEpoch)
+	}
+	if len(data.Records) > 0 {
+		if err := p.Log.AppendAsFollower(data.Records); err != nil {
+			return err
+		}
+	}
+	p.Log.SetHighWatermark(data.HighWatermark)
+	return nil
+}
+
+// fetchPartition describes where a follower continues fetching.
+func (p *Partition) fetchPartition(maxBytes int32) (FetchPartition, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return FetchPartition{
+		TopicID:            p.TopicID,
+		Partition:          p.Index,
+		CurrentLeaderEpoch: p.leaderEpoch,
+		FetchOffset:        p.Log.LogEndOffset(),
+		LastFetchedEpoch:   p.Log.LastEpoch(),
+		LogStartOffset:     p.Log.LogStartOffset(),
+		MaxBytes:           maxBytes,
+	}, !p.isLeader()
+}
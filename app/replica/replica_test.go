@@ -0,0 +1,293 @@
+package replica
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+// newTestController runs a single voter quorum with a metadata follower, and
+// registers and unfences brokers 1 and 2.
+func newTestController(t *testing.T) (*raft.RaftNode, *controller.QuorumController) {
+	dir := t.TempDir()
+	network := raft.NewLocalNetwork()
+	node, err := raft.NewRaftNode(raft.Config{
+		NodeID:          1,
+		ClusterID:       "test-cluster",
+		Voters:          []int32{1},
+		Dir:             dir,
+		ElectionTimeout: 100 * time.Millisecond,
+		FetchTimeout:    300 * time.Millisecond,
+		FetchMaxWait:    50 * time.Millisecond,
+		Tick:            10 * time.Millisecond,
+	}, network.Transport(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.Add(node)
+
+	metadata.Publish(metadata.EmptyImage())
+	metadata.CommittedOffset = node.HighWatermark
+	pollInterval := metadata.MetadataPollInterval
+	metadata.MetadataPollInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	go node.Run(stop)
+	go metadata.NewMetadataFollower(dir).Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		time.Sleep(50 * time.Millisecond)
+		node.Close()
+		metadata.CommittedOffset = nil
+		metadata.MetadataPollInterval = pollInterval
+		metadata.Publish(metadata.EmptyImage())
+	})
+	waitFor(t, "the node to lead", func() bool { return node.Role() == raft.LEADER })
+
+	c := controller.NewQuorumController(controller.Config{
+		ClusterID:         "test-cluster",
+		SessionTimeout:    time.Minute,
+		HeartbeatInterval: time.Second,
+		CommitTimeout:     5 * time.Second,
+	}, node)
+	for _, brokerId := range []int32{1, 2} {
+		incarnationId, _ := uuid.NewV4()
+		resp := c.RegisterBroker(controller.BrokerRegistrationRequest{
+			BrokerID:            brokerId,
+			ClusterID:           "test-cluster",
+			IncarnationID:       incarnationId,
+			PreviousBrokerEpoch: -1,
+		})
+		if resp.ErrorCode != utils.NONE {
+			t.Fatalf("Failed to register broker %d: error code %d", brokerId, resp.ErrorCode)
+		}
+		heartbeat := c.BrokerHeartbeat(controller.BrokerHeartbeatRequest{BrokerID: brokerId, BrokerEpoch: resp.BrokerEpoch, CurrentMetadataOffset: resp.BrokerEpoch})
+		if heartbeat.ErrorCode != utils.NONE || heartbeat.IsFenced {
+			t.Fatalf("Failed to unfence broker %d: %+v", brokerId, heartbeat)
+		}
+	}
+	return node, c
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// createTopic writes a partition led by broker 1 in leaderEpoch and
+// replicated to broker 2.
+func createTopic(t *testing.T, node *raft.RaftNode, name string, leaderEpoch int32) uuid.UUID {
+	topicId, _ := uuid.NewV4()
+	offset, err := node.Append([]metadata.MetadataRecord{
+		&metadata.TopicRecord{Name: name, TopicID: topicId},
+		&metadata.PartitionRecord{TopicID: topicId, Replicas: []int32{1, 2}, Isr: []int32{1, 2}, Leader: 1, LeaderEpoch: leaderEpoch},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the topic to be applied", func() bool { return metadata.LastAppliedOffset() >= offset })
+	return topicId
+}
+
+// newTestManager hosts a broker's logs in dir and sends its ISR changes to c.
+// Calling the returned function stops the broker.
+func newTestManager(t *testing.T, brokerId int32, dir string, c *controller.QuorumController, client FetchClient) (*ReplicaManager, func()) {
+	m := NewReplicaManager(Config{
+		BrokerID:          brokerId,
+		ReplicaLagTimeMax: 300 * time.Millisecond,
+		FetchMaxWait:      50 * time.Millisecond,
+		FetchMinBytes:     1,
+		FetchMaxBytes:     1024 * 1024,
+		FetchBackoff:      20 * time.Millisecond,
+		MinInsyncReplicas: 2,
+	}, client)
+	m.OpenLog = func(topic string, partition int32) (*storage.Log, error) {
+		return storage.OpenLog(filepath.Join(dir, topic+"-"+strconv.Itoa(int(partition))))
+	}
+	m.AlterPartition = func(req controller.AlterPartitionRequest) (controller.AlterPartitionResponse, error) {
+		req.BrokerID = brokerId
+		req.BrokerEpoch = metadata.Image().Brokers[brokerId].BrokerEpoch
+		return c.AlterPartition(req), nil
+	}
+
+	// Apply every new image, as the subscription set up by Start would
+	stop := make(chan struct{})
+	go func() {
+		var applied *metadata.MetadataImage
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			if image := metadata.Image(); image != applied {
+				m.ApplyImage(image)
+				applied = image
+			}
+		}
+	}()
+	var once sync.Once
+	shutDown := func() {
+		once.Do(func() {
+			close(stop)
+			m.Close()
+		})
+	}
+	t.Cleanup(shutDown)
+	return m, shutDown
+}
+
+func testBatch(t *testing.T, count int) []byte {
+	records := make([]record.Record, count)
+	for i := range records {
+		records[i].Value = []byte("value")
+	}
+	raw, err := record.NewRecordBatch(records).Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode batch: %v", err)
+	}
+	return raw
+}
+
+func imageIsr(topicId uuid.UUID) []int32 {
+	topic, ok := metadata.Image().TopicById(topicId)
+	if !ok {
+		return nil
+	}
+	return topic.Partition(0).InsyncReplicaNodeIDs
+}
+
+func TestReplicationAndIsrChanges(t *testing.T) {
+	node, c := newTestController(t)
+	topicId := createTopic(t, node, "replicated", 0)
+
+	leaderDir, followerDir := t.TempDir(), t.TempDir()
+	leaderClient := &LocalFetchClient{Managers: map[int32]*ReplicaManager{}}
+	leader, _ := newTestManager(t, 1, leaderDir, c, leaderClient)
+	follower, stopFollower := newTestManager(t, 2, followerDir, c, &LocalFetchClient{Managers: map[int32]*ReplicaManager{1: leader}})
+	waitFor(t, "both brokers to host the partition", func() bool {
+		return leader.Partition(topicId, 0) != nil && leader.Partition(topicId, 0).IsLeader() && follower.Partition(topicId, 0) != nil
+	})
+	leaderPartition, followerPartition := leader.Partition(topicId, 0), follower.Partition(topicId, 0)
+	if err := followerPartition.AppendControlAsLeader(1, 0, 0, true); err != ErrNotLeader {
+		t.Fatalf("Expected the follower to refuse transaction markers, got %v", err)
+	}
+
+	// acks=all completes once the follower has fetched the records
+	info, err := leaderPartition.AppendAsLeader(testBatch(t, 3), -1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaderPartition.WaitForAcks(info.LastOffset+1, 2, 5*time.Second); err != nil {
+		t.Fatalf("Expected the ISR to acknowledge the records, got %v", err)
+	}
+	if leaderPartition.Log.HighWatermark() != 3 || followerPartition.Log.LogEndOffset() != 3 {
+		t.Fatalf("Expected the high watermark and follower at 3, got %d and %d", leaderPartition.Log.HighWatermark(), followerPartition.Log.LogEndOffset())
+	}
+	waitFor(t, "the follower to learn the high watermark", func() bool { return followerPartition.Log.HighWatermark() == 3 })
+
+	// A follower that stops fetching leaves the ISR
+	stopFollower()
+	waitFor(t, "the ISR to shrink", func() bool {
+		leader.MaybeShrinkIsr(time.Now())
+		return slices.Equal(imageIsr(topicId), []int32{1}) && slices.Equal(leaderPartition.Isr(), []int32{1})
+	})
+	if _, err := leaderPartition.AppendAsLeader(testBatch(t, 1), -1, 2); err != ErrNotEnoughReplicas {
+		t.Fatalf("Expected not enough replicas, got %v", err)
+	}
+	if _, err := leaderPartition.AppendAsLeader(testBatch(t, 2), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if leaderPartition.Log.HighWatermark() != 5 {
+		t.Fatalf("Expected the high watermark to follow the leader alone to 5, got %d", leaderPartition.Log.HighWatermark())
+	}
+
+	// It rejoins once it has caught up again
+	restarted, _ := newTestManager(t, 2, followerDir, c, &LocalFetchClient{Managers: map[int32]*ReplicaManager{1: leader}})
+	waitFor(t, "the ISR to expand", func() bool {
+		return slices.Equal(imageIsr(topicId), []int32{1, 2}) && slices.Equal(leaderPartition.Isr(), []int32{1, 2})
+	})
+	waitFor(t, "the follower to catch up", func() bool {
+		p := restarted.Partition(topicId, 0)
+		return p != nil && p.Log.LogEndOffset() == 5 && p.Log.HighWatermark() == 5
+	})
+}
+
+func TestMinInsyncReplicasFromTopicConfig(t *testing.T) {
+	m := NewReplicaManager(DefaultConfig(1), nil)
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	delta.Replay(&metadata.ConfigRecord{ResourceType: metadata.CONFIG_RESOURCE_TOPIC, ResourceName: "strict", Name: "min.insync.replicas", Value: ptr("3")})
+	image := delta.Apply()
+	if minIsr := m.MinInsyncReplicas(image, "strict"); minIsr != 3 {
+		t.Errorf("Expected the topic's min.insync.replicas of 3, got %d", minIsr)
+	}
+	if minIsr := m.MinInsyncReplicas(image, "other"); minIsr != 1 {
+		t.Errorf("Expected the broker's min.insync.replicas of 1, got %d", minIsr)
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// writeLog writes a batch of count records in each of epochs to a new log
+// in dir.
+func writeLog(t *testing.T, dir string, count int, epochs ...int32) {
+	l, err := storage.OpenLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, epoch := range epochs {
+		if _, err := l.AppendAsLeader(testBatch(t, count), epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+}
+
+func TestFollowerTruncatesDivergingRecords(t *testing.T) {
+	node, c := newTestController(t)
+	leaderDir, followerDir := t.TempDir(), t.TempDir()
+	// The follower has records from epoch 1 that the leader of epoch 2
+	// never got
+	writeLog(t, filepath.Join(leaderDir, "diverged-0"), 2, 0, 2, 2)
+	writeLog(t, filepath.Join(followerDir, "diverged-0"), 2, 0, 1, 1, 1)
+	topicId := createTopic(t, node, "diverged", 2)
+
+	leader, _ := newTestManager(t, 1, leaderDir, c, &LocalFetchClient{Managers: map[int32]*ReplicaManager{}})
+	waitFor(t, "the leader to host the partition", func() bool { return leader.Partition(topicId, 0) != nil })
+	leaderPartition := leader.Partition(topicId, 0)
+	errorCode, result := leaderPartition.LastOffsetForLeaderEpoch(2, 1)
+	if errorCode != utils.NONE || result != (EpochEndOffset{Epoch: 0, EndOffset: 2}) {
+		t.Errorf("Expected epoch 1 to be answered with epoch 0 ending at 2, got %d %+v", errorCode, result)
+	}
+	if errorCode, _ := leaderPartition.LastOffsetForLeaderEpoch(1, 1); errorCode != utils.FENCED_LEADER_EPOCH {
+		t.Errorf("Expected a fenced leader epoch, got %d", errorCode)
+	}
+
+	follower, _ := newTestManager(t, 2, followerDir, c, &LocalFetchClient{Managers: map[int32]*ReplicaManager{1: leader}})
+	waitFor(t, "the follower to replace its diverging records", func() bool {
+		p := follower.Partition(topicId, 0)
+		return p != nil && p.Log.LogEndOffset() == 6 && slices.Equal(p.Log.EpochEntries(), leaderPartition.Log.EpochEntries())
+	})
+	expected := []storage.EpochEntry{{Epoch: 0, StartOffset: 0}, {Epoch: 2, StartOffset: 2}}
+	if entries := leaderPartition.Log.EpochEntries(); !slices.Equal(entries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, entries)
+	}
+}
@@ -0,0 +1,132 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Acl_Creation struct {
+	ResourceType        int8
+	ResourceName        string
+	ResourcePatternType int8
+	Principal           string
+	Host                string
+	Operation           int8
+	PermissionType      int8
+}
+
+// Acl_Filter selects ACLs. Null names, principals and hosts match any.
+type Acl_Filter struct {
+	ResourceTypeFilter int8
+	ResourceNameFilter *string
+	PatternTypeFilter  int8
+	PrincipalFilter    *string
+	HostFilter         *string
+	Operation          int8
+	PermissionType     int8
+}
+
+type Create_Acls_Request struct {
+	Creations []Acl_Creation
+}
+
+type Delete_Acls_Request struct {
+	Filters []Acl_Filter
+}
+
+func decodeAclFilter(filter *Acl_Filter, data *bytes.Buffer) error {
+	if err := binary.Read(data, binary.BigEndian, &filter.ResourceTypeFilter); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactNullableString(&filter.ResourceNameFilter, data); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &filter.PatternTypeFilter); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactNullableString(&filter.PrincipalFilter, data); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactNullableString(&filter.HostFilter, data); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &filter.Operation); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &filter.PermissionType); err != nil {
+		return err
+	}
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeDescribeAcls(data *bytes.Buffer) error {
+	describeAclsRequest := Acl_Filter{}
+
+	if err := decodeAclFilter(&describeAclsRequest, data); err != nil {
+		return err
+	}
+
+	r.DescribeAclsRequest = &describeAclsRequest
+	return nil
+}
+
+func (r *Request) DecodeCreateAcls(data *bytes.Buffer) error {
+	createAclsRequest := Create_Acls_Request{}
+
+	var creationLen int
+	if err := utils.ReadCompactArrayLength(&creationLen, data); err != nil {
+		return err
+	}
+	for range creationLen {
+		creation := Acl_Creation{}
+		if err := binary.Read(data, binary.BigEndian, &creation.ResourceType); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactString(&creation.ResourceName, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &creation.ResourcePatternType); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactString(&creation.Principal, data); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactString(&creation.Host, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &creation.Operation); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &creation.PermissionType); err != nil {
+			return err
+		}
+		createAclsRequest.Creations = append(createAclsRequest.Creations, creation)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.CreateAclsRequest = &createAclsRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeDeleteAcls(data *bytes.Buffer) error {
+	deleteAclsRequest := Delete_Acls_Request{}
+
+	var filterLen int
+	if err := utils.ReadCompactArrayLength(&filterLen, data); err != nil {
+		return err
+	}
+	for range filterLen {
+		filter := Acl_Filter{}
+		if err := decodeAclFilter(&filter, data); err != nil {
+			return err
+		}
+		deleteAclsRequest.Filters = append(deleteAclsRequest.Filters, filter)
+	}
+
+	r.DeleteAclsRequest = &deleteAclsRequest
+	return utils.SkipTaggedFields(data)
+}
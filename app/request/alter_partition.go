@@ -0,0 +1,110 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+type Alter_Partition_Request_Isr_Member struct {
+	BrokerID    int32
+	BrokerEpoch int64
+}
+
+type Alter_Partition_Request_Partition struct {
+	PartitionIndex      int32
+	LeaderEpoch         int32
+	NewIsr              []Alter_Partition_Request_Isr_Member
+	LeaderRecoveryState int8
+	PartitionEpoch      int32
+}
+
+type Alter_Partition_Request_Topic struct {
+	TopicID    uuid.UUID
+	Partitions []Alter_Partition_Request_Partition
+}
+
+type Alter_Partition_Request struct {
+	BrokerID    int32
+	BrokerEpoch int64
+	Topics      []Alter_Partition_Request_Topic
+}
+
+// DecodeAlterPartition reads versions 2 and 3, which name topics by id.
+// Version 3 sends the broker epoch of every ISR member.
+func (r *Request) DecodeAlterPartition(data *bytes.Buffer) error {
+	alterPartitionRequest := Alter_Partition_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &alterPartitionRequest.BrokerID); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &alterPartitionRequest.BrokerEpoch); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Alter_Partition_Request_Topic{}
+		if err := utils.ReadUUID(&topic.TopicID, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Alter_Partition_Request_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition.PartitionIndex); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.LeaderEpoch); err != nil {
+				return err
+			}
+			if r.ApiVersion >= 3 {
+				var memberLen int
+				if err := utils.ReadCompactArrayLength(&memberLen, data); err != nil {
+					return err
+				}
+				for range memberLen {
+					member := Alter_Partition_Request_Isr_Member{}
+					if err := binary.Read(data, binary.BigEndian, &member); err != nil {
+						return err
+					}
+					partition.NewIsr = append(partition.NewIsr, member)
+					if err := utils.SkipTaggedFields(data); err != nil {
+						return err
+					}
+				}
+			} else {
+				var brokerIds []int32
+				if err := utils.ReadINT32Array(&brokerIds, data); err != nil {
+					return err
+				}
+				for _, brokerId := range brokerIds {
+					partition.NewIsr = append(partition.NewIsr, Alter_Partition_Request_Isr_Member{BrokerID: brokerId, BrokerEpoch: -1})
+				}
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.LeaderRecoveryState); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.PartitionEpoch); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		alterPartitionRequest.Topics = append(alterPartitionRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.AlterPartitionRequest = &alterPartitionRequest
+	return utils.SkipTaggedFields(data)
+}
@@ -0,0 +1,155 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+type Broker_Registration_Request_Listener struct {
+	Name             string
+	Host             string
+	Port             uint16
+	SecurityProtocol int16
+}
+
+type Broker_Registration_Request_Feature struct {
+	Name                string
+	MinSupportedVersion int16
+	MaxSupportedVersion int16
+}
+
+type Broker_Registration_Request struct {
+	BrokerID            int32
+	ClusterID           string
+	IncarnationID       uuid.UUID
+	Listeners           []Broker_Registration_Request_Listener
+	Features            []Broker_Registration_Request_Feature
+	Rack                *string
+	IsMigratingZkBroker bool
+	LogDirs             []uuid.UUID
+	PreviousBrokerEpoch int64
+}
+
+type Broker_Heartbeat_Request struct {
+	BrokerID              int32
+	BrokerEpoch           int64
+	CurrentMetadataOffset int64
+	WantFence             bool
+	WantShutDown          bool
+	OfflineLogDirs        []uuid.UUID
+}
+
+func (r *Request) DecodeBrokerRegistration(data *bytes.Buffer) error {
+	registrationRequest := Broker_Registration_Request{PreviousBrokerEpoch: -1}
+
+	if err := binary.Read(data, binary.BigEndian, &registrationRequest.BrokerID); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactString(&registrationRequest.ClusterID, data); err != nil {
+		return err
+	}
+	if err := utils.ReadUUID(&registrationRequest.IncarnationID, data); err != nil {
+		return err
+	}
+
+	var listenerLen int
+	if err := utils.ReadCompactArrayLength(&listenerLen, data); err != nil {
+		return err
+	}
+	for range listenerLen {
+		listener := Broker_Registration_Request_Listener{}
+		if err := utils.ReadCompactString(&listener.Name, data); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactString(&listener.Host, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &listener.Port); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &listener.SecurityProtocol); err != nil {
+			return err
+		}
+		registrationRequest.Listeners = append(registrationRequest.Listeners, listener)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	var featureLen int
+	if err := utils.ReadCompactArrayLength(&featureLen, data); err != nil {
+		return err
+	}
+	for range featureLen {
+		feature := Broker_Registration_Request_Feature{}
+		if err := utils.ReadCompactString(&feature.Name, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &feature.MinSupportedVersion); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &feature.MaxSupportedVersion); err != nil {
+			return err
+		}
+		registrationRequest.Features = append(registrationRequest.Features, feature)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	if err := utils.ReadCompactNullableString(&registrationRequest.Rack, data); err != nil {
+		return err
+	}
+	if r.ApiVersion >= 1 {
+		if err := binary.Read(data, binary.BigEndian, &registrationRequest.IsMigratingZkBroker); err != nil {
+			return err
+		}
+	}
+	if r.ApiVersion >= 2 {
+		if err := utils.ReadUUIDArray(&registrationRequest.LogDirs, data); err != nil {
+			return err
+		}
+	}
+	if r.ApiVersion >= 3 {
+		if err := binary.Read(data, binary.BigEndian, &registrationRequest.PreviousBrokerEpoch); err != nil {
+			return err
+		}
+	}
+
+	r.BrokerRegistrationRequest = &registrationRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeBrokerHeartbeat(data *bytes.Buffer) error {
+	heartbeatRequest := Broker_Heartbeat_Request{}
+
+	fields := []any{
+		&heartbeatRequest.BrokerID,
+		&heartbeatRequest.BrokerEpoch,
+		&heartbeatRequest.CurrentMetadataOffset,
+		&heartbeatRequest.WantFence,
+		&heartbeatRequest.WantShutDown,
+	}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return err
+		}
+	}
+
+	taggedFields, err := utils.ReadTaggedFields(data)
+	if err != nil {
+		return err
+	}
+	// offline_log_dirs is tagged field 0 from version 1
+	if value, ok := taggedFields[0]; ok && r.ApiVersion >= 1 {
+		if err := utils.ReadUUIDArray(&heartbeatRequest.OfflineLogDirs, bytes.NewBuffer(value)); err != nil {
+			return err
+		}
+	}
+
+	r.BrokerHeartbeatRequest = &heartbeatRequest
+	return nil
+}
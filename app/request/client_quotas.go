@@ -0,0 +1,140 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const CLIENT_QUOTA_MATCH_EXACT int8 = 0
+const CLIENT_QUOTA_MATCH_DEFAULT int8 = 1
+const CLIENT_QUOTA_MATCH_ANY int8 = 2
+
+type Client_Quota_Filter_Component struct {
+	EntityType string
+	MatchType  int8
+	// Match is the entity name to match exactly
+	Match *string
+}
+
+// Describe_Client_Quotas_Request describes the entities that match every
+// component, and when Strict only those with no other entity types.
+type Describe_Client_Quotas_Request struct {
+	Components []Client_Quota_Filter_Component
+	Strict     bool
+}
+
+// Client_Quota_Entity names a user or client id, or its default when Name
+// is nil
+type Client_Quota_Entity struct {
+	EntityType string
+	EntityName *string
+}
+
+type Client_Quota_Op struct {
+	Key    string
+	Value  float64
+	Remove bool
+}
+
+type Client_Quota_Entry struct {
+	Entity []Client_Quota_Entity
+	Ops    []Client_Quota_Op
+}
+
+type Alter_Client_Quotas_Request struct {
+	Entries      []Client_Quota_Entry
+	ValidateOnly bool
+}
+
+func (r *Request) DecodeDescribeClientQuotas(data *bytes.Buffer) error {
+	describeClientQuotasRequest := Describe_Client_Quotas_Request{}
+
+	var componentLen int
+	if err := utils.ReadCompactArrayLength(&componentLen, data); err != nil {
+		return err
+	}
+	for range componentLen {
+		component := Client_Quota_Filter_Component{}
+		if err := utils.ReadCompactString(&component.EntityType, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &component.MatchType); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactNullableString(&component.Match, data); err != nil {
+			return err
+		}
+		describeClientQuotasRequest.Components = append(describeClientQuotasRequest.Components, component)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+	if err := binary.Read(data, binary.BigEndian, &describeClientQuotasRequest.Strict); err != nil {
+		return err
+	}
+
+	r.DescribeClientQuotasRequest = &describeClientQuotasRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeAlterClientQuotas(data *bytes.Buffer) error {
+	alterClientQuotasRequest := Alter_Client_Quotas_Request{}
+
+	var entryLen int
+	if err := utils.ReadCompactArrayLength(&entryLen, data); err != nil {
+		return err
+	}
+	for range entryLen {
+		entry := Client_Quota_Entry{}
+		var entityLen int
+		if err := utils.ReadCompactArrayLength(&entityLen, data); err != nil {
+			return err
+		}
+		for range entityLen {
+			entity := Client_Quota_Entity{}
+			if err := utils.ReadCompactString(&entity.EntityType, data); err != nil {
+				return err
+			}
+			if err := utils.ReadCompactNullableString(&entity.EntityName, data); err != nil {
+				return err
+			}
+			entry.Entity = append(entry.Entity, entity)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+
+		var opLen int
+		if err := utils.ReadCompactArrayLength(&opLen, data); err != nil {
+			return err
+		}
+		for range opLen {
+			op := Client_Quota_Op{}
+			if err := utils.ReadCompactString(&op.Key, data); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &op.Value); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &op.Remove); err != nil {
+				return err
+			}
+			entry.Ops = append(entry.Ops, op)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		alterClientQuotasRequest.Entries = append(alterClientQuotasRequest.Entries, entry)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+	if err := binary.Read(data, binary.BigEndian, &alterClientQuotasRequest.ValidateOnly); err != nil {
+		return err
+	}
+
+	r.AlterClientQuotasRequest = &alterClientQuotasRequest
+	return utils.SkipTaggedFields(data)
+}
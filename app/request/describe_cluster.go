@@ -0,0 +1,33 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const ENDPOINT_TYPE_BROKER int8 = 1
+const ENDPOINT_TYPE_CONTROLLER int8 = 2
+
+type Describe_Cluster_Request struct {
+	IncludeClusterAuthorizedOperations bool
+	// EndpointType is sent from version 1
+	EndpointType int8
+}
+
+func (r *Request) DecodeDescribeCluster(data *bytes.Buffer) error {
+	describeClusterRequest := Describe_Cluster_Request{EndpointType: ENDPOINT_TYPE_BROKER}
+
+	if err := binary.Read(data, binary.BigEndian, &describeClusterRequest.IncludeClusterAuthorizedOperations); err != nil {
+		return err
+	}
+	if r.ApiVersion >= 1 {
+		if err := binary.Read(data, binary.BigEndian, &describeClusterRequest.EndpointType); err != nil {
+			return err
+		}
+	}
+
+	r.DescribeClusterRequest = &describeClusterRequest
+	return utils.SkipTaggedFields(data)
+}
@@ -0,0 +1,64 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Describe_Producers_Request struct {
+	Topics []Txn_Topic
+}
+
+type Describe_Transactions_Request struct {
+	TransactionalIDs []string
+}
+
+type List_Transactions_Request struct {
+	StateFilters      []string
+	ProducerIDFilters []int64
+	DurationFilterMs  int64
+}
+
+func (r *Request) DecodeDescribeProducers(data *bytes.Buffer) error {
+	describeProducersRequest := Describe_Producers_Request{}
+
+	if err := readTxnTopics(&describeProducersRequest.Topics, data); err != nil {
+		return err
+	}
+
+	r.DescribeProducersRequest = &describeProducersRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeDescribeTransactions(data *bytes.Buffer) error {
+	describeTransactionsRequest := Describe_Transactions_Request{}
+
+	if err := utils.ReadCompactStringArray(&describeTransactionsRequest.TransactionalIDs, data); err != nil {
+		return err
+	}
+
+	r.DescribeTransactionsRequest = &describeTransactionsRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeListTransactions(data *bytes.Buffer) error {
+	listTransactionsRequest := List_Transactions_Request{DurationFilterMs: -1}
+
+	if err := utils.ReadCompactStringArray(&listTransactionsRequest.StateFilters, data); err != nil {
+		return err
+	}
+	if err := utils.ReadINT64Array(&listTransactionsRequest.ProducerIDFilters, data); err != nil {
+		return err
+	}
+	// duration_filter was added in version 1
+	if r.ApiVersion >= 1 {
+		if err := binary.Read(data, binary.BigEndian, &listTransactionsRequest.DurationFilterMs); err != nil {
+			return err
+		}
+	}
+
+	r.ListTransactionsRequest = &listTransactionsRequest
+	return utils.SkipTaggedFields(data)
+}
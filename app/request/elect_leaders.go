@@ -0,0 +1,54 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Elect_Leaders_Request_Topic struct {
+	Topic      string
+	Partitions []int32
+}
+
+type Elect_Leaders_Request struct {
+	ElectionType int8
+	// TopicPartitions is nil to elect leaders for every partition
+	TopicPartitions []Elect_Leaders_Request_Topic
+	TimeoutMs       int32
+}
+
+func (r *Request) DecodeElectLeaders(data *bytes.Buffer) error {
+	electLeadersRequest := Elect_Leaders_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &electLeadersRequest.ElectionType); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	if topicLen >= 0 {
+		electLeadersRequest.TopicPartitions = []Elect_Leaders_Request_Topic{}
+	}
+	for range topicLen {
+		topic := Elect_Leaders_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.Topic, data); err != nil {
+			return err
+		}
+		if err := utils.ReadINT32Array(&topic.Partitions, data); err != nil {
+			return err
+		}
+		electLeadersRequest.TopicPartitions = append(electLeadersRequest.TopicPartitions, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+	if err := binary.Read(data, binary.BigEndian, &electLeadersRequest.TimeoutMs); err != nil {
+		return err
+	}
+
+	r.ElectLeadersRequest = &electLeadersRequest
+	return utils.SkipTaggedFields(data)
+}
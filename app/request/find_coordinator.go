@@ -0,0 +1,38 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const COORDINATOR_KEY_TYPE_GROUP int8 = 0
+const COORDINATOR_KEY_TYPE_TRANSACTION int8 = 1
+
+type Find_Coordinator_Request struct {
+	KeyType         int8
+	CoordinatorKeys []string
+}
+
+func (r *Request) DecodeFindCoordinator(data *bytes.Buffer) error {
+	findCoordinatorRequest := Find_Coordinator_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &findCoordinatorRequest.KeyType); err != nil {
+		return err
+	}
+	var keyLen int
+	if err := utils.ReadCompactArrayLength(&keyLen, data); err != nil {
+		return err
+	}
+	for range keyLen {
+		var key string
+		if err := utils.ReadCompactString(&key, data); err != nil {
+			return err
+		}
+		findCoordinatorRequest.CoordinatorKeys = append(findCoordinatorRequest.CoordinatorKeys, key)
+	}
+
+	r.FindCoordinatorRequest = &findCoordinatorRequest
+	return utils.SkipTaggedFields(data)
+}
@@ -0,0 +1,35 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Init_Producer_Id_Request struct {
+	TransactionalID      *string
+	TransactionTimeoutMs int32
+	ProducerID           int64
+	ProducerEpoch        int16
+}
+
+func (r *Request) DecodeInitProducerId(data *bytes.Buffer) error {
+	initProducerIdRequest := Init_Producer_Id_Request{}
+
+	if err := utils.ReadCompactNullableString(&initProducerIdRequest.TransactionalID, data); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &initProducerIdRequest.TransactionTimeoutMs); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &initProducerIdRequest.ProducerID); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &initProducerIdRequest.ProducerEpoch); err != nil {
+		return err
+	}
+
+	r.InitProducerIdRequest = &initProducerIdRequest
+	return utils.SkipTaggedFields(data)
+}
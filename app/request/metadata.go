@@ -0,0 +1,65 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+type Metadata_Request_Topic struct {
+	TopicID uuid.UUID
+	Name    *string
+}
+
+type Metadata_Request struct {
+	// Topics is nil when every topic is requested
+	Topics                             []Metadata_Request_Topic
+	AllowAutoTopicCreation             bool
+	IncludeClusterAuthorizedOperations bool
+	IncludeTopicAuthorizedOperations   bool
+}
+
+func (r *Request) DecodeMetadata(data *bytes.Buffer) error {
+	metadataRequest := Metadata_Request{}
+
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	if topicLen >= 0 {
+		metadataRequest.Topics = []Metadata_Request_Topic{}
+	}
+	for range topicLen {
+		topic := Metadata_Request_Topic{}
+		if r.ApiVersion >= 10 {
+			if err := utils.ReadUUID(&topic.TopicID, data); err != nil {
+				return err
+			}
+		}
+		if err := utils.ReadCompactNullableString(&topic.Name, data); err != nil {
+			return err
+		}
+		metadataRequest.Topics = append(metadataRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Read(data, binary.BigEndian, &metadataRequest.AllowAutoTopicCreation); err != nil {
+		return err
+	}
+	// include_cluster_authorized_operations was dropped in version 11
+	if r.ApiVersion <= 10 {
+		if err := binary.Read(data, binary.BigEndian, &metadataRequest.IncludeClusterAuthorizedOperations); err != nil {
+			return err
+		}
+	}
+	if err := binary.Read(data, binary.BigEndian, &metadataRequest.IncludeTopicAuthorizedOperations); err != nil {
+		return err
+	}
+
+	r.MetadataRequest = &metadataRequest
+	return utils.SkipTaggedFields(data)
+}
@@ -0,0 +1,64 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Offset_For_Leader_Epoch_Request_Partition struct {
+	Partition          int32
+	CurrentLeaderEpoch int32
+	LeaderEpoch        int32
+}
+
+type Offset_For_Leader_Epoch_Request_Topic struct {
+	Topic      string
+	Partitions []Offset_For_Leader_Epoch_Request_Partition
+}
+
+type Offset_For_Leader_Epoch_Request struct {
+	// ReplicaID is -1 for consumers
+	ReplicaID int32
+	Topics    []Offset_For_Leader_Epoch_Request_Topic
+}
+
+func (r *Request) DecodeOffsetForLeaderEpoch(data *bytes.Buffer) error {
+	offsetForLeaderEpochRequest := Offset_For_Leader_Epoch_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &offsetForLeaderEpochRequest.ReplicaID); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Offset_For_Leader_Epoch_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.Topic, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Offset_For_Leader_Epoch_Request_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		offsetForLeaderEpochRequest.Topics = append(offsetForLeaderEpochRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.OffsetForLeaderEpochRequest = &offsetForLeaderEpochRequest
+	return utils.SkipTaggedFields(data)
+}
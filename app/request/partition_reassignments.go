@@ -0,0 +1,115 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Alter_Partition_Reassignments_Request_Partition struct {
+	PartitionIndex int32
+	// Replicas is nil to cancel the partition's reassignment
+	Replicas []int32
+}
+
+type Alter_Partition_Reassignments_Request_Topic struct {
+	Name       string
+	Partitions []Alter_Partition_Reassignments_Request_Partition
+}
+
+type Alter_Partition_Reassignments_Request struct {
+	TimeoutMs int32
+	Topics    []Alter_Partition_Reassignments_Request_Topic
+}
+
+type List_Partition_Reassignments_Request_Topic struct {
+	Name             string
+	PartitionIndexes []int32
+}
+
+type List_Partition_Reassignments_Request struct {
+	TimeoutMs int32
+	// Topics is nil to list every ongoing reassignment
+	Topics []List_Partition_Reassignments_Request_Topic
+}
+
+func (r *Request) DecodeAlterPartitionReassignments(data *bytes.Buffer) error {
+	alterPartitionReassignmentsRequest := Alter_Partition_Reassignments_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &alterPartitionReassignmentsRequest.TimeoutMs); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Alter_Partition_Reassignments_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.Name, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Alter_Partition_Reassignments_Request_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition.PartitionIndex); err != nil {
+				return err
+			}
+			var replicaLen int
+			if err := utils.ReadCompactArrayLength(&replicaLen, data); err != nil {
+				return err
+			}
+			if replicaLen >= 0 {
+				partition.Replicas = make([]int32, replicaLen)
+				if err := binary.Read(data, binary.BigEndian, partition.Replicas); err != nil {
+					return err
+				}
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		alterPartitionReassignmentsRequest.Topics = append(alterPartitionReassignmentsRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.AlterPartitionReassignmentsRequest = &alterPartitionReassignmentsRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeListPartitionReassignments(data *bytes.Buffer) error {
+	listPartitionReassignmentsRequest := List_Partition_Reassignments_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &listPartitionReassignmentsRequest.TimeoutMs); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	if topicLen >= 0 {
+		listPartitionReassignmentsRequest.Topics = []List_Partition_Reassignments_Request_Topic{}
+	}
+	for range topicLen {
+		topic := List_Partition_Reassignments_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.Name, data); err != nil {
+			return err
+		}
+		if err := utils.ReadINT32Array(&topic.PartitionIndexes, data); err != nil {
+			return err
+		}
+		listPartitionReassignmentsRequest.Topics = append(listPartitionReassignmentsRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.ListPartitionReassignmentsRequest = &listPartitionReassignmentsRequest
+	return utils.SkipTaggedFields(data)
+}
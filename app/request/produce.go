@@ -0,0 +1,74 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Produce_Request_Partition struct {
+	Index   int32
+	Records []byte
+}
+
+type Produce_Request_Topic struct {
+	Name       string
+	Partitions []Produce_Request_Partition
+}
+
+type Produce_Request struct {
+	TransactionalID *string
+	Acks            int16
+	TimeoutMs       int32
+	Topics          []Produce_Request_Topic
+}
+
+func (r *Request) DecodeProduce(data *bytes.Buffer) error {
+	produceRequest := Produce_Request{}
+
+	if err := utils.ReadCompactNullableString(&produceRequest.TransactionalID, data); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &produceRequest.Acks); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &produceRequest.TimeoutMs); err != nil {
+		return err
+	}
+
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Produce_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.Name, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Produce_Request_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition.Index); err != nil {
+				return err
+			}
+			if err := utils.ReadCompactBytes(&partition.Records, data); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		produceRequest.Topics = append(produceRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.ProduceRequest = &produceRequest
+	return utils.SkipTaggedFields(data)
+}
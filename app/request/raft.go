@@ -0,0 +1,269 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Vote_Request_Partition struct {
+	PartitionIndex  int32
+	CandidateEpoch  int32
+	CandidateID     int32
+	LastOffsetEpoch int32
+	LastOffset      int64
+}
+
+type Vote_Request_Topic struct {
+	TopicName  string
+	Partitions []Vote_Request_Partition
+}
+
+type Vote_Request struct {
+	ClusterID *string
+	Topics    []Vote_Request_Topic
+}
+
+type Quorum_Epoch_Request_Partition struct {
+	PartitionIndex      int32
+	LeaderID            int32
+	LeaderEpoch         int32
+	PreferredSuccessors []int32
+}
+
+type Quorum_Epoch_Request_Topic struct {
+	TopicName  string
+	Partitions []Quorum_Epoch_Request_Partition
+}
+
+// Quorum_Epoch_Request is either a BeginQuorumEpoch or an EndQuorumEpoch
+// request. Only the latter has preferred successors.
+type Quorum_Epoch_Request struct {
+	ClusterID *string
+	Topics    []Quorum_Epoch_Request_Topic
+}
+
+type Describe_Quorum_Request_Topic struct {
+	TopicName  string
+	Partitions []int32
+}
+
+type Describe_Quorum_Request struct {
+	Topics []Describe_Quorum_Request_Topic
+}
+
+type Fetch_Snapshot_Request_Partition struct {
+	Partition          int32
+	CurrentLeaderEpoch int32
+	SnapshotEndOffset  int64
+	SnapshotEpoch      int32
+	Position           int64
+}
+
+type Fetch_Snapshot_Request_Topic struct {
+	Name       string
+	Partitions []Fetch_Snapshot_Request_Partition
+}
+
+type Fetch_Snapshot_Request struct {
+	ClusterID *string
+	ReplicaID int32
+	MaxBytes  int32
+	Topics    []Fetch_Snapshot_Request_Topic
+}
+
+func (r *Request) DecodeVote(data *bytes.Buffer) error {
+	voteRequest := Vote_Request{}
+
+	if err := utils.ReadCompactNullableString(&voteRequest.ClusterID, data); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Vote_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.TopicName, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Vote_Request_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		voteRequest.Topics = append(voteRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.VoteRequest = &voteRequest
+	return utils.SkipTaggedFields(data)
+}
+
+// DecodeQuorumEpoch reads the version 0 BeginQuorumEpoch and EndQuorumEpoch
+// requests, which are not flexible.
+func (r *Request) DecodeQuorumEpoch(data *bytes.Buffer) error {
+	quorumEpochRequest := Quorum_Epoch_Request{}
+
+	if err := utils.ReadNullableString(&quorumEpochRequest.ClusterID, data); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Quorum_Epoch_Request_Topic{}
+		if err := utils.ReadString(&topic.TopicName, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Quorum_Epoch_Request_Partition{}
+			fields := []any{&partition.PartitionIndex, &partition.LeaderID, &partition.LeaderEpoch}
+			for _, field := range fields {
+				if err := binary.Read(data, binary.BigEndian, field); err != nil {
+					return err
+				}
+			}
+			if r.ApiKey == utils.END_QUORUM_EPOCH_KEY {
+				var successorLen int
+				if err := utils.ReadArrayLength(&successorLen, data); err != nil {
+					return err
+				}
+				for range successorLen {
+					var successor int32
+					if err := binary.Read(data, binary.BigEndian, &successor); err != nil {
+						return err
+					}
+					partition.PreferredSuccessors = append(partition.PreferredSuccessors, successor)
+				}
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		quorumEpochRequest.Topics = append(quorumEpochRequest.Topics, topic)
+	}
+
+	r.QuorumEpochRequest = &quorumEpochRequest
+	return nil
+}
+
+func (r *Request) DecodeDescribeQuorum(data *bytes.Buffer) error {
+	describeQuorumRequest := Describe_Quorum_Request{}
+
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Describe_Quorum_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.TopicName, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			var partitionIndex int32
+			if err := binary.Read(data, binary.BigEndian, &partitionIndex); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partitionIndex)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		describeQuorumRequest.Topics = append(describeQuorumRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.DescribeQuorumRequest = &describeQuorumRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeFetchSnapshot(data *bytes.Buffer) error {
+	fetchSnapshotRequest := Fetch_Snapshot_Request{}
+
+	if err := binary.Read(data, binary.BigEndian, &fetchSnapshotRequest.ReplicaID); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &fetchSnapshotRequest.MaxBytes); err != nil {
+		return err
+	}
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Fetch_Snapshot_Request_Topic{}
+		if err := utils.ReadCompactString(&topic.Name, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Fetch_Snapshot_Request_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition.Partition); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.CurrentLeaderEpoch); err != nil {
+				return err
+			}
+			// snapshot_id is a struct with its own tagged fields
+			if err := binary.Read(data, binary.BigEndian, &partition.SnapshotEndOffset); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.SnapshotEpoch); err != nil {
+				return err
+			}
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.Position); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		fetchSnapshotRequest.Topics = append(fetchSnapshotRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	// cluster_id is tagged field 0
+	fields, err := utils.ReadTaggedFields(data)
+	if err != nil {
+		return err
+	}
+	if value, ok := fields[0]; ok {
+		if err := utils.ReadCompactNullableString(&fetchSnapshotRequest.ClusterID, bytes.NewBuffer(value)); err != nil {
+			return err
+		}
+	}
+
+	r.FetchSnapshotRequest = &fetchSnapshotRequest
+	return nil
+}
@@ -5,12 +5,21 @@ import (
 	"encoding/binary"
 	"errors"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/sasl"
 	"github.com/codecrafters-io/kafka-starter-go/app/utils"
 	"github.com/gofrs/uuid"
 )
 
+type Describe_Topic_Partition_Cursor struct {
+	TopicName      string
+	PartitionIndex int32
+}
+
 type Describe_Topic_Partition_Request struct {
-	TopicArray []string
+	TopicArray             []string
+	ResponsePartitionLimit int32
+	// Cursor is where the previous page stopped, or nil for the first page
+	Cursor *Describe_Topic_Partition_Cursor
 }
 type Api_Version_Request struct {
 	ClientID              string
@@ -29,9 +38,28 @@ type Fetch_Request_Topic struct {
 	TopicID    uuid.UUID
 	Partitions []Fetch_Request_Partition
 }
+
+const READ_UNCOMMITTED int8 = 0
+const READ_COMMITTED int8 = 1
+
+type Fetch_Request_Forgotten_Topic struct {
+	TopicID    uuid.UUID
+	Partitions []int32
+}
 type Fetch_Request struct {
-	SessionID int32
-	Topics    []Fetch_Request_Topic
+	MaxWaitMs       int32
+	MinBytes        int32
+	MaxBytes        int32
+	IsolationLevel  int8
+	SessionID       int32
+	SessionEpoch    int32
+	Topics          []Fetch_Request_Topic
+	ForgottenTopics []Fetch_Request_Forgotten_Topic
+	RackID          string
+	// Set by replicas rather than consumers
+	ClusterID    *string
+	ReplicaID    int32
+	ReplicaEpoch int64
 }
 
 type Request struct {
@@ -39,10 +67,55 @@ type Request struct {
 	ApiVersion    uint16
 	CorrelationID uint32
 	ClientId      string
-
-	DescribeTopicPartitionRequest *Describe_Topic_Partition_Request
-	ApiVersionRequest             *Api_Version_Request
-	FetchRequest                  *Fetch_Request
+	// Principal and ClientHost identify the connection the request came in
+	// on, for authorization
+	Principal  string
+	ClientHost string
+	// ListenerName is the listener the connection was accepted on
+	ListenerName string
+	// Sasl is the SASL exchange of the connection, on SASL listeners
+	Sasl *sasl.Session
+	// ThrottleTimeMs is written as the response's throttle_time_ms. The
+	// server sets the real value once the request has been metered, see
+	// response.SetThrottleTimeMs
+	ThrottleTimeMs int32
+
+	DescribeTopicPartitionRequest       *Describe_Topic_Partition_Request
+	ApiVersionRequest                   *Api_Version_Request
+	FetchRequest                        *Fetch_Request
+	ProduceRequest                      *Produce_Request
+	InitProducerIdRequest               *Init_Producer_Id_Request
+	FindCoordinatorRequest              *Find_Coordinator_Request
+	AddPartitionsToTxnRequest           *Add_Partitions_To_Txn_Request
+	AddOffsetsToTxnRequest              *Add_Offsets_To_Txn_Request
+	EndTxnRequest                       *End_Txn_Request
+	WriteTxnMarkersRequest              *Write_Txn_Markers_Request
+	TxnOffsetCommitRequest              *Txn_Offset_Commit_Request
+	DescribeProducersRequest            *Describe_Producers_Request
+	DescribeTransactionsRequest         *Describe_Transactions_Request
+	ListTransactionsRequest             *List_Transactions_Request
+	VoteRequest                         *Vote_Request
+	QuorumEpochRequest                  *Quorum_Epoch_Request
+	DescribeQuorumRequest               *Describe_Quorum_Request
+	FetchSnapshotRequest                *Fetch_Snapshot_Request
+	BrokerRegistrationRequest           *Broker_Registration_Request
+	BrokerHeartbeatRequest              *Broker_Heartbeat_Request
+	MetadataRequest                     *Metadata_Request
+	AlterPartitionRequest               *Alter_Partition_Request
+	OffsetForLeaderEpochRequest         *Offset_For_Leader_Epoch_Request
+	ElectLeadersRequest                 *Elect_Leaders_Request
+	AlterPartitionReassignmentsRequest  *Alter_Partition_Reassignments_Request
+	ListPartitionReassignmentsRequest   *List_Partition_Reassignments_Request
+	DescribeClusterRequest              *Describe_Cluster_Request
+	DescribeAclsRequest                 *Acl_Filter
+	CreateAclsRequest                   *Create_Acls_Request
+	DeleteAclsRequest                   *Delete_Acls_Request
+	SaslHandshakeRequest                *Sasl_Handshake_Request
+	SaslAuthenticateRequest             *Sasl_Authenticate_Request
+	DescribeUserScramCredentialsRequest *Describe_User_Scram_Credentials_Request
+	AlterUserScramCredentialsRequest    *Alter_User_Scram_Credentials_Request
+	DescribeClientQuotasRequest         *Describe_Client_Quotas_Request
+	AlterClientQuotasRequest            *Alter_Client_Quotas_Request
 }
 
 func Deserialize(data *bytes.Buffer) (Request, error) {
@@ -51,10 +124,8 @@ func Deserialize(data *bytes.Buffer) (Request, error) {
 	if err := newRequest.ParseRequestHeader(data); err != nil {
 		return Request{}, err
 	}
-	if newRequest.ApiVersion != utils.FETCH {
-		if newRequest.CheckVersionValidity() == false {
-			return newRequest, errors.New(errors.ErrUnsupported.Error())
-		}
+	if newRequest.CheckVersionValidity() == false {
+		return newRequest, errors.New(errors.ErrUnsupported.Error())
 	}
 	if err := newRequest.ParseRequestBody(data); err != nil {
 		return Request{}, err
@@ -86,7 +157,8 @@ func (r *Request) ParseRequestHeader(data *bytes.Buffer) error {
 }
 
 func (r Request) CheckVersionValidity() bool {
-	if r.ApiVersion >= 0 && r.ApiVersion <= 4 {
+	versions, ok := utils.SUPPORTED_API_VERSIONS[r.ApiKey]
+	if ok && r.ApiVersion >= versions.Min && r.ApiVersion <= versions.Max {
 		return true
 	}
 	return false
@@ -94,19 +166,151 @@ func (r Request) CheckVersionValidity() bool {
 
 func (r *Request) ParseRequestBody(data *bytes.Buffer) error {
 
-	switch r.ApiVersion {
-	case utils.DESCRIBE_TOPIC_PARTITIONS:
+	switch r.ApiKey {
+	case utils.DESCRIBE_TOPIC_PARTITIONS_KEY:
 		if err := r.DecodeVersion0(data); err != nil {
 			return err
 		}
-	case utils.API_VERSION:
+	case utils.API_VERSIONS_KEY:
 		if err := r.DecodeVersion4(data); err != nil {
 			return err
 		}
-	case utils.FETCH:
+	case utils.FETCH_KEY:
 		if err := r.DecodeVersion16(data); err != nil {
 			return err
 		}
+	case utils.METADATA_KEY:
+		if err := r.DecodeMetadata(data); err != nil {
+			return err
+		}
+	case utils.PRODUCE_KEY:
+		if err := r.DecodeProduce(data); err != nil {
+			return err
+		}
+	case utils.INIT_PRODUCER_ID_KEY:
+		if err := r.DecodeInitProducerId(data); err != nil {
+			return err
+		}
+	case utils.FIND_COORDINATOR_KEY:
+		if err := r.DecodeFindCoordinator(data); err != nil {
+			return err
+		}
+	case utils.ADD_PARTITIONS_TO_TXN_KEY:
+		if err := r.DecodeAddPartitionsToTxn(data); err != nil {
+			return err
+		}
+	case utils.ADD_OFFSETS_TO_TXN_KEY:
+		if err := r.DecodeAddOffsetsToTxn(data); err != nil {
+			return err
+		}
+	case utils.END_TXN_KEY:
+		if err := r.DecodeEndTxn(data); err != nil {
+			return err
+		}
+	case utils.WRITE_TXN_MARKERS_KEY:
+		if err := r.DecodeWriteTxnMarkers(data); err != nil {
+			return err
+		}
+	case utils.TXN_OFFSET_COMMIT_KEY:
+		if err := r.DecodeTxnOffsetCommit(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_PRODUCERS_KEY:
+		if err := r.DecodeDescribeProducers(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_TRANSACTIONS_KEY:
+		if err := r.DecodeDescribeTransactions(data); err != nil {
+			return err
+		}
+	case utils.LIST_TRANSACTIONS_KEY:
+		if err := r.DecodeListTransactions(data); err != nil {
+			return err
+		}
+	case utils.VOTE_KEY:
+		if err := r.DecodeVote(data); err != nil {
+			return err
+		}
+	case utils.BEGIN_QUORUM_EPOCH_KEY, utils.END_QUORUM_EPOCH_KEY:
+		if err := r.DecodeQuorumEpoch(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_QUORUM_KEY:
+		if err := r.DecodeDescribeQuorum(data); err != nil {
+			return err
+		}
+	case utils.FETCH_SNAPSHOT_KEY:
+		if err := r.DecodeFetchSnapshot(data); err != nil {
+			return err
+		}
+	case utils.ALTER_PARTITION_KEY:
+		if err := r.DecodeAlterPartition(data); err != nil {
+			return err
+		}
+	case utils.OFFSET_FOR_LEADER_EPOCH_KEY:
+		if err := r.DecodeOffsetForLeaderEpoch(data); err != nil {
+			return err
+		}
+	case utils.ELECT_LEADERS_KEY:
+		if err := r.DecodeElectLeaders(data); err != nil {
+			return err
+		}
+	case utils.ALTER_PARTITION_REASSIGNMENTS_KEY:
+		if err := r.DecodeAlterPartitionReassignments(data); err != nil {
+			return err
+		}
+	case utils.LIST_PARTITION_REASSIGNMENTS_KEY:
+		if err := r.DecodeListPartitionReassignments(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_CLUSTER_KEY:
+		if err := r.DecodeDescribeCluster(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_ACLS_KEY:
+		if err := r.DecodeDescribeAcls(data); err != nil {
+			return err
+		}
+	case utils.CREATE_ACLS_KEY:
+		if err := r.DecodeCreateAcls(data); err != nil {
+			return err
+		}
+	case utils.DELETE_ACLS_KEY:
+		if err := r.DecodeDeleteAcls(data); err != nil {
+			return err
+		}
+	case utils.SASL_HANDSHAKE_KEY:
+		if err := r.DecodeSaslHandshake(data); err != nil {
+			return err
+		}
+	case utils.SASL_AUTHENTICATE_KEY:
+		if err := r.DecodeSaslAuthenticate(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_USER_SCRAM_CREDENTIALS_KEY:
+		if err := r.DecodeDescribeUserScramCredentials(data); err != nil {
+			return err
+		}
+	case utils.ALTER_USER_SCRAM_CREDENTIALS_KEY:
+		if err := r.DecodeAlterUserScramCredentials(data); err != nil {
+			return err
+		}
+	case utils.DESCRIBE_CLIENT_QUOTAS_KEY:
+		if err := r.DecodeDescribeClientQuotas(data); err != nil {
+			return err
+		}
+	case utils.ALTER_CLIENT_QUOTAS_KEY:
+		if err := r.DecodeAlterClientQuotas(data); err != nil {
+			return err
+		}
+	case utils.BROKER_REGISTRATION_KEY:
+		if err := r.DecodeBrokerRegistration(data); err != nil {
+			return err
+		}
+	case utils.BROKER_HEARTBEAT_KEY:
+		if err := r.DecodeBrokerHeartbeat(data); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -139,12 +343,37 @@ func (r *Request) DecodeVersion0(data *bytes.Buffer) error {
 
 	r.DescribeTopicPartitionRequest.TopicArray = topicArray
 
+	if err := binary.Read(data, binary.BigEndian, &r.DescribeTopicPartitionRequest.ResponsePartitionLimit); err != nil {
+		return err
+	}
+	var cursorPresent int8
+	if err := binary.Read(data, binary.BigEndian, &cursorPresent); err != nil {
+		return err
+	}
+	if cursorPresent >= 0 {
+		cursor := Describe_Topic_Partition_Cursor{}
+		if err := utils.ReadCompactString(&cursor.TopicName, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &cursor.PartitionIndex); err != nil {
+			return err
+		}
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+		r.DescribeTopicPartitionRequest.Cursor = &cursor
+	}
+
 	return nil
 
 }
 
 func (r *Request) DecodeVersion4(data *bytes.Buffer) error {
 	r.ApiVersionRequest = &Api_Version_Request{}
+	// Versions before 3 have an empty body
+	if r.ApiVersion < 3 {
+		return nil
+	}
 	var clientIdLength uint8
 	if err := utils.ReadUINT8(&clientIdLength, data); err != nil {
 		return err
@@ -174,55 +403,96 @@ func (r *Request) DecodeVersion4(data *bytes.Buffer) error {
 }
 
 func (r *Request) DecodeVersion16(data *bytes.Buffer) error {
-	data.Next(4 + 4 + 4 + 1)
 	fetchRequest := Fetch_Request{
-		Topics: []Fetch_Request_Topic{},
+		Topics:       []Fetch_Request_Topic{},
+		ReplicaID:    -1,
+		ReplicaEpoch: -1,
 	}
-	if err := binary.Read(data, binary.BigEndian, &fetchRequest.SessionID); err != nil {
-		return err
+	// replica_id moved into a tagged field in version 15
+	if r.ApiVersion < 15 {
+		if err := binary.Read(data, binary.BigEndian, &fetchRequest.ReplicaID); err != nil {
+			return err
+		}
+	}
+	fields := []any{&fetchRequest.MaxWaitMs, &fetchRequest.MinBytes, &fetchRequest.MaxBytes, &fetchRequest.IsolationLevel, &fetchRequest.SessionID, &fetchRequest.SessionEpoch}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return err
+		}
 	}
-	data.Next(4)
 
-	var topicLen byte
-	if err := binary.Read(data, binary.BigEndian, &topicLen); err != nil {
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
 		return err
 	}
-	for i := 0; i < int(topicLen); i++ {
-		buffer := make([]byte, 16)
-		if err := binary.Read(data, binary.BigEndian, &buffer); err != nil {
+	for range topicLen {
+		fetchRequestTopic := Fetch_Request_Topic{}
+		if err := utils.ReadUUID(&fetchRequestTopic.TopicID, data); err != nil {
 			return err
 		}
-		topicId, err := uuid.FromBytes(buffer)
-		if err != nil {
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
 			return err
 		}
-		fetchRequestTopic := Fetch_Request_Topic{
-			TopicID: topicId,
-		}
-		var paritionLen byte
-		if err := binary.Read(data, binary.BigEndian, &paritionLen); err != nil {
-			return err
-		}
-		for j := 0; j < int(paritionLen); j++ {
+		for range partitionLen {
 			fetchRequestPartition := Fetch_Request_Partition{}
-			binary.Read(data, binary.BigEndian, &fetchRequestPartition.PartitionID)
-			binary.Read(data, binary.BigEndian, &fetchRequestPartition.CurrentLeaderEpoch)
-			binary.Read(data, binary.BigEndian, &fetchRequestPartition.FetchOffset)
-			binary.Read(data, binary.BigEndian, &fetchRequestPartition.LastFetchedEpoch)
-			binary.Read(data, binary.BigEndian, &fetchRequestPartition.LogStartOffset)
-			binary.Read(data, binary.BigEndian, &fetchRequestPartition.PartitionMaxBytes)
+			if err := binary.Read(data, binary.BigEndian, &fetchRequestPartition); err != nil {
+				return err
+			}
 			fetchRequestTopic.Partitions = append(fetchRequestTopic.Partitions, fetchRequestPartition)
-			r.SkipTagBuffer(data)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
 		}
 		fetchRequest.Topics = append(fetchRequest.Topics, fetchRequestTopic)
-		r.SkipTagBuffer(data)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
 
+	var forgottenLen int
+	if err := utils.ReadCompactArrayLength(&forgottenLen, data); err != nil {
+		return err
+	}
+	for range forgottenLen {
+		forgottenTopic := Fetch_Request_Forgotten_Topic{}
+		if err := utils.ReadUUID(&forgottenTopic.TopicID, data); err != nil {
+			return err
+		}
+		if err := utils.ReadINT32Array(&forgottenTopic.Partitions, data); err != nil {
+			return err
+		}
+		fetchRequest.ForgottenTopics = append(fetchRequest.ForgottenTopics, forgottenTopic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+	if err := utils.ReadCompactString(&fetchRequest.RackID, data); err != nil {
+		return err
 	}
 
-	r.FetchRequest = &fetchRequest
+	// cluster_id is tagged field 0 and replica_state tagged field 1
+	taggedFields, err := utils.ReadTaggedFields(data)
+	if err != nil {
+		return err
+	}
+	if value, ok := taggedFields[0]; ok {
+		if err := utils.ReadCompactNullableString(&fetchRequest.ClusterID, bytes.NewBuffer(value)); err != nil {
+			return err
+		}
+	}
+	if value, ok := taggedFields[1]; ok {
+		replicaState := bytes.NewBuffer(value)
+		if err := binary.Read(replicaState, binary.BigEndian, &fetchRequest.ReplicaID); err != nil {
+			return err
+		}
+		if err := binary.Read(replicaState, binary.BigEndian, &fetchRequest.ReplicaEpoch); err != nil {
+			return err
+		}
+	}
 
+	r.FetchRequest = &fetchRequest
 	return nil
-
 }
 
 func (r *Request) ReadClientId(data *bytes.Buffer) error {
@@ -236,7 +506,10 @@ func (r *Request) ReadClientId(data *bytes.Buffer) error {
 		return err
 	}
 	r.ClientId = string(clientId)
-	r.SkipTagBuffer(data)
+	// Only flexible requests use the header with tagged fields
+	if utils.IsFlexible(r.ApiKey, r.ApiVersion) {
+		r.SkipTagBuffer(data)
+	}
 	return nil
 
 }
@@ -5,15 +5,17 @@ import (
 	"encoding/binary"
 	"log"
 	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
 )
 
 func TestDeserailize(t *testing.T) {
 	TestReq := Request{
-		ApiKey:        75,
-		ApiVersion:    0,
-		CorrelationID: 7,
-		ClientId:      "kafka-cli",
-		TopicArray:    []Topic{{TopicName: "foo"}},
+		ApiKey:                        75,
+		ApiVersion:                    0,
+		CorrelationID:                 7,
+		ClientId:                      "kafka-cli",
+		DescribeTopicPartitionRequest: &Describe_Topic_Partition_Request{TopicArray: []string{"foo"}},
 	}
 
 	log.Println("Starting TestNewReqFromConn...")
@@ -30,14 +32,14 @@ func TestDeserailize(t *testing.T) {
 	binary.Write(&buf, binary.BigEndian, uint16(len(clientID))) // Client ID Length
 	buf.WriteString(clientID)                                   // Client ID
 
-	binary.Write(&buf, binary.BigEndian, uint8(0))                         // Empty Tagged Field Array
-	binary.Write(&buf, binary.BigEndian, uint8(len(TestReq.TopicArray)+1)) // Topics Array Length
+	binary.Write(&buf, binary.BigEndian, uint8(0))                                                       // Empty Tagged Field Array
+	binary.Write(&buf, binary.BigEndian, uint8(len(TestReq.DescribeTopicPartitionRequest.TopicArray)+1)) // Topics Array Length
 
 	// Add topics
-	for _, topic := range TestReq.TopicArray {
-		binary.Write(&buf, binary.BigEndian, uint8(len(topic.TopicName)+1)) // Topic Name Length
-		buf.WriteString(topic.TopicName)                                    // Topic Name
-		binary.Write(&buf, binary.BigEndian, uint8(0))                      // Empty TAG_BUFFER
+	for _, topic := range TestReq.DescribeTopicPartitionRequest.TopicArray {
+		binary.Write(&buf, binary.BigEndian, uint8(len(topic)+1)) // Topic Name Length
+		buf.WriteString(topic)                                    // Topic Name
+		binary.Write(&buf, binary.BigEndian, uint8(0))            // Empty TAG_BUFFER
 	}
 
 	binary.Write(&buf, binary.BigEndian, uint32(100)) // Response Partition Limit
@@ -54,11 +56,25 @@ func TestDeserailize(t *testing.T) {
 		t.Errorf("Request does not match expected values.")
 	}
 
-	for i, topic := range req.TopicArray {
-		if topic.TopicName != TestReq.TopicArray[i].TopicName {
+	for i, topic := range req.DescribeTopicPartitionRequest.TopicArray {
+		if topic != TestReq.DescribeTopicPartitionRequest.TopicArray[i] {
 			t.Errorf("Topic %d does not match expected value.", i)
 		}
 	}
 
+	if req.DescribeTopicPartitionRequest.ResponsePartitionLimit != 100 || req.DescribeTopicPartitionRequest.Cursor != nil {
+		t.Errorf("Expected a limit of 100 and no cursor, got %+v", req.DescribeTopicPartitionRequest)
+	}
+
 	log.Println("Test completed.")
 }
+
+func TestFetchVersionsMatchTheDecoder(t *testing.T) {
+	// Only the flexible layouts with topic ids are decoded
+	for version, valid := range map[uint16]bool{12: false, 13: true, 17: true, 18: false} {
+		req := Request{ApiKey: utils.FETCH_KEY, ApiVersion: version}
+		if req.CheckVersionValidity() != valid {
+			t.Errorf("Expected fetch v%d to be valid: %v", version, valid)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package request
+
+import (
+	"bytes"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Sasl_Handshake_Request struct {
+	Mechanism string
+}
+
+type Sasl_Authenticate_Request struct {
+	AuthBytes []byte
+}
+
+func (r *Request) DecodeSaslHandshake(data *bytes.Buffer) error {
+	saslHandshakeRequest := Sasl_Handshake_Request{}
+
+	if err := utils.ReadString(&saslHandshakeRequest.Mechanism, data); err != nil {
+		return err
+	}
+
+	r.SaslHandshakeRequest = &saslHandshakeRequest
+	return nil
+}
+
+func (r *Request) DecodeSaslAuthenticate(data *bytes.Buffer) error {
+	saslAuthenticateRequest := Sasl_Authenticate_Request{}
+
+	if utils.IsFlexible(r.ApiKey, r.ApiVersion) {
+		if err := utils.ReadCompactBytes(&saslAuthenticateRequest.AuthBytes, data); err != nil {
+			return err
+		}
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	} else {
+		var authBytesLen int
+		if err := utils.ReadArrayLength(&authBytesLen, data); err != nil {
+			return err
+		}
+		saslAuthenticateRequest.AuthBytes = bytes.Clone(data.Next(authBytesLen))
+	}
+
+	r.SaslAuthenticateRequest = &saslAuthenticateRequest
+	return nil
+}
@@ -0,0 +1,109 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// Describe_User_Scram_Credentials_Request describes all users when Users is
+// nil.
+type Describe_User_Scram_Credentials_Request struct {
+	Users []string
+}
+
+type Scram_Credential_Deletion struct {
+	Name      string
+	Mechanism int8
+}
+
+type Scram_Credential_Upsertion struct {
+	Name           string
+	Mechanism      int8
+	Iterations     int32
+	Salt           []byte
+	SaltedPassword []byte
+}
+
+type Alter_User_Scram_Credentials_Request struct {
+	Deletions  []Scram_Credential_Deletion
+	Upsertions []Scram_Credential_Upsertion
+}
+
+func (r *Request) DecodeDescribeUserScramCredentials(data *bytes.Buffer) error {
+	describeUserScramCredentialsRequest := Describe_User_Scram_Credentials_Request{}
+
+	var userLen int
+	if err := utils.ReadCompactArrayLength(&userLen, data); err != nil {
+		return err
+	}
+	if userLen >= 0 {
+		describeUserScramCredentialsRequest.Users = []string{}
+	}
+	for range userLen {
+		var name string
+		if err := utils.ReadCompactString(&name, data); err != nil {
+			return err
+		}
+		describeUserScramCredentialsRequest.Users = append(describeUserScramCredentialsRequest.Users, name)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.DescribeUserScramCredentialsRequest = &describeUserScramCredentialsRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeAlterUserScramCredentials(data *bytes.Buffer) error {
+	alterUserScramCredentialsRequest := Alter_User_Scram_Credentials_Request{}
+
+	var deletionLen int
+	if err := utils.ReadCompactArrayLength(&deletionLen, data); err != nil {
+		return err
+	}
+	for range deletionLen {
+		deletion := Scram_Credential_Deletion{}
+		if err := utils.ReadCompactString(&deletion.Name, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &deletion.Mechanism); err != nil {
+			return err
+		}
+		alterUserScramCredentialsRequest.Deletions = append(alterUserScramCredentialsRequest.Deletions, deletion)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	var upsertionLen int
+	if err := utils.ReadCompactArrayLength(&upsertionLen, data); err != nil {
+		return err
+	}
+	for range upsertionLen {
+		upsertion := Scram_Credential_Upsertion{}
+		if err := utils.ReadCompactString(&upsertion.Name, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &upsertion.Mechanism); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &upsertion.Iterations); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactBytes(&upsertion.Salt, data); err != nil {
+			return err
+		}
+		if err := utils.ReadCompactBytes(&upsertion.SaltedPassword, data); err != nil {
+			return err
+		}
+		alterUserScramCredentialsRequest.Upsertions = append(alterUserScramCredentialsRequest.Upsertions, upsertion)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.AlterUserScramCredentialsRequest = &alterUserScramCredentialsRequest
+	return utils.SkipTaggedFields(data)
+}
@@ -0,0 +1,243 @@
+package request
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type Txn_Topic struct {
+	Name       string
+	Partitions []int32
+}
+
+type Add_Partitions_To_Txn_Request struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+	Topics          []Txn_Topic
+}
+
+type Add_Offsets_To_Txn_Request struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+	GroupID         string
+}
+
+type End_Txn_Request struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+	Committed       bool
+}
+
+type Write_Txn_Marker struct {
+	ProducerID        int64
+	ProducerEpoch     int16
+	TransactionResult bool
+	Topics            []Txn_Topic
+	CoordinatorEpoch  int32
+}
+
+type Write_Txn_Markers_Request struct {
+	Markers []Write_Txn_Marker
+}
+
+type Txn_Offset_Commit_Partition struct {
+	PartitionIndex       int32
+	CommittedOffset      int64
+	CommittedLeaderEpoch int32
+	CommittedMetadata    *string
+}
+
+type Txn_Offset_Commit_Topic struct {
+	Name       string
+	Partitions []Txn_Offset_Commit_Partition
+}
+
+type Txn_Offset_Commit_Request struct {
+	TransactionalID string
+	GroupID         string
+	ProducerID      int64
+	ProducerEpoch   int16
+	GenerationID    int32
+	MemberID        string
+	GroupInstanceID *string
+	Topics          []Txn_Offset_Commit_Topic
+}
+
+func readTxnTopics(topics *[]Txn_Topic, data *bytes.Buffer) error {
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Txn_Topic{}
+		if err := utils.ReadCompactString(&topic.Name, data); err != nil {
+			return err
+		}
+		if err := utils.ReadINT32Array(&topic.Partitions, data); err != nil {
+			return err
+		}
+		*topics = append(*topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readProducer(producerId *int64, producerEpoch *int16, data *bytes.Buffer) error {
+	if err := binary.Read(data, binary.BigEndian, producerId); err != nil {
+		return err
+	}
+	return binary.Read(data, binary.BigEndian, producerEpoch)
+}
+
+func (r *Request) DecodeAddPartitionsToTxn(data *bytes.Buffer) error {
+	addPartitionsRequest := Add_Partitions_To_Txn_Request{}
+
+	if err := utils.ReadCompactString(&addPartitionsRequest.TransactionalID, data); err != nil {
+		return err
+	}
+	if err := readProducer(&addPartitionsRequest.ProducerID, &addPartitionsRequest.ProducerEpoch, data); err != nil {
+		return err
+	}
+	if err := readTxnTopics(&addPartitionsRequest.Topics, data); err != nil {
+		return err
+	}
+
+	r.AddPartitionsToTxnRequest = &addPartitionsRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeAddOffsetsToTxn(data *bytes.Buffer) error {
+	addOffsetsRequest := Add_Offsets_To_Txn_Request{}
+
+	if err := utils.ReadCompactString(&addOffsetsRequest.TransactionalID, data); err != nil {
+		return err
+	}
+	if err := readProducer(&addOffsetsRequest.ProducerID, &addOffsetsRequest.ProducerEpoch, data); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactString(&addOffsetsRequest.GroupID, data); err != nil {
+		return err
+	}
+
+	r.AddOffsetsToTxnRequest = &addOffsetsRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeEndTxn(data *bytes.Buffer) error {
+	endTxnRequest := End_Txn_Request{}
+
+	if err := utils.ReadCompactString(&endTxnRequest.TransactionalID, data); err != nil {
+		return err
+	}
+	if err := readProducer(&endTxnRequest.ProducerID, &endTxnRequest.ProducerEpoch, data); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &endTxnRequest.Committed); err != nil {
+		return err
+	}
+
+	r.EndTxnRequest = &endTxnRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeWriteTxnMarkers(data *bytes.Buffer) error {
+	writeTxnMarkersRequest := Write_Txn_Markers_Request{}
+
+	var markerLen int
+	if err := utils.ReadCompactArrayLength(&markerLen, data); err != nil {
+		return err
+	}
+	for range markerLen {
+		marker := Write_Txn_Marker{}
+		if err := readProducer(&marker.ProducerID, &marker.ProducerEpoch, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &marker.TransactionResult); err != nil {
+			return err
+		}
+		if err := readTxnTopics(&marker.Topics, data); err != nil {
+			return err
+		}
+		if err := binary.Read(data, binary.BigEndian, &marker.CoordinatorEpoch); err != nil {
+			return err
+		}
+		writeTxnMarkersRequest.Markers = append(writeTxnMarkersRequest.Markers, marker)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.WriteTxnMarkersRequest = &writeTxnMarkersRequest
+	return utils.SkipTaggedFields(data)
+}
+
+func (r *Request) DecodeTxnOffsetCommit(data *bytes.Buffer) error {
+	txnOffsetCommitRequest := Txn_Offset_Commit_Request{}
+
+	if err := utils.ReadCompactString(&txnOffsetCommitRequest.TransactionalID, data); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactString(&txnOffsetCommitRequest.GroupID, data); err != nil {
+		return err
+	}
+	if err := readProducer(&txnOffsetCommitRequest.ProducerID, &txnOffsetCommitRequest.ProducerEpoch, data); err != nil {
+		return err
+	}
+	if err := binary.Read(data, binary.BigEndian, &txnOffsetCommitRequest.GenerationID); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactString(&txnOffsetCommitRequest.MemberID, data); err != nil {
+		return err
+	}
+	if err := utils.ReadCompactNullableString(&txnOffsetCommitRequest.GroupInstanceID, data); err != nil {
+		return err
+	}
+
+	var topicLen int
+	if err := utils.ReadCompactArrayLength(&topicLen, data); err != nil {
+		return err
+	}
+	for range topicLen {
+		topic := Txn_Offset_Commit_Topic{}
+		if err := utils.ReadCompactString(&topic.Name, data); err != nil {
+			return err
+		}
+		var partitionLen int
+		if err := utils.ReadCompactArrayLength(&partitionLen, data); err != nil {
+			return err
+		}
+		for range partitionLen {
+			partition := Txn_Offset_Commit_Partition{}
+			if err := binary.Read(data, binary.BigEndian, &partition.PartitionIndex); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.CommittedOffset); err != nil {
+				return err
+			}
+			if err := binary.Read(data, binary.BigEndian, &partition.CommittedLeaderEpoch); err != nil {
+				return err
+			}
+			if err := utils.ReadCompactNullableString(&partition.CommittedMetadata, data); err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+			if err := utils.SkipTaggedFields(data); err != nil {
+				return err
+			}
+		}
+		txnOffsetCommitRequest.Topics = append(txnOffsetCommitRequest.Topics, topic)
+		if err := utils.SkipTaggedFields(data); err != nil {
+			return err
+		}
+	}
+
+	r.TxnOffsetCommitRequest = &txnOffsetCommitRequest
+	return utils.SkipTaggedFields(data)
+}
@@ -0,0 +1,198 @@
+package response
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func aclFilter(filter request.Acl_Filter) authorizer.AclBindingFilter {
+	return authorizer.AclBindingFilter{
+		ResourceType:   filter.ResourceTypeFilter,
+		ResourceName:   filter.ResourceNameFilter,
+		PatternType:    filter.PatternTypeFilter,
+		Principal:      filter.PrincipalFilter,
+		Host:           filter.HostFilter,
+		Operation:      filter.Operation,
+		PermissionType: filter.PermissionType,
+	}
+}
+
+// aclErrorCode is the error of an ACL request that this broker cannot serve
+// or the client may not send.
+func aclErrorCode(req request.Request, operation int8) int16 {
+	if !authorizer.AclsEnabled() {
+		return utils.SECURITY_DISABLED
+	}
+	if !isClusterAuthorized(req, operation) {
+		return utils.CLUSTER_AUTHORIZATION_FAILED
+	}
+	return utils.NONE
+}
+
+func compareBindings(a, b authorizer.AclBinding) int {
+	return cmp.Or(
+		cmp.Compare(a.ResourceType, b.ResourceType),
+		cmp.Compare(a.ResourceName, b.ResourceName),
+		cmp.Compare(a.PatternType, b.PatternType),
+		cmp.Compare(a.Principal, b.Principal),
+		cmp.Compare(a.Host, b.Host),
+		cmp.Compare(a.Operation, b.Operation),
+		cmp.Compare(a.PermissionType, b.PermissionType),
+	)
+}
+
+// SerializeDescribeAcls answers from this broker's metadata image, with the
+// matching ACLs grouped by resource pattern.
+func SerializeDescribeAcls(req request.Request) ([]byte, error) {
+	filter := aclFilter(*req.DescribeAclsRequest)
+
+	errorCode := aclErrorCode(req, authorizer.OPERATION_DESCRIBE)
+	if errorCode == utils.NONE && filter.Validate() != nil {
+		errorCode = utils.INVALID_REQUEST
+	}
+	bindings := []authorizer.AclBinding{}
+	if errorCode == utils.NONE {
+		for _, binding := range authorizer.MatchingAcls(metadata.Image(), filter) {
+			bindings = append(bindings, binding)
+		}
+		slices.SortFunc(bindings, compareBindings)
+	}
+	resources := [][]authorizer.AclBinding{}
+	for i, binding := range bindings {
+		previous := authorizer.AclBinding{}
+		if i > 0 {
+			previous = bindings[i-1]
+		}
+		if i == 0 || binding.ResourceType != previous.ResourceType || binding.ResourceName != previous.ResourceName ||
+			binding.PatternType != previous.PatternType {
+			resources = append(resources, nil)
+		}
+		resources[len(resources)-1] = append(resources[len(resources)-1], binding)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	// error_message
+	utils.WriteCompactNullableString(&body, nil)
+	utils.WriteCompactArrayLength(&body, len(resources))
+	for _, acls := range resources {
+		binary.Write(&body, binary.BigEndian, acls[0].ResourceType)
+		utils.WriteCompactString(&body, acls[0].ResourceName)
+		binary.Write(&body, binary.BigEndian, acls[0].PatternType)
+		utils.WriteCompactArrayLength(&body, len(acls))
+		for _, acl := range acls {
+			utils.WriteCompactString(&body, acl.Principal)
+			utils.WriteCompactString(&body, acl.Host)
+			binary.Write(&body, binary.BigEndian, acl.Operation)
+			binary.Write(&body, binary.BigEndian, acl.PermissionType)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeCreateAcls(req request.Request) ([]byte, error) {
+	creations := req.CreateAclsRequest.Creations
+
+	results := make([]int16, len(creations))
+	errorCode := aclErrorCode(req, authorizer.OPERATION_ALTER)
+	if errorCode == utils.NONE && controller.Controller() == nil {
+		errorCode = utils.NOT_CONTROLLER
+	}
+	if errorCode != utils.NONE {
+		for i := range results {
+			results[i] = errorCode
+		}
+	} else {
+		bindings := []authorizer.AclBinding{}
+		for _, creation := range creations {
+			bindings = append(bindings, authorizer.AclBinding{
+				ResourceType:   creation.ResourceType,
+				ResourceName:   creation.ResourceName,
+				PatternType:    creation.ResourcePatternType,
+				Principal:      creation.Principal,
+				Host:           creation.Host,
+				Operation:      creation.Operation,
+				PermissionType: creation.PermissionType,
+			})
+		}
+		results = controller.Controller().CreateAcls(bindings)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(results))
+	for _, result := range results {
+		binary.Write(&body, binary.BigEndian, result)
+		// error_message
+		utils.WriteCompactNullableString(&body, nil)
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeDeleteAcls(req request.Request) ([]byte, error) {
+	filters := req.DeleteAclsRequest.Filters
+
+	results := make([]controller.DeleteAclsResult, len(filters))
+	errorCode := aclErrorCode(req, authorizer.OPERATION_ALTER)
+	if errorCode == utils.NONE && controller.Controller() == nil {
+		errorCode = utils.NOT_CONTROLLER
+	}
+	if errorCode != utils.NONE {
+		for i := range results {
+			results[i].ErrorCode = errorCode
+		}
+	} else {
+		bindingFilters := []authorizer.AclBindingFilter{}
+		for _, filter := range filters {
+			bindingFilters = append(bindingFilters, aclFilter(filter))
+		}
+		results = controller.Controller().DeleteAcls(bindingFilters)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(results))
+	for _, result := range results {
+		binary.Write(&body, binary.BigEndian, result.ErrorCode)
+		// error_message
+		utils.WriteCompactNullableString(&body, nil)
+		slices.SortFunc(result.Deleted, compareBindings)
+		utils.WriteCompactArrayLength(&body, len(result.Deleted))
+		for _, acl := range result.Deleted {
+			binary.Write(&body, binary.BigEndian, utils.NONE)
+			// error_message
+			utils.WriteCompactNullableString(&body, nil)
+			binary.Write(&body, binary.BigEndian, acl.ResourceType)
+			utils.WriteCompactString(&body, acl.ResourceName)
+			binary.Write(&body, binary.BigEndian, acl.PatternType)
+			utils.WriteCompactString(&body, acl.Principal)
+			utils.WriteCompactString(&body, acl.Host)
+			binary.Write(&body, binary.BigEndian, acl.Operation)
+			binary.Write(&body, binary.BigEndian, acl.PermissionType)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
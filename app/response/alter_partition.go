@@ -0,0 +1,63 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func SerializeAlterPartition(req request.Request) ([]byte, error) {
+	alterPartitionRequest := req.AlterPartitionRequest
+
+	resp := controller.AlterPartitionResponse{ErrorCode: utils.NOT_CONTROLLER}
+	if !isClusterAuthorized(req, authorizer.OPERATION_CLUSTER_ACTION) {
+		resp.ErrorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if quorumController := controller.Controller(); quorumController != nil {
+		alterPartition := controller.AlterPartitionRequest{BrokerID: alterPartitionRequest.BrokerID, BrokerEpoch: alterPartitionRequest.BrokerEpoch}
+		for _, topic := range alterPartitionRequest.Topics {
+			topicRequest := controller.AlterPartitionRequestTopic{TopicID: topic.TopicID}
+			for _, partition := range topic.Partitions {
+				partitionRequest := controller.AlterPartitionRequestPartition{
+					PartitionIndex:      partition.PartitionIndex,
+					LeaderEpoch:         partition.LeaderEpoch,
+					LeaderRecoveryState: partition.LeaderRecoveryState,
+					PartitionEpoch:      partition.PartitionEpoch,
+				}
+				for _, member := range partition.NewIsr {
+					partitionRequest.NewIsr = append(partitionRequest.NewIsr, controller.IsrMember(member))
+				}
+				topicRequest.Partitions = append(topicRequest.Partitions, partitionRequest)
+			}
+			alterPartition.Topics = append(alterPartition.Topics, topicRequest)
+		}
+		resp = quorumController.AlterPartition(alterPartition)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+	utils.WriteCompactArrayLength(&body, len(resp.Topics))
+	for _, topic := range resp.Topics {
+		body.Write(topic.TopicID.Bytes())
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			binary.Write(&body, binary.BigEndian, partition.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, partition.ErrorCode)
+			binary.Write(&body, binary.BigEndian, partition.LeaderID)
+			binary.Write(&body, binary.BigEndian, partition.LeaderEpoch)
+			utils.WriteINT32Array(&body, partition.Isr)
+			binary.Write(&body, binary.BigEndian, partition.LeaderRecoveryState)
+			binary.Write(&body, binary.BigEndian, partition.PartitionEpoch)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
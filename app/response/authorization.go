@@ -0,0 +1,40 @@
+package response
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+)
+
+func requestContext(req request.Request) authorizer.RequestContext {
+	principal := req.Principal
+	if principal == "" {
+		principal = authorizer.ANONYMOUS
+	}
+	return authorizer.RequestContext{Principal: principal, Host: req.ClientHost}
+}
+
+// topicAuthorizedOperations is the bitfield of operations on a topic, sent
+// only to clients that may describe it.
+func topicAuthorizedOperations(ctx authorizer.RequestContext, topic string) int32 {
+	if !authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, topic) {
+		return 0
+	}
+	return authorizer.AuthorizedOperations(ctx, authorizer.RESOURCE_TOPIC, topic)
+}
+
+// clusterAuthorizedOperations is the bitfield of operations on the cluster,
+// sent only to clients that may describe it.
+func clusterAuthorizedOperations(ctx authorizer.RequestContext) int32 {
+	if !authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_CLUSTER, authorizer.CLUSTER_NAME) {
+		return 0
+	}
+	return authorizer.AuthorizedOperations(ctx, authorizer.RESOURCE_CLUSTER, authorizer.CLUSTER_NAME)
+}
+
+func isAuthorized(req request.Request, operation int8, resourceType int8, resourceName string) bool {
+	return authorizer.IsAuthorized(requestContext(req), operation, resourceType, resourceName)
+}
+
+func isClusterAuthorized(req request.Request, operation int8) bool {
+	return isAuthorized(req, operation, authorizer.RESOURCE_CLUSTER, authorizer.CLUSTER_NAME)
+}
@@ -0,0 +1,74 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func SerializeBrokerRegistration(req request.Request) ([]byte, error) {
+	registrationRequest := req.BrokerRegistrationRequest
+
+	resp := controller.BrokerRegistrationResponse{ErrorCode: utils.NOT_CONTROLLER, BrokerEpoch: -1}
+	if !isClusterAuthorized(req, authorizer.OPERATION_CLUSTER_ACTION) {
+		resp.ErrorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if quorumController := controller.Controller(); quorumController != nil {
+		registration := controller.BrokerRegistrationRequest{
+			BrokerID:            registrationRequest.BrokerID,
+			ClusterID:           registrationRequest.ClusterID,
+			IncarnationID:       registrationRequest.IncarnationID,
+			Rack:                registrationRequest.Rack,
+			LogDirs:             registrationRequest.LogDirs,
+			PreviousBrokerEpoch: registrationRequest.PreviousBrokerEpoch,
+		}
+		for _, listener := range registrationRequest.Listeners {
+			registration.Listeners = append(registration.Listeners, metadata.BrokerEndpoint(listener))
+		}
+		for _, feature := range registrationRequest.Features {
+			registration.Features = append(registration.Features, metadata.BrokerFeature(feature))
+		}
+		resp = quorumController.RegisterBroker(registration)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+	binary.Write(&body, binary.BigEndian, resp.BrokerEpoch)
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeBrokerHeartbeat(req request.Request) ([]byte, error) {
+	heartbeatRequest := req.BrokerHeartbeatRequest
+
+	resp := controller.BrokerHeartbeatResponse{ErrorCode: utils.NOT_CONTROLLER, IsFenced: true}
+	if !isClusterAuthorized(req, authorizer.OPERATION_CLUSTER_ACTION) {
+		resp.ErrorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if quorumController := controller.Controller(); quorumController != nil {
+		resp = quorumController.BrokerHeartbeat(controller.BrokerHeartbeatRequest{
+			BrokerID:              heartbeatRequest.BrokerID,
+			BrokerEpoch:           heartbeatRequest.BrokerEpoch,
+			CurrentMetadataOffset: heartbeatRequest.CurrentMetadataOffset,
+			WantFence:             heartbeatRequest.WantFence,
+			WantShutDown:          heartbeatRequest.WantShutDown,
+		})
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+	binary.Write(&body, binary.BigEndian, resp.IsCaughtUp)
+	binary.Write(&body, binary.BigEndian, resp.IsFenced)
+	binary.Write(&body, binary.BigEndian, resp.ShouldShutDown)
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
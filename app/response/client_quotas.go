@@ -0,0 +1,169 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func validateClientQuotaFilter(components []request.Client_Quota_Filter_Component) error {
+	entityTypes := []string{}
+	for _, component := range components {
+		if component.EntityType != quota.ENTITY_USER && component.EntityType != quota.ENTITY_CLIENT_ID {
+			return fmt.Errorf("unhandled client quota entity type: %s", component.EntityType)
+		}
+		if slices.Contains(entityTypes, component.EntityType) {
+			return fmt.Errorf("duplicate filter component entity type %s", component.EntityType)
+		}
+		entityTypes = append(entityTypes, component.EntityType)
+		switch component.MatchType {
+		case request.CLIENT_QUOTA_MATCH_EXACT:
+			if component.Match == nil {
+				return fmt.Errorf("exact match of %s needs an entity name", component.EntityType)
+			}
+		case request.CLIENT_QUOTA_MATCH_DEFAULT, request.CLIENT_QUOTA_MATCH_ANY:
+		default:
+			return fmt.Errorf("unknown match type %d", component.MatchType)
+		}
+	}
+	return nil
+}
+
+func matchesClientQuotaFilter(entity []metadata.ClientQuotaEntity, filter *request.Describe_Client_Quotas_Request) bool {
+	if filter.Strict && len(entity) != len(filter.Components) {
+		return false
+	}
+	for _, component := range filter.Components {
+		i := slices.IndexFunc(entity, func(e metadata.ClientQuotaEntity) bool { return e.EntityType == component.EntityType })
+		if i < 0 {
+			return false
+		}
+		name := entity[i].EntityName
+		switch component.MatchType {
+		case request.CLIENT_QUOTA_MATCH_EXACT:
+			if name == nil || *name != *component.Match {
+				return false
+			}
+		case request.CLIENT_QUOTA_MATCH_DEFAULT:
+			if name != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func writeClientQuotaEntity(body *bytes.Buffer, entity []metadata.ClientQuotaEntity) {
+	utils.WriteCompactArrayLength(body, len(entity))
+	for _, e := range entity {
+		utils.WriteCompactString(body, e.EntityType)
+		utils.WriteCompactNullableString(body, e.EntityName)
+		utils.WriteTaggedFields(body)
+	}
+}
+
+// SerializeDescribeClientQuotas answers from this broker's metadata image.
+func SerializeDescribeClientQuotas(req request.Request) ([]byte, error) {
+	describeRequest := req.DescribeClientQuotasRequest
+
+	errorCode := utils.NONE
+	var errorMessage *string
+	if !isClusterAuthorized(req, authorizer.OPERATION_DESCRIBE_CONFIGS) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if err := validateClientQuotaFilter(describeRequest.Components); err != nil {
+		message := err.Error()
+		errorCode, errorMessage = utils.INVALID_REQUEST, &message
+	}
+	quotas := metadata.Image().ClientQuotas
+	keys := []string{}
+	if errorCode == utils.NONE {
+		for key := range quotas {
+			if matchesClientQuotaFilter(metadata.ParseClientQuotaEntityKey(key), describeRequest) {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	utils.WriteCompactNullableString(&body, errorMessage)
+	if errorCode != utils.NONE {
+		utils.WriteCompactArrayLength(&body, -1)
+	} else {
+		utils.WriteCompactArrayLength(&body, len(keys))
+	}
+	for _, key := range keys {
+		writeClientQuotaEntity(&body, metadata.ParseClientQuotaEntityKey(key))
+		names := []string{}
+		for name := range quotas[key] {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		utils.WriteCompactArrayLength(&body, len(names))
+		for _, name := range names {
+			utils.WriteCompactString(&body, name)
+			binary.Write(&body, binary.BigEndian, quotas[key][name])
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeAlterClientQuotas(req request.Request) ([]byte, error) {
+	alterRequest := req.AlterClientQuotasRequest
+
+	entries := []controller.ClientQuotaEntry{}
+	for _, entry := range alterRequest.Entries {
+		alterEntry := controller.ClientQuotaEntry{Entity: []metadata.ClientQuotaEntity{}}
+		for _, entity := range entry.Entity {
+			alterEntry.Entity = append(alterEntry.Entity, metadata.ClientQuotaEntity{EntityType: entity.EntityType, EntityName: entity.EntityName})
+		}
+		for _, op := range entry.Ops {
+			alterEntry.Ops = append(alterEntry.Ops, controller.ClientQuotaOp{Key: op.Key, Value: op.Value, Remove: op.Remove})
+		}
+		entries = append(entries, alterEntry)
+	}
+
+	errorCode := utils.NONE
+	if !isClusterAuthorized(req, authorizer.OPERATION_ALTER_CONFIGS) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if controller.Controller() == nil {
+		errorCode = utils.NOT_CONTROLLER
+	}
+	results := []controller.ClientQuotaResult{}
+	if errorCode != utils.NONE {
+		for _, entry := range entries {
+			results = append(results, controller.ClientQuotaResult{Entity: entry.Entity, ErrorCode: errorCode})
+		}
+	} else {
+		results = controller.Controller().AlterClientQuotas(entries, alterRequest.ValidateOnly)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(results))
+	for _, result := range results {
+		binary.Write(&body, binary.BigEndian, result.ErrorCode)
+		utils.WriteCompactNullableString(&body, result.ErrorMessage)
+		writeClientQuotaEntity(&body, result.Entity)
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
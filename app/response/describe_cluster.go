@@ -0,0 +1,58 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func SerializeDescribeCluster(req request.Request) ([]byte, error) {
+	describeClusterRequest := req.DescribeClusterRequest
+
+	// Every listener of this broker is a broker endpoint
+	errorCode := utils.NONE
+	switch describeClusterRequest.EndpointType {
+	case request.ENDPOINT_TYPE_BROKER:
+	case request.ENDPOINT_TYPE_CONTROLLER:
+		errorCode = utils.MISMATCHED_ENDPOINT_TYPE
+	default:
+		errorCode = utils.UNSUPPORTED_ENDPOINT_TYPE
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	// error_message
+	utils.WriteCompactNullableString(&body, nil)
+	if req.ApiVersion >= 1 {
+		binary.Write(&body, binary.BigEndian, describeClusterRequest.EndpointType)
+	}
+	utils.WriteCompactString(&body, utils.ClusterID)
+	binary.Write(&body, binary.BigEndian, controllerId())
+
+	brokers := []metadataBroker{}
+	if errorCode == utils.NONE {
+		brokers = liveBrokers(metadata.Image(), req.ListenerName)
+	}
+	utils.WriteCompactArrayLength(&body, len(brokers))
+	for _, broker := range brokers {
+		binary.Write(&body, binary.BigEndian, broker.NodeID)
+		utils.WriteCompactString(&body, broker.Host)
+		binary.Write(&body, binary.BigEndian, broker.Port)
+		utils.WriteCompactNullableString(&body, broker.Rack)
+		utils.WriteTaggedFields(&body)
+	}
+
+	authorizedOperations := AUTHORIZED_OPERATIONS_OMITTED
+	if describeClusterRequest.IncludeClusterAuthorizedOperations {
+		authorizedOperations = clusterAuthorizedOperations(requestContext(req))
+	}
+	binary.Write(&body, binary.BigEndian, authorizedOperations)
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
@@ -0,0 +1,71 @@
+package response
+
+import (
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// DESCRIBE_TOPIC_PARTITIONS_LIMIT caps the partitions in one
+// DescribeTopicPartitions response, as max.request.partition.size.limit does
+const DESCRIBE_TOPIC_PARTITIONS_LIMIT = 2000
+
+type describedTopic struct {
+	Name       string
+	Topic      *metadata.ClusterTopic
+	Partitions []metadata.ClusterTopicPartition
+}
+
+// describeTopicPartitionsPage picks the topics and partitions of one
+// response: topics sorted by name from the cursor on, with at most the
+// partition limit across them. It returns the cursor of the next page, or nil
+// once nothing is left.
+func describeTopicPartitionsPage(ctx authorizer.RequestContext, describeRequest *request.Describe_Topic_Partition_Request) ([]describedTopic, *request.Describe_Topic_Partition_Cursor) {
+	names := slices.Clone(describeRequest.TopicArray)
+	if len(names) == 0 {
+		// Only the topics the client may describe are listed
+		names = slices.DeleteFunc(metadata.Image().TopicNames(), func(name string) bool {
+			return !authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, name)
+		})
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	limit := int(describeRequest.ResponsePartitionLimit)
+	if limit <= 0 || limit > DESCRIBE_TOPIC_PARTITIONS_LIMIT {
+		limit = DESCRIBE_TOPIC_PARTITIONS_LIMIT
+	}
+	cursor := describeRequest.Cursor
+
+	topics := []describedTopic{}
+	for i, name := range names {
+		if cursor != nil && name < cursor.TopicName {
+			continue
+		}
+		clusterTopic := metadata.GetClusterTopic(name)
+		if !authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, name) {
+			clusterTopic = &metadata.ClusterTopic{ErrorCode: utils.TOPIC_AUTHORIZATION_FAILED, Name: name}
+		}
+		partitions := clusterTopic.Partitions
+		if cursor != nil && name == cursor.TopicName {
+			first := slices.IndexFunc(partitions, func(p metadata.ClusterTopicPartition) bool { return p.PartitionIndex >= cursor.PartitionIndex })
+			if first < 0 {
+				first = len(partitions)
+			}
+			partitions = partitions[first:]
+		}
+		if len(partitions) > limit {
+			topics = append(topics, describedTopic{Name: name, Topic: clusterTopic, Partitions: partitions[:limit]})
+			return topics, &request.Describe_Topic_Partition_Cursor{TopicName: name, PartitionIndex: partitions[limit].PartitionIndex}
+		}
+		topics = append(topics, describedTopic{Name: name, Topic: clusterTopic, Partitions: partitions})
+		limit -= len(partitions)
+		if limit == 0 && i+1 < len(names) {
+			return topics, &request.Describe_Topic_Partition_Cursor{TopicName: names[i+1], PartitionIndex: 0}
+		}
+	}
+	return topics, nil
+}
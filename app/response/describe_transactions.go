@@ -0,0 +1,176 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/txn"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func activeProducers(topicName string, partitionIndex int32) ([]storage.ProducerStateEntry, int16) {
+	clusterTopic := metadata.GetClusterTopic(topicName)
+	if clusterTopic.ErrorCode != utils.NONE || findPartition(clusterTopic, partitionIndex) == nil {
+		return nil, utils.UNKNOWN_TOPIC_OR_PARTITION
+	}
+	partitionLog, err := storage.GetLog(topicName, partitionIndex)
+	if err != nil {
+		log.Printf("Failed to open log for %s-%d: %s\n", topicName, partitionIndex, err.Error())
+		return nil, utils.UNKNOWN_SERVER_ERROR
+	}
+	return partitionLog.ActiveProducers(), utils.NONE
+}
+
+func SerializeDescribeProducers(req request.Request) ([]byte, error) {
+	describeProducersRequest := req.DescribeProducersRequest
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(describeProducersRequest.Topics))
+	for _, topic := range describeProducersRequest.Topics {
+		utils.WriteCompactString(&body, topic.Name)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		authorized := isAuthorized(req, authorizer.OPERATION_READ, authorizer.RESOURCE_TOPIC, topic.Name)
+		for _, partition := range topic.Partitions {
+			producers, errorCode := []storage.ProducerStateEntry{}, utils.TOPIC_AUTHORIZATION_FAILED
+			if authorized {
+				producers, errorCode = activeProducers(topic.Name, partition)
+			}
+
+			binary.Write(&body, binary.BigEndian, partition)
+			binary.Write(&body, binary.BigEndian, errorCode)
+			// error_message
+			utils.WriteCompactNullableString(&body, nil)
+			utils.WriteCompactArrayLength(&body, len(producers))
+			for _, producer := range producers {
+				lastSequence := int32(-1)
+				if len(producer.Batches) > 0 {
+					lastSequence = producer.Batches[len(producer.Batches)-1].LastSeq
+				}
+				binary.Write(&body, binary.BigEndian, producer.ProducerID)
+				binary.Write(&body, binary.BigEndian, int32(producer.ProducerEpoch))
+				binary.Write(&body, binary.BigEndian, lastSequence)
+				binary.Write(&body, binary.BigEndian, producer.LastTimestamp)
+				binary.Write(&body, binary.BigEndian, producer.CoordinatorEpoch)
+				binary.Write(&body, binary.BigEndian, producer.CurrentTxnFirstOffset)
+				utils.WriteTaggedFields(&body)
+			}
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func writeTransactionTopics(body *bytes.Buffer, partitions []txn.TopicPartition) {
+	topics := []request.Txn_Topic{}
+	for _, tp := range partitions {
+		if len(topics) == 0 || topics[len(topics)-1].Name != tp.Topic {
+			topics = append(topics, request.Txn_Topic{Name: tp.Topic})
+		}
+		topics[len(topics)-1].Partitions = append(topics[len(topics)-1].Partitions, tp.Partition)
+	}
+
+	utils.WriteCompactArrayLength(body, len(topics))
+	for _, topic := range topics {
+		utils.WriteCompactString(body, topic.Name)
+		utils.WriteINT32Array(body, topic.Partitions)
+		utils.WriteTaggedFields(body)
+	}
+}
+
+func SerializeDescribeTransactions(req request.Request) ([]byte, error) {
+	describeTransactionsRequest := req.DescribeTransactionsRequest
+	coordinator := txn.Coordinator()
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(describeTransactionsRequest.TransactionalIDs))
+	for _, transactionalId := range describeTransactionsRequest.TransactionalIDs {
+		m := txn.TransactionMetadata{ProducerID: -1, ProducerEpoch: -1, StartTimestamp: -1}
+		errorCode := utils.COORDINATOR_NOT_AVAILABLE
+		if !isAuthorized(req, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TRANSACTIONAL_ID, transactionalId) {
+			errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+		} else if coordinator != nil {
+			if described, ok := coordinator.DescribeTransaction(transactionalId); ok {
+				m = described
+				errorCode = utils.NONE
+			} else {
+				errorCode = utils.TRANSACTIONAL_ID_NOT_FOUND
+			}
+		}
+
+		binary.Write(&body, binary.BigEndian, errorCode)
+		utils.WriteCompactString(&body, transactionalId)
+		if errorCode == utils.NONE {
+			utils.WriteCompactString(&body, m.State.String())
+		} else {
+			utils.WriteCompactString(&body, "")
+		}
+		binary.Write(&body, binary.BigEndian, m.TimeoutMs)
+		binary.Write(&body, binary.BigEndian, m.StartTimestamp)
+		binary.Write(&body, binary.BigEndian, m.ProducerID)
+		binary.Write(&body, binary.BigEndian, m.ProducerEpoch)
+		writeTransactionTopics(&body, m.SortedPartitions())
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeListTransactions(req request.Request) ([]byte, error) {
+	listTransactionsRequest := req.ListTransactionsRequest
+	coordinator := txn.Coordinator()
+
+	states := []txn.TransactionState{}
+	unknownStates := []string{}
+	for _, name := range listTransactionsRequest.StateFilters {
+		if state, ok := txn.ParseTransactionState(name); ok {
+			states = append(states, state)
+		} else {
+			unknownStates = append(unknownStates, name)
+		}
+	}
+
+	errorCode := utils.COORDINATOR_NOT_AVAILABLE
+	transactions := []txn.TransactionMetadata{}
+	if coordinator != nil {
+		errorCode = utils.NONE
+		// Filtering on unknown states alone would match every transaction
+		if len(states) > 0 || len(unknownStates) == 0 {
+			listed := coordinator.ListTransactions(states, listTransactionsRequest.ProducerIDFilters, listTransactionsRequest.DurationFilterMs, time.Now().UnixMilli())
+			for _, m := range listed {
+				if isAuthorized(req, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TRANSACTIONAL_ID, m.TransactionalID) {
+					transactions = append(transactions, m)
+				}
+			}
+		}
+	}
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	utils.WriteCompactStringArray(&body, unknownStates)
+	utils.WriteCompactArrayLength(&body, len(transactions))
+	for _, m := range transactions {
+		utils.WriteCompactString(&body, m.TransactionalID)
+		binary.Write(&body, binary.BigEndian, m.ProducerID)
+		utils.WriteCompactString(&body, m.State.String())
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
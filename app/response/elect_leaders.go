@@ -0,0 +1,54 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func writePartitionResults(body *bytes.Buffer, results []controller.TopicPartitionResults) {
+	utils.WriteCompactArrayLength(body, len(results))
+	for _, topic := range results {
+		utils.WriteCompactString(body, topic.Topic)
+		utils.WriteCompactArrayLength(body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			binary.Write(body, binary.BigEndian, partition.Partition)
+			binary.Write(body, binary.BigEndian, partition.ErrorCode)
+			// error_message
+			utils.WriteCompactNullableString(body, nil)
+			utils.WriteTaggedFields(body)
+		}
+		utils.WriteTaggedFields(body)
+	}
+}
+
+func SerializeElectLeaders(req request.Request) ([]byte, error) {
+	electLeadersRequest := req.ElectLeadersRequest
+
+	errorCode, results := utils.NOT_CONTROLLER, []controller.TopicPartitionResults{}
+	if !isClusterAuthorized(req, authorizer.OPERATION_ALTER) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if quorumController := controller.Controller(); quorumController != nil {
+		var topics []controller.TopicPartitions
+		if electLeadersRequest.TopicPartitions != nil {
+			topics = []controller.TopicPartitions{}
+			for _, topic := range electLeadersRequest.TopicPartitions {
+				topics = append(topics, controller.TopicPartitions(topic))
+			}
+		}
+		errorCode, results = quorumController.ElectLeaders(electLeadersRequest.ElectionType, topics)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	writePartitionResults(&body, results)
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
@@ -0,0 +1,275 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/replica"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const FETCH_POLL_INTERVAL = 10 * time.Millisecond
+
+type fetchPartitionResult struct {
+	PartitionIndex      int32
+	ErrorCode           int16
+	HighWatermark       int64
+	LastStableOffset    int64
+	LogStartOffset      int64
+	AbortedTransactions []storage.AbortedTxn
+	DivergingEpoch      *replica.EpochEndOffset
+	Records             []byte
+}
+
+// writeFetchPartitionTaggedFields ends a partition of a Fetch response, with
+// diverging_epoch as tagged field 0 when the fetcher's log diverged.
+func writeFetchPartitionTaggedFields(body *bytes.Buffer, divergingEpoch *replica.EpochEndOffset) {
+	if divergingEpoch == nil {
+		utils.WriteTaggedFields(body)
+		return
+	}
+	var field bytes.Buffer
+	binary.Write(&field, binary.BigEndian, divergingEpoch.Epoch)
+	binary.Write(&field, binary.BigEndian, divergingEpoch.EndOffset)
+	utils.WriteTaggedFields(&field)
+	utils.WriteTaggedFieldValues(body, map[uint64][]byte{0: field.Bytes()})
+}
+
+// readPartition reads from the fetch offset up to the high watermark, or up
+// to the last stable offset for read_committed consumers along with the
+// transactions aborted in that range.
+func readPartition(topicName string, clusterTopic *metadata.ClusterTopic, partition request.Fetch_Request_Partition, isolationLevel int8, maxBytes int) fetchPartitionResult {
+	result := fetchPartitionResult{
+		PartitionIndex:   partition.PartitionID,
+		HighWatermark:    -1,
+		LastStableOffset: -1,
+		LogStartOffset:   -1,
+	}
+	if clusterTopic.ErrorCode != utils.NONE {
+		result.ErrorCode = clusterTopic.ErrorCode
+		return result
+	}
+	if findPartition(clusterTopic, partition.PartitionID) == nil {
+		result.ErrorCode = utils.UNKNOWN_TOPIC_OR_PARTITION
+		return result
+	}
+	// Consumers read from the leader of a replicated partition
+	if manager := replica.Manager(); manager != nil {
+		if p := manager.Partition(clusterTopic.TopicId, partition.PartitionID); p != nil && !p.IsLeader() {
+			result.ErrorCode = utils.NOT_LEADER_OR_FOLLOWER
+			return result
+		}
+	}
+
+	partitionLog, err := storage.GetLog(topicName, partition.PartitionID)
+	if err != nil {
+		log.Printf("Failed to open log for %s-%d: %s\n", topicName, partition.PartitionID, err.Error())
+		result.ErrorCode = utils.UNKNOWN_SERVER_ERROR
+		return result
+	}
+	result.HighWatermark = partitionLog.HighWatermark()
+	result.LastStableOffset = partitionLog.LastStableOffset()
+	result.LogStartOffset = partitionLog.LogStartOffset()
+	if partition.LastFetchedEpoch >= 0 {
+		epoch, endOffset := partitionLog.EndOffsetForEpoch(partition.LastFetchedEpoch)
+		if epoch != partition.LastFetchedEpoch || endOffset < partition.FetchOffset {
+			result.DivergingEpoch = &replica.EpochEndOffset{Epoch: epoch, EndOffset: endOffset}
+			return result
+		}
+	}
+
+	maxOffset := result.HighWatermark
+	if isolationLevel == request.READ_COMMITTED {
+		maxOffset = result.LastStableOffset
+	}
+	if maxBytes <= 0 || partition.FetchOffset == maxOffset {
+		return result
+	}
+	result.Records, err = partitionLog.Read(partition.FetchOffset, maxOffset, maxBytes)
+	if err != nil {
+		result.ErrorCode = storageErrorCode(err)
+		return result
+	}
+	if isolationLevel == request.READ_COMMITTED {
+		result.AbortedTransactions = partitionLog.CollectAbortedTxns(partition.FetchOffset, maxOffset)
+	}
+	return result
+}
+
+func readFetchTopics(req request.Request) ([][]fetchPartitionResult, int, bool) {
+	fetchRequest := req.FetchRequest
+	results := make([][]fetchPartitionResult, len(fetchRequest.Topics))
+	remaining := int(fetchRequest.MaxBytes)
+	hasError := false
+
+	for i, topic := range fetchRequest.Topics {
+		topicName, clusterTopic := metadata.GetClusterTopicById(topic.TopicID)
+		authorized := clusterTopic.ErrorCode != utils.NONE || isAuthorized(req, authorizer.OPERATION_READ, authorizer.RESOURCE_TOPIC, topicName)
+		for _, partition := range topic.Partitions {
+			if !authorized {
+				results[i] = append(results[i], fetchPartitionResult{
+					PartitionIndex:   partition.PartitionID,
+					ErrorCode:        utils.TOPIC_AUTHORIZATION_FAILED,
+					HighWatermark:    -1,
+					LastStableOffset: -1,
+					LogStartOffset:   -1,
+				})
+				hasError = true
+				continue
+			}
+			maxBytes := min(remaining, int(partition.PartitionMaxBytes))
+			result := readPartition(topicName, clusterTopic, partition, fetchRequest.IsolationLevel, maxBytes)
+			remaining -= len(result.Records)
+			hasError = hasError || result.ErrorCode != utils.NONE || result.DivergingEpoch != nil
+			results[i] = append(results[i], result)
+		}
+	}
+	return results, int(fetchRequest.MaxBytes) - remaining, hasError
+}
+
+// fetchesMetadataLog reports whether a replica is fetching the metadata log
+// from the quorum.
+func fetchesMetadataLog(fetchRequest *request.Fetch_Request) bool {
+	for _, topic := range fetchRequest.Topics {
+		if topic.TopicID == raft.METADATA_TOPIC_ID {
+			return true
+		}
+	}
+	return false
+}
+
+func SerializeVersion16(req request.Request) ([]byte, error) {
+	fetchRequest := req.FetchRequest
+	// Replicas fetch on behalf of the cluster
+	if (fetchRequest.ReplicaID >= 0 || fetchesMetadataLog(fetchRequest)) && !isClusterAuthorized(req, authorizer.OPERATION_CLUSTER_ACTION) {
+		var body bytes.Buffer
+		// throttle_time_ms
+		binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+		binary.Write(&body, binary.BigEndian, utils.CLUSTER_AUTHORIZATION_FAILED)
+		binary.Write(&body, binary.BigEndian, int32(0))
+		utils.WriteCompactArrayLength(&body, 0)
+		utils.WriteTaggedFields(&body)
+		return writeFlexibleResponse(req, &body), nil
+	}
+	if node := raft.Node(); node != nil && fetchesMetadataLog(fetchRequest) {
+		return serializeRaftFetch(req, node)
+	}
+	if manager := replica.Manager(); manager != nil && fetchRequest.ReplicaID >= 0 {
+		return serializeReplicaFetch(req, manager)
+	}
+
+	// Wait up to max_wait_ms for min_bytes of data, unless a partition failed
+	deadline := time.Now().Add(time.Duration(fetchRequest.MaxWaitMs) * time.Millisecond)
+	results, size, hasError := readFetchTopics(req)
+	for !hasError && size < int(fetchRequest.MinBytes) && time.Now().Before(deadline) {
+		time.Sleep(FETCH_POLL_INTERVAL)
+		results, size, hasError = readFetchTopics(req)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	// Fetch sessions are not supported, so every response is a full one
+	binary.Write(&body, binary.BigEndian, int32(0))
+
+	utils.WriteCompactArrayLength(&body, len(fetchRequest.Topics))
+	for i, topic := range fetchRequest.Topics {
+		body.Write(topic.TopicID.Bytes())
+		utils.WriteCompactArrayLength(&body, len(results[i]))
+
+		for _, result := range results[i] {
+			binary.Write(&body, binary.BigEndian, result.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, result.ErrorCode)
+			binary.Write(&body, binary.BigEndian, result.HighWatermark)
+			binary.Write(&body, binary.BigEndian, result.LastStableOffset)
+			binary.Write(&body, binary.BigEndian, result.LogStartOffset)
+			if fetchRequest.IsolationLevel == request.READ_COMMITTED {
+				utils.WriteCompactArrayLength(&body, len(result.AbortedTransactions))
+				for _, abortedTxn := range result.AbortedTransactions {
+					binary.Write(&body, binary.BigEndian, abortedTxn.ProducerID)
+					binary.Write(&body, binary.BigEndian, abortedTxn.FirstOffset)
+					utils.WriteTaggedFields(&body)
+				}
+			} else {
+				utils.WriteCompactArrayLength(&body, -1)
+			}
+			// preferred_read_replica
+			binary.Write(&body, binary.BigEndian, int32(-1))
+			utils.WriteCompactBytes(&body, result.Records)
+			writeFetchPartitionTaggedFields(&body, result.DivergingEpoch)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+// serializeReplicaFetch serves a follower of the partitions this broker
+// leads.
+func serializeReplicaFetch(req request.Request, manager *replica.ReplicaManager) ([]byte, error) {
+	fetchRequest := req.FetchRequest
+	replicaRequest := replica.FetchRequest{
+		ReplicaID:    fetchRequest.ReplicaID,
+		ReplicaEpoch: fetchRequest.ReplicaEpoch,
+		MaxWait:      time.Duration(fetchRequest.MaxWaitMs) * time.Millisecond,
+		MinBytes:     fetchRequest.MinBytes,
+		MaxBytes:     fetchRequest.MaxBytes,
+	}
+	for _, topic := range fetchRequest.Topics {
+		for _, partition := range topic.Partitions {
+			replicaRequest.Partitions = append(replicaRequest.Partitions, replica.FetchPartition{
+				TopicID:            topic.TopicID,
+				Partition:          partition.PartitionID,
+				CurrentLeaderEpoch: partition.CurrentLeaderEpoch,
+				FetchOffset:        partition.FetchOffset,
+				LastFetchedEpoch:   partition.LastFetchedEpoch,
+				LogStartOffset:     partition.LogStartOffset,
+				MaxBytes:           partition.PartitionMaxBytes,
+			})
+		}
+	}
+	results := manager.HandleFetch(replicaRequest)
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	binary.Write(&body, binary.BigEndian, int32(0))
+
+	// HandleFetch answers the partitions in the order they were requested
+	utils.WriteCompactArrayLength(&body, len(fetchRequest.Topics))
+	for _, topic := range fetchRequest.Topics {
+		body.Write(topic.TopicID.Bytes())
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for range topic.Partitions {
+			result := results[0]
+			results = results[1:]
+
+			binary.Write(&body, binary.BigEndian, result.Partition)
+			binary.Write(&body, binary.BigEndian, result.ErrorCode)
+			binary.Write(&body, binary.BigEndian, result.HighWatermark)
+			// last_stable_offset
+			binary.Write(&body, binary.BigEndian, result.HighWatermark)
+			binary.Write(&body, binary.BigEndian, result.LogStartOffset)
+			// aborted_transactions
+			utils.WriteCompactArrayLength(&body, -1)
+			// preferred_read_replica
+			binary.Write(&body, binary.BigEndian, int32(-1))
+			utils.WriteCompactBytes(&body, result.Records)
+			writeFetchPartitionTaggedFields(&body, result.DivergingEpoch)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
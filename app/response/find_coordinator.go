@@ -0,0 +1,50 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// SerializeFindCoordinator answers with this broker for every key, as it
+// hosts the single partition of both internal coordinator topics.
+func SerializeFindCoordinator(req request.Request) ([]byte, error) {
+	findCoordinatorRequest := req.FindCoordinatorRequest
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(findCoordinatorRequest.CoordinatorKeys))
+	for _, key := range findCoordinatorRequest.CoordinatorKeys {
+		endpoint, ok := utils.AdvertisedEndpoint(req.ListenerName)
+		nodeId, host, port, errorCode := utils.BrokerID, endpoint.Host, endpoint.Port, utils.NONE
+		if findCoordinatorRequest.KeyType == request.COORDINATOR_KEY_TYPE_TRANSACTION {
+			if !isAuthorized(req, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TRANSACTIONAL_ID, key) {
+				errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+			}
+		} else if !isAuthorized(req, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_GROUP, key) {
+			errorCode = utils.GROUP_AUTHORIZATION_FAILED
+		}
+		// This broker has no endpoint for the client's listener
+		if errorCode == utils.NONE && !ok {
+			errorCode = utils.COORDINATOR_NOT_AVAILABLE
+		}
+		if errorCode != utils.NONE {
+			nodeId, host, port = -1, "", -1
+		}
+		utils.WriteCompactString(&body, key)
+		binary.Write(&body, binary.BigEndian, nodeId)
+		utils.WriteCompactString(&body, host)
+		binary.Write(&body, binary.BigEndian, port)
+		binary.Write(&body, binary.BigEndian, errorCode)
+		// error_message
+		utils.WriteCompactNullableString(&body, nil)
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
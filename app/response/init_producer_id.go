@@ -0,0 +1,40 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/producer"
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/txn"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func SerializeInitProducerId(req request.Request) ([]byte, error) {
+	initProducerIdRequest := req.InitProducerIdRequest
+
+	producerId, producerEpoch, errorCode := record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.COORDINATOR_NOT_AVAILABLE
+	if initProducerIdRequest.TransactionalID == nil && !isClusterAuthorized(req, authorizer.OPERATION_IDEMPOTENT_WRITE) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if initProducerIdRequest.TransactionalID != nil &&
+		!isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TRANSACTIONAL_ID, *initProducerIdRequest.TransactionalID) {
+		errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if initProducerIdRequest.TransactionalID == nil {
+		producerId, producerEpoch, errorCode = producer.InitProducerId(initProducerIdRequest.ProducerID, initProducerIdRequest.ProducerEpoch)
+	} else if coordinator := txn.Coordinator(); coordinator != nil {
+		producerId, producerEpoch, errorCode = coordinator.InitProducerId(*initProducerIdRequest.TransactionalID,
+			initProducerIdRequest.TransactionTimeoutMs, initProducerIdRequest.ProducerID, initProducerIdRequest.ProducerEpoch)
+	}
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	binary.Write(&body, binary.BigEndian, producerId)
+	binary.Write(&body, binary.BigEndian, producerEpoch)
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
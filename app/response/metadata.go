@@ -0,0 +1,177 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// Sent in place of the authorized operations a client did not ask for
+const AUTHORIZED_OPERATIONS_OMITTED int32 = math.MinInt32
+
+type metadataBroker struct {
+	NodeID int32
+	Host   string
+	Port   int32
+	Rack   *string
+}
+
+// liveBrokers lists the unfenced brokers at their endpoint for the listener
+// the client connected on, leaving out brokers without one. Without
+// registrations this broker only knows about itself.
+func liveBrokers(image *metadata.MetadataImage, listenerName string) []metadataBroker {
+	if len(image.Brokers) == 0 {
+		endpoint, ok := utils.AdvertisedEndpoint(listenerName)
+		if !ok {
+			return []metadataBroker{}
+		}
+		return []metadataBroker{{NodeID: utils.BrokerID, Host: endpoint.Host, Port: endpoint.Port}}
+	}
+	brokers := []metadataBroker{}
+	for _, broker := range image.LiveBrokers() {
+		for _, endpoint := range broker.EndPoints {
+			if endpoint.Name == listenerName || listenerName == "" {
+				brokers = append(brokers, metadataBroker{NodeID: broker.BrokerID, Host: endpoint.Host, Port: int32(endpoint.Port), Rack: broker.Rack})
+				break
+			}
+		}
+	}
+	return brokers
+}
+
+// partitionErrorCode is LISTENER_NOT_FOUND for partitions whose leader is
+// live but has no endpoint among brokers, which the client cannot reach.
+func partitionErrorCode(image *metadata.MetadataImage, brokers []metadataBroker, partition metadata.ClusterTopicPartition) int16 {
+	if partition.ErrorCode != utils.NONE || len(image.Brokers) == 0 {
+		return partition.ErrorCode
+	}
+	leader, ok := image.Brokers[partition.LeaderID]
+	if ok && !leader.Fenced && !slices.ContainsFunc(brokers, func(broker metadataBroker) bool { return broker.NodeID == partition.LeaderID }) {
+		return utils.LISTENER_NOT_FOUND
+	}
+	return utils.NONE
+}
+
+// controllerId is the active controller as the metadata quorum last saw it.
+func controllerId() int32 {
+	if node := raft.Node(); node != nil {
+		return node.LeaderAndEpoch().LeaderID
+	}
+	return -1
+}
+
+// requestedTopics resolves the requested topics by name, or by id from
+// version 10. A nil list asks for every topic the client may describe.
+func requestedTopics(ctx authorizer.RequestContext, image *metadata.MetadataImage, topics []request.Metadata_Request_Topic) []*metadata.ClusterTopic {
+	clusterTopics := []*metadata.ClusterTopic{}
+	if topics == nil {
+		for _, name := range image.TopicNames() {
+			if authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, name) {
+				clusterTopics = append(clusterTopics, metadata.GetClusterTopic(name))
+			}
+		}
+		return clusterTopics
+	}
+	for _, topic := range topics {
+		if topic.Name != nil {
+			if !authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, *topic.Name) {
+				clusterTopics = append(clusterTopics, &metadata.ClusterTopic{ErrorCode: utils.TOPIC_AUTHORIZATION_FAILED, Name: *topic.Name})
+				continue
+			}
+			clusterTopic := metadata.GetClusterTopic(*topic.Name)
+			if clusterTopic.ErrorCode != utils.NONE {
+				clusterTopic = &metadata.ClusterTopic{ErrorCode: clusterTopic.ErrorCode, Name: *topic.Name}
+			}
+			clusterTopics = append(clusterTopics, clusterTopic)
+			continue
+		}
+		name, clusterTopic := metadata.GetClusterTopicById(topic.TopicID)
+		if clusterTopic.ErrorCode != utils.NONE {
+			clusterTopic = &metadata.ClusterTopic{ErrorCode: clusterTopic.ErrorCode, TopicId: topic.TopicID}
+		} else if !authorizer.IsAuthorized(ctx, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, name) {
+			clusterTopic = &metadata.ClusterTopic{ErrorCode: utils.TOPIC_AUTHORIZATION_FAILED, TopicId: topic.TopicID}
+		}
+		clusterTopics = append(clusterTopics, clusterTopic)
+	}
+	return clusterTopics
+}
+
+func SerializeMetadata(req request.Request) ([]byte, error) {
+	metadataRequest := req.MetadataRequest
+	image := metadata.Image()
+	ctx := requestContext(req)
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+
+	brokers := liveBrokers(image, req.ListenerName)
+	utils.WriteCompactArrayLength(&body, len(brokers))
+	for _, broker := range brokers {
+		binary.Write(&body, binary.BigEndian, broker.NodeID)
+		utils.WriteCompactString(&body, broker.Host)
+		binary.Write(&body, binary.BigEndian, broker.Port)
+		utils.WriteCompactNullableString(&body, broker.Rack)
+		utils.WriteTaggedFields(&body)
+	}
+
+	clusterId := &utils.ClusterID
+	if utils.ClusterID == "" {
+		clusterId = nil
+	}
+	utils.WriteCompactNullableString(&body, clusterId)
+	binary.Write(&body, binary.BigEndian, controllerId())
+
+	topics := requestedTopics(ctx, image, metadataRequest.Topics)
+	utils.WriteCompactArrayLength(&body, len(topics))
+	for _, topic := range topics {
+		binary.Write(&body, binary.BigEndian, topic.ErrorCode)
+		if topic.Name == "" {
+			utils.WriteCompactNullableString(&body, nil)
+		} else {
+			utils.WriteCompactString(&body, topic.Name)
+		}
+		if req.ApiVersion >= 10 {
+			body.Write(topic.TopicId.Bytes())
+		}
+		// is_internal
+		binary.Write(&body, binary.BigEndian, false)
+
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			binary.Write(&body, binary.BigEndian, partitionErrorCode(image, brokers, partition))
+			binary.Write(&body, binary.BigEndian, partition.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, partition.LeaderID)
+			binary.Write(&body, binary.BigEndian, partition.LeaderEpoch)
+			utils.WriteINT32Array(&body, partition.ReplicaNodeIDs)
+			utils.WriteINT32Array(&body, partition.InsyncReplicaNodeIDs)
+			utils.WriteINT32Array(&body, partition.OfflineReplicaNodeIDs)
+			utils.WriteTaggedFields(&body)
+		}
+
+		authorizedOperations := AUTHORIZED_OPERATIONS_OMITTED
+		if metadataRequest.IncludeTopicAuthorizedOperations && topic.ErrorCode == utils.NONE {
+			authorizedOperations = topicAuthorizedOperations(ctx, topic.Name)
+		}
+		binary.Write(&body, binary.BigEndian, authorizedOperations)
+		utils.WriteTaggedFields(&body)
+	}
+
+	if req.ApiVersion <= 10 {
+		authorizedOperations := AUTHORIZED_OPERATIONS_OMITTED
+		if metadataRequest.IncludeClusterAuthorizedOperations {
+			authorizedOperations = clusterAuthorizedOperations(ctx)
+		}
+		binary.Write(&body, binary.BigEndian, authorizedOperations)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
@@ -0,0 +1,80 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/replica"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// lastOffsetForLeaderEpoch finds where an epoch ends in a partition's log,
+// as its leader knows it.
+func lastOffsetForLeaderEpoch(topicName string, partition request.Offset_For_Leader_Epoch_Request_Partition) (int16, replica.EpochEndOffset) {
+	result := replica.EpochEndOffset{Epoch: -1, EndOffset: -1}
+	clusterTopic := metadata.GetClusterTopic(topicName)
+	clusterPartition := findPartition(clusterTopic, partition.Partition)
+	if clusterTopic.ErrorCode != utils.NONE || clusterPartition == nil {
+		return utils.UNKNOWN_TOPIC_OR_PARTITION, result
+	}
+	if manager := replica.Manager(); manager != nil {
+		if p := manager.Partition(clusterTopic.TopicId, partition.Partition); p != nil {
+			return p.LastOffsetForLeaderEpoch(partition.CurrentLeaderEpoch, partition.LeaderEpoch)
+		}
+	}
+
+	if errorCode := replica.CheckLeaderEpoch(partition.CurrentLeaderEpoch, clusterPartition.LeaderEpoch); errorCode != utils.NONE {
+		return errorCode, result
+	}
+	partitionLog, err := storage.GetLog(topicName, partition.Partition)
+	if err != nil {
+		log.Printf("Failed to open log for %s-%d: %s\n", topicName, partition.Partition, err.Error())
+		return utils.UNKNOWN_SERVER_ERROR, result
+	}
+	result.Epoch, result.EndOffset = partitionLog.EndOffsetForEpoch(partition.LeaderEpoch)
+	return utils.NONE, result
+}
+
+func SerializeOffsetForLeaderEpoch(req request.Request) ([]byte, error) {
+	offsetForLeaderEpochRequest := req.OffsetForLeaderEpochRequest
+
+	// Followers ask on behalf of the cluster, consumers need to describe the
+	// topic
+	replicaAuthorized := offsetForLeaderEpochRequest.ReplicaID < 0 || isClusterAuthorized(req, authorizer.OPERATION_CLUSTER_ACTION)
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(offsetForLeaderEpochRequest.Topics))
+	for _, topic := range offsetForLeaderEpochRequest.Topics {
+		authorizationError := utils.NONE
+		if !replicaAuthorized {
+			authorizationError = utils.CLUSTER_AUTHORIZATION_FAILED
+		} else if offsetForLeaderEpochRequest.ReplicaID < 0 && !isAuthorized(req, authorizer.OPERATION_DESCRIBE, authorizer.RESOURCE_TOPIC, topic.Topic) {
+			authorizationError = utils.TOPIC_AUTHORIZATION_FAILED
+		}
+		utils.WriteCompactString(&body, topic.Topic)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			errorCode, result := authorizationError, replica.EpochEndOffset{Epoch: -1, EndOffset: -1}
+			if errorCode == utils.NONE {
+				errorCode, result = lastOffsetForLeaderEpoch(topic.Topic, partition)
+			}
+
+			binary.Write(&body, binary.BigEndian, errorCode)
+			binary.Write(&body, binary.BigEndian, partition.Partition)
+			binary.Write(&body, binary.BigEndian, result.Epoch)
+			binary.Write(&body, binary.BigEndian, result.EndOffset)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
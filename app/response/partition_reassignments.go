@@ -0,0 +1,85 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func SerializeAlterPartitionReassignments(req request.Request) ([]byte, error) {
+	alterPartitionReassignmentsRequest := req.AlterPartitionReassignmentsRequest
+
+	errorCode, results := utils.NOT_CONTROLLER, []controller.TopicPartitionResults{}
+	if !isClusterAuthorized(req, authorizer.OPERATION_ALTER) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if quorumController := controller.Controller(); quorumController != nil {
+		topics := []controller.ReassignableTopic{}
+		for _, topic := range alterPartitionReassignmentsRequest.Topics {
+			reassignableTopic := controller.ReassignableTopic{Topic: topic.Name}
+			for _, partition := range topic.Partitions {
+				reassignableTopic.Partitions = append(reassignableTopic.Partitions, controller.ReassignablePartition{Partition: partition.PartitionIndex, Replicas: partition.Replicas})
+			}
+			topics = append(topics, reassignableTopic)
+		}
+		errorCode, results = quorumController.AlterPartitionReassignments(topics)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	// error_message
+	utils.WriteCompactNullableString(&body, nil)
+	writePartitionResults(&body, results)
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+// SerializeListPartitionReassignments answers from this broker's metadata
+// image, where the adding and removing replicas of each partition are kept.
+func SerializeListPartitionReassignments(req request.Request) ([]byte, error) {
+	listPartitionReassignmentsRequest := req.ListPartitionReassignmentsRequest
+
+	var topics []controller.TopicPartitions
+	if listPartitionReassignmentsRequest.Topics != nil {
+		topics = []controller.TopicPartitions{}
+		for _, topic := range listPartitionReassignmentsRequest.Topics {
+			topics = append(topics, controller.TopicPartitions{Topic: topic.Name, Partitions: topic.PartitionIndexes})
+		}
+	}
+	errorCode, ongoing := utils.NONE, []controller.OngoingTopicReassignment{}
+	if isClusterAuthorized(req, authorizer.OPERATION_DESCRIBE) {
+		ongoing = controller.ListPartitionReassignments(metadata.Image(), topics)
+	} else {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	// error_message
+	utils.WriteCompactNullableString(&body, nil)
+	utils.WriteCompactArrayLength(&body, len(ongoing))
+	for _, topic := range ongoing {
+		utils.WriteCompactString(&body, topic.Topic)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			binary.Write(&body, binary.BigEndian, partition.Partition)
+			utils.WriteINT32Array(&body, partition.Replicas)
+			utils.WriteINT32Array(&body, partition.AddingReplicas)
+			utils.WriteINT32Array(&body, partition.RemovingReplicas)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
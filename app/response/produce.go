@@ -0,0 +1,162 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/replica"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func storageErrorCode(err error) int16 {
+	switch err {
+	case nil:
+		return utils.NONE
+	case storage.ErrCorruptMessage:
+		return utils.CORRUPT_MESSAGE
+	case storage.ErrOutOfOrderSequence:
+		return utils.OUT_OF_ORDER_SEQUENCE_NUMBER
+	case storage.ErrDuplicateSequence:
+		return utils.DUPLICATE_SEQUENCE_NUMBER
+	case storage.ErrInvalidProducerEpoch:
+		return utils.INVALID_PRODUCER_EPOCH
+	case storage.ErrOffsetOutOfRange:
+		return utils.OFFSET_OUT_OF_RANGE
+	case replica.ErrNotLeader:
+		return utils.NOT_LEADER_OR_FOLLOWER
+	case replica.ErrNotEnoughReplicas:
+		return utils.NOT_ENOUGH_REPLICAS
+	case replica.ErrNotEnoughReplicasAfterAppend:
+		return utils.NOT_ENOUGH_REPLICAS_AFTER_APPEND
+	case replica.ErrRequestTimedOut:
+		return utils.REQUEST_TIMED_OUT
+	}
+	return utils.UNKNOWN_SERVER_ERROR
+}
+
+func findPartition(clusterTopic *metadata.ClusterTopic, partitionIndex int32) *metadata.ClusterTopicPartition {
+	for i := range clusterTopic.Partitions {
+		if clusterTopic.Partitions[i].PartitionIndex == partitionIndex {
+			return &clusterTopic.Partitions[i]
+		}
+	}
+	return nil
+}
+
+// producedPartition is a partition appended to by a produce request, with
+// what acks=all still has to wait for.
+type producedPartition struct {
+	info      storage.LogAppendInfo
+	errorCode int16
+	replica   *replica.Partition
+	minIsr    int
+}
+
+func appendRecords(topicName string, partition request.Produce_Request_Partition, acks int16) producedPartition {
+	result := producedPartition{info: storage.LogAppendInfo{FirstOffset: -1, LogStartOffset: -1}}
+
+	clusterTopic := metadata.GetClusterTopic(topicName)
+	clusterPartition := findPartition(clusterTopic, partition.Index)
+	if clusterTopic.ErrorCode != utils.NONE || clusterPartition == nil {
+		result.errorCode = utils.UNKNOWN_TOPIC_OR_PARTITION
+		return result
+	}
+
+	if manager := replica.Manager(); manager != nil {
+		if p := manager.Partition(clusterTopic.TopicId, partition.Index); p != nil {
+			result.replica = p
+			result.minIsr = manager.MinInsyncReplicas(metadata.Image(), topicName)
+			info, err := p.AppendAsLeader(partition.Records, acks, result.minIsr)
+			result.info, result.errorCode = info, storageErrorCode(err)
+			return result
+		}
+	}
+
+	partitionLog, err := storage.GetLog(topicName, partition.Index)
+	if err != nil {
+		log.Printf("Failed to open log for %s-%d: %s\n", topicName, partition.Index, err.Error())
+		result.errorCode = utils.UNKNOWN_SERVER_ERROR
+		return result
+	}
+	info, err := partitionLog.AppendAsLeader(partition.Records, clusterPartition.LeaderEpoch)
+	result.info, result.errorCode = info, storageErrorCode(err)
+	return result
+}
+
+func SerializeProduce(req request.Request) ([]byte, error) {
+	produceRequest := req.ProduceRequest
+
+	// Every partition is appended to before waiting on any of them, so that
+	// they replicate together
+	transactionalIdAuthorized := produceRequest.TransactionalID == nil ||
+		isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TRANSACTIONAL_ID, *produceRequest.TransactionalID)
+	results := make([][]producedPartition, len(produceRequest.Topics))
+	for i, topic := range produceRequest.Topics {
+		topicAuthorized := isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TOPIC, topic.Name)
+		for _, partition := range topic.Partitions {
+			result := producedPartition{info: storage.LogAppendInfo{FirstOffset: -1, LogStartOffset: -1}}
+			switch {
+			case !transactionalIdAuthorized:
+				result.errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+			case !topicAuthorized:
+				result.errorCode = utils.TOPIC_AUTHORIZATION_FAILED
+			default:
+				result = appendRecords(topic.Name, partition, produceRequest.Acks)
+			}
+			results[i] = append(results[i], result)
+		}
+	}
+	if produceRequest.Acks == -1 {
+		deadline := time.Now().Add(time.Duration(produceRequest.TimeoutMs) * time.Millisecond)
+		for i := range results {
+			for j := range results[i] {
+				result := &results[i][j]
+				if result.replica == nil || result.errorCode != utils.NONE {
+					continue
+				}
+				err := result.replica.WaitForAcks(result.info.LastOffset+1, result.minIsr, time.Until(deadline))
+				result.errorCode = storageErrorCode(err)
+			}
+		}
+	}
+
+	var body bytes.Buffer
+	utils.WriteCompactArrayLength(&body, len(produceRequest.Topics))
+	for i, topic := range produceRequest.Topics {
+		utils.WriteCompactString(&body, topic.Name)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+
+		for j, partition := range topic.Partitions {
+			result := results[i][j]
+
+			binary.Write(&body, binary.BigEndian, partition.Index)
+			binary.Write(&body, binary.BigEndian, result.errorCode)
+			// base_offset
+			binary.Write(&body, binary.BigEndian, result.info.FirstOffset)
+			// log_append_time_ms, -1 for CreateTime
+			binary.Write(&body, binary.BigEndian, int64(-1))
+			binary.Write(&body, binary.BigEndian, result.info.LogStartOffset)
+			// record_errors
+			utils.WriteCompactArrayLength(&body, 0)
+			// error_message
+			utils.WriteCompactNullableString(&body, nil)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteTaggedFields(&body)
+
+	// acks=0 producers do not wait for a response
+	if produceRequest.Acks == 0 {
+		return []byte{}, nil
+	}
+	return writeFlexibleResponse(req, &body), nil
+}
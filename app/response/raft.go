@@ -0,0 +1,306 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// The quorum only replicates __cluster_metadata-0
+func isMetadataPartition(topicName string, partitionIndex int32) bool {
+	return topicName == metadata.CLUSTER_METADATA_TOPIC && partitionIndex == raft.METADATA_PARTITION
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// quorumErrorCode is the top-level error of a request to the quorum from a
+// client that may not send it, or to a broker that is not a controller.
+func quorumErrorCode(req request.Request, node *raft.RaftNode, operation int8) int16 {
+	if node == nil {
+		return utils.NOT_CONTROLLER
+	}
+	if !isClusterAuthorized(req, operation) {
+		return utils.CLUSTER_AUTHORIZATION_FAILED
+	}
+	return utils.NONE
+}
+
+func writeLeaderAndEpoch(body *bytes.Buffer, leader raft.LeaderAndEpoch) {
+	binary.Write(body, binary.BigEndian, leader.LeaderID)
+	binary.Write(body, binary.BigEndian, leader.LeaderEpoch)
+}
+
+func SerializeVote(req request.Request) ([]byte, error) {
+	voteRequest := req.VoteRequest
+	node := raft.Node()
+
+	var body bytes.Buffer
+	if errorCode := quorumErrorCode(req, node, authorizer.OPERATION_CLUSTER_ACTION); errorCode != utils.NONE {
+		binary.Write(&body, binary.BigEndian, errorCode)
+		utils.WriteCompactArrayLength(&body, 0)
+		utils.WriteTaggedFields(&body)
+		return writeFlexibleResponse(req, &body), nil
+	}
+
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	utils.WriteCompactArrayLength(&body, len(voteRequest.Topics))
+	for _, topic := range voteRequest.Topics {
+		utils.WriteCompactString(&body, topic.TopicName)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			resp := raft.VoteResponse{ErrorCode: utils.UNKNOWN_TOPIC_OR_PARTITION, LeaderID: raft.NO_LEADER, LeaderEpoch: -1}
+			if isMetadataPartition(topic.TopicName, partition.PartitionIndex) {
+				resp = node.HandleVote(raft.VoteRequest{
+					ClusterID:          stringValue(voteRequest.ClusterID),
+					CandidateEpoch:     partition.CandidateEpoch,
+					CandidateID:        partition.CandidateID,
+					LastEpoch:          partition.LastOffsetEpoch,
+					LastEpochEndOffset: partition.LastOffset,
+				})
+			}
+			binary.Write(&body, binary.BigEndian, partition.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+			writeLeaderAndEpoch(&body, raft.LeaderAndEpoch{LeaderID: resp.LeaderID, LeaderEpoch: resp.LeaderEpoch})
+			binary.Write(&body, binary.BigEndian, resp.VoteGranted)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+// SerializeQuorumEpoch answers BeginQuorumEpoch and EndQuorumEpoch, whose
+// version 0 responses are not flexible.
+func SerializeQuorumEpoch(req request.Request) ([]byte, error) {
+	quorumEpochRequest := req.QuorumEpochRequest
+	node := raft.Node()
+
+	var body bytes.Buffer
+	if errorCode := quorumErrorCode(req, node, authorizer.OPERATION_CLUSTER_ACTION); errorCode != utils.NONE {
+		binary.Write(&body, binary.BigEndian, errorCode)
+		binary.Write(&body, binary.BigEndian, int32(0))
+		return writeResponse(req, &body), nil
+	}
+
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	binary.Write(&body, binary.BigEndian, int32(len(quorumEpochRequest.Topics)))
+	for _, topic := range quorumEpochRequest.Topics {
+		utils.WriteString(&body, topic.TopicName)
+		binary.Write(&body, binary.BigEndian, int32(len(topic.Partitions)))
+		for _, partition := range topic.Partitions {
+			resp := raft.QuorumEpochResponse{ErrorCode: utils.UNKNOWN_TOPIC_OR_PARTITION, LeaderID: raft.NO_LEADER, LeaderEpoch: -1}
+			if isMetadataPartition(topic.TopicName, partition.PartitionIndex) {
+				clusterId := stringValue(quorumEpochRequest.ClusterID)
+				if req.ApiKey == utils.BEGIN_QUORUM_EPOCH_KEY {
+					resp = node.HandleBeginQuorumEpoch(raft.BeginQuorumEpochRequest{ClusterID: clusterId, LeaderID: partition.LeaderID, LeaderEpoch: partition.LeaderEpoch})
+				} else {
+					resp = node.HandleEndQuorumEpoch(raft.EndQuorumEpochRequest{ClusterID: clusterId, LeaderID: partition.LeaderID, LeaderEpoch: partition.LeaderEpoch, PreferredSuccessors: partition.PreferredSuccessors})
+				}
+			}
+			binary.Write(&body, binary.BigEndian, partition.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+			writeLeaderAndEpoch(&body, raft.LeaderAndEpoch{LeaderID: resp.LeaderID, LeaderEpoch: resp.LeaderEpoch})
+		}
+	}
+
+	return writeResponse(req, &body), nil
+}
+
+func writeReplicaStates(body *bytes.Buffer, replicas []raft.ReplicaState, apiVersion uint16) {
+	utils.WriteCompactArrayLength(body, len(replicas))
+	for _, replica := range replicas {
+		binary.Write(body, binary.BigEndian, replica.ReplicaID)
+		binary.Write(body, binary.BigEndian, replica.LogEndOffset)
+		// The fetch timestamps were added in version 1
+		if apiVersion >= 1 {
+			binary.Write(body, binary.BigEndian, replica.LastFetchTimestamp)
+			binary.Write(body, binary.BigEndian, replica.LastCaughtUpTimestamp)
+		}
+		utils.WriteTaggedFields(body)
+	}
+}
+
+func SerializeDescribeQuorum(req request.Request) ([]byte, error) {
+	describeQuorumRequest := req.DescribeQuorumRequest
+	node := raft.Node()
+
+	var body bytes.Buffer
+	if errorCode := quorumErrorCode(req, node, authorizer.OPERATION_DESCRIBE); errorCode != utils.NONE {
+		binary.Write(&body, binary.BigEndian, errorCode)
+		utils.WriteCompactArrayLength(&body, 0)
+		utils.WriteTaggedFields(&body)
+		return writeFlexibleResponse(req, &body), nil
+	}
+
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	utils.WriteCompactArrayLength(&body, len(describeQuorumRequest.Topics))
+	for _, topic := range describeQuorumRequest.Topics {
+		utils.WriteCompactString(&body, topic.TopicName)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partitionIndex := range topic.Partitions {
+			info, errorCode := raft.QuorumInfo{LeaderID: raft.NO_LEADER, LeaderEpoch: -1, HighWatermark: -1}, utils.UNKNOWN_TOPIC_OR_PARTITION
+			if isMetadataPartition(topic.TopicName, partitionIndex) {
+				info, errorCode = node.DescribeQuorum()
+			}
+			binary.Write(&body, binary.BigEndian, partitionIndex)
+			binary.Write(&body, binary.BigEndian, errorCode)
+			writeLeaderAndEpoch(&body, raft.LeaderAndEpoch{LeaderID: info.LeaderID, LeaderEpoch: info.LeaderEpoch})
+			binary.Write(&body, binary.BigEndian, info.HighWatermark)
+			writeReplicaStates(&body, info.Voters, req.ApiVersion)
+			writeReplicaStates(&body, info.Observers, req.ApiVersion)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func writeSnapshotID(body *bytes.Buffer, snapshotId metadata.SnapshotID) {
+	binary.Write(body, binary.BigEndian, snapshotId.EndOffset)
+	binary.Write(body, binary.BigEndian, snapshotId.Epoch)
+	utils.WriteTaggedFields(body)
+}
+
+// leaderTaggedField encodes the current_leader tagged field of Fetch and
+// FetchSnapshot partitions.
+func leaderTaggedField(leader raft.LeaderAndEpoch) []byte {
+	var field bytes.Buffer
+	writeLeaderAndEpoch(&field, leader)
+	utils.WriteTaggedFields(&field)
+	return field.Bytes()
+}
+
+func SerializeFetchSnapshot(req request.Request) ([]byte, error) {
+	fetchSnapshotRequest := req.FetchSnapshotRequest
+	node := raft.Node()
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	if errorCode := quorumErrorCode(req, node, authorizer.OPERATION_CLUSTER_ACTION); errorCode != utils.NONE {
+		binary.Write(&body, binary.BigEndian, errorCode)
+		utils.WriteCompactArrayLength(&body, 0)
+		utils.WriteTaggedFields(&body)
+		return writeFlexibleResponse(req, &body), nil
+	}
+
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	utils.WriteCompactArrayLength(&body, len(fetchSnapshotRequest.Topics))
+	for _, topic := range fetchSnapshotRequest.Topics {
+		utils.WriteCompactString(&body, topic.Name)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			snapshotId := metadata.SnapshotID{EndOffset: partition.SnapshotEndOffset, Epoch: partition.SnapshotEpoch}
+			resp := raft.FetchSnapshotResponse{
+				ErrorCode:     utils.UNKNOWN_TOPIC_OR_PARTITION,
+				CurrentLeader: raft.LeaderAndEpoch{LeaderID: raft.NO_LEADER, LeaderEpoch: -1},
+				SnapshotID:    snapshotId,
+				Size:          -1,
+				Position:      -1,
+			}
+			if isMetadataPartition(topic.Name, partition.Partition) {
+				resp = node.HandleFetchSnapshot(raft.FetchSnapshotRequest{
+					ClusterID:          stringValue(fetchSnapshotRequest.ClusterID),
+					ReplicaID:          fetchSnapshotRequest.ReplicaID,
+					CurrentLeaderEpoch: partition.CurrentLeaderEpoch,
+					SnapshotID:         snapshotId,
+					Position:           partition.Position,
+					MaxBytes:           fetchSnapshotRequest.MaxBytes,
+				})
+			}
+			binary.Write(&body, binary.BigEndian, partition.Partition)
+			binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+			writeSnapshotID(&body, resp.SnapshotID)
+			binary.Write(&body, binary.BigEndian, resp.Size)
+			binary.Write(&body, binary.BigEndian, resp.Position)
+			utils.WriteCompactBytes(&body, resp.Records)
+			utils.WriteTaggedFieldValues(&body, map[uint64][]byte{0: leaderTaggedField(resp.CurrentLeader)})
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+// serializeRaftFetch answers a replica fetching the metadata log. It is
+// served by the quorum, which also decides how long to hold the fetch.
+func serializeRaftFetch(req request.Request, node *raft.RaftNode) ([]byte, error) {
+	fetchRequest := req.FetchRequest
+	maxWait := time.Duration(fetchRequest.MaxWaitMs) * time.Millisecond
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, utils.NONE)
+	binary.Write(&body, binary.BigEndian, int32(0))
+
+	utils.WriteCompactArrayLength(&body, len(fetchRequest.Topics))
+	for _, topic := range fetchRequest.Topics {
+		body.Write(topic.TopicID.Bytes())
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			resp := raft.FetchResponse{
+				ErrorCode:      utils.UNKNOWN_TOPIC_OR_PARTITION,
+				CurrentLeader:  raft.LeaderAndEpoch{LeaderID: raft.NO_LEADER, LeaderEpoch: -1},
+				HighWatermark:  -1,
+				LogStartOffset: -1,
+			}
+			if topic.TopicID == raft.METADATA_TOPIC_ID && partition.PartitionID == raft.METADATA_PARTITION {
+				resp = node.HandleFetch(raft.FetchRequest{
+					ClusterID:          stringValue(fetchRequest.ClusterID),
+					ReplicaID:          fetchRequest.ReplicaID,
+					CurrentLeaderEpoch: partition.CurrentLeaderEpoch,
+					FetchOffset:        partition.FetchOffset,
+					LastFetchedEpoch:   partition.LastFetchedEpoch,
+					MaxBytes:           min(fetchRequest.MaxBytes, partition.PartitionMaxBytes),
+				}, maxWait)
+			}
+
+			binary.Write(&body, binary.BigEndian, partition.PartitionID)
+			binary.Write(&body, binary.BigEndian, resp.ErrorCode)
+			binary.Write(&body, binary.BigEndian, resp.HighWatermark)
+			// last_stable_offset
+			binary.Write(&body, binary.BigEndian, resp.HighWatermark)
+			binary.Write(&body, binary.BigEndian, resp.LogStartOffset)
+			utils.WriteCompactArrayLength(&body, -1)
+			// preferred_read_replica
+			binary.Write(&body, binary.BigEndian, int32(-1))
+			utils.WriteCompactBytes(&body, resp.Records)
+
+			fields := map[uint64][]byte{1: leaderTaggedField(resp.CurrentLeader)}
+			if resp.DivergingEpoch != nil {
+				var field bytes.Buffer
+				binary.Write(&field, binary.BigEndian, resp.DivergingEpoch.Epoch)
+				binary.Write(&field, binary.BigEndian, resp.DivergingEpoch.Offset)
+				utils.WriteTaggedFields(&field)
+				fields[0] = field.Bytes()
+			}
+			if resp.SnapshotID != nil {
+				var field bytes.Buffer
+				writeSnapshotID(&field, *resp.SnapshotID)
+				fields[2] = field.Bytes()
+			}
+			utils.WriteTaggedFieldValues(&body, fields)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
@@ -3,9 +3,8 @@ package response
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
+	"sort"
 
-	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
 	"github.com/codecrafters-io/kafka-starter-go/app/request"
 	"github.com/codecrafters-io/kafka-starter-go/app/utils"
 	"github.com/gofrs/uuid"
@@ -18,26 +17,92 @@ type ApiVersion struct {
 }
 
 func Serialize(req request.Request) ([]byte, error) {
-	switch req.ApiVersion {
-	case utils.DESCRIBE_TOPIC_PARTITIONS:
+	switch req.ApiKey {
+	case utils.DESCRIBE_TOPIC_PARTITIONS_KEY:
 		res, err := SerializeVersion0(req)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
 
-	case utils.API_VERSION:
+	case utils.API_VERSIONS_KEY:
 		res, err := SerializeVersion4(req)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
-	case utils.FETCH:
+	case utils.FETCH_KEY:
 		res, err := SerializeVersion16(req)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
+	case utils.METADATA_KEY:
+		return SerializeMetadata(req)
+	case utils.PRODUCE_KEY:
+		return SerializeProduce(req)
+	case utils.INIT_PRODUCER_ID_KEY:
+		return SerializeInitProducerId(req)
+	case utils.FIND_COORDINATOR_KEY:
+		return SerializeFindCoordinator(req)
+	case utils.ADD_PARTITIONS_TO_TXN_KEY:
+		return SerializeAddPartitionsToTxn(req)
+	case utils.ADD_OFFSETS_TO_TXN_KEY:
+		return SerializeAddOffsetsToTxn(req)
+	case utils.END_TXN_KEY:
+		return SerializeEndTxn(req)
+	case utils.WRITE_TXN_MARKERS_KEY:
+		return SerializeWriteTxnMarkers(req)
+	case utils.TXN_OFFSET_COMMIT_KEY:
+		return SerializeTxnOffsetCommit(req)
+	case utils.DESCRIBE_PRODUCERS_KEY:
+		return SerializeDescribeProducers(req)
+	case utils.DESCRIBE_TRANSACTIONS_KEY:
+		return SerializeDescribeTransactions(req)
+	case utils.LIST_TRANSACTIONS_KEY:
+		return SerializeListTransactions(req)
+	case utils.VOTE_KEY:
+		return SerializeVote(req)
+	case utils.BEGIN_QUORUM_EPOCH_KEY, utils.END_QUORUM_EPOCH_KEY:
+		return SerializeQuorumEpoch(req)
+	case utils.DESCRIBE_QUORUM_KEY:
+		return SerializeDescribeQuorum(req)
+	case utils.FETCH_SNAPSHOT_KEY:
+		return SerializeFetchSnapshot(req)
+	case utils.ALTER_PARTITION_KEY:
+		return SerializeAlterPartition(req)
+	case utils.OFFSET_FOR_LEADER_EPOCH_KEY:
+		return SerializeOffsetForLeaderEpoch(req)
+	case utils.ELECT_LEADERS_KEY:
+		return SerializeElectLeaders(req)
+	case utils.ALTER_PARTITION_REASSIGNMENTS_KEY:
+		return SerializeAlterPartitionReassignments(req)
+	case utils.LIST_PARTITION_REASSIGNMENTS_KEY:
+		return SerializeListPartitionReassignments(req)
+	case utils.DESCRIBE_CLUSTER_KEY:
+		return SerializeDescribeCluster(req)
+	case utils.DESCRIBE_ACLS_KEY:
+		return SerializeDescribeAcls(req)
+	case utils.CREATE_ACLS_KEY:
+		return SerializeCreateAcls(req)
+	case utils.DELETE_ACLS_KEY:
+		return SerializeDeleteAcls(req)
+	case utils.SASL_HANDSHAKE_KEY:
+		return SerializeSaslHandshake(req)
+	case utils.SASL_AUTHENTICATE_KEY:
+		return SerializeSaslAuthenticate(req)
+	case utils.DESCRIBE_USER_SCRAM_CREDENTIALS_KEY:
+		return SerializeDescribeUserScramCredentials(req)
+	case utils.ALTER_USER_SCRAM_CREDENTIALS_KEY:
+		return SerializeAlterUserScramCredentials(req)
+	case utils.DESCRIBE_CLIENT_QUOTAS_KEY:
+		return SerializeDescribeClientQuotas(req)
+	case utils.ALTER_CLIENT_QUOTAS_KEY:
+		return SerializeAlterClientQuotas(req)
+	case utils.BROKER_REGISTRATION_KEY:
+		return SerializeBrokerRegistration(req)
+	case utils.BROKER_HEARTBEAT_KEY:
+		return SerializeBrokerHeartbeat(req)
 	}
 	return []byte{}, nil
 }
@@ -60,29 +125,23 @@ func SerializeVersion0(req request.Request) ([]byte, error) {
 	var body bytes.Buffer
 
 	// Throttle Time
-	if err := binary.Write(&body, binary.BigEndian, uint32(0)); err != nil {
+	if err := binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs); err != nil {
 		return []byte{}, err
 	}
+	ctx := requestContext(req)
+	topics, nextCursor := describeTopicPartitionsPage(ctx, req.DescribeTopicPartitionRequest)
 	// Topic Array Length
-	if err := binary.Write(&body, binary.BigEndian, uint8(len(req.DescribeTopicPartitionRequest.TopicArray)+1)); err != nil {
-		return []byte{}, err
-	}
+	utils.WriteCompactArrayLength(&body, len(topics))
 	// Encode Topic
-	for i := 0; i < len(req.DescribeTopicPartitionRequest.TopicArray); i++ {
+	for _, topic := range topics {
 
-		topicNameLength := len(req.DescribeTopicPartitionRequest.TopicArray[i])
-		topicName := req.DescribeTopicPartitionRequest.TopicArray[i]
+		topicNameLength := len(topic.Name)
+		topicName := topic.Name
 
-		clusterTopic := metadata.GetClusterTopic(topicName)
+		clusterTopic := topic.Topic
 
-		if clusterTopic.ErrorCode == 3 {
-			if err := binary.Write(&body, binary.BigEndian, uint16(0x0003)); err != nil {
-				return []byte{}, err
-			}
-		} else {
-			if err := binary.Write(&body, binary.BigEndian, uint16(0x0000)); err != nil {
-				return []byte{}, err
-			}
+		if err := binary.Write(&body, binary.BigEndian, clusterTopic.ErrorCode); err != nil {
+			return []byte{}, err
 		}
 
 		if err := binary.Write(&body, binary.BigEndian, uint8(topicNameLength+1)); err != nil {
@@ -90,7 +149,7 @@ func SerializeVersion0(req request.Request) ([]byte, error) {
 		}
 		_, _ = body.Write([]byte(topicName))
 
-		if clusterTopic.ErrorCode == 3 {
+		if clusterTopic.ErrorCode != utils.NONE {
 
 			nullUUID := uuid.UUID{}
 			if err := binary.Write(&body, binary.BigEndian, nullUUID.Bytes()); err != nil {
@@ -108,19 +167,17 @@ func SerializeVersion0(req request.Request) ([]byte, error) {
 		}
 
 		// Partition array
-		partionsLength := len(clusterTopic.Partitions)
+		partionsLength := len(topic.Partitions)
 		if partionsLength == 0 {
 			if err := binary.Write(&body, binary.BigEndian, uint8(0x01)); err != nil {
 				return []byte{}, err
 			}
 		} else {
 
-			if err := binary.Write(&body, binary.BigEndian, uint8(partionsLength+1)); err != nil {
-				return []byte{}, err
-			}
+			utils.WriteCompactArrayLength(&body, partionsLength)
 
 			for i := 0; i < partionsLength; i++ {
-				partition := clusterTopic.Partitions[i]
+				partition := topic.Partitions[i]
 				binary.Write(&body, binary.BigEndian, uint16(0x0000))
 				binary.Write(&body, binary.BigEndian, uint32(partition.PartitionIndex))
 				binary.Write(&body, binary.BigEndian, uint32(partition.LeaderID))
@@ -160,7 +217,7 @@ func SerializeVersion0(req request.Request) ([]byte, error) {
 		}
 
 		// Topic Authorized
-		if err := binary.Write(&body, binary.BigEndian, uint32(0x00000df8)); err != nil {
+		if err := binary.Write(&body, binary.BigEndian, topicAuthorizedOperations(ctx, topicName)); err != nil {
 			return []byte{}, err
 		}
 		// Tag buffer
@@ -169,8 +226,16 @@ func SerializeVersion0(req request.Request) ([]byte, error) {
 		}
 	}
 
-	if err := binary.Write(&body, binary.BigEndian, uint8(0xff)); err != nil {
-		return []byte{}, err
+	// Next Cursor
+	if nextCursor == nil {
+		if err := binary.Write(&body, binary.BigEndian, uint8(0xff)); err != nil {
+			return []byte{}, err
+		}
+	} else {
+		binary.Write(&body, binary.BigEndian, uint8(0x01))
+		utils.WriteCompactString(&body, nextCursor.TopicName)
+		binary.Write(&body, binary.BigEndian, nextCursor.PartitionIndex)
+		utils.WriteTaggedFields(&body)
 	}
 	if err := binary.Write(&body, binary.BigEndian, uint8(0x00)); err != nil {
 		return []byte{}, err
@@ -195,7 +260,11 @@ func SerializeVersion4(req request.Request) ([]byte, error) {
 	// Body
 	binary.Write(&responseBody, binary.BigEndian, uint16(0))
 
-	apiVersionArr := []ApiVersion{ApiVersion{ApiKey: 1, Min: 0, Max: 17}, ApiVersion{ApiKey: 18, Min: 0, Max: 4}, ApiVersion{ApiKey: 75, Min: 0, Max: 0}}
+	apiVersionArr := []ApiVersion{}
+	for apiKey, versions := range utils.SUPPORTED_API_VERSIONS {
+		apiVersionArr = append(apiVersionArr, ApiVersion{ApiKey: int(apiKey), Min: int(versions.Min), Max: int(versions.Max)})
+	}
+	sort.Slice(apiVersionArr, func(i, j int) bool { return apiVersionArr[i].ApiKey < apiVersionArr[j].ApiKey })
 
 	binary.Write(&responseBody, binary.BigEndian, uint8(len(apiVersionArr)+1))
 
@@ -222,116 +291,6 @@ func SerializeVersion4(req request.Request) ([]byte, error) {
 	return response.Bytes(), nil
 }
 
-func SerializeVersion16(req request.Request) ([]byte, error) {
-	var responseBody bytes.Buffer
-
-	// Write throttle_time_ms (4 bytes, int32)
-	if err := binary.Write(&responseBody, binary.BigEndian, uint32(0)); err != nil {
-		return nil, fmt.Errorf("failed to write throttle_time_ms: %v", err)
-	}
-
-	// Write error_code (2 bytes, int16)
-	if err := binary.Write(&responseBody, binary.BigEndian, uint16(0)); err != nil {
-		return nil, fmt.Errorf("failed to write error_code: %v", err)
-	}
-	// Write session_id (4 bytes, int32)
-	if err := binary.Write(&responseBody, binary.BigEndian, req.FetchRequest.SessionID); err != nil {
-		return nil, fmt.Errorf("failed to write session_id: %v", err)
-	}
-	// Write responses field (0 elements for empty topics array)
-	// Array length is 0, so we only write the length (4 bytes, int32)
-	topicLen := len(req.FetchRequest.Topics)
-	if err := binary.Write(&responseBody, binary.BigEndian, topicLen+1); err != nil {
-		return nil, fmt.Errorf("failed to write responses field: %v", err)
-	}
-
-	for _, topic := range req.FetchRequest.Topics {
-		topicId := topic.TopicID
-		if err := binary.Write(&responseBody, binary.BigEndian, topicId.Bytes()); err != nil {
-			return nil, fmt.Errorf("failed to write responses field: %v", err)
-		}
-
-		partitionLen := len(topic.Partitions)
-		if err := binary.Write(&responseBody, binary.BigEndian, partitionLen+1); err != nil {
-			return nil, err
-		}
-
-		for _, _ = range topic.Partitions {
-			if err := binary.Write(&responseBody, binary.BigEndian, int32(0)); err != nil {
-				return nil, fmt.Errorf("failed to write responses field: %v", err)
-			}
-			if err := binary.Write(&responseBody, binary.BigEndian, int16(100)); err != nil {
-				return nil, fmt.Errorf("failed to write responses field: %v", err)
-			}
-
-			// high_watermark
-			if err := binary.Write(&responseBody, binary.BigEndian, int64(0)); err != nil {
-				return nil, err
-			}
-			// last_stable_offset
-			if err := binary.Write(&responseBody, binary.BigEndian, int64(0)); err != nil {
-				return nil, err
-			}
-			// log_start_offset
-			if err := binary.Write(&responseBody, binary.BigEndian, int64(0)); err != nil {
-				return nil, err
-			}
-			// preferred_read_replica
-			if err := binary.Write(&responseBody, binary.BigEndian, int32(0)); err != nil {
-				return nil, err
-			}
-			if err := binary.Write(&responseBody, binary.BigEndian, int8(0)); err != nil {
-				return nil, err
-			}
-			// Tag_buffer
-			if err := binary.Write(&responseBody, binary.BigEndian, int16(0)); err != nil {
-				return nil, err
-			}
-		}
-		// Tag_buffer
-		if err := binary.Write(&responseBody, binary.BigEndian, int16(0)); err != nil {
-			return nil, err
-		}
-
-	}
-	if err := binary.Write(&responseBody, binary.BigEndian, int16(0)); err != nil {
-		return nil, err
-	}
-
-	var responseHeader bytes.Buffer
-
-	// Write correlation_id (4 bytes, int32)
-	if err := binary.Write(&responseHeader, binary.BigEndian, req.CorrelationID); err != nil {
-		return nil, fmt.Errorf("failed to write correlation_id: %v", err)
-	}
-
-	// Write TAG_BUFFER field (1 byte, uint8)
-	// Assuming this is a reserved field, set to 0 for now
-	if err := binary.Write(&responseHeader, binary.BigEndian, uint8(0)); err != nil {
-		return nil, fmt.Errorf("failed to write TAG_BUFFER: %v", err)
-	}
-
-	var response bytes.Buffer
-
-	// Write total message length (4 bytes, int32)
-	totalLength := responseHeader.Len() + responseBody.Len()
-	if err := binary.Write(&response, binary.BigEndian, int32(totalLength)); err != nil {
-		return nil, fmt.Errorf("failed to write message length: %v", err)
-	}
-
-	// Write response header
-	if _, err := response.Write(responseHeader.Bytes()); err != nil {
-		return nil, fmt.Errorf("failed to write response header: %v", err)
-	}
-
-	// Write response body
-	if _, err := response.Write(responseBody.Bytes()); err != nil {
-		return nil, fmt.Errorf("failed to write response body: %v", err)
-	}
-
-	return response.Bytes(), nil
-}
-
 func GetErrorResponse(req request.Request) []byte {
 	var messageBody bytes.Buffer
 	binary.Write(&messageBody, binary.BigEndian, req.CorrelationID)
@@ -343,3 +302,48 @@ func GetErrorResponse(req request.Request) []byte {
 	binary.Write(&errorMessage, binary.BigEndian, messageBody.Bytes())
 	return errorMessage.Bytes()
 }
+
+// writeFlexibleResponse frames a body behind a v1 response header
+func writeFlexibleResponse(req request.Request, body *bytes.Buffer) []byte {
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, req.CorrelationID)
+	utils.WriteTaggedFields(&header)
+
+	var response bytes.Buffer
+	binary.Write(&response, binary.BigEndian, uint32(header.Len()+body.Len()))
+	response.Write(header.Bytes())
+	response.Write(body.Bytes())
+	return response.Bytes()
+}
+
+// FLEXIBLE_HEADER_SIZE is the length prefix, correlation id and empty tagged
+// fields in front of a flexible response body.
+const FLEXIBLE_HEADER_SIZE = 4 + 4 + 1
+
+// SetThrottleTimeMs overwrites throttle_time_ms in a serialized response, so
+// that it can be set once the request has been metered. The field starts the
+// body of the responses that have it, but Produce responses end with it.
+func SetThrottleTimeMs(req request.Request, res []byte, throttleTimeMs int32) {
+	offset := FLEXIBLE_HEADER_SIZE
+	switch req.ApiKey {
+	case utils.PRODUCE_KEY:
+		offset = len(res) - 4 - 1
+	case utils.API_VERSIONS_KEY, utils.SASL_HANDSHAKE_KEY, utils.SASL_AUTHENTICATE_KEY, utils.DESCRIBE_QUORUM_KEY,
+		utils.VOTE_KEY, utils.BEGIN_QUORUM_EPOCH_KEY, utils.END_QUORUM_EPOCH_KEY:
+		return
+	}
+	if offset < FLEXIBLE_HEADER_SIZE || offset+4 > len(res) {
+		return
+	}
+	binary.BigEndian.PutUint32(res[offset:], uint32(throttleTimeMs))
+}
+
+// writeResponse frames a body behind a v0 response header, for requests that
+// are not flexible.
+func writeResponse(req request.Request, body *bytes.Buffer) []byte {
+	var response bytes.Buffer
+	binary.Write(&response, binary.BigEndian, uint32(4+body.Len()))
+	binary.Write(&response, binary.BigEndian, req.CorrelationID)
+	response.Write(body.Bytes())
+	return response.Bytes()
+}
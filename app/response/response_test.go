@@ -3,10 +3,15 @@ package response
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"log"
+	"reflect"
 	"testing"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
 	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
 	"github.com/gofrs/uuid"
 )
 
@@ -36,11 +41,11 @@ func TestNewResponse(t *testing.T) {
 	binary.Write(&desiredResponse, binary.BigEndian, uint8(0))
 
 	testReq := request.Request{
-		ApiKey:        uint16(75),
-		ApiVersion:    uint16(0),
-		CorrelationID: uint32(7),
-		ClientId:      "kafka-cli",
-		TopicArray:    []request.Topic{request.Topic{TopicName: "foo"}},
+		ApiKey:                        uint16(75),
+		ApiVersion:                    uint16(0),
+		CorrelationID:                 uint32(7),
+		ClientId:                      "kafka-cli",
+		DescribeTopicPartitionRequest: &request.Describe_Topic_Partition_Request{TopicArray: []string{"foo"}},
 	}
 
 	res, err := Serialize(testReq)
@@ -56,3 +61,231 @@ func TestNewResponse(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestDescribeTopicPartitionsPagination(t *testing.T) {
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	for name, partitions := range map[string]int{"a": 3, "b": 2, "c": 1} {
+		topicId, _ := uuid.NewV4()
+		delta.Replay(&metadata.TopicRecord{Name: name, TopicID: topicId})
+		for i := range partitions {
+			delta.Replay(&metadata.PartitionRecord{PartitionID: int32(i), TopicID: topicId, Replicas: []int32{1}, Isr: []int32{1}, Leader: 1})
+		}
+	}
+	metadata.Publish(delta.Apply())
+	t.Cleanup(func() { metadata.Publish(metadata.EmptyImage()) })
+
+	type page struct {
+		partitions []string
+		next       *request.Describe_Topic_Partition_Cursor
+	}
+	describe := func(topics []string, limit int32, cursor *request.Describe_Topic_Partition_Cursor) page {
+		described, next := describeTopicPartitionsPage(authorizer.RequestContext{Principal: authorizer.ANONYMOUS}, &request.Describe_Topic_Partition_Request{TopicArray: topics, ResponsePartitionLimit: limit, Cursor: cursor})
+		result := page{partitions: []string{}, next: next}
+		for _, topic := range described {
+			for _, partition := range topic.Partitions {
+				result.partitions = append(result.partitions, fmt.Sprintf("%s-%d", topic.Name, partition.PartitionIndex))
+			}
+		}
+		return result
+	}
+
+	cases := []struct {
+		topics   []string
+		limit    int32
+		cursor   *request.Describe_Topic_Partition_Cursor
+		expected page
+	}{
+		{nil, 2, nil, page{[]string{"a-0", "a-1"}, &request.Describe_Topic_Partition_Cursor{TopicName: "a", PartitionIndex: 2}}},
+		{nil, 2, &request.Describe_Topic_Partition_Cursor{TopicName: "a", PartitionIndex: 2}, page{[]string{"a-2", "b-0"}, &request.Describe_Topic_Partition_Cursor{TopicName: "b", PartitionIndex: 1}}},
+		{nil, 2, &request.Describe_Topic_Partition_Cursor{TopicName: "b", PartitionIndex: 1}, page{[]string{"b-1", "c-0"}, nil}},
+		{nil, 5, nil, page{[]string{"a-0", "a-1", "a-2", "b-0", "b-1"}, &request.Describe_Topic_Partition_Cursor{TopicName: "c", PartitionIndex: 0}}},
+		{[]string{"c", "a"}, 0, &request.Describe_Topic_Partition_Cursor{TopicName: "a", PartitionIndex: 1}, page{[]string{"a-1", "a-2", "c-0"}, nil}},
+	}
+	for _, c := range cases {
+		if got := describe(c.topics, c.limit, c.cursor); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Expected %+v for topics %v from %+v with limit %d, got %+v", c.expected, c.topics, c.cursor, c.limit, got)
+		}
+	}
+}
+
+// topicReader may only read and describe the topics it names.
+type topicReader map[string]bool
+
+func (r topicReader) Authorize(ctx authorizer.RequestContext, action authorizer.Action) bool {
+	return action.ResourceType == authorizer.RESOURCE_TOPIC && r[action.ResourceName] &&
+		(action.Operation == authorizer.OPERATION_READ || action.Operation == authorizer.OPERATION_DESCRIBE)
+}
+
+func TestAuthorizedTopics(t *testing.T) {
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	for _, name := range []string{"hidden", "visible"} {
+		topicId, _ := uuid.NewV4()
+		delta.Replay(&metadata.TopicRecord{Name: name, TopicID: topicId})
+		delta.Replay(&metadata.PartitionRecord{TopicID: topicId, Replicas: []int32{1}, Isr: []int32{1}, Leader: 1})
+	}
+	metadata.Publish(delta.Apply())
+	authorizer.SetAuthorizer(topicReader{"visible": true})
+	t.Cleanup(func() {
+		metadata.Publish(metadata.EmptyImage())
+		authorizer.SetAuthorizer(authorizer.AllowAll{})
+	})
+	ctx := authorizer.RequestContext{Principal: authorizer.ANONYMOUS}
+
+	if operations := topicAuthorizedOperations(ctx, "visible"); operations != 1<<authorizer.OPERATION_READ|1<<authorizer.OPERATION_DESCRIBE {
+		t.Errorf("Expected READ and DESCRIBE on visible, got %#x", operations)
+	}
+	if operations := clusterAuthorizedOperations(ctx); operations != 0 {
+		t.Errorf("Expected no cluster operations, got %#x", operations)
+	}
+
+	topics := requestedTopics(ctx, metadata.Image(), nil)
+	if len(topics) != 1 || topics[0].Name != "visible" {
+		t.Errorf("Expected only visible to be listed, got %+v", topics)
+	}
+	hidden := "hidden"
+	topics = requestedTopics(ctx, metadata.Image(), []request.Metadata_Request_Topic{{Name: &hidden}})
+	if len(topics) != 1 || topics[0].ErrorCode != utils.TOPIC_AUTHORIZATION_FAILED || len(topics[0].Partitions) != 0 {
+		t.Errorf("Expected TOPIC_AUTHORIZATION_FAILED for hidden, got %+v", topics)
+	}
+
+	described, _ := describeTopicPartitionsPage(ctx, &request.Describe_Topic_Partition_Request{})
+	if len(described) != 1 || described[0].Name != "visible" {
+		t.Errorf("Expected only visible to be described, got %+v", described)
+	}
+	described, _ = describeTopicPartitionsPage(ctx, &request.Describe_Topic_Partition_Request{TopicArray: []string{"hidden", "visible"}})
+	if len(described) != 2 || described[0].Topic.ErrorCode != utils.TOPIC_AUTHORIZATION_FAILED || len(described[0].Partitions) != 0 || described[1].Topic.ErrorCode != utils.NONE {
+		t.Errorf("Expected hidden to fail authorization and visible to be described, got %+v", described)
+	}
+}
+
+func TestDescribeAcls(t *testing.T) {
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	for _, operation := range []int8{authorizer.OPERATION_WRITE, authorizer.OPERATION_READ} {
+		id, _ := uuid.NewV4()
+		delta.Replay(authorizer.AclBinding{
+			ResourceType:   authorizer.RESOURCE_TOPIC,
+			ResourceName:   "orders",
+			PatternType:    authorizer.PATTERN_LITERAL,
+			Principal:      "User:alice",
+			Host:           authorizer.WILDCARD_HOST,
+			Operation:      operation,
+			PermissionType: authorizer.PERMISSION_ALLOW,
+		}.Record(id))
+	}
+	metadata.Publish(delta.Apply())
+	t.Cleanup(func() {
+		metadata.Publish(metadata.EmptyImage())
+		authorizer.SetAuthorizer(authorizer.AllowAll{})
+	})
+	req := request.Request{
+		ApiKey:     utils.DESCRIBE_ACLS_KEY,
+		ApiVersion: utils.DESCRIBE_ACLS,
+		DescribeAclsRequest: &request.Acl_Filter{
+			ResourceTypeFilter: authorizer.RESOURCE_ANY,
+			PatternTypeFilter:  authorizer.PATTERN_ANY,
+			Operation:          authorizer.OPERATION_ANY,
+			PermissionType:     authorizer.PERMISSION_ANY,
+		},
+	}
+	// The body follows the size, correlation id and header tagged fields, and
+	// throttle_time_ms
+	describe := func(principal string) []byte {
+		req.Principal = principal
+		res, err := SerializeDescribeAcls(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res[13:]
+	}
+
+	if body := describe("User:admin"); int16(binary.BigEndian.Uint16(body)) != utils.SECURITY_DISABLED {
+		t.Fatalf("Expected SECURITY_DISABLED without ACLs enabled, got %v", body)
+	}
+	authorizer.SetAuthorizer(authorizer.NewStandardAuthorizer([]string{"User:admin"}, false))
+	if body := describe("User:alice"); int16(binary.BigEndian.Uint16(body)) != utils.CLUSTER_AUTHORIZATION_FAILED {
+		t.Fatalf("Expected CLUSTER_AUTHORIZATION_FAILED for alice, got %v", body)
+	}
+
+	var expected bytes.Buffer
+	binary.Write(&expected, binary.BigEndian, utils.NONE)
+	utils.WriteCompactNullableString(&expected, nil)
+	utils.WriteCompactArrayLength(&expected, 1)
+	binary.Write(&expected, binary.BigEndian, authorizer.RESOURCE_TOPIC)
+	utils.WriteCompactString(&expected, "orders")
+	binary.Write(&expected, binary.BigEndian, authorizer.PATTERN_LITERAL)
+	utils.WriteCompactArrayLength(&expected, 2)
+	for _, operation := range []int8{authorizer.OPERATION_READ, authorizer.OPERATION_WRITE} {
+		utils.WriteCompactString(&expected, "User:alice")
+		utils.WriteCompactString(&expected, authorizer.WILDCARD_HOST)
+		binary.Write(&expected, binary.BigEndian, operation)
+		binary.Write(&expected, binary.BigEndian, authorizer.PERMISSION_ALLOW)
+		utils.WriteTaggedFields(&expected)
+	}
+	utils.WriteTaggedFields(&expected)
+	utils.WriteTaggedFields(&expected)
+	if body := describe("User:admin"); !bytes.Equal(body, expected.Bytes()) {
+		t.Fatalf("Expected %v, got %v", expected.Bytes(), body)
+	}
+}
+
+func TestListenerEndpoints(t *testing.T) {
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	delta.Replay(&metadata.RegisterBrokerRecord{BrokerID: 1, EndPoints: []metadata.BrokerEndpoint{
+		{Name: "INTERNAL", Host: "kafka-1.internal", Port: 9092},
+		{Name: "EXTERNAL", Host: "kafka-1.example.com", Port: 19092},
+	}})
+	delta.Replay(&metadata.RegisterBrokerRecord{BrokerID: 2, EndPoints: []metadata.BrokerEndpoint{
+		{Name: "INTERNAL", Host: "kafka-2.internal", Port: 9092},
+	}})
+	metadata.Publish(delta.Apply())
+	t.Cleanup(func() { metadata.Publish(metadata.EmptyImage()) })
+	image := metadata.Image()
+
+	if brokers := liveBrokers(image, "INTERNAL"); len(brokers) != 2 || brokers[0].Host != "kafka-1.internal" || brokers[1].Host != "kafka-2.internal" {
+		t.Fatalf("Expected both brokers at their internal endpoints, got %+v", brokers)
+	}
+	external := liveBrokers(image, "EXTERNAL")
+	if len(external) != 1 || external[0].Host != "kafka-1.example.com" || external[0].Port != 19092 {
+		t.Fatalf("Expected only broker 1 at its external endpoint, got %+v", external)
+	}
+	if errorCode := partitionErrorCode(image, external, metadata.ClusterTopicPartition{LeaderID: 2}); errorCode != utils.LISTENER_NOT_FOUND {
+		t.Fatalf("Expected LISTENER_NOT_FOUND for a leader without an external endpoint, got %d", errorCode)
+	}
+	if errorCode := partitionErrorCode(image, external, metadata.ClusterTopicPartition{LeaderID: 1}); errorCode != utils.NONE {
+		t.Fatalf("Expected no error for a reachable leader, got %d", errorCode)
+	}
+
+	// Without registrations, this broker answers for itself per listener
+	metadata.Publish(metadata.EmptyImage())
+	advertised := utils.AdvertisedListeners
+	utils.AdvertisedListeners = []utils.AdvertisedListener{{Name: "INTERNAL", Host: "localhost", Port: 9092}, {Name: "EXTERNAL", Host: "kafka.example.com", Port: 19092}}
+	t.Cleanup(func() { utils.AdvertisedListeners = advertised })
+	if brokers := liveBrokers(metadata.Image(), "EXTERNAL"); len(brokers) != 1 || brokers[0].Host != "kafka.example.com" {
+		t.Fatalf("Expected the external endpoint, got %+v", brokers)
+	}
+}
+
+func TestSetThrottleTimeMs(t *testing.T) {
+	requests := []request.Request{
+		{ApiKey: utils.DESCRIBE_TOPIC_PARTITIONS_KEY, DescribeTopicPartitionRequest: &request.Describe_Topic_Partition_Request{TopicArray: []string{"foo"}}},
+		{ApiKey: utils.METADATA_KEY, ApiVersion: utils.METADATA, MetadataRequest: &request.Metadata_Request{Topics: []request.Metadata_Request_Topic{}}},
+		{ApiKey: utils.FIND_COORDINATOR_KEY, FindCoordinatorRequest: &request.Find_Coordinator_Request{CoordinatorKeys: []string{"group"}}},
+		{ApiKey: utils.DESCRIBE_CLUSTER_KEY, DescribeClusterRequest: &request.Describe_Cluster_Request{}},
+		{ApiKey: utils.PRODUCE_KEY, ProduceRequest: &request.Produce_Request{Acks: 1}},
+	}
+	for _, req := range requests {
+		unthrottled, err := Serialize(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.ThrottleTimeMs = 1234
+		expected, err := Serialize(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetThrottleTimeMs(req, unthrottled, 1234)
+		if !bytes.Equal(unthrottled, expected) {
+			t.Errorf("Expected throttle_time_ms to be set in the response to api key %d", req.ApiKey)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+// SerializeSaslHandshake picks the mechanism of the connection's SASL
+// exchange, which only SASL listeners have.
+func SerializeSaslHandshake(req request.Request) ([]byte, error) {
+	errorCode := utils.ILLEGAL_SASL_STATE
+	mechanisms := []string{}
+	if req.Sasl != nil {
+		errorCode = req.Sasl.Handshake(req.SaslHandshakeRequest.Mechanism)
+		mechanisms = req.Sasl.Mechanisms
+	}
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, errorCode)
+	binary.Write(&body, binary.BigEndian, int32(len(mechanisms)))
+	for _, mechanism := range mechanisms {
+		utils.WriteString(&body, mechanism)
+	}
+
+	return writeResponse(req, &body), nil
+}
+
+func SerializeSaslAuthenticate(req request.Request) ([]byte, error) {
+	errorCode := utils.ILLEGAL_SASL_STATE
+	var errorMessage *string
+	authBytes := []byte{}
+	if req.Sasl != nil {
+		challenge, code, err := req.Sasl.Authenticate(req.SaslAuthenticateRequest.AuthBytes)
+		errorCode = code
+		if err != nil {
+			message := err.Error()
+			errorMessage = &message
+		}
+		if challenge != nil {
+			authBytes = challenge
+		}
+	}
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, errorCode)
+	flexible := utils.IsFlexible(req.ApiKey, req.ApiVersion)
+	if flexible {
+		utils.WriteCompactNullableString(&body, errorMessage)
+		utils.WriteCompactBytes(&body, authBytes)
+	} else {
+		utils.WriteNullableString(&body, errorMessage)
+		binary.Write(&body, binary.BigEndian, int32(len(authBytes)))
+		body.Write(authBytes)
+	}
+	if req.ApiVersion >= 1 {
+		// session_lifetime_ms, where 0 is a session that never expires
+		var sessionLifetimeMs int64
+		if req.Sasl != nil {
+			sessionLifetimeMs = req.Sasl.SessionLifetimeMs()
+		}
+		binary.Write(&body, binary.BigEndian, sessionLifetimeMs)
+	}
+	if !flexible {
+		return writeResponse(req, &body), nil
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
@@ -0,0 +1,142 @@
+package response
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+type scramCredentialsResult struct {
+	user        string
+	errorCode   int16
+	credentials []*metadata.UserScramCredentialRecord
+}
+
+// SerializeDescribeUserScramCredentials answers from this broker's metadata
+// image. Credentials are described by mechanism and iterations only.
+func SerializeDescribeUserScramCredentials(req request.Request) ([]byte, error) {
+	users := req.DescribeUserScramCredentialsRequest.Users
+
+	errorCode := utils.NONE
+	if !isClusterAuthorized(req, authorizer.OPERATION_DESCRIBE) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	}
+	results := []scramCredentialsResult{}
+	if errorCode == utils.NONE {
+		credentials := map[string][]*metadata.UserScramCredentialRecord{}
+		for _, credential := range metadata.Image().ScramCredentials {
+			credentials[credential.Name] = append(credentials[credential.Name], credential)
+		}
+		if users == nil {
+			for user := range credentials {
+				users = append(users, user)
+			}
+			slices.Sort(users)
+		}
+		named := map[string]int{}
+		for _, user := range users {
+			named[user]++
+		}
+		for _, user := range users {
+			result := scramCredentialsResult{user: user, errorCode: utils.NONE, credentials: credentials[user]}
+			if named[user] > 1 {
+				result = scramCredentialsResult{user: user, errorCode: utils.DUPLICATE_RESOURCE}
+			} else if len(result.credentials) == 0 {
+				result.errorCode = utils.RESOURCE_NOT_FOUND
+			}
+			slices.SortFunc(result.credentials, func(a, b *metadata.UserScramCredentialRecord) int {
+				return cmp.Compare(a.Mechanism, b.Mechanism)
+			})
+			results = append(results, result)
+		}
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	binary.Write(&body, binary.BigEndian, errorCode)
+	// error_message
+	utils.WriteCompactNullableString(&body, nil)
+	utils.WriteCompactArrayLength(&body, len(results))
+	for _, result := range results {
+		utils.WriteCompactString(&body, result.user)
+		binary.Write(&body, binary.BigEndian, result.errorCode)
+		// error_message
+		utils.WriteCompactNullableString(&body, nil)
+		utils.WriteCompactArrayLength(&body, len(result.credentials))
+		for _, credential := range result.credentials {
+			binary.Write(&body, binary.BigEndian, credential.Mechanism)
+			binary.Write(&body, binary.BigEndian, credential.Iterations)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeAlterUserScramCredentials(req request.Request) ([]byte, error) {
+	alterRequest := req.AlterUserScramCredentialsRequest
+
+	deletions := []controller.ScramCredentialDeletion{}
+	for _, deletion := range alterRequest.Deletions {
+		deletions = append(deletions, controller.ScramCredentialDeletion{Name: deletion.Name, Mechanism: deletion.Mechanism})
+	}
+	upsertions := []controller.ScramCredentialUpsertion{}
+	for _, upsertion := range alterRequest.Upsertions {
+		upsertions = append(upsertions, controller.ScramCredentialUpsertion{
+			Name:           upsertion.Name,
+			Mechanism:      upsertion.Mechanism,
+			Iterations:     upsertion.Iterations,
+			Salt:           upsertion.Salt,
+			SaltedPassword: upsertion.SaltedPassword,
+		})
+	}
+
+	errorCode := utils.NONE
+	if !isClusterAuthorized(req, authorizer.OPERATION_ALTER) {
+		errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+	} else if controller.Controller() == nil {
+		errorCode = utils.NOT_CONTROLLER
+	}
+	results := []controller.UserResult{}
+	if errorCode != utils.NONE {
+		users := []string{}
+		for _, deletion := range deletions {
+			users = append(users, deletion.Name)
+		}
+		for _, upsertion := range upsertions {
+			users = append(users, upsertion.Name)
+		}
+		for i, user := range users {
+			if !slices.Contains(users[:i], user) {
+				results = append(results, controller.UserResult{User: user, ErrorCode: errorCode})
+			}
+		}
+	} else {
+		results = controller.Controller().AlterUserScramCredentials(deletions, upsertions)
+	}
+
+	var body bytes.Buffer
+	// throttle_time_ms
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(results))
+	for _, result := range results {
+		utils.WriteCompactString(&body, result.User)
+		binary.Write(&body, binary.BigEndian, result.ErrorCode)
+		// error_message
+		utils.WriteCompactNullableString(&body, nil)
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
@@ -0,0 +1,193 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/request"
+	"github.com/codecrafters-io/kafka-starter-go/app/txn"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func writeThrottleAndError(body *bytes.Buffer, throttleTimeMs int32, errorCode int16) {
+	// Throttle Time
+	binary.Write(body, binary.BigEndian, throttleTimeMs)
+	binary.Write(body, binary.BigEndian, errorCode)
+	utils.WriteTaggedFields(body)
+}
+
+func SerializeAddPartitionsToTxn(req request.Request) ([]byte, error) {
+	addPartitionsRequest := req.AddPartitionsToTxnRequest
+
+	// Nothing is added unless every partition may be written to
+	partitions := []txn.TopicPartition{}
+	results := map[txn.TopicPartition]int16{}
+	transactionalIdAuthorized := isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TRANSACTIONAL_ID, addPartitionsRequest.TransactionalID)
+	for _, topic := range addPartitionsRequest.Topics {
+		topicAuthorized := isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TOPIC, topic.Name)
+		for _, partition := range topic.Partitions {
+			tp := txn.TopicPartition{Topic: topic.Name, Partition: partition}
+			partitions = append(partitions, tp)
+			if !transactionalIdAuthorized {
+				results[tp] = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+			} else if !topicAuthorized {
+				results[tp] = utils.TOPIC_AUTHORIZATION_FAILED
+			}
+		}
+	}
+	if len(results) > 0 {
+		for _, tp := range partitions {
+			if _, ok := results[tp]; !ok {
+				results[tp] = utils.OPERATION_NOT_ATTEMPTED
+			}
+		}
+	} else if coordinator := txn.Coordinator(); coordinator != nil {
+		results = coordinator.AddPartitions(addPartitionsRequest.TransactionalID, addPartitionsRequest.ProducerID, addPartitionsRequest.ProducerEpoch, partitions)
+	}
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(addPartitionsRequest.Topics))
+	for _, topic := range addPartitionsRequest.Topics {
+		utils.WriteCompactString(&body, topic.Name)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			errorCode, ok := results[txn.TopicPartition{Topic: topic.Name, Partition: partition}]
+			if !ok {
+				errorCode = utils.COORDINATOR_NOT_AVAILABLE
+			}
+			binary.Write(&body, binary.BigEndian, partition)
+			binary.Write(&body, binary.BigEndian, errorCode)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeAddOffsetsToTxn(req request.Request) ([]byte, error) {
+	addOffsetsRequest := req.AddOffsetsToTxnRequest
+
+	errorCode := utils.COORDINATOR_NOT_AVAILABLE
+	if !isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TRANSACTIONAL_ID, addOffsetsRequest.TransactionalID) {
+		errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if !isAuthorized(req, authorizer.OPERATION_READ, authorizer.RESOURCE_GROUP, addOffsetsRequest.GroupID) {
+		errorCode = utils.GROUP_AUTHORIZATION_FAILED
+	} else if coordinator := txn.Coordinator(); coordinator != nil {
+		errorCode = coordinator.AddOffsets(addOffsetsRequest.TransactionalID, addOffsetsRequest.ProducerID, addOffsetsRequest.ProducerEpoch, addOffsetsRequest.GroupID)
+	}
+
+	var body bytes.Buffer
+	writeThrottleAndError(&body, req.ThrottleTimeMs, errorCode)
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeEndTxn(req request.Request) ([]byte, error) {
+	endTxnRequest := req.EndTxnRequest
+
+	errorCode := utils.COORDINATOR_NOT_AVAILABLE
+	if !isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TRANSACTIONAL_ID, endTxnRequest.TransactionalID) {
+		errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if coordinator := txn.Coordinator(); coordinator != nil {
+		errorCode = coordinator.EndTxn(endTxnRequest.TransactionalID, endTxnRequest.ProducerID, endTxnRequest.ProducerEpoch, endTxnRequest.Committed)
+	}
+
+	var body bytes.Buffer
+	writeThrottleAndError(&body, req.ThrottleTimeMs, errorCode)
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeWriteTxnMarkers(req request.Request) ([]byte, error) {
+	writeTxnMarkersRequest := req.WriteTxnMarkersRequest
+	// Only the transaction coordinators of the cluster write markers
+	authorized := isClusterAuthorized(req, authorizer.OPERATION_CLUSTER_ACTION)
+
+	var body bytes.Buffer
+	utils.WriteCompactArrayLength(&body, len(writeTxnMarkersRequest.Markers))
+	for _, marker := range writeTxnMarkersRequest.Markers {
+		binary.Write(&body, binary.BigEndian, marker.ProducerID)
+		utils.WriteCompactArrayLength(&body, len(marker.Topics))
+		for _, topic := range marker.Topics {
+			utils.WriteCompactString(&body, topic.Name)
+			utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				tp := txn.TopicPartition{Topic: topic.Name, Partition: partition}
+				errorCode := utils.NONE
+				if !authorized {
+					errorCode = utils.CLUSTER_AUTHORIZATION_FAILED
+				} else if topic.Name != txn.CONSUMER_OFFSETS_TOPIC && !txnPartitionExists(tp) {
+					errorCode = utils.UNKNOWN_TOPIC_OR_PARTITION
+				} else {
+					errorCode = storageErrorCode(txn.WriteMarker(tp, marker.ProducerID, marker.ProducerEpoch, marker.CoordinatorEpoch, marker.TransactionResult))
+				}
+				binary.Write(&body, binary.BigEndian, partition)
+				binary.Write(&body, binary.BigEndian, errorCode)
+				utils.WriteTaggedFields(&body)
+			}
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func SerializeTxnOffsetCommit(req request.Request) ([]byte, error) {
+	txnOffsetCommitRequest := req.TxnOffsetCommitRequest
+
+	offsets := map[txn.TopicPartition]txn.OffsetAndMetadata{}
+	for _, topic := range txnOffsetCommitRequest.Topics {
+		for _, partition := range topic.Partitions {
+			offset := txn.OffsetAndMetadata{Offset: partition.CommittedOffset, LeaderEpoch: partition.CommittedLeaderEpoch}
+			if partition.CommittedMetadata != nil {
+				offset.Metadata = *partition.CommittedMetadata
+			}
+			offsets[txn.TopicPartition{Topic: topic.Name, Partition: partition.PartitionIndex}] = offset
+		}
+	}
+
+	topicsAuthorized := true
+	for _, topic := range txnOffsetCommitRequest.Topics {
+		topicsAuthorized = topicsAuthorized && isAuthorized(req, authorizer.OPERATION_READ, authorizer.RESOURCE_TOPIC, topic.Name)
+	}
+	errorCode := utils.COORDINATOR_NOT_AVAILABLE
+	if !isAuthorized(req, authorizer.OPERATION_WRITE, authorizer.RESOURCE_TRANSACTIONAL_ID, txnOffsetCommitRequest.TransactionalID) {
+		errorCode = utils.TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if !isAuthorized(req, authorizer.OPERATION_READ, authorizer.RESOURCE_GROUP, txnOffsetCommitRequest.GroupID) {
+		errorCode = utils.GROUP_AUTHORIZATION_FAILED
+	} else if !topicsAuthorized {
+		errorCode = utils.TOPIC_AUTHORIZATION_FAILED
+	} else if coordinator := txn.Coordinator(); coordinator != nil {
+		errorCode = coordinator.TxnOffsetCommit(txnOffsetCommitRequest.TransactionalID, txnOffsetCommitRequest.GroupID,
+			txnOffsetCommitRequest.ProducerID, txnOffsetCommitRequest.ProducerEpoch, offsets)
+	}
+
+	var body bytes.Buffer
+	// Throttle Time
+	binary.Write(&body, binary.BigEndian, req.ThrottleTimeMs)
+	utils.WriteCompactArrayLength(&body, len(txnOffsetCommitRequest.Topics))
+	for _, topic := range txnOffsetCommitRequest.Topics {
+		utils.WriteCompactString(&body, topic.Name)
+		utils.WriteCompactArrayLength(&body, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			binary.Write(&body, binary.BigEndian, partition.PartitionIndex)
+			binary.Write(&body, binary.BigEndian, errorCode)
+			utils.WriteTaggedFields(&body)
+		}
+		utils.WriteTaggedFields(&body)
+	}
+	utils.WriteTaggedFields(&body)
+
+	return writeFlexibleResponse(req, &body), nil
+}
+
+func txnPartitionExists(tp txn.TopicPartition) bool {
+	clusterTopic := metadata.GetClusterTopic(tp.Topic)
+	return clusterTopic.ErrorCode == utils.NONE && findPartition(clusterTopic, tp.Partition) != nil
+}
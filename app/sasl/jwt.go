@@ -0,0 +1,287 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JwtValidator validates JWTs: unsecured ones when AllowUnsecured, and JWS
+// tokens signed by a key in Keys. Every token must name a principal and
+// expire.
+type JwtValidator struct {
+	PrincipalClaim string
+	ScopeClaim     string
+	// Keys are the public keys of the JWKS, by key id
+	Keys             map[string]crypto.PublicKey
+	AllowUnsecured   bool
+	ExpectedIssuer   string
+	ExpectedAudience []string
+	// ClockSkew is how far token times may be off from the broker's clock
+	ClockSkew time.Duration
+}
+
+func NewJwtValidator() *JwtValidator {
+	return &JwtValidator{PrincipalClaim: "sub", ScopeClaim: "scope", ClockSkew: 30 * time.Second}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (v *JwtValidator) Validate(token string) (*OAuthBearerToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+	header := jwtHeader{}
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	claims := map[string]any{}
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.verifySignature(header, parts); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, fmt.Errorf("no exp claim")
+	}
+	if now.After(expiry.Add(v.ClockSkew)) {
+		return nil, fmt.Errorf("token expired at %s", expiry.Format(time.RFC3339))
+	}
+	if notBefore, ok := numericDate(claims["nbf"]); ok && now.Add(v.ClockSkew).Before(notBefore) {
+		return nil, fmt.Errorf("token is not valid before %s", notBefore.Format(time.RFC3339))
+	}
+	if issuedAt, ok := numericDate(claims["iat"]); ok && now.Add(v.ClockSkew).Before(issuedAt) {
+		return nil, fmt.Errorf("token was issued in the future")
+	}
+	if v.ExpectedIssuer != "" && claims["iss"] != v.ExpectedIssuer {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if len(v.ExpectedAudience) > 0 && !slices.ContainsFunc(stringsClaim(claims["aud"]), func(audience string) bool {
+		return slices.Contains(v.ExpectedAudience, audience)
+	}) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	principal, _ := claims[v.PrincipalClaim].(string)
+	if principal == "" {
+		return nil, fmt.Errorf("no %s claim", v.PrincipalClaim)
+	}
+	return &OAuthBearerToken{
+		Value:     token,
+		Principal: principal,
+		Scope:     stringsClaim(claims[v.ScopeClaim]),
+		Expiry:    expiry,
+	}, nil
+}
+
+func (v *JwtValidator) verifySignature(header jwtHeader, parts []string) error {
+	if header.Alg == "none" {
+		if !v.AllowUnsecured || parts[2] != "" {
+			return fmt.Errorf("unsecured JWTs are not accepted")
+		}
+		return nil
+	}
+	key, ok := v.Keys[header.Kid]
+	if !ok && header.Kid == "" && len(v.Keys) == 1 {
+		for _, only := range v.Keys {
+			key, ok = only, true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("unknown key id %q", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed JWT signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	var hash crypto.Hash
+	switch header.Alg[min(2, len(header.Alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	digest := jwsDigest(hash, signed)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(header.Alg, "RS") {
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		} else if strings.HasPrefix(header.Alg, "PS") {
+			err = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = fmt.Errorf("algorithm %q does not match an RSA key", header.Alg)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(header.Alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			err = fmt.Errorf("invalid signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported key type %T", key)
+	}
+	return err
+}
+
+func jwsDigest(hash crypto.Hash, signed []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		digest := sha512.Sum384(signed)
+		return digest[:]
+	case crypto.SHA512:
+		digest := sha512.Sum512(signed)
+		return digest[:]
+	}
+	digest := sha256.Sum256(signed)
+	return digest[:]
+}
+
+func decodeJwtPart(part string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed JWT")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("malformed JWT")
+	}
+	return nil
+}
+
+// numericDate reads a JWT time, in seconds since the epoch.
+func numericDate(claim any) (time.Time, bool) {
+	number, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(seconds * 1000)), true
+}
+
+// stringsClaim reads a claim that is either a list of strings or a single
+// space separated string, as scopes are.
+func stringsClaim(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []any:
+		values := []string{}
+		for _, value := range claim {
+			if value, ok := value.(string); ok {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJwks reads the signing keys of a JWKS file, given as a path or a file:
+// URL.
+func LoadJwks(location string) (map[string]crypto.PublicKey, error) {
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" {
+		if u.Scheme != "file" {
+			return nil, fmt.Errorf("only file JWKS locations are supported, not %s", u.Scheme)
+		}
+		location = u.Path
+	}
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
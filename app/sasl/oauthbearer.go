@@ -0,0 +1,76 @@
+package sasl
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type OAuthBearerToken struct {
+	Value     string
+	Principal string
+	Scope     []string
+	Expiry    time.Time
+}
+
+// TokenValidator checks the bearer tokens OAUTHBEARER clients send.
+type TokenValidator interface {
+	Validate(token string) (*OAuthBearerToken, error)
+}
+
+// Validator validates OAUTHBEARER tokens. The mechanism is disabled while it
+// is nil.
+var Validator TokenValidator
+
+// oauthBearerAuthenticator checks the client response of RFC 7628. A
+// rejected token is answered with an error challenge, which the client
+// acknowledges before the exchange fails.
+type oauthBearerAuthenticator struct {
+	token  *OAuthBearerToken
+	failed error
+}
+
+func (a *oauthBearerAuthenticator) evaluate(response []byte) ([]byte, bool, error) {
+	if a.failed != nil {
+		return nil, false, a.failed
+	}
+	// gs2-header, then key=value pairs each ended by ^A, then a final ^A
+	gs2Header, kvPairs, ok := strings.Cut(string(response), "\x01")
+	if !ok || !strings.HasSuffix(kvPairs, "\x01\x01") {
+		return nil, false, ErrInvalidMessage
+	}
+	gs2Parts := strings.Split(gs2Header, ",")
+	if len(gs2Parts) != 3 || gs2Parts[0] != "n" || gs2Parts[2] != "" {
+		return nil, false, ErrInvalidMessage
+	}
+	var auth string
+	for _, kvPair := range strings.Split(strings.TrimSuffix(kvPairs, "\x01\x01"), "\x01") {
+		if key, value, ok := strings.Cut(kvPair, "="); ok && key == "auth" {
+			auth = value
+		}
+	}
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, false, ErrInvalidMessage
+	}
+
+	validated, err := Validator.Validate(token)
+	if err == nil && gs2Parts[1] != "" && gs2Parts[1] != "a="+validated.Principal {
+		err = ErrAuthenticationFailed
+	}
+	if err != nil {
+		a.failed = err
+		challenge, _ := json.Marshal(map[string]string{"status": "invalid_token"})
+		return challenge, false, nil
+	}
+	a.token = validated
+	return []byte{}, true, nil
+}
+
+func (a *oauthBearerAuthenticator) principal() string {
+	return "User:" + a.token.Principal
+}
+
+func (a *oauthBearerAuthenticator) expiry() time.Time {
+	return a.token.Expiry
+}
@@ -0,0 +1,55 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"regexp"
+	"time"
+)
+
+// PlainUsers are the passwords of the users PLAIN authenticates, by name.
+var PlainUsers = map[string]string{}
+
+var jaasUserOption = regexp.MustCompile(`user_([^=\s]+)\s*=\s*"([^"]*)"`)
+
+// ParsePlainJaasUsers reads the user_<name>="<password>" options of a
+// PlainLoginModule sasl.jaas.config.
+func ParsePlainJaasUsers(jaasConfig string) map[string]string {
+	users := map[string]string{}
+	for _, match := range jaasUserOption.FindAllStringSubmatch(jaasConfig, -1) {
+		users[match[1]] = match[2]
+	}
+	return users
+}
+
+// plainAuthenticator checks the single message of RFC 4616,
+// [authzid] NUL authcid NUL passwd.
+type plainAuthenticator struct {
+	username string
+}
+
+func (a *plainAuthenticator) evaluate(response []byte) ([]byte, bool, error) {
+	parts := bytes.Split(response, []byte{0})
+	if len(parts) != 3 || len(parts[1]) == 0 {
+		return nil, false, ErrInvalidMessage
+	}
+	authzid, username, password := string(parts[0]), string(parts[1]), parts[2]
+	// Clients may not act as another user
+	if authzid != "" && authzid != username {
+		return nil, false, ErrAuthenticationFailed
+	}
+	expected, ok := PlainUsers[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), password) != 1 {
+		return nil, false, ErrAuthenticationFailed
+	}
+	a.username = username
+	return []byte{}, true, nil
+}
+
+func (a *plainAuthenticator) principal() string {
+	return "User:" + a.username
+}
+
+func (a *plainAuthenticator) expiry() time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,143 @@
+package sasl
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const MECHANISM_PLAIN = "PLAIN"
+const MECHANISM_SCRAM_SHA_256 = "SCRAM-SHA-256"
+const MECHANISM_SCRAM_SHA_512 = "SCRAM-SHA-512"
+const MECHANISM_OAUTHBEARER = "OAUTHBEARER"
+
+var SUPPORTED_MECHANISMS = []string{MECHANISM_PLAIN, MECHANISM_SCRAM_SHA_256, MECHANISM_SCRAM_SHA_512, MECHANISM_OAUTHBEARER}
+
+var ErrAuthenticationFailed = errors.New("invalid username or password")
+var ErrInvalidMessage = errors.New("invalid SASL message")
+
+// authenticator is the server side of one mechanism's exchange. evaluate
+// answers each client message with a challenge, until it has authenticated
+// the client as principal. expiry is when the client's credential expires,
+// or zero if it does not.
+type authenticator interface {
+	evaluate(response []byte) (challenge []byte, complete bool, err error)
+	principal() string
+	expiry() time.Time
+}
+
+func newAuthenticator(mechanism string) authenticator {
+	switch mechanism {
+	case MECHANISM_PLAIN:
+		return &plainAuthenticator{}
+	case MECHANISM_SCRAM_SHA_256:
+		return &scramAuthenticator{mechanism: SCRAM_SHA_256}
+	case MECHANISM_SCRAM_SHA_512:
+		return &scramAuthenticator{mechanism: SCRAM_SHA_512}
+	case MECHANISM_OAUTHBEARER:
+		if Validator != nil {
+			return &oauthBearerAuthenticator{}
+		}
+	}
+	return nil
+}
+
+const STATE_HANDSHAKE = 0
+const STATE_AUTHENTICATE = 1
+const STATE_COMPLETE = 2
+const STATE_FAILED = 3
+
+// Session is the SASL exchange of a connection: a SaslHandshake picking the
+// mechanism, then SaslAuthenticate requests until the client is
+// authenticated or has failed to. Clients re-authenticate (KIP-368) by
+// starting over before the session expires, with the same mechanism and
+// principal.
+type Session struct {
+	Mechanisms []string
+	// MaxReauth caps the lifetime of sessions, which otherwise last as long as
+	// the client's credential. Zero leaves them uncapped.
+	MaxReauth     time.Duration
+	state         int
+	mechanism     string
+	authenticator authenticator
+	principal     string
+	expiresAt     time.Time
+}
+
+func NewSession(mechanisms []string, maxReauth time.Duration) *Session {
+	return &Session{Mechanisms: mechanisms, MaxReauth: maxReauth}
+}
+
+func (s *Session) Handshake(mechanism string) int16 {
+	if s.state != STATE_HANDSHAKE && s.state != STATE_COMPLETE {
+		return utils.ILLEGAL_SASL_STATE
+	}
+	if !slices.Contains(s.Mechanisms, mechanism) || newAuthenticator(mechanism) == nil {
+		return utils.UNSUPPORTED_SASL_MECHANISM
+	}
+	if s.state == STATE_COMPLETE && mechanism != s.mechanism {
+		s.state = STATE_FAILED
+		return utils.ILLEGAL_SASL_STATE
+	}
+	s.mechanism = mechanism
+	s.authenticator = newAuthenticator(mechanism)
+	s.state = STATE_AUTHENTICATE
+	return utils.NONE
+}
+
+// Authenticate evaluates a client message. Any failure ends the session, and
+// the connection is closed once the error is sent.
+func (s *Session) Authenticate(response []byte) ([]byte, int16, error) {
+	if s.state != STATE_AUTHENTICATE {
+		s.state = STATE_FAILED
+		return nil, utils.ILLEGAL_SASL_STATE, errors.New("unexpected SaslAuthenticate request")
+	}
+	challenge, complete, err := s.authenticator.evaluate(response)
+	if err == nil && complete && s.principal != "" && s.authenticator.principal() != s.principal {
+		err = errors.New("re-authenticated as a different principal")
+	}
+	if err != nil {
+		s.state = STATE_FAILED
+		return nil, utils.SASL_AUTHENTICATION_FAILED, err
+	}
+	if complete {
+		s.state = STATE_COMPLETE
+		s.principal = s.authenticator.principal()
+		s.expiresAt = s.authenticator.expiry()
+		if s.MaxReauth > 0 && (s.expiresAt.IsZero() || time.Until(s.expiresAt) > s.MaxReauth) {
+			s.expiresAt = time.Now().Add(s.MaxReauth)
+		}
+	}
+	return challenge, utils.NONE, nil
+}
+
+// IsAuthenticated reports whether the client may send requests other than
+// the SASL ones, which it may not while it re-authenticates.
+func (s *Session) IsAuthenticated() bool {
+	return s.state == STATE_COMPLETE
+}
+
+func (s *Session) Failed() bool {
+	return s.state == STATE_FAILED
+}
+
+// Expired reports whether the client should have re-authenticated by now.
+func (s *Session) Expired() bool {
+	return !s.expiresAt.IsZero() && time.Now().After(s.expiresAt)
+}
+
+// SessionLifetimeMs is how long the client has until it must re-authenticate,
+// or 0 if it never has to.
+func (s *Session) SessionLifetimeMs() int64 {
+	if s.state != STATE_COMPLETE || s.expiresAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(s.expiresAt).Milliseconds(), 1)
+}
+
+// Principal is the authenticated user, as User:name.
+func (s *Session) Principal() string {
+	return s.principal
+}
@@ -0,0 +1,218 @@
+package sasl
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+func TestPlain(t *testing.T) {
+	PlainUsers = ParsePlainJaasUsers(`org.apache.kafka.common.security.plain.PlainLoginModule required username="admin" password="admin-secret" user_admin="admin-secret" user_alice="alice-secret";`)
+	t.Cleanup(func() { PlainUsers = map[string]string{} })
+
+	session := NewSession(SUPPORTED_MECHANISMS, 0)
+	if errorCode := session.Handshake("GSSAPI"); errorCode != utils.UNSUPPORTED_SASL_MECHANISM {
+		t.Fatalf("Expected GSSAPI to be unsupported, got %d", errorCode)
+	}
+	if errorCode := session.Handshake(MECHANISM_PLAIN); errorCode != utils.NONE {
+		t.Fatalf("Expected the handshake to succeed, got %d", errorCode)
+	}
+	if _, errorCode, err := session.Authenticate([]byte("\x00alice\x00alice-secret")); errorCode != utils.NONE {
+		t.Fatalf("Expected alice to authenticate, got %d: %v", errorCode, err)
+	}
+	if !session.IsAuthenticated() || session.Principal() != "User:alice" {
+		t.Fatalf("Expected to be authenticated as User:alice, got %s", session.Principal())
+	}
+
+	session = NewSession(SUPPORTED_MECHANISMS, 0)
+	session.Handshake(MECHANISM_PLAIN)
+	if _, errorCode, _ := session.Authenticate([]byte("admin\x00alice\x00alice-secret")); errorCode != utils.SASL_AUTHENTICATION_FAILED || !session.Failed() {
+		t.Fatalf("Expected alice not to act as admin, got %d", errorCode)
+	}
+}
+
+func TestScram(t *testing.T) {
+	salt := []byte("NaCl")
+	credential := NewScramCredential("alice", SCRAM_SHA_512, salt, SaltedPassword(SCRAM_SHA_512, []byte("alice-secret"), salt, 4096), 4096)
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	delta.Replay(credential)
+	metadata.Publish(delta.Apply())
+	t.Cleanup(func() { metadata.Publish(metadata.EmptyImage()) })
+
+	for _, c := range []struct {
+		password      string
+		authenticated bool
+	}{{"alice-secret", true}, {"wrong", false}} {
+		session := NewSession([]string{MECHANISM_SCRAM_SHA_512}, 0)
+		if errorCode := session.Handshake(MECHANISM_SCRAM_SHA_512); errorCode != utils.NONE {
+			t.Fatalf("Expected the handshake to succeed, got %d", errorCode)
+		}
+
+		clientFirstBare := "n=alice,r=fyko+d2lbbFgONRv9qkxdawL"
+		serverFirst, errorCode, err := session.Authenticate([]byte("n,," + clientFirstBare))
+		if errorCode != utils.NONE {
+			t.Fatalf("Expected a server-first message, got %d: %v", errorCode, err)
+		}
+		attributes, _ := parseScramAttributes(string(serverFirst))
+		if !strings.HasPrefix(attributes["r"], "fyko+d2lbbFgONRv9qkxdawL") || attributes["i"] != "4096" {
+			t.Fatalf("Unexpected server-first message %s", serverFirst)
+		}
+
+		iterations, _ := strconv.Atoi(attributes["i"])
+		saltedPassword := SaltedPassword(SCRAM_SHA_512, []byte(c.password), salt, iterations)
+		clientFinalWithoutProof := "c=biws,r=" + attributes["r"]
+		authMessage := []byte(clientFirstBare + "," + string(serverFirst) + "," + clientFinalWithoutProof)
+		clientKey := scramHmac(SCRAM_SHA_512, saltedPassword, []byte("Client Key"))
+		storedKey := scramHash(SCRAM_SHA_512)()
+		storedKey.Write(clientKey)
+		proof := scramHmac(SCRAM_SHA_512, storedKey.Sum(nil), authMessage)
+		for i := range proof {
+			proof[i] ^= clientKey[i]
+		}
+
+		serverFinal, errorCode, _ := session.Authenticate([]byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)))
+		if session.IsAuthenticated() != c.authenticated {
+			t.Fatalf("Expected %s to authenticate: %v, got error %d", c.password, c.authenticated, errorCode)
+		}
+		if !c.authenticated {
+			continue
+		}
+		serverSignature := scramHmac(SCRAM_SHA_512, scramHmac(SCRAM_SHA_512, saltedPassword, []byte("Server Key")), authMessage)
+		if !hmac.Equal(serverFinal, []byte("v="+base64.StdEncoding.EncodeToString(serverSignature))) {
+			t.Fatalf("Unexpected server-final message %s", serverFinal)
+		}
+		if session.Principal() != "User:alice" {
+			t.Fatalf("Expected User:alice, got %s", session.Principal())
+		}
+	}
+}
+
+func jwt(t *testing.T, header map[string]any, claims map[string]any, sign func([]byte) []byte) string {
+	encode := func(value any) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	if sign == nil {
+		return signed + "."
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func oauthBearerResponse(token string) []byte {
+	return []byte("n,,\x01auth=Bearer " + token + "\x01\x01")
+}
+
+func TestOAuthBearer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadJwks("file://" + jwksPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := NewJwtValidator()
+	validator.PrincipalClaim = "client_id"
+	validator.Keys = keys
+	Validator = validator
+	t.Cleanup(func() { Validator = nil })
+
+	rs256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	expiry := time.Now().Add(time.Hour).Unix()
+	signedToken := jwt(t, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{"client_id": "orders", "scope": "read write", "exp": expiry}, rs256)
+
+	token, err := validator.Validate(signedToken)
+	if err != nil {
+		t.Fatalf("Expected the signed token to be valid: %v", err)
+	}
+	if token.Principal != "orders" || !slices.Equal(token.Scope, []string{"read", "write"}) || token.Expiry.Unix() != expiry {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+	for _, invalid := range []string{
+		jwt(t, map[string]any{"alg": "none"}, map[string]any{"client_id": "orders", "exp": expiry}, nil),
+		jwt(t, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{"client_id": "orders", "exp": time.Now().Add(-time.Hour).Unix()}, rs256),
+		jwt(t, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{"sub": "orders", "exp": expiry}, rs256),
+		jwt(t, map[string]any{"alg": "RS256", "kid": "k2"}, map[string]any{"client_id": "orders", "exp": expiry}, rs256),
+		signedToken[:len(signedToken)-4] + "AAAA",
+	} {
+		if _, err := validator.Validate(invalid); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+
+	validator.AllowUnsecured = true
+	unsecured := jwt(t, map[string]any{"alg": "none"}, map[string]any{"client_id": "orders", "exp": expiry}, nil)
+	if _, err := validator.Validate(unsecured); err != nil {
+		t.Fatalf("Expected the unsecured token to be valid: %v", err)
+	}
+
+	session := NewSession([]string{MECHANISM_OAUTHBEARER}, 10*time.Minute)
+	session.Handshake(MECHANISM_OAUTHBEARER)
+	if _, errorCode, err := session.Authenticate(oauthBearerResponse(signedToken)); errorCode != utils.NONE {
+		t.Fatalf("Expected to authenticate, got %d: %v", errorCode, err)
+	}
+	if session.Principal() != "User:orders" {
+		t.Fatalf("Expected User:orders, got %s", session.Principal())
+	}
+	// The session is capped by connections.max.reauth.ms rather than the token
+	if lifetime := session.SessionLifetimeMs(); lifetime <= 0 || lifetime > (10*time.Minute).Milliseconds() {
+		t.Fatalf("Unexpected session lifetime %d", lifetime)
+	}
+
+	// Re-authenticating as someone else ends the session
+	other := jwt(t, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{"client_id": "payments", "exp": expiry}, rs256)
+	if errorCode := session.Handshake(MECHANISM_OAUTHBEARER); errorCode != utils.NONE {
+		t.Fatalf("Expected to re-authenticate, got %d", errorCode)
+	}
+	if _, errorCode, _ := session.Authenticate(oauthBearerResponse(other)); errorCode != utils.SASL_AUTHENTICATION_FAILED || !session.Failed() {
+		t.Fatalf("Expected re-authenticating as User:payments to fail, got %d", errorCode)
+	}
+
+	// A rejected token is answered with an error challenge first
+	session = NewSession([]string{MECHANISM_OAUTHBEARER}, 0)
+	session.Handshake(MECHANISM_OAUTHBEARER)
+	challenge, errorCode, _ := session.Authenticate(oauthBearerResponse("not.a.token"))
+	if errorCode != utils.NONE || !strings.Contains(string(challenge), "invalid_token") {
+		t.Fatalf("Expected an error challenge, got %d %s", errorCode, challenge)
+	}
+	if _, errorCode, _ := session.Authenticate([]byte("\x01")); errorCode != utils.SASL_AUTHENTICATION_FAILED {
+		t.Fatalf("Expected authentication to fail, got %d", errorCode)
+	}
+}
@@ -0,0 +1,194 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+)
+
+// SCRAM mechanisms as numbered by the Kafka protocol
+const SCRAM_UNKNOWN int8 = 0
+const SCRAM_SHA_256 int8 = 1
+const SCRAM_SHA_512 int8 = 2
+
+// The iteration counts Kafka accepts for SCRAM credentials
+const SCRAM_MIN_ITERATIONS = 4096
+const SCRAM_MAX_ITERATIONS = 16384
+
+func ScramMechanismName(mechanism int8) string {
+	switch mechanism {
+	case SCRAM_SHA_256:
+		return MECHANISM_SCRAM_SHA_256
+	case SCRAM_SHA_512:
+		return MECHANISM_SCRAM_SHA_512
+	}
+	return ""
+}
+
+func scramHash(mechanism int8) func() hash.Hash {
+	if mechanism == SCRAM_SHA_512 {
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func scramHmac(mechanism int8, key []byte, message []byte) []byte {
+	mac := hmac.New(scramHash(mechanism), key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// SaltedPassword is Hi() of RFC 5802, which is PBKDF2 with the mechanism's
+// HMAC and an output as long as its hash.
+func SaltedPassword(mechanism int8, password []byte, salt []byte, iterations int) []byte {
+	block := scramHmac(mechanism, password, binary.BigEndian.AppendUint32(append([]byte{}, salt...), 1))
+	result := append([]byte{}, block...)
+	for range iterations - 1 {
+		block = scramHmac(mechanism, password, block)
+		for i := range result {
+			result[i] ^= block[i]
+		}
+	}
+	return result
+}
+
+// NewScramCredential derives the stored and server keys of a user from their
+// salted password.
+func NewScramCredential(name string, mechanism int8, salt []byte, saltedPassword []byte, iterations int32) *metadata.UserScramCredentialRecord {
+	clientKey := scramHmac(mechanism, saltedPassword, []byte("Client Key"))
+	storedKey := scramHash(mechanism)()
+	storedKey.Write(clientKey)
+	return &metadata.UserScramCredentialRecord{
+		Name:       name,
+		Mechanism:  mechanism,
+		Salt:       salt,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  scramHmac(mechanism, saltedPassword, []byte("Server Key")),
+		Iterations: iterations,
+	}
+}
+
+// parseScramAttributes splits a SCRAM message into its attributes, which
+// are single letters.
+func parseScramAttributes(message string) (map[string]string, error) {
+	attributes := map[string]string{}
+	for _, attribute := range strings.Split(message, ",") {
+		name, value, ok := strings.Cut(attribute, "=")
+		if !ok {
+			return nil, ErrInvalidMessage
+		}
+		attributes[name] = value
+	}
+	return attributes, nil
+}
+
+// unescapeSaslName decodes a username as RFC 5802 escapes it.
+func unescapeSaslName(name string) string {
+	return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(name)
+}
+
+// scramAuthenticator is the server of RFC 5802. It is answered by the
+// client-first message, then the client-final message with its proof.
+type scramAuthenticator struct {
+	mechanism       int8
+	step            int
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	credential      *metadata.UserScramCredentialRecord
+}
+
+func (a *scramAuthenticator) evaluate(response []byte) ([]byte, bool, error) {
+	if a.step == 0 {
+		a.step++
+		challenge, err := a.clientFirst(string(response))
+		return challenge, false, err
+	}
+	challenge, err := a.clientFinal(string(response))
+	return challenge, err == nil, err
+}
+
+func (a *scramAuthenticator) clientFirst(message string) ([]byte, error) {
+	// gs2-header is the channel binding flag, which must not ask for channel
+	// binding, and the optional authzid
+	parts := strings.SplitN(message, ",", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") {
+		return nil, ErrInvalidMessage
+	}
+	a.gs2Header = parts[0] + "," + parts[1] + ","
+	a.clientFirstBare = parts[2]
+	attributes, err := parseScramAttributes(a.clientFirstBare)
+	if err != nil || attributes["n"] == "" || attributes["r"] == "" {
+		return nil, ErrInvalidMessage
+	}
+	username := unescapeSaslName(attributes["n"])
+	if parts[1] != "" && parts[1] != "a="+attributes["n"] {
+		return nil, ErrAuthenticationFailed
+	}
+	credential, ok := metadata.Image().ScramCredentials[metadata.ScramCredentialKey{Name: username, Mechanism: a.mechanism}]
+	if !ok {
+		return nil, ErrAuthenticationFailed
+	}
+	a.credential = credential
+
+	serverNonce := make([]byte, 18)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	a.nonce = attributes["r"] + base64.RawURLEncoding.EncodeToString(serverNonce)
+	a.serverFirst = "r=" + a.nonce + ",s=" + base64.StdEncoding.EncodeToString(credential.Salt) + ",i=" + strconv.Itoa(int(credential.Iterations))
+	return []byte(a.serverFirst), nil
+}
+
+func (a *scramAuthenticator) clientFinal(message string) ([]byte, error) {
+	withoutProof, proofAttribute, ok := strings.Cut(message, ",p=")
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+	attributes, err := parseScramAttributes(withoutProof)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := base64.StdEncoding.DecodeString(proofAttribute)
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+	if attributes["c"] != base64.StdEncoding.EncodeToString([]byte(a.gs2Header)) || attributes["r"] != a.nonce {
+		return nil, ErrInvalidMessage
+	}
+
+	authMessage := []byte(a.clientFirstBare + "," + a.serverFirst + "," + withoutProof)
+	clientSignature := scramHmac(a.mechanism, a.credential.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return nil, ErrAuthenticationFailed
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := scramHash(a.mechanism)()
+	storedKey.Write(clientKey)
+	if !hmac.Equal(storedKey.Sum(nil), a.credential.StoredKey) {
+		return nil, ErrAuthenticationFailed
+	}
+	serverSignature := scramHmac(a.mechanism, a.credential.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+func (a *scramAuthenticator) principal() string {
+	return "User:" + a.credential.Name
+}
+
+func (a *scramAuthenticator) expiry() time.Time {
+	return time.Time{}
+}
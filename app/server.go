@@ -2,83 +2,698 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/authorizer"
+	"github.com/codecrafters-io/kafka-starter-go/app/config"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	"github.com/codecrafters-io/kafka-starter-go/app/listener"
 	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
+	"github.com/codecrafters-io/kafka-starter-go/app/replica"
 	"github.com/codecrafters-io/kafka-starter-go/app/request"
 	"github.com/codecrafters-io/kafka-starter-go/app/response"
+	"github.com/codecrafters-io/kafka-starter-go/app/sasl"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/txn"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
 )
 
 var TAG_BUFFER = []byte{0x00}
 
+const MAX_REQUEST_SIZE = 100 * 1024 * 1024
+
+// SASL_MAX_RECEIVE_SIZE is the default sasl.server.max.receive.size, the
+// largest request a SASL client may send before it has authenticated
+const SASL_MAX_RECEIVE_SIZE = 512 * 1024
+const CONTROLLED_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// saslMechanisms are the mechanisms SASL_* listeners offer
+var saslMechanisms = sasl.SUPPORTED_MECHANISMS
+
+// maxReauth is connections.max.reauth.ms, how long SASL clients stay
+// authenticated before they must re-authenticate
+var maxReauth time.Duration
+
+var saslMaxReceiveSize = SASL_MAX_RECEIVE_SIZE
+
+// connectionTimeouts bound how long a connection may sit idle and how long
+// each of its requests may take.
+type connectionTimeouts struct {
+	// maxIdle is connections.max.idle.ms, how long a connection may wait for
+	// its next request before it is closed
+	maxIdle time.Duration
+	// request is request.timeout.ms, how long a request may take to arrive
+	// once it has started, to be processed, and its response to be written
+	request time.Duration
+}
+
+func defaultConnectionTimeouts() connectionTimeouts {
+	return connectionTimeouts{maxIdle: 10 * time.Minute, request: 30 * time.Second}
+}
+
+var errConnectionIdle = errors.New("idle for longer than connections.max.idle.ms")
+var errRequestTimeout = errors.New("request timed out")
+
 func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	fmt.Println("Logs from your program will appear here!")
 
+	if len(os.Args) > 1 {
+		props, err := config.Load(os.Args[1])
+		if err != nil {
+			log.Fatalf("Failed to load %s: %s\n", os.Args[1], err.Error())
+		}
+		applyConfig(props)
+	}
 	startServer()
 
 }
 
-func startServer() {
-	l, err := net.Listen("tcp", "0.0.0.0:9092")
+func applyConfig(props config.Properties) {
+	config.Current = props
+	if logDirs := props.List("log.dirs"); len(logDirs) > 0 {
+		storage.LogDir = logDirs[0]
+	} else {
+		storage.LogDir = props.String("log.dir", storage.LogDir)
+	}
+	metadata.MetadataLogDir = props.String("metadata.log.dir", storage.LogDir)
+	metadata.MaxBytesBetweenSnapshots = props.Int64("metadata.log.max.record.bytes.between.snapshots", metadata.MaxBytesBetweenSnapshots)
+	metadata.MaxSnapshotInterval = time.Duration(props.Int64("metadata.log.max.snapshot.interval.ms", metadata.MaxSnapshotInterval.Milliseconds())) * time.Millisecond
+	utils.BrokerID = int32(props.Int64("node.id", int64(utils.BrokerID)))
+	// Any authorizer.class.name selects the ACL authorizer, the only one
+	// there is
+	if props.String("authorizer.class.name", "") != "" {
+		superUsers := []string{}
+		for _, superUser := range strings.Split(props.String("super.users", ""), ";") {
+			if superUser = strings.TrimSpace(superUser); superUser != "" {
+				superUsers = append(superUsers, superUser)
+			}
+		}
+		authorizer.SetAuthorizer(authorizer.NewStandardAuthorizer(superUsers, props.Bool("allow.everyone.if.no.acl.found", false)))
+	}
+	if err := applyListenerConfig(props); err != nil {
+		log.Fatalf("Invalid listeners: %s\n", err.Error())
+	}
+	timeouts := defaultConnectionTimeouts()
+	timeouts.maxIdle = time.Duration(props.Int64("connections.max.idle.ms", timeouts.maxIdle.Milliseconds())) * time.Millisecond
+	timeouts.request = time.Duration(props.Int64("request.timeout.ms", timeouts.request.Milliseconds())) * time.Millisecond
+	for _, bl := range brokerListeners {
+		bl.timeouts = timeouts
+	}
+	applySaslConfig(props)
+	quotaConfig := quota.DefaultConfig()
+	quotaConfig.NumSamples = int(props.Int64("quota.window.num", int64(quotaConfig.NumSamples)))
+	quotaConfig.SampleWindow = time.Duration(props.Int64("quota.window.size.seconds", int64(quotaConfig.SampleWindow.Seconds()))) * time.Second
+	quota.Current = quota.NewQuotaManager(quotaConfig)
+
+	connectionConfig := quota.DefaultConnectionConfig()
+	connectionConfig.MaxConnections = int(props.Int64("max.connections", int64(connectionConfig.MaxConnections)))
+	connectionConfig.MaxConnectionsPerIp = int(props.Int64("max.connections.per.ip", int64(connectionConfig.MaxConnectionsPerIp)))
+	connectionConfig.MaxConnectionCreationRate = float64(props.Int64("max.connection.creation.rate", int64(connectionConfig.MaxConnectionCreationRate)))
+	connectionConfig.MaxConnectionCreationRatePerIp = float64(props.Int64("max.connection.creation.rate.per.ip", int64(connectionConfig.MaxConnectionCreationRatePerIp)))
+	connectionConfig.Window = quotaConfig
+	overrides, err := quota.ParseConnectionOverrides(props.List("max.connections.per.ip.overrides"))
+	if err != nil {
+		log.Fatalf("Invalid max.connections.per.ip.overrides: %s\n", err.Error())
+	}
+	connectionConfig.MaxConnectionsPerIpOverrides = resolveConnectionOverrides(overrides)
+	quota.Connections = quota.NewConnectionQuotas(connectionConfig)
+}
+
+// resolveConnectionOverrides keys overrides by address, as connections are
+// counted, resolving those given by host name.
+func resolveConnectionOverrides(overrides map[string]int) map[string]int {
+	resolved := map[string]int{}
+	for host, count := range overrides {
+		if net.ParseIP(host) != nil {
+			resolved[host] = count
+			continue
+		}
+		addresses, err := net.LookupHost(host)
+		if err != nil {
+			log.Printf("Ignoring the connection override of %s: %s\n", host, err.Error())
+			continue
+		}
+		for _, address := range addresses {
+			resolved[address] = count
+		}
+	}
+	return resolved
+}
+
+// brokerListener is a listener with what it needs to serve its security
+// protocol.
+type brokerListener struct {
+	listener.Listener
+	tlsConfig       *tls.Config
+	principalMapper *listener.PrincipalMapper
+	// quotaExempt listeners, for inter-broker and controller traffic, are not
+	// held to max.connections and the connection creation rates
+	quotaExempt bool
+	timeouts    connectionTimeouts
+}
+
+var brokerListeners = []*brokerListener{
+	{Listener: listener.Listener{Name: listener.PROTOCOL_PLAINTEXT, SecurityProtocol: listener.PROTOCOL_PLAINTEXT, Host: "0.0.0.0", Port: 9092}, timeouts: defaultConnectionTimeouts()},
+}
+
+// listenerProp reads a setting of a listener, which listener.name.<name>.
+// prefixed keys override for it.
+func listenerProp(props config.Properties, name string, key string, defaultValue string) string {
+	return props.String("listener.name."+strings.ToLower(name)+"."+key, props.String(key, defaultValue))
+}
+
+func applyListenerConfig(props config.Properties) error {
+	if len(props.List("listeners")) == 0 {
+		return nil
+	}
+	protocols, err := listener.ParseProtocolMap(props.List("listener.security.protocol.map"))
 	if err != nil {
-		fmt.Println("Failed to bind to port 9092")
-		os.Exit(1)
+		return err
+	}
+	listeners, err := listener.ParseListeners(props.List("listeners"), protocols)
+	if err != nil {
+		return err
+	}
+	if err := applyAdvertisedListenerConfig(props, listeners, protocols); err != nil {
+		return err
+	}
+	brokerListeners = []*brokerListener{}
+	for _, l := range listeners {
+		bl := &brokerListener{Listener: l, timeouts: defaultConnectionTimeouts()}
+		bl.quotaExempt = l.Name == interBrokerListener || slices.Contains(props.List("controller.listener.names"), l.Name)
+		if l.IsTls() {
+			store, err := listener.NewCertificateStore(listener.TlsConfig{
+				CertificateFile: listenerProp(props, l.Name, "ssl.certificate.location", ""),
+				KeyFile:         listenerProp(props, l.Name, "ssl.key.location", ""),
+				ClientCaFile:    listenerProp(props, l.Name, "ssl.truststore.location", ""),
+				ClientAuth:      listenerProp(props, l.Name, "ssl.client.auth", listener.CLIENT_AUTH_NONE),
+			})
+			if err != nil {
+				return fmt.Errorf("listener %s: %w", l.Name, err)
+			}
+			bl.tlsConfig = store.TlsConfig()
+			// ssl.principal.mapping.rules may hold commas, so it is not a List
+			if bl.principalMapper, err = listener.NewPrincipalMapper(listenerProp(props, l.Name, "ssl.principal.mapping.rules", "")); err != nil {
+				return fmt.Errorf("listener %s: %w", l.Name, err)
+			}
+		}
+		brokerListeners = append(brokerListeners, bl)
+	}
+	return nil
+}
+
+// interBrokerListener is the listener replicas fetch from their leaders on
+var interBrokerListener = ""
+
+// applyAdvertisedListenerConfig reads advertised.listeners, the endpoints
+// this broker registers for clients of each listener. They default to the
+// listeners themselves. Controller listeners are never advertised.
+func applyAdvertisedListenerConfig(props config.Properties, listeners []listener.Listener, protocols map[string]string) error {
+	advertised := listeners
+	if entries := props.List("advertised.listeners"); len(entries) > 0 {
+		var err error
+		if advertised, err = listener.ParseListeners(entries, protocols); err != nil {
+			return err
+		}
+	}
+	controllerListeners := props.List("controller.listener.names")
+	utils.AdvertisedListeners = []utils.AdvertisedListener{}
+	for _, l := range advertised {
+		if !slices.ContainsFunc(listeners, func(bound listener.Listener) bool { return bound.Name == l.Name }) {
+			return fmt.Errorf("advertised listener %s is not a listener", l.Name)
+		}
+		if slices.Contains(controllerListeners, l.Name) {
+			continue
+		}
+		// Listeners bound to every interface are advertised on localhost
+		host := l.Host
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		utils.AdvertisedListeners = append(utils.AdvertisedListeners, utils.AdvertisedListener{Name: l.Name, Host: host, Port: int32(l.Port)})
+	}
+	interBrokerListener = props.String("inter.broker.listener.name", "")
+	if interBrokerListener == "" && len(utils.AdvertisedListeners) > 0 {
+		interBrokerListener = utils.AdvertisedListeners[0].Name
+	}
+	return nil
+}
+
+// applySaslConfig reads the SASL settings, of the first SASL listener where
+// they are per listener.
+func applySaslConfig(props config.Properties) {
+	listenerName := ""
+	for _, l := range brokerListeners {
+		if l.IsSasl() {
+			listenerName = l.Name
+			break
+		}
+	}
+	if mechanisms := props.List("sasl.enabled.mechanisms"); len(mechanisms) > 0 {
+		saslMechanisms = mechanisms
+	}
+	jaasConfig := props.String("listener.name."+strings.ToLower(listenerName)+".plain.sasl.jaas.config", props.String("sasl.jaas.config", ""))
+	sasl.PlainUsers = sasl.ParsePlainJaasUsers(jaasConfig)
+	maxReauth = time.Duration(props.Int64("connections.max.reauth.ms", 0)) * time.Millisecond
+	saslMaxReceiveSize = int(props.Int64("sasl.server.max.receive.size", SASL_MAX_RECEIVE_SIZE))
+
+	// Without a JWKS, OAUTHBEARER accepts unsecured tokens, as Kafka does, so
+	// it is only enabled when asked for
+	jwksUrl := props.String("sasl.oauthbearer.jwks.endpoint.url", "")
+	if jwksUrl == "" && !slices.Contains(props.List("sasl.enabled.mechanisms"), sasl.MECHANISM_OAUTHBEARER) {
+		return
+	}
+	validator := sasl.NewJwtValidator()
+	validator.PrincipalClaim = props.String("sasl.oauthbearer.sub.claim.name", validator.PrincipalClaim)
+	validator.ScopeClaim = props.String("sasl.oauthbearer.scope.claim.name", validator.ScopeClaim)
+	validator.ClockSkew = time.Duration(props.Int64("sasl.oauthbearer.clock.skew.seconds", int64(validator.ClockSkew.Seconds()))) * time.Second
+	validator.ExpectedIssuer = props.String("sasl.oauthbearer.expected.issuer", "")
+	validator.ExpectedAudience = props.List("sasl.oauthbearer.expected.audience")
+	if jwksUrl == "" {
+		validator.AllowUnsecured = true
+	} else {
+		keys, err := sasl.LoadJwks(jwksUrl)
+		if err != nil {
+			log.Fatalf("Failed to load the JWKS at %s: %s\n", jwksUrl, err.Error())
+		}
+		validator.Keys = keys
+	}
+	sasl.Validator = validator
+}
+
+func startServer() {
+	netListeners := []net.Listener{}
+	for _, bl := range brokerListeners {
+		l, err := net.Listen("tcp", bl.Address())
+		if err != nil {
+			fmt.Printf("Failed to bind to %s\n", bl.Address())
+			os.Exit(1)
+		}
+		if bl.tlsConfig != nil {
+			l = tls.NewListener(l, bl.tlsConfig)
+		}
+		netListeners = append(netListeners, l)
+	}
+	if err := startQuorum(config.Current); err != nil {
+		log.Fatalf("Failed to start the metadata quorum: %s\n", err.Error())
 	}
 	metadata.SetClusterTopics()
+	if brokerLifecycle != nil {
+		startReplicaManager(config.Current)
+	}
+	if err := storage.LoadLogs(); err != nil {
+		log.Printf("Failed to load logs: %s\n", err.Error())
+	}
+	if err := txn.LoadTransactionState(); err != nil {
+		log.Printf("Failed to load transaction state: %s\n", err.Error())
+	}
+	go closeOnSignal()
+	go logConnectionMetrics()
+	for i, l := range netListeners {
+		go acceptConns(l, brokerListeners[i])
+	}
+	select {}
+}
+
+func acceptConns(l net.Listener, bl *brokerListener) {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			fmt.Println("Error accepting connection: ", err.Error())
 			os.Exit(1)
 		}
-		conn.SetDeadline(time.Now().Add(10 * time.Second))
-		go handleConn(&conn)
+		// Accepting waits here while the broker is at its connection limits
+		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if err := quota.Connections.Accept(ip, bl.quotaExempt); err != nil {
+			log.Printf("Closing connection from %s: %s\n", ip, err.Error())
+			conn.Close()
+			continue
+		}
+		go func() {
+			handleConn(&conn, bl)
+			quota.Connections.Release(ip, bl.quotaExempt)
+		}()
+	}
+}
+
+// logConnectionMetrics logs the connections accepted, rejected and
+// throttled, every minute they change.
+func logConnectionMetrics() {
+	last := quota.ConnectionMetrics{}
+	for range time.Tick(time.Minute) {
+		metrics := quota.Connections.Metrics()
+		if metrics == last {
+			continue
+		}
+		last = metrics
+		log.Printf("Connections: %d active, %d accepted, %d rejected, %d throttled\n", metrics.Active, metrics.Accepted, metrics.Rejected, metrics.Throttled)
+	}
+}
+
+// startQuorum replicates the metadata log with the controllers listed in
+// controller.quorum.voters. Nodes that are not voters follow it as observers.
+func startQuorum(props config.Properties) error {
+	addresses, err := raft.ParseVoters(props.String("controller.quorum.voters", ""))
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+	voters := []int32{}
+	for voter := range addresses {
+		voters = append(voters, voter)
+	}
+	slices.Sort(voters)
+
+	raftConfig := raft.DefaultConfig(utils.BrokerID, voters)
+	raftConfig.ElectionTimeout = time.Duration(props.Int64("controller.quorum.election.timeout.ms", raftConfig.ElectionTimeout.Milliseconds())) * time.Millisecond
+	raftConfig.FetchTimeout = time.Duration(props.Int64("controller.quorum.fetch.timeout.ms", raftConfig.FetchTimeout.Milliseconds())) * time.Millisecond
+	// kafka-storage.sh format records the cluster id in meta.properties
+	if metaProperties, err := config.Load(filepath.Join(metadata.MetadataLogDir, "meta.properties")); err == nil {
+		utils.ClusterID = metaProperties.String("cluster.id", "")
+	}
+	raftConfig.ClusterID = utils.ClusterID
+	transport := raft.NewNetworkTransport(utils.BrokerID, addresses)
+	node, err := raft.Start(raftConfig, transport)
+	if err != nil {
+		return err
+	}
+
+	controllerConfig := controller.DefaultConfig()
+	controllerConfig.ClusterID = utils.ClusterID
+	controllerConfig.SessionTimeout = time.Duration(props.Int64("broker.session.timeout.ms", controllerConfig.SessionTimeout.Milliseconds())) * time.Millisecond
+	controllerConfig.HeartbeatInterval = time.Duration(props.Int64("broker.heartbeat.interval.ms", controllerConfig.HeartbeatInterval.Milliseconds())) * time.Millisecond
+	if slices.Contains(voters, utils.BrokerID) {
+		controller.Start(controllerConfig, node)
+	}
+	if roles := props.List("process.roles"); len(roles) == 0 || slices.Contains(roles, "broker") {
+		startBrokerLifecycle(props, controllerConfig.HeartbeatInterval, &controller.NetworkChannel{Transport: transport}, voters)
+	}
+	return nil
+}
+
+var brokerLifecycle *controller.BrokerLifecycleManager
+
+// startBrokerLifecycle registers this broker with the active controller and
+// keeps it unfenced with heartbeats.
+func startBrokerLifecycle(props config.Properties, heartbeatInterval time.Duration, channel controller.Channel, controllers []int32) {
+	incarnationId, _ := uuid.NewV4()
+	registration := controller.BrokerRegistrationRequest{
+		BrokerID:      utils.BrokerID,
+		ClusterID:     utils.ClusterID,
+		IncarnationID: incarnationId,
+	}
+	for _, advertised := range utils.AdvertisedListeners {
+		endpoint := metadata.BrokerEndpoint{Name: advertised.Name, Host: advertised.Host, Port: uint16(advertised.Port)}
+		for _, bl := range brokerListeners {
+			if bl.Name == advertised.Name {
+				endpoint.SecurityProtocol = listener.SECURITY_PROTOCOL_IDS[bl.SecurityProtocol]
+			}
+		}
+		registration.Listeners = append(registration.Listeners, endpoint)
+	}
+	if rack := props.String("broker.rack", ""); rack != "" {
+		registration.Rack = &rack
+	}
+	brokerLifecycle = controller.NewBrokerLifecycleManager(registration, channel, controllers)
+	brokerLifecycle.HeartbeatInterval = heartbeatInterval
+	brokerLifecycle.ActiveController = func() int32 { return raft.Node().LeaderAndEpoch().LeaderID }
+	go brokerLifecycle.Run(nil)
+}
+
+// startReplicaManager replicates the partitions assigned to this broker,
+// sending ISR changes to the controller through the broker lifecycle.
+func startReplicaManager(props config.Properties) {
+	replicaConfig := replica.DefaultConfig(utils.BrokerID)
+	replicaConfig.ReplicaLagTimeMax = time.Duration(props.Int64("replica.lag.time.max.ms", replicaConfig.ReplicaLagTimeMax.Milliseconds())) * time.Millisecond
+	replicaConfig.FetchMaxWait = time.Duration(props.Int64("replica.fetch.wait.max.ms", replicaConfig.FetchMaxWait.Milliseconds())) * time.Millisecond
+	replicaConfig.FetchMinBytes = int32(props.Int64("replica.fetch.min.bytes", int64(replicaConfig.FetchMinBytes)))
+	replicaConfig.FetchMaxBytes = int32(props.Int64("replica.fetch.response.max.bytes", int64(replicaConfig.FetchMaxBytes)))
+	replicaConfig.FetchBackoff = time.Duration(props.Int64("replica.fetch.backoff.ms", replicaConfig.FetchBackoff.Milliseconds())) * time.Millisecond
+	replicaConfig.MinInsyncReplicas = int(props.Int64("min.insync.replicas", int64(replicaConfig.MinInsyncReplicas)))
+
+	fetchClient := replica.NewNetworkFetchClient(utils.BrokerID)
+	fetchClient.ListenerName = interBrokerListener
+	manager := replica.NewReplicaManager(replicaConfig, fetchClient)
+	manager.AlterPartition = brokerLifecycle.AlterPartition
+	manager.BrokerEpoch = brokerLifecycle.BrokerEpoch
+	replica.Start(manager)
+}
+
+// readMessage reads one length prefixed request, keeping the length prefix
+// as request.Deserialize expects it. It waits up to the idle timeout for the
+// request to start, and then up to the request timeout for the rest of it.
+// Requests over maxSize bytes are refused before they are read.
+func readMessage(conn net.Conn, timeouts connectionTimeouts, maxSize int) ([]byte, error) {
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(timeouts.maxIdle))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, errConnectionIdle
+		}
+		return nil, err
+	}
+	messageLength := binary.BigEndian.Uint32(buf)
+	if int64(messageLength) > int64(maxSize) {
+		return nil, fmt.Errorf("request of %d bytes exceeds the maximum of %d", messageLength, maxSize)
+	}
+	buf = append(buf, make([]byte, messageLength)...)
+	conn.SetReadDeadline(time.Now().Add(timeouts.request))
+	if _, err := io.ReadFull(conn, buf[4:]); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("%w: request of %d bytes not received in time", errRequestTimeout, messageLength)
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ownTimeout is the timeout a request sets for itself, such as how long a
+// produce waits for acks or a fetch for records, or 0 when it has none.
+func ownTimeout(req request.Request) time.Duration {
+	var timeoutMs int32
+	switch req.ApiKey {
+	case utils.PRODUCE_KEY:
+		timeoutMs = req.ProduceRequest.TimeoutMs
+	case utils.FETCH_KEY:
+		timeoutMs = req.FetchRequest.MaxWaitMs
+	case utils.ELECT_LEADERS_KEY:
+		timeoutMs = req.ElectLeadersRequest.TimeoutMs
+	case utils.ALTER_PARTITION_REASSIGNMENTS_KEY:
+		timeoutMs = req.AlterPartitionReassignmentsRequest.TimeoutMs
+	case utils.LIST_PARTITION_REASSIGNMENTS_KEY:
+		timeoutMs = req.ListPartitionReassignmentsRequest.TimeoutMs
+	}
+	return time.Duration(max(timeoutMs, 0)) * time.Millisecond
+}
+
+// serializeWithTimeout answers req, unless that takes longer than the request
+// timeout, or than the request's own timeout when that is longer. A request
+// that times out still runs to the end and keeps its effects, such as the
+// records it appended, but its response is dropped.
+func serializeWithTimeout(req request.Request, timeout time.Duration) ([]byte, error) {
+	type result struct {
+		res []byte
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		res, err := response.Serialize(req)
+		results <- result{res: res, err: err}
+	}()
+	timer := time.NewTimer(max(timeout, ownTimeout(req)))
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return result.res, result.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: api key %d not processed in time", errRequestTimeout, req.ApiKey)
+	}
+}
+
+// closeReason describes why reading the next request from a connection
+// failed.
+func closeReason(err error) string {
+	if errors.Is(err, io.EOF) {
+		return "closed by the client"
+	}
+	return err.Error()
+}
+
+// closeOnSignal flushes partition state such as producer snapshots before
+// the process exits.
+func closeOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	if brokerLifecycle != nil {
+		brokerLifecycle.ControlledShutdown(CONTROLLED_SHUTDOWN_TIMEOUT)
+	}
+	if node := raft.Node(); node != nil {
+		node.Resign()
+		node.Close()
+	}
+	storage.CloseAll()
+	os.Exit(0)
+}
+
+// isSaslRequest reports whether a request may be sent on a SASL listener
+// before the client has authenticated.
+func isSaslRequest(apiKey uint16) bool {
+	return apiKey == utils.API_VERSIONS_KEY || apiKey == utils.SASL_HANDSHAKE_KEY || apiKey == utils.SASL_AUTHENTICATE_KEY
+}
+
+// isQuotaExempt reports whether a request is exempt from client quotas: the
+// requests brokers and controllers send each other, and those a client sends
+// to authenticate.
+func isQuotaExempt(req request.Request) bool {
+	switch req.ApiKey {
+	case utils.VOTE_KEY, utils.BEGIN_QUORUM_EPOCH_KEY, utils.END_QUORUM_EPOCH_KEY, utils.FETCH_SNAPSHOT_KEY,
+		utils.BROKER_REGISTRATION_KEY, utils.BROKER_HEARTBEAT_KEY, utils.ALTER_PARTITION_KEY, utils.WRITE_TXN_MARKERS_KEY:
+		return true
+	case utils.FETCH_KEY:
+		if req.FetchRequest.ReplicaID >= 0 {
+			return true
+		}
+		return slices.ContainsFunc(req.FetchRequest.Topics, func(topic request.Fetch_Request_Topic) bool {
+			return topic.TopicID == raft.METADATA_TOPIC_ID
+		})
 	}
+	return isSaslRequest(req.ApiKey)
+}
 
+// recordQuotas meters a request against the client's quotas, its time as a
+// percentage of one thread's time and the bytes it produced or fetched, and
+// returns how long the client is throttled for them.
+func recordQuotas(req request.Request, client quota.Client, requestSize int, elapsed time.Duration, responseSize int) time.Duration {
+	throttle := quota.Current.Record(quota.REQUEST_PERCENTAGE, client, elapsed.Seconds()*100)
+	switch req.ApiKey {
+	case utils.PRODUCE_KEY:
+		throttle = max(throttle, quota.Current.Record(quota.PRODUCER_BYTE_RATE, client, float64(requestSize)))
+	case utils.FETCH_KEY:
+		throttle = max(throttle, quota.Current.Record(quota.CONSUMER_BYTE_RATE, client, float64(responseSize)))
+	}
+	return throttle
 }
 
-func handleConn(conn *net.Conn) {
+func handleConn(conn *net.Conn, bl *brokerListener) {
+	clientHost, _, _ := net.SplitHostPort((*conn).RemoteAddr().String())
+	principal := authorizer.ANONYMOUS
+	if tlsConn, ok := (*conn).(*tls.Conn); ok {
+		(*conn).SetDeadline(time.Now().Add(bl.timeouts.request))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("Closing connection from %s: TLS handshake failed: %s\n", clientHost, err.Error())
+			(*conn).Close()
+			return
+		}
+		// Clients without a certificate stay anonymous
+		if certificates := tlsConn.ConnectionState().PeerCertificates; len(certificates) > 0 {
+			principal = bl.principalMapper.Principal(certificates[0])
+		}
+	}
+	var session *sasl.Session
+	if bl.IsSasl() {
+		session = sasl.NewSession(saslMechanisms, maxReauth)
+	}
 	for {
-		buf := make([]byte, 1024)
-		n, err := (*conn).Read(buf)
-		if n == 0 {
-			continue
+		// Unauthenticated SASL clients may only send small requests, so they
+		// cannot make the broker allocate up to MAX_REQUEST_SIZE
+		maxSize := MAX_REQUEST_SIZE
+		if session != nil && !session.IsAuthenticated() {
+			maxSize = saslMaxReceiveSize
 		}
+		buf, err := readMessage(*conn, bl.timeouts, maxSize)
 		if err != nil {
+			log.Printf("Closing connection from %s: %s\n", clientHost, closeReason(err))
 			(*conn).Close()
 			return
 		}
 
-		reqData := bytes.NewBuffer(buf[:n])
+		reqData := bytes.NewBuffer(buf)
 		req, err := request.Deserialize(reqData)
 		if err != nil {
 			if err.Error() == errors.ErrUnsupported.Error() {
 				errorRes := response.GetErrorResponse(req)
+				(*conn).SetWriteDeadline(time.Now().Add(bl.timeouts.request))
 				(*conn).Write(errorRes)
 				continue
 			}
-			if err == io.EOF {
-				continue
+			log.Printf("Failed to parse request: %s\n", err.Error())
+			continue
+		}
+		req.Principal = principal
+		req.ClientHost = clientHost
+		req.ListenerName = bl.Name
+		if session != nil {
+			if !session.IsAuthenticated() && !isSaslRequest(req.ApiKey) {
+				log.Printf("Closing connection from %s: api key %d sent before authenticating\n", req.ClientHost, req.ApiKey)
+				(*conn).Close()
+				return
+			}
+			// KIP-368 clients re-authenticate before their session expires
+			if session.IsAuthenticated() && session.Expired() && !isSaslRequest(req.ApiKey) {
+				log.Printf("Closing connection from %s: SASL session expired\n", req.ClientHost)
+				(*conn).Close()
+				return
+			}
+			req.Sasl = session
+			if session.IsAuthenticated() {
+				req.Principal = session.Principal()
 			}
 		}
 
-		res, err := response.Serialize(req)
+		start := time.Now()
+		res, err := serializeWithTimeout(req, bl.timeouts.request)
+		if errors.Is(err, errRequestTimeout) {
+			log.Printf("Closing connection from %s: %s\n", req.ClientHost, err.Error())
+			(*conn).Close()
+			return
+		}
 		if err != nil {
 			log.Fatalf("Failed to create response from request %s\n", err.Error())
 			(*conn).Close()
 			return
 		}
+		// The response tells the client how long it is about to be muted for
+		var throttle time.Duration
+		if !isQuotaExempt(req) {
+			client := quota.NewClient(req.Principal, req.ClientId)
+			throttle = recordQuotas(req, client, len(buf), time.Since(start), len(res))
+			response.SetThrottleTimeMs(req, res, int32(throttle.Milliseconds()))
+		}
 
-		_, err = (*conn).Write(res)
-		if err != nil {
-			log.Fatalln("Failed to write to client")
+		if len(res) > 0 {
+			// Clients too slow to read their responses are closed
+			(*conn).SetWriteDeadline(time.Now().Add(bl.timeouts.request))
+			_, err = (*conn).Write(res)
+			if err != nil {
+				log.Printf("Closing connection from %s: failed to write response: %s\n", req.ClientHost, err.Error())
+				(*conn).Close()
+				return
+			}
+		}
+		// The channel is muted while the client is throttled, so it cannot
+		// get around its quota by ignoring throttle_time_ms
+		if throttle > 0 {
+			time.Sleep(throttle)
+		}
+		if session != nil && session.Failed() {
+			log.Printf("Closing connection from %s: SASL authentication failed\n", req.ClientHost)
 			(*conn).Close()
 			return
 		}
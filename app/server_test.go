@@ -3,21 +3,32 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 	"log"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/config"
+	"github.com/codecrafters-io/kafka-starter-go/app/controller"
+	"github.com/codecrafters-io/kafka-starter-go/app/listener"
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/raft"
 	"github.com/codecrafters-io/kafka-starter-go/app/request"
 	"github.com/codecrafters-io/kafka-starter-go/app/response"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
 	"github.com/gofrs/uuid"
 )
 
 func ConstructEncodedMessage() bytes.Buffer {
 	TestReq := request.Request{
-		ApiKey:        75,
-		ApiVersion:    0,
-		CorrelationID: 7,
-		ClientId:      "kafka-cli",
-		TopicArray:    []request.Topic{{TopicName: "foo"}},
+		ApiKey:                        75,
+		ApiVersion:                    0,
+		CorrelationID:                 7,
+		ClientId:                      "kafka-cli",
+		DescribeTopicPartitionRequest: &request.Describe_Topic_Partition_Request{TopicArray: []string{"foo"}},
 	}
 	var buf bytes.Buffer
 
@@ -31,14 +42,14 @@ func ConstructEncodedMessage() bytes.Buffer {
 	binary.Write(&buf, binary.BigEndian, uint16(len(clientID))) // Client ID Length
 	buf.WriteString(clientID)                                   // Client ID
 
-	binary.Write(&buf, binary.BigEndian, uint8(0))                         // Empty Tagged Field Array
-	binary.Write(&buf, binary.BigEndian, uint8(len(TestReq.TopicArray)+1)) // Topics Array Length
+	binary.Write(&buf, binary.BigEndian, uint8(0))                                                       // Empty Tagged Field Array
+	binary.Write(&buf, binary.BigEndian, uint8(len(TestReq.DescribeTopicPartitionRequest.TopicArray)+1)) // Topics Array Length
 
 	// Add topics
-	for _, topic := range TestReq.TopicArray {
-		binary.Write(&buf, binary.BigEndian, uint8(len(topic.TopicName)+1)) // Topic Name Length
-		buf.WriteString(topic.TopicName)                                    // Topic Name
-		binary.Write(&buf, binary.BigEndian, uint8(0))                      // Empty TAG_BUFFER
+	for _, topic := range TestReq.DescribeTopicPartitionRequest.TopicArray {
+		binary.Write(&buf, binary.BigEndian, uint8(len(topic)+1)) // Topic Name Length
+		buf.WriteString(topic)                                    // Topic Name
+		binary.Write(&buf, binary.BigEndian, uint8(0))            // Empty TAG_BUFFER
 	}
 
 	binary.Write(&buf, binary.BigEndian, uint32(100)) // Response Partition Limit
@@ -98,3 +109,305 @@ func TestHandleConn(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// serveOnLocalhost handles connections on an ephemeral port and returns its
+// address.
+func serveOnLocalhost(t *testing.T) string {
+	return serveListenerOnLocalhost(t, brokerListeners[0])
+}
+
+func serveListenerOnLocalhost(t *testing.T, bl *brokerListener) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConn(&conn, bl)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestQuorumRequestsOverTheWire(t *testing.T) {
+	committedOffset, truncateLog := metadata.CommittedOffset, metadata.TruncateLog
+	t.Cleanup(func() { metadata.CommittedOffset, metadata.TruncateLog = committedOffset, truncateLog })
+
+	config := raft.DefaultConfig(1, []int32{1})
+	config.Dir = t.TempDir()
+	config.ClusterID = "test-cluster"
+	node, err := raft.Start(config, raft.NewLocalNetwork().Transport(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := raft.NewNetworkTransport(2, map[int32]string{1: serveOnLocalhost(t)})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for node.Role() != raft.LEADER {
+		if time.Now().After(deadline) {
+			t.Fatal("Single voter did not become leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	epoch := node.LeaderAndEpoch().LeaderEpoch
+	offset, err := node.Append([]metadata.MetadataRecord{&metadata.TopicRecord{Name: "foo", TopicID: uuid.Must(uuid.NewV4())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := raft.FetchRequest{ClusterID: "test-cluster", ReplicaID: 2, CurrentLeaderEpoch: epoch, MaxBytes: 1024 * 1024}
+	fetchResponse, err := transport.Fetch(1, fetch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetchResponse.ErrorCode != utils.NONE || len(fetchResponse.Records) == 0 || fetchResponse.HighWatermark != offset+1 || fetchResponse.CurrentLeader.LeaderID != 1 {
+		t.Errorf("Unexpected fetch response %+v", fetchResponse)
+	}
+
+	fetch.FetchOffset, fetch.LastFetchedEpoch = offset+10, epoch
+	fetchResponse, err = transport.Fetch(1, fetch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetchResponse.DivergingEpoch == nil || *fetchResponse.DivergingEpoch != (raft.OffsetAndEpoch{Offset: offset + 1, Epoch: epoch}) {
+		t.Errorf("Expected the log to diverge at %d, got %+v", offset+1, fetchResponse.DivergingEpoch)
+	}
+
+	fetch.ClusterID = "other-cluster"
+	fetchResponse, err = transport.Fetch(1, fetch, 0)
+	if err != nil || fetchResponse.ErrorCode != utils.INCONSISTENT_CLUSTER_ID {
+		t.Errorf("Expected INCONSISTENT_CLUSTER_ID, got %+v (%v)", fetchResponse, err)
+	}
+
+	voteResponse, err := transport.Vote(1, raft.VoteRequest{ClusterID: "test-cluster", CandidateEpoch: epoch + 1, CandidateID: 2})
+	if err != nil || voteResponse.ErrorCode != utils.INCONSISTENT_VOTER_SET || voteResponse.VoteGranted || voteResponse.LeaderID != 1 {
+		t.Errorf("Unexpected vote response %+v (%v)", voteResponse, err)
+	}
+
+	quorumEpochResponse, err := transport.BeginQuorumEpoch(1, raft.BeginQuorumEpochRequest{ClusterID: "test-cluster", LeaderID: 2, LeaderEpoch: epoch - 1})
+	if err != nil || quorumEpochResponse.ErrorCode != utils.FENCED_LEADER_EPOCH || quorumEpochResponse.LeaderEpoch != epoch {
+		t.Errorf("Unexpected BeginQuorumEpoch response %+v (%v)", quorumEpochResponse, err)
+	}
+
+	snapshotResponse, err := transport.FetchSnapshot(1, raft.FetchSnapshotRequest{ClusterID: "test-cluster", ReplicaID: 2, CurrentLeaderEpoch: epoch, SnapshotID: metadata.SnapshotID{EndOffset: offset + 1, Epoch: epoch}, MaxBytes: 1024})
+	if err != nil || snapshotResponse.ErrorCode != utils.SNAPSHOT_NOT_FOUND {
+		t.Errorf("Expected SNAPSHOT_NOT_FOUND, got %+v (%v)", snapshotResponse, err)
+	}
+}
+
+func TestBrokerRegistrationOverTheWire(t *testing.T) {
+	committedOffset, truncateLog := metadata.CommittedOffset, metadata.TruncateLog
+	t.Cleanup(func() {
+		metadata.CommittedOffset, metadata.TruncateLog = committedOffset, truncateLog
+		metadata.Publish(metadata.EmptyImage())
+	})
+
+	config := raft.DefaultConfig(1, []int32{1})
+	config.Dir = t.TempDir()
+	config.ClusterID = "test-cluster"
+	node, err := raft.Start(config, raft.NewLocalNetwork().Transport(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.Publish(metadata.EmptyImage())
+	follower := metadata.NewMetadataFollower(config.Dir)
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				follower.Poll()
+			}
+		}
+	}()
+	controllerConfig := controller.DefaultConfig()
+	controllerConfig.ClusterID = "test-cluster"
+	controller.Start(controllerConfig, node)
+
+	transport := raft.NewNetworkTransport(2, map[int32]string{1: serveOnLocalhost(t)})
+	channel := &controller.NetworkChannel{Transport: transport}
+	deadline := time.Now().Add(5 * time.Second)
+	for node.Role() != raft.LEADER {
+		if time.Now().After(deadline) {
+			t.Fatal("Single voter did not become leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	registration, err := channel.RegisterBroker(1, controller.BrokerRegistrationRequest{
+		BrokerID:            2,
+		ClusterID:           "test-cluster",
+		IncarnationID:       uuid.Must(uuid.NewV4()),
+		Listeners:           []metadata.BrokerEndpoint{{Name: "PLAINTEXT", Host: "broker-2", Port: 9093}},
+		PreviousBrokerEpoch: -1,
+	})
+	if err != nil || registration.ErrorCode != utils.NONE {
+		t.Fatalf("Unexpected registration response %+v (%v)", registration, err)
+	}
+	heartbeat, err := channel.BrokerHeartbeat(1, controller.BrokerHeartbeatRequest{BrokerID: 2, BrokerEpoch: registration.BrokerEpoch, CurrentMetadataOffset: registration.BrokerEpoch})
+	if err != nil || heartbeat.ErrorCode != utils.NONE || !heartbeat.IsCaughtUp || heartbeat.IsFenced {
+		t.Fatalf("Unexpected heartbeat response %+v (%v)", heartbeat, err)
+	}
+
+	// Metadata v12 for every topic
+	var body bytes.Buffer
+	utils.WriteCompactArrayLength(&body, -1)
+	binary.Write(&body, binary.BigEndian, false)
+	binary.Write(&body, binary.BigEndian, false)
+	utils.WriteTaggedFields(&body)
+	data, err := transport.Send(1, utils.METADATA_KEY, 12, body.Bytes(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var throttleTimeMs, nodeId, port int32
+	var brokerLen int
+	var host string
+	binary.Read(data, binary.BigEndian, &throttleTimeMs)
+	utils.ReadCompactArrayLength(&brokerLen, data)
+	binary.Read(data, binary.BigEndian, &nodeId)
+	utils.ReadCompactString(&host, data)
+	binary.Read(data, binary.BigEndian, &port)
+	if brokerLen != 1 || nodeId != 2 || host != "broker-2" || port != 9093 {
+		t.Errorf("Expected only broker 2 at broker-2:9093, got %d brokers starting with %d at %s:%d", brokerLen, nodeId, host, port)
+	}
+
+	// A preferred election for every partition, of which there are none
+	body.Reset()
+	binary.Write(&body, binary.BigEndian, int8(0))
+	utils.WriteCompactArrayLength(&body, -1)
+	binary.Write(&body, binary.BigEndian, int32(1000))
+	utils.WriteTaggedFields(&body)
+	data, err = transport.Send(1, utils.ELECT_LEADERS_KEY, utils.ELECT_LEADERS, body.Bytes(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errorCode int16
+	var resultLen int
+	binary.Read(data, binary.BigEndian, &throttleTimeMs)
+	binary.Read(data, binary.BigEndian, &errorCode)
+	utils.ReadCompactArrayLength(&resultLen, data)
+	if errorCode != utils.NONE || resultLen != 0 {
+		t.Errorf("Expected no elections, got error code %d and %d results", errorCode, resultLen)
+	}
+
+	// DescribeCluster v1 for the broker endpoints and every cluster operation
+	body.Reset()
+	binary.Write(&body, binary.BigEndian, true)
+	binary.Write(&body, binary.BigEndian, request.ENDPOINT_TYPE_BROKER)
+	utils.WriteTaggedFields(&body)
+	data, err = transport.Send(1, utils.DESCRIBE_CLUSTER_KEY, utils.DESCRIBE_CLUSTER, body.Bytes(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errorMessage *string
+	var endpointType int8
+	var clusterId string
+	var controllerId, clusterAuthorizedOperations int32
+	binary.Read(data, binary.BigEndian, &throttleTimeMs)
+	binary.Read(data, binary.BigEndian, &errorCode)
+	utils.ReadCompactNullableString(&errorMessage, data)
+	binary.Read(data, binary.BigEndian, &endpointType)
+	utils.ReadCompactString(&clusterId, data)
+	binary.Read(data, binary.BigEndian, &controllerId)
+	utils.ReadCompactArrayLength(&brokerLen, data)
+	binary.Read(data, binary.BigEndian, &nodeId)
+	utils.ReadCompactString(&host, data)
+	binary.Read(data, binary.BigEndian, &port)
+	data.Next(2)
+	binary.Read(data, binary.BigEndian, &clusterAuthorizedOperations)
+	if errorCode != utils.NONE || controllerId != 1 || brokerLen != 1 || nodeId != 2 || clusterAuthorizedOperations != 0x7fa0 {
+		t.Errorf("Unexpected DescribeCluster response: error code %d, controller %d, %d brokers starting with %d, operations %#x", errorCode, controllerId, brokerLen, nodeId, clusterAuthorizedOperations)
+	}
+}
+
+func TestApplyListenerConfig(t *testing.T) {
+	listeners, advertised := brokerListeners, utils.AdvertisedListeners
+	t.Cleanup(func() { brokerListeners, utils.AdvertisedListeners, interBrokerListener = listeners, advertised, "" })
+
+	props := config.Properties{
+		"listeners":                      "INTERNAL://0.0.0.0:9092,EXTERNAL://0.0.0.0:19092,CONTROLLER://0.0.0.0:9093",
+		"advertised.listeners":           "INTERNAL://kafka-1:9092,EXTERNAL://kafka.example.com:19092",
+		"listener.security.protocol.map": "INTERNAL:PLAINTEXT,EXTERNAL:SASL_PLAINTEXT,CONTROLLER:PLAINTEXT",
+		"controller.listener.names":      "CONTROLLER",
+		"inter.broker.listener.name":     "INTERNAL",
+	}
+	if err := applyListenerConfig(props); err != nil {
+		t.Fatal(err)
+	}
+	if len(brokerListeners) != 3 || !brokerListeners[1].IsSasl() {
+		t.Fatalf("Expected three listeners with EXTERNAL on SASL, got %+v", brokerListeners)
+	}
+	expected := []utils.AdvertisedListener{{Name: "INTERNAL", Host: "kafka-1", Port: 9092}, {Name: "EXTERNAL", Host: "kafka.example.com", Port: 19092}}
+	if !reflect.DeepEqual(utils.AdvertisedListeners, expected) || interBrokerListener != "INTERNAL" {
+		t.Fatalf("Expected %+v on INTERNAL, got %+v on %s", expected, utils.AdvertisedListeners, interBrokerListener)
+	}
+
+	props["advertised.listeners"] = "PUBLIC://kafka.example.com:443"
+	props["listener.security.protocol.map"] += ",PUBLIC:SSL"
+	if err := applyListenerConfig(props); err == nil {
+		t.Fatal("Expected an advertised listener that is not bound to be rejected")
+	}
+}
+
+func TestIdleConnectionsAreClosed(t *testing.T) {
+	bl := &brokerListener{Listener: brokerListeners[0].Listener, timeouts: defaultConnectionTimeouts()}
+	bl.timeouts.maxIdle = 200 * time.Millisecond
+
+	conn, err := net.Dial("tcp", serveListenerOnLocalhost(t, bl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// A connection in use outlives connections.max.idle.ms
+	for range 5 {
+		time.Sleep(100 * time.Millisecond)
+		message := ConstructEncodedMessage()
+		if _, err := conn.Write(message.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readMessage(conn, defaultConnectionTimeouts(), MAX_REQUEST_SIZE); err != nil {
+			t.Fatalf("Expected a response, got %v", err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the idle connection to be closed, got %v", err)
+	}
+}
+
+func TestRequestsKeepTheirOwnTimeout(t *testing.T) {
+	produce := request.Request{ApiKey: utils.PRODUCE_KEY, ProduceRequest: &request.Produce_Request{TimeoutMs: 60000}}
+	if timeout := ownTimeout(produce); timeout != time.Minute {
+		t.Fatalf("Expected a produce to wait up to its 60s timeout, got %v", timeout)
+	}
+	if timeout := ownTimeout(request.Request{ApiKey: utils.METADATA_KEY}); timeout != 0 {
+		t.Fatalf("Expected no timeout of its own, got %v", timeout)
+	}
+}
+
+func TestUnauthenticatedSaslRequestsAreCapped(t *testing.T) {
+	bl := &brokerListener{Listener: listener.Listener{Name: "SASL", SecurityProtocol: listener.PROTOCOL_SASL_PLAINTEXT}, timeouts: defaultConnectionTimeouts()}
+	conn, err := net.Dial("tcp", serveListenerOnLocalhost(t, bl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The length alone closes the connection, the body is never read
+	binary.Write(conn, binary.BigEndian, uint32(SASL_MAX_RECEIVE_SIZE+1))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const LEADER_EPOCH_CHECKPOINT_FILE = "leader-epoch-checkpoint"
+const LEADER_EPOCH_CHECKPOINT_VERSION = 0
+
+var ErrCorruptCheckpoint = errors.New("corrupt leader epoch checkpoint")
+
+type EpochEntry struct {
+	Epoch       int32
+	StartOffset int64
+}
+
+// LeaderEpochCache maps each leader epoch in a log to the offset of its first
+// record, checkpointed to the log directory in the same text format as Kafka.
+// It is guarded by the owning Log's lock.
+type LeaderEpochCache struct {
+	path    string
+	entries []EpochEntry
+}
+
+func NewLeaderEpochCache(dir string) *LeaderEpochCache {
+	return &LeaderEpochCache{path: filepath.Join(dir, LEADER_EPOCH_CHECKPOINT_FILE)}
+}
+
+// Load reads the checkpoint. A missing checkpoint leaves the cache empty, to
+// be rebuilt from the batches of the log.
+func (c *LeaderEpochCache) Load() error {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	if len(lines) < 2 || lines[0] != strconv.Itoa(LEADER_EPOCH_CHECKPOINT_VERSION) {
+		return ErrCorruptCheckpoint
+	}
+	count, err := strconv.Atoi(lines[1])
+	if err != nil || count != len(lines)-2 {
+		return ErrCorruptCheckpoint
+	}
+	entries := []EpochEntry{}
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return ErrCorruptCheckpoint
+		}
+		epoch, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			return ErrCorruptCheckpoint
+		}
+		startOffset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return ErrCorruptCheckpoint
+		}
+		entries = append(entries, EpochEntry{Epoch: int32(epoch), StartOffset: startOffset})
+	}
+	c.entries = entries
+	return nil
+}
+
+func (c *LeaderEpochCache) flush() error {
+	var data bytes.Buffer
+	fmt.Fprintf(&data, "%d\n%d\n", LEADER_EPOCH_CHECKPOINT_VERSION, len(c.entries))
+	for _, entry := range c.entries {
+		fmt.Fprintf(&data, "%d %d\n", entry.Epoch, entry.StartOffset)
+	}
+	if err := os.WriteFile(c.path+".tmp", data.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(c.path+".tmp", c.path)
+}
+
+func (c *LeaderEpochCache) Entries() []EpochEntry {
+	return append([]EpochEntry{}, c.entries...)
+}
+
+// LatestEpoch is the last epoch in the cache, or -1 when it is empty.
+func (c *LeaderEpochCache) LatestEpoch() int32 {
+	if len(c.entries) == 0 {
+		return -1
+	}
+	return c.entries[len(c.entries)-1].Epoch
+}
+
+// Assign records that epoch starts at startOffset. Only epochs newer than the
+// latest one are added, so assigning every batch of a log is harmless.
+func (c *LeaderEpochCache) Assign(epoch int32, startOffset int64) error {
+	if epoch < 0 || epoch <= c.LatestEpoch() {
+		return nil
+	}
+	c.entries = append(c.entries, EpochEntry{Epoch: epoch, StartOffset: startOffset})
+	return c.flush()
+}
+
+// TruncateFromEnd removes the epochs that start at or after endOffset.
+func (c *LeaderEpochCache) TruncateFromEnd(endOffset int64) error {
+	keep := len(c.entries)
+	for keep > 0 && c.entries[keep-1].StartOffset >= endOffset {
+		keep--
+	}
+	if keep == len(c.entries) {
+		return nil
+	}
+	c.entries = c.entries[:keep]
+	return c.flush()
+}
+
+// TruncateFromStart removes the epochs that end before startOffset and moves
+// the start of the first remaining one up to it.
+func (c *LeaderEpochCache) TruncateFromStart(startOffset int64) error {
+	first := 0
+	for first+1 < len(c.entries) && c.entries[first+1].StartOffset <= startOffset {
+		first++
+	}
+	if first == 0 && (len(c.entries) == 0 || c.entries[0].StartOffset >= startOffset) {
+		return nil
+	}
+	c.entries = c.entries[first:]
+	c.entries[0].StartOffset = max(c.entries[0].StartOffset, startOffset)
+	return c.flush()
+}
+
+func (c *LeaderEpochCache) Clear() error {
+	c.entries = nil
+	return c.flush()
+}
+
+// EndOffsetFor returns the largest epoch not above epoch and the offset
+// where it ends: the start of the next epoch, or logEndOffset for the latest
+// one. Both are -1 when every epoch in the cache is above epoch.
+func (c *LeaderEpochCache) EndOffsetFor(epoch int32, logEndOffset int64) (int32, int64) {
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].Epoch > epoch {
+			continue
+		}
+		if i == len(c.entries)-1 {
+			return c.entries[i].Epoch, logEndOffset
+		}
+		return c.entries[i].Epoch, c.entries[i+1].StartOffset
+	}
+	return -1, -1
+}
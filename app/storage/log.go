@@ -0,0 +1,696 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+)
+
+const LOG_FILE_SUFFIX = ".log"
+
+var SegmentBytes int64 = 1 << 30
+
+var (
+	ErrCorruptMessage       = errors.New("corrupt message")
+	ErrOffsetOutOfRange     = errors.New("offset out of range")
+	ErrOutOfOrderSequence   = errors.New("out of order sequence number")
+	ErrDuplicateSequence    = errors.New("duplicate sequence number")
+	ErrInvalidProducerEpoch = errors.New("invalid producer epoch")
+)
+
+type batchEntry struct {
+	BaseOffset int64
+	LastOffset int64
+	Position   int64
+	Size       int32
+	Epoch      int32
+}
+
+type Segment struct {
+	BaseOffset int64
+	file       *os.File
+	size       int64
+	entries    []batchEntry
+	txnIndex   *TransactionIndex
+}
+
+type LogAppendInfo struct {
+	FirstOffset    int64
+	LastOffset     int64
+	LogStartOffset int64
+}
+
+// Log is the on-disk log of a single topic partition, split into segments
+// named after the first offset they contain.
+type Log struct {
+	mu             sync.Mutex
+	Dir            string
+	segments       []*Segment
+	nextOffset     int64
+	logStartOffset int64
+	highWatermark  int64
+	// replicated logs only move the high watermark when the partition's
+	// replicas have the records, others keep it at the log end offset
+	replicated bool
+	producers  *ProducerStateManager
+	epochs     *LeaderEpochCache
+}
+
+func segmentFileName(dir string, baseOffset int64, suffix string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", baseOffset, suffix))
+}
+
+func parseOffsetFileName(name string, suffix string) (int64, bool) {
+	if !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(strings.TrimSuffix(name, suffix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+func OpenLog(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{Dir: dir, producers: NewProducerStateManager(dir), epochs: NewLeaderEpochCache(dir)}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	baseOffsets := []int64{}
+	for _, file := range files {
+		if offset, ok := parseOffsetFileName(file.Name(), LOG_FILE_SUFFIX); ok {
+			baseOffsets = append(baseOffsets, offset)
+		}
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
+
+	if err := l.epochs.Load(); err != nil {
+		log.Printf("Failed to load leader epoch checkpoint in %s: %s\n", dir, err.Error())
+	}
+	snapshotOffset, err := l.producers.LoadLatestSnapshot()
+	if err != nil {
+		log.Printf("Failed to load producer snapshot in %s: %s\n", dir, err.Error())
+	}
+
+	for _, baseOffset := range baseOffsets {
+		segment, err := l.loadSegment(baseOffset, snapshotOffset)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, segment)
+		l.nextOffset = baseOffset
+		if len(segment.entries) > 0 {
+			l.nextOffset = segment.entries[len(segment.entries)-1].LastOffset + 1
+		}
+	}
+
+	if len(l.segments) == 0 {
+		if err := l.roll(0); err != nil {
+			return nil, err
+		}
+	}
+	l.logStartOffset = l.segments[0].BaseOffset
+	l.highWatermark = l.nextOffset
+	// The checkpoint may be ahead of a log that lost its tail in a crash
+	if err := l.epochs.TruncateFromEnd(l.nextOffset); err != nil {
+		return nil, err
+	}
+	if err := l.epochs.TruncateFromStart(l.logStartOffset); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadSegment indexes the batches of a segment file and truncates a partially
+// written batch at its end. Batches past the producer snapshot are replayed
+// into the producer state.
+func (l *Log) loadSegment(baseOffset int64, snapshotOffset int64) (*Segment, error) {
+	path := segmentFileName(l.Dir, baseOffset, LOG_FILE_SUFFIX)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	txnIndex, err := openTransactionIndex(l.Dir, baseOffset)
+	if err != nil {
+		return nil, err
+	}
+	segment := &Segment{BaseOffset: baseOffset, txnIndex: txnIndex}
+	position := int64(0)
+	for int(position)+record.BATCH_HEADER_SIZE <= len(data) {
+		raw := data[position:]
+		size := record.LOG_OVERHEAD + int32(binary.BigEndian.Uint32(raw[8:12]))
+		if size < record.BATCH_HEADER_SIZE || int(size) > len(raw) {
+			break
+		}
+		header, err := record.DecodeBatchHeader(raw[:size])
+		if err != nil {
+			break
+		}
+		segment.entries = append(segment.entries, batchEntry{
+			BaseOffset: header.BaseOffset,
+			LastOffset: header.LastOffset(),
+			Position:   position,
+			Size:       size,
+			Epoch:      header.PartitionLeaderEpoch,
+		})
+		if err := l.epochs.Assign(header.PartitionLeaderEpoch, header.BaseOffset); err != nil {
+			return nil, err
+		}
+		if header.HasProducerID() && header.BaseOffset >= snapshotOffset {
+			completed := l.producers.Update(header)
+			if completed != nil && !txnIndex.contains(completed.ProducerID, completed.LastOffset) && isAbortMarker(raw[:size]) {
+				l.nextOffset = header.NextOffset()
+				l.indexAbortedTxn(segment, completed)
+			}
+		}
+		position += int64(size)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if position != int64(len(data)) {
+		log.Printf("Truncating %s from %d to %d bytes\n", path, len(data), position)
+		if err := file.Truncate(position); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(position, 0); err != nil {
+		return nil, err
+	}
+	segment.file = file
+	segment.size = position
+	return segment, nil
+}
+
+func (l *Log) roll(baseOffset int64) error {
+	if len(l.segments) > 0 {
+		if err := l.producers.TakeSnapshot(baseOffset); err != nil {
+			log.Printf("Failed to snapshot producer state in %s: %s\n", l.Dir, err.Error())
+		}
+	}
+	path := segmentFileName(l.Dir, baseOffset, LOG_FILE_SUFFIX)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	txnIndex, err := openTransactionIndex(l.Dir, baseOffset)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, &Segment{BaseOffset: baseOffset, file: file, txnIndex: txnIndex})
+	return nil
+}
+
+func (l *Log) indexAbortedTxn(segment *Segment, completed *CompletedTxn) {
+	entry := AbortedTxn{
+		ProducerID:       completed.ProducerID,
+		FirstOffset:      completed.FirstOffset,
+		LastOffset:       completed.LastOffset,
+		LastStableOffset: l.lastStableOffset(),
+	}
+	if err := segment.txnIndex.Append(entry); err != nil {
+		log.Printf("Failed to index aborted transaction in %s: %s\n", l.Dir, err.Error())
+	}
+}
+
+func splitBatches(raw []byte) ([][]byte, error) {
+	batches := [][]byte{}
+	for len(raw) > 0 {
+		if len(raw) < record.BATCH_HEADER_SIZE {
+			return nil, ErrCorruptMessage
+		}
+		size := record.LOG_OVERHEAD + int(binary.BigEndian.Uint32(raw[8:12]))
+		if size < record.BATCH_HEADER_SIZE || size > len(raw) {
+			return nil, ErrCorruptMessage
+		}
+		batches = append(batches, raw[:size])
+		raw = raw[size:]
+	}
+	return batches, nil
+}
+
+// AppendAsLeader validates the batches in raw, assigns them offsets and writes
+// them to the active segment. Every batch is validated before any is written,
+// so a request is not left half appended. A batch the producer already wrote
+// is not written again; its original offsets are returned with
+// ErrDuplicateSequence.
+func (l *Log) AppendAsLeader(raw []byte, leaderEpoch int32) (LogAppendInfo, error) {
+	info := LogAppendInfo{FirstOffset: -1, LastOffset: -1}
+	rawBatches, err := splitBatches(raw)
+	if err != nil || len(rawBatches) == 0 {
+		return info, ErrCorruptMessage
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	info.LogStartOffset = l.logStartOffset
+
+	batches := []*record.RecordBatch{}
+	for _, rawBatch := range rawBatches {
+		batch, err := record.DecodeBatchHeader(rawBatch)
+		if err != nil || batch.IsControl() {
+			return info, ErrCorruptMessage
+		}
+		if _, err := record.DecodeBatches(rawBatch); err != nil {
+			return info, ErrCorruptMessage
+		}
+		// Sequences are checked against the producer state before the
+		// request, which only holds for one batch per request
+		if batch.HasProducerID() && len(rawBatches) > 1 {
+			return info, ErrCorruptMessage
+		}
+		if batch.HasProducerID() {
+			duplicate, err := l.producers.CheckSequence(batch)
+			if err == ErrDuplicateSequence {
+				info.FirstOffset, info.LastOffset = duplicate.FirstOffset, duplicate.LastOffset
+				return info, err
+			}
+			if err != nil {
+				return info, err
+			}
+		}
+		batches = append(batches, batch)
+	}
+
+	for i, batch := range batches {
+		batch.BaseOffset = l.nextOffset
+		batch.PartitionLeaderEpoch = leaderEpoch
+		if err := l.append(rawBatches[i], batch); err != nil {
+			return info, err
+		}
+		if info.FirstOffset < 0 {
+			info.FirstOffset = batch.BaseOffset
+		}
+		info.LastOffset = batch.LastOffset()
+	}
+	return info, nil
+}
+
+// AppendControl writes a transaction marker for a producer. Markers from an
+// older producer epoch are rejected as they come from a fenced coordinator
+// request.
+func (l *Log) AppendControl(producerId int64, producerEpoch int16, coordinatorEpoch int32, commit bool, leaderEpoch int32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := record.NewEndTxnMarker(producerId, producerEpoch, coordinatorEpoch, commit, time.Now().UnixMilli())
+	if _, err := l.producers.CheckSequence(batch); err != nil {
+		return err
+	}
+	batch.BaseOffset = l.nextOffset
+	batch.PartitionLeaderEpoch = leaderEpoch
+	raw, err := batch.Encode()
+	if err != nil {
+		return err
+	}
+	return l.append(raw, batch)
+}
+
+func (l *Log) append(rawBatch []byte, batch *record.RecordBatch) error {
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+int64(len(rawBatch)) > SegmentBytes {
+		if err := l.roll(batch.BaseOffset); err != nil {
+			return err
+		}
+		active = l.segments[len(l.segments)-1]
+	}
+
+	data := make([]byte, len(rawBatch))
+	copy(data, rawBatch)
+	record.SetBaseOffset(data, batch.BaseOffset)
+	record.SetPartitionLeaderEpoch(data, batch.PartitionLeaderEpoch)
+	if _, err := active.file.WriteAt(data, active.size); err != nil {
+		return err
+	}
+
+	active.entries = append(active.entries, batchEntry{
+		BaseOffset: batch.BaseOffset,
+		LastOffset: batch.LastOffset(),
+		Position:   active.size,
+		Size:       int32(len(data)),
+		Epoch:      batch.PartitionLeaderEpoch,
+	})
+	active.size += int64(len(data))
+	l.nextOffset = batch.NextOffset()
+	if err := l.epochs.Assign(batch.PartitionLeaderEpoch, batch.BaseOffset); err != nil {
+		return err
+	}
+	if !l.replicated {
+		l.highWatermark = l.nextOffset
+	}
+	if batch.HasProducerID() {
+		completed := l.producers.Update(batch)
+		if completed != nil && isAbortMarker(data) {
+			l.indexAbortedTxn(active, completed)
+		}
+	}
+	return nil
+}
+
+// Read returns whole batches starting with the one containing fetchOffset
+// and ending before maxOffset. At least one batch is returned even if it is
+// larger than maxBytes so that consumers can always make progress.
+func (l *Log) Read(fetchOffset int64, maxOffset int64, maxBytes int) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if fetchOffset < l.logStartOffset || fetchOffset > l.nextOffset {
+		return nil, ErrOffsetOutOfRange
+	}
+
+	out := []byte{}
+	for _, segment := range l.segments {
+		if len(segment.entries) == 0 || segment.entries[len(segment.entries)-1].LastOffset < fetchOffset {
+			continue
+		}
+		start := sort.Search(len(segment.entries), func(i int) bool {
+			return segment.entries[i].LastOffset >= fetchOffset
+		})
+		end := start
+		size := 0
+		for end < len(segment.entries) {
+			entry := segment.entries[end]
+			if entry.BaseOffset >= maxOffset {
+				break
+			}
+			if len(out)+size+int(entry.Size) > maxBytes && len(out)+size > 0 {
+				break
+			}
+			size += int(entry.Size)
+			end++
+		}
+		if size > 0 {
+			buf := make([]byte, size)
+			if _, err := segment.file.ReadAt(buf, segment.entries[start].Position); err != nil {
+				return nil, err
+			}
+			out = append(out, buf...)
+		}
+		if end < len(segment.entries) {
+			break
+		}
+	}
+	return out, nil
+}
+
+// AppendAsFollower writes batches that already carry their offsets and
+// leader epochs, as copied from a leader. They must continue the log.
+func (l *Log) AppendAsFollower(raw []byte) error {
+	rawBatches, err := splitBatches(raw)
+	if err != nil {
+		return ErrCorruptMessage
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, rawBatch := range rawBatches {
+		batch, err := record.DecodeBatchHeader(rawBatch)
+		if err != nil {
+			return ErrCorruptMessage
+		}
+		if batch.BaseOffset != l.nextOffset {
+			return ErrOffsetOutOfRange
+		}
+		if err := l.append(rawBatch, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TruncateTo removes every batch that ends at or after offset, so that the
+// log ends at the first batch boundary at or before it. The transaction index
+// and producer state are rolled back along with it.
+func (l *Log) TruncateTo(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.segments) > 0 {
+		segment := l.segments[len(l.segments)-1]
+		if segment.BaseOffset >= offset && len(l.segments) > 1 {
+			if err := l.deleteSegment(segment); err != nil {
+				return err
+			}
+			l.segments = l.segments[:len(l.segments)-1]
+			continue
+		}
+
+		keep := sort.Search(len(segment.entries), func(i int) bool {
+			return segment.entries[i].LastOffset >= offset
+		})
+		size := segment.size
+		if keep < len(segment.entries) {
+			size = segment.entries[keep].Position
+		}
+		if err := segment.file.Truncate(size); err != nil {
+			return err
+		}
+		segment.entries = segment.entries[:keep]
+		segment.size = size
+		l.nextOffset = segment.BaseOffset
+		if keep > 0 {
+			l.nextOffset = segment.entries[keep-1].LastOffset + 1
+		}
+		if err := segment.txnIndex.truncateTo(l.nextOffset); err != nil {
+			return err
+		}
+		break
+	}
+	l.highWatermark = min(l.highWatermark, l.nextOffset)
+	if err := l.rebuildProducerState(); err != nil {
+		return err
+	}
+	return l.epochs.TruncateFromEnd(l.nextOffset)
+}
+
+// rebuildProducerState restores the latest producer snapshot at or before
+// the log end offset and replays the batches after it.
+func (l *Log) rebuildProducerState() error {
+	snapshotOffset, err := l.producers.TruncateAndReload(l.nextOffset)
+	if err != nil {
+		log.Printf("Failed to load producer snapshot in %s: %s\n", l.Dir, err.Error())
+	}
+	for _, segment := range l.segments {
+		for _, entry := range segment.entries {
+			if entry.BaseOffset < snapshotOffset {
+				continue
+			}
+			raw := make([]byte, entry.Size)
+			if _, err := segment.file.ReadAt(raw, entry.Position); err != nil {
+				return err
+			}
+			header, err := record.DecodeBatchHeader(raw)
+			if err != nil {
+				return err
+			}
+			if header.HasProducerID() {
+				l.producers.Update(header)
+			}
+		}
+	}
+	return nil
+}
+
+// TruncateFullyAndStartAt empties the log and restarts it at offset, as when
+// a follower replaces its log with a snapshot.
+func (l *Log) TruncateFullyAndStartAt(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, segment := range l.segments {
+		if err := l.deleteSegment(segment); err != nil {
+			return err
+		}
+	}
+	l.segments = nil
+	if err := l.roll(offset); err != nil {
+		return err
+	}
+	l.nextOffset = offset
+	l.logStartOffset = offset
+	l.highWatermark = offset
+	return l.epochs.Clear()
+}
+
+// DeleteSegmentsBefore removes the segments that only hold offsets before
+// offset. The active segment is always kept.
+func (l *Log) DeleteSegmentsBefore(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.segments) > 1 && l.segments[1].BaseOffset <= offset {
+		if err := l.deleteSegment(l.segments[0]); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	l.logStartOffset = max(l.logStartOffset, l.segments[0].BaseOffset)
+	return l.epochs.TruncateFromStart(l.logStartOffset)
+}
+
+func (l *Log) deleteSegment(segment *Segment) error {
+	segment.file.Close()
+	segment.txnIndex.Close()
+	if err := os.Remove(segmentFileName(l.Dir, segment.BaseOffset, LOG_FILE_SUFFIX)); err != nil {
+		return err
+	}
+	return os.Remove(segmentFileName(l.Dir, segment.BaseOffset, TXN_INDEX_FILE_SUFFIX))
+}
+
+// LastEpoch is the leader epoch of the last batch in the log, or -1 when the
+// log is empty.
+func (l *Log) LastEpoch() int32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		if entries := l.segments[i].entries; len(entries) > 0 {
+			return entries[len(entries)-1].Epoch
+		}
+	}
+	return -1
+}
+
+// EndOffsetForEpoch returns the largest epoch in the log not above epoch,
+// and the offset where it ends: the start of the next epoch, or the log end
+// offset for the latest one. Both are -1 when no such epoch is in the log.
+func (l *Log) EndOffsetForEpoch(epoch int32) (int32, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epochs.EndOffsetFor(epoch, l.nextOffset)
+}
+
+// AssignEpochStartOffset starts a new leader epoch at offset, before any
+// record of that epoch is written, as a new leader does at its log end.
+func (l *Log) AssignEpochStartOffset(epoch int32, offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epochs.Assign(epoch, offset)
+}
+
+func (l *Log) EpochEntries() []EpochEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epochs.Entries()
+}
+
+func (l *Log) HighWatermark() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.highWatermark
+}
+
+// SetHighWatermark moves the high watermark of a replicated log, never past
+// the log end offset.
+func (l *Log) SetHighWatermark(offset int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.highWatermark = max(min(offset, l.nextOffset), l.logStartOffset)
+}
+
+// SetReplicated hands the high watermark over to the partition's replication.
+// A log that is no longer replicated commits everything it has.
+func (l *Log) SetReplicated(replicated bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.replicated = replicated
+	if !replicated {
+		l.highWatermark = l.nextOffset
+	}
+}
+
+func (l *Log) lastStableOffset() int64 {
+	if firstUnstableOffset := l.producers.FirstUnstableOffset(); firstUnstableOffset >= 0 {
+		return min(firstUnstableOffset, l.highWatermark)
+	}
+	return l.highWatermark
+}
+
+// LastStableOffset bounds what read_committed consumers may see: nothing at
+// or after the first offset of a still open transaction.
+func (l *Log) LastStableOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastStableOffset()
+}
+
+// CollectAbortedTxns returns the aborted transactions with data in
+// [fetchOffset, upperBoundOffset).
+func (l *Log) CollectAbortedTxns(fetchOffset int64, upperBoundOffset int64) []AbortedTxn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	abortedTxns := []AbortedTxn{}
+	for _, segment := range l.segments {
+		for _, entry := range segment.txnIndex.entries {
+			if entry.LastOffset >= fetchOffset && entry.FirstOffset < upperBoundOffset {
+				abortedTxns = append(abortedTxns, entry)
+			}
+		}
+	}
+	return abortedTxns
+}
+
+func (l *Log) ActiveProducers() []ProducerStateEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.producers.Producers()
+}
+
+// ProducerEpoch is the latest epoch the producer wrote to this partition
+// with, if it has written to it.
+func (l *Log) ProducerEpoch(producerId int64) (int16, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.producers.producers[producerId]
+	if !ok {
+		return 0, false
+	}
+	return entry.ProducerEpoch, true
+}
+
+func (l *Log) LogEndOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextOffset
+}
+
+func (l *Log) LogStartOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logStartOffset
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.producers.TakeSnapshot(l.nextOffset); err != nil {
+		log.Printf("Failed to snapshot producer state in %s: %s\n", l.Dir, err.Error())
+	}
+	for _, segment := range l.segments {
+		if err := segment.file.Close(); err != nil {
+			return err
+		}
+		if err := segment.txnIndex.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+)
+
+func producerBatch(t *testing.T, producerId int64, epoch int16, baseSequence int32, count int) []byte {
+	records := make([]record.Record, count)
+	for i := range records {
+		records[i].Value = []byte("value")
+	}
+	batch := record.NewRecordBatch(records)
+	batch.ProducerID = producerId
+	batch.ProducerEpoch = epoch
+	batch.BaseSequence = baseSequence
+	raw, err := batch.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode batch: %v", err)
+	}
+	return raw
+}
+
+func TestIdempotentAppend(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenLog(dir)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+
+	info, err := l.AppendAsLeader(producerBatch(t, 1000, 0, 0, 3), 0)
+	if err != nil || info.FirstOffset != 0 || info.LastOffset != 2 {
+		t.Fatalf("Unexpected first append %+v %v", info, err)
+	}
+	if _, err := l.AppendAsLeader(producerBatch(t, 1000, 0, 3, 2), 0); err != nil {
+		t.Fatalf("Unexpected error for in sequence batch: %v", err)
+	}
+
+	info, err = l.AppendAsLeader(producerBatch(t, 1000, 0, 0, 3), 0)
+	if err != ErrDuplicateSequence || info.FirstOffset != 0 || info.LastOffset != 2 {
+		t.Errorf("Expected duplicate of offsets 0-2, got %+v %v", info, err)
+	}
+	if _, err := l.AppendAsLeader(producerBatch(t, 1000, 0, 7, 1), 0); err != ErrOutOfOrderSequence {
+		t.Errorf("Expected out of order sequence, got %v", err)
+	}
+	// A request with an invalid batch writes none of its batches
+	corrupt := producerBatch(t, -1, -1, -1, 1)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := l.AppendAsLeader(append(producerBatch(t, -1, -1, -1, 1), corrupt...), 0); err != ErrCorruptMessage {
+		t.Errorf("Expected a corrupt message, got %v", err)
+	}
+	if l.LogEndOffset() != 5 {
+		t.Errorf("Expected log end offset 5, got %d", l.LogEndOffset())
+	}
+
+	// State must survive a restart, both from a snapshot and from replaying
+	// the log when there is none.
+	for _, snapshot := range []bool{true, false} {
+		if snapshot {
+			l.Close()
+		} else {
+			for _, segment := range l.segments {
+				segment.file.Close()
+			}
+			for _, offset := range l.producers.snapshotOffsets() {
+				if err := os.Remove(segmentFileName(dir, offset, SNAPSHOT_FILE_SUFFIX)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		l, err = OpenLog(dir)
+		if err != nil {
+			t.Fatalf("Failed to reopen log: %v", err)
+		}
+		if _, err := l.AppendAsLeader(producerBatch(t, 1000, 0, 3, 2), 0); err != ErrDuplicateSequence {
+			t.Errorf("Expected duplicate after restart, got %v", err)
+		}
+	}
+
+	data, err := l.Read(3, l.LogEndOffset(), 1)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	batches, _ := record.DecodeBatches(data)
+	if len(batches) != 1 || batches[0].BaseOffset != 3 {
+		t.Errorf("Expected a single batch at offset 3, got %v", batches)
+	}
+}
+
+func TestAbortedTransactionIndex(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenLog(dir)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+
+	for _, producerId := range []int64{1, 2} {
+		batches, _ := record.DecodeBatches(producerBatch(t, producerId, 0, 0, 2))
+		batches[0].Attributes |= record.TRANSACTIONAL_FLAG_MASK
+		raw, _ := batches[0].Encode()
+		if _, err := l.AppendAsLeader(raw, 0); err != nil {
+			t.Fatalf("Failed to append transactional batch: %v", err)
+		}
+	}
+	if lso := l.LastStableOffset(); lso != 0 {
+		t.Errorf("Expected last stable offset 0, got %d", lso)
+	}
+
+	if err := l.AppendControl(1, 0, 0, false, 0); err != nil {
+		t.Fatalf("Failed to abort: %v", err)
+	}
+	if lso := l.LastStableOffset(); lso != 2 {
+		t.Errorf("Expected last stable offset 2 after the abort, got %d", lso)
+	}
+	if err := l.AppendControl(2, 0, 0, true, 0); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if lso := l.LastStableOffset(); lso != 6 {
+		t.Errorf("Expected last stable offset 6 after the commit, got %d", lso)
+	}
+
+	expected := AbortedTxn{ProducerID: 1, FirstOffset: 0, LastOffset: 4, LastStableOffset: 2}
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			for _, segment := range l.segments {
+				segment.file.Close()
+				segment.txnIndex.Close()
+			}
+			for _, offset := range l.producers.snapshotOffsets() {
+				os.Remove(segmentFileName(dir, offset, SNAPSHOT_FILE_SUFFIX))
+			}
+			if l, err = OpenLog(dir); err != nil {
+				t.Fatalf("Failed to reopen log: %v", err)
+			}
+		}
+		abortedTxns := l.CollectAbortedTxns(0, l.LastStableOffset())
+		if len(abortedTxns) != 1 || abortedTxns[0] != expected {
+			t.Errorf("Expected %+v, got %+v", expected, abortedTxns)
+		}
+		if abortedTxns := l.CollectAbortedTxns(5, l.LastStableOffset()); len(abortedTxns) != 0 {
+			t.Errorf("Expected no aborted transactions past the marker, got %+v", abortedTxns)
+		}
+	}
+}
+
+func TestTruncateRollsBackProducerState(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenLog(dir)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	batches, _ := record.DecodeBatches(producerBatch(t, 1, 0, 0, 2))
+	batches[0].Attributes |= record.TRANSACTIONAL_FLAG_MASK
+	raw, _ := batches[0].Encode()
+	if _, err := l.AppendAsLeader(raw, 0); err != nil {
+		t.Fatalf("Failed to append transactional batch: %v", err)
+	}
+	if err := l.AppendControl(1, 0, 0, false, 0); err != nil {
+		t.Fatalf("Failed to abort: %v", err)
+	}
+	if _, err := l.AppendAsLeader(producerBatch(t, 2, 0, 0, 2), 0); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if err := l.producers.TakeSnapshot(5); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	if _, err := l.AppendAsLeader(producerBatch(t, 2, 0, 2, 2), 0); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	// The truncated batch is no longer a duplicate once the log is cut
+	if err := l.TruncateTo(5); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+	info, err := l.AppendAsLeader(producerBatch(t, 2, 0, 2, 2), 0)
+	if err != nil || info.FirstOffset != 5 {
+		t.Fatalf("Expected the batch to be written again at 5, got %+v, %v", info, err)
+	}
+
+	// Cutting the abort marker reopens the transaction and drops its index
+	// entry along with the snapshot taken after it
+	if err := l.TruncateTo(2); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+	if abortedTxns := l.CollectAbortedTxns(0, 2); len(abortedTxns) != 0 {
+		t.Errorf("Expected no aborted transactions, got %+v", abortedTxns)
+	}
+	if lso := l.LastStableOffset(); lso != 0 {
+		t.Errorf("Expected the transaction to be open again, got last stable offset %d", lso)
+	}
+	if offsets := l.producers.snapshotOffsets(); len(offsets) != 0 {
+		t.Errorf("Expected the snapshot past the log end to be removed, got %v", offsets)
+	}
+	if _, ok := l.ProducerEpoch(2); ok {
+		t.Errorf("Expected producer 2 to be forgotten")
+	}
+}
+
+func TestLeaderEpochCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenLog(dir)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	for _, epoch := range []int32{1, 1, 3, 4} {
+		if _, err := l.AppendAsLeader(producerBatch(t, -1, -1, -1, 2), epoch); err != nil {
+			t.Fatalf("Failed to append in epoch %d: %v", epoch, err)
+		}
+	}
+	expected := []EpochEntry{{Epoch: 1, StartOffset: 0}, {Epoch: 3, StartOffset: 4}, {Epoch: 4, StartOffset: 6}}
+	if entries := l.EpochEntries(); !slices.Equal(entries, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, entries)
+	}
+
+	for _, test := range []struct {
+		epoch       int32
+		foundEpoch  int32
+		endOffset   int64
+		description string
+	}{
+		{0, -1, -1, "an epoch before the log"},
+		{1, 1, 4, "an earlier epoch"},
+		{2, 1, 4, "an epoch missing from the log"},
+		{4, 4, 8, "the latest epoch"},
+		{7, 4, 8, "a later epoch"},
+	} {
+		if epoch, endOffset := l.EndOffsetForEpoch(test.epoch); epoch != test.foundEpoch || endOffset != test.endOffset {
+			t.Errorf("Expected %d ending at %d for %s, got %d ending at %d", test.foundEpoch, test.endOffset, test.description, epoch, endOffset)
+		}
+	}
+
+	// Truncation drops the epochs that no longer have records, and the
+	// checkpoint survives a restart
+	if err := l.TruncateTo(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AssignEpochStartOffset(5, l.LogEndOffset()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []EpochEntry{{Epoch: 1, StartOffset: 0}, {Epoch: 5, StartOffset: 4}}
+	if entries := l.EpochEntries(); !slices.Equal(entries, expected) {
+		t.Fatalf("Expected %+v after truncating, got %+v", expected, entries)
+	}
+	l.Close()
+	if l, err = OpenLog(dir); err != nil {
+		t.Fatalf("Failed to reopen log: %v", err)
+	}
+	if entries := l.EpochEntries(); !slices.Equal(entries, expected[:1]) {
+		t.Errorf("Expected the epoch without records to be dropped on restart, got %+v", entries)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, LEADER_EPOCH_CHECKPOINT_FILE))
+	if err != nil || string(data) != "0\n1\n1 0\n" {
+		t.Errorf("Unexpected checkpoint %q %v", data, err)
+	}
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+)
+
+const SNAPSHOT_FILE_SUFFIX = ".snapshot"
+const SNAPSHOT_VERSION int16 = 2
+
+// Number of recent batches kept per producer for duplicate detection. This
+// matches max.in.flight.requests.per.connection for idempotent producers.
+const MAX_BATCHES_PER_PRODUCER = 5
+
+var ErrCorruptSnapshot = errors.New("corrupt producer snapshot")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+type BatchMetadata struct {
+	FirstSeq    int32
+	LastSeq     int32
+	FirstOffset int64
+	LastOffset  int64
+	Timestamp   int64
+}
+
+type ProducerStateEntry struct {
+	ProducerID            int64
+	ProducerEpoch         int16
+	CoordinatorEpoch      int32
+	LastTimestamp         int64
+	CurrentTxnFirstOffset int64
+	Batches               []BatchMetadata
+}
+
+type CompletedTxn struct {
+	ProducerID  int64
+	FirstOffset int64
+	LastOffset  int64
+}
+
+// ProducerStateManager tracks the sequence numbers written by each idempotent
+// producer to one partition. It is guarded by the owning Log's lock.
+type ProducerStateManager struct {
+	dir       string
+	producers map[int64]*ProducerStateEntry
+}
+
+func NewProducerStateManager(dir string) *ProducerStateManager {
+	return &ProducerStateManager{dir: dir, producers: map[int64]*ProducerStateEntry{}}
+}
+
+func inSequence(lastSeq int32, nextSeq int32) bool {
+	return nextSeq == lastSeq+1 || (nextSeq == 0 && lastSeq == math.MaxInt32)
+}
+
+// CheckSequence validates an incoming batch against the producer's state
+// without modifying it. For a duplicate the cached batch is returned.
+func (m *ProducerStateManager) CheckSequence(batch *record.RecordBatch) (*BatchMetadata, error) {
+	entry, ok := m.producers[batch.ProducerID]
+	if !ok {
+		// Unknown producers start wherever they are, e.g. after their state
+		// was lost to retention.
+		return nil, nil
+	}
+	if batch.ProducerEpoch < entry.ProducerEpoch {
+		return nil, ErrInvalidProducerEpoch
+	}
+	// Batches written by a coordinator carry no sequence
+	if batch.BaseSequence == record.NO_SEQUENCE {
+		return nil, nil
+	}
+	if batch.ProducerEpoch > entry.ProducerEpoch {
+		if batch.BaseSequence != 0 {
+			return nil, ErrOutOfOrderSequence
+		}
+		return nil, nil
+	}
+
+	for i := range entry.Batches {
+		cached := entry.Batches[i]
+		if cached.FirstSeq == batch.BaseSequence && cached.LastSeq == batch.LastSequence() {
+			return &cached, ErrDuplicateSequence
+		}
+	}
+	if len(entry.Batches) == 0 {
+		return nil, nil
+	}
+	if !inSequence(entry.Batches[len(entry.Batches)-1].LastSeq, batch.BaseSequence) {
+		return nil, ErrOutOfOrderSequence
+	}
+	return nil, nil
+}
+
+// Update records a batch that has been written at its assigned offsets. The
+// producer's open transaction starts at its first transactional batch and
+// ends with a control batch, for which the completed transaction is returned.
+func (m *ProducerStateManager) Update(batch *record.RecordBatch) *CompletedTxn {
+	entry, ok := m.producers[batch.ProducerID]
+	if !ok {
+		entry = &ProducerStateEntry{ProducerID: batch.ProducerID, ProducerEpoch: batch.ProducerEpoch, CurrentTxnFirstOffset: -1}
+		m.producers[batch.ProducerID] = entry
+	}
+	if batch.ProducerEpoch != entry.ProducerEpoch {
+		entry.ProducerEpoch = batch.ProducerEpoch
+		entry.Batches = nil
+	}
+	entry.LastTimestamp = batch.MaxTimestamp
+
+	if batch.IsControl() {
+		if entry.CurrentTxnFirstOffset < 0 {
+			return nil
+		}
+		completed := &CompletedTxn{ProducerID: entry.ProducerID, FirstOffset: entry.CurrentTxnFirstOffset, LastOffset: batch.BaseOffset}
+		entry.CurrentTxnFirstOffset = -1
+		return completed
+	}
+	if batch.IsTransactional() && entry.CurrentTxnFirstOffset < 0 {
+		entry.CurrentTxnFirstOffset = batch.BaseOffset
+	}
+	if batch.BaseSequence == record.NO_SEQUENCE {
+		return nil
+	}
+
+	entry.Batches = append(entry.Batches, BatchMetadata{
+		FirstSeq:    batch.BaseSequence,
+		LastSeq:     batch.LastSequence(),
+		FirstOffset: batch.BaseOffset,
+		LastOffset:  batch.LastOffset(),
+		Timestamp:   batch.MaxTimestamp,
+	})
+	if len(entry.Batches) > MAX_BATCHES_PER_PRODUCER {
+		entry.Batches = entry.Batches[len(entry.Batches)-MAX_BATCHES_PER_PRODUCER:]
+	}
+	return nil
+}
+
+// FirstUnstableOffset is the first offset of the oldest open transaction, or
+// -1 when there is none.
+func (m *ProducerStateManager) FirstUnstableOffset() int64 {
+	firstUnstableOffset := int64(-1)
+	for _, entry := range m.producers {
+		if entry.CurrentTxnFirstOffset >= 0 && (firstUnstableOffset < 0 || entry.CurrentTxnFirstOffset < firstUnstableOffset) {
+			firstUnstableOffset = entry.CurrentTxnFirstOffset
+		}
+	}
+	return firstUnstableOffset
+}
+
+func (m *ProducerStateManager) Producers() []ProducerStateEntry {
+	entries := make([]ProducerStateEntry, 0, len(m.producers))
+	for _, entry := range m.producers {
+		copied := *entry
+		copied.Batches = append([]BatchMetadata{}, entry.Batches...)
+		entries = append(entries, copied)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ProducerID < entries[j].ProducerID })
+	return entries
+}
+
+func (m *ProducerStateManager) snapshotOffsets() []int64 {
+	offsets := []int64{}
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		return offsets
+	}
+	for _, file := range files {
+		if offset, ok := parseOffsetFileName(file.Name(), SNAPSHOT_FILE_SUFFIX); ok {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// TakeSnapshot writes the state as of offset, then removes all but the two
+// most recent snapshots.
+func (m *ProducerStateManager) TakeSnapshot(offset int64) error {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int32(len(m.producers)))
+	for _, entry := range m.Producers() {
+		binary.Write(&body, binary.BigEndian, entry.ProducerID)
+		binary.Write(&body, binary.BigEndian, entry.ProducerEpoch)
+		binary.Write(&body, binary.BigEndian, entry.CoordinatorEpoch)
+		binary.Write(&body, binary.BigEndian, entry.LastTimestamp)
+		binary.Write(&body, binary.BigEndian, entry.CurrentTxnFirstOffset)
+		binary.Write(&body, binary.BigEndian, int32(len(entry.Batches)))
+		for _, batch := range entry.Batches {
+			binary.Write(&body, binary.BigEndian, batch)
+		}
+	}
+
+	var snapshot bytes.Buffer
+	binary.Write(&snapshot, binary.BigEndian, SNAPSHOT_VERSION)
+	binary.Write(&snapshot, binary.BigEndian, crc32.Checksum(body.Bytes(), crc32c))
+	snapshot.Write(body.Bytes())
+
+	path := segmentFileName(m.dir, offset, SNAPSHOT_FILE_SUFFIX)
+	if err := os.WriteFile(path+".tmp", snapshot.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	offsets := m.snapshotOffsets()
+	for i := 0; i < len(offsets)-2; i++ {
+		os.Remove(segmentFileName(m.dir, offsets[i], SNAPSHOT_FILE_SUFFIX))
+	}
+	return nil
+}
+
+// LoadLatestSnapshot restores the newest readable snapshot and returns the
+// offset it was taken at, from which the log must be replayed.
+func (m *ProducerStateManager) LoadLatestSnapshot() (int64, error) {
+	offsets := m.snapshotOffsets()
+	var lastErr error
+	for i := len(offsets) - 1; i >= 0; i-- {
+		producers, err := readSnapshot(segmentFileName(m.dir, offsets[i], SNAPSHOT_FILE_SUFFIX))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		m.producers = producers
+		return offsets[i], nil
+	}
+	return 0, lastErr
+}
+
+// TruncateAndReload removes the snapshots taken after offset and restores
+// the newest one left, or an empty state when there is none. It returns the
+// offset the log must be replayed from.
+func (m *ProducerStateManager) TruncateAndReload(offset int64) (int64, error) {
+	for _, snapshotOffset := range m.snapshotOffsets() {
+		if snapshotOffset > offset {
+			os.Remove(segmentFileName(m.dir, snapshotOffset, SNAPSHOT_FILE_SUFFIX))
+		}
+	}
+	m.producers = map[int64]*ProducerStateEntry{}
+	return m.LoadLatestSnapshot()
+}
+
+func readSnapshot(path string) (map[int64]*ProducerStateEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bytes.NewBuffer(data)
+	var version int16
+	var crc uint32
+	if err := binary.Read(reader, binary.BigEndian, &version); err != nil || version != SNAPSHOT_VERSION {
+		return nil, ErrCorruptSnapshot
+	}
+	if err := binary.Read(reader, binary.BigEndian, &crc); err != nil {
+		return nil, ErrCorruptSnapshot
+	}
+	if crc32.Checksum(reader.Bytes(), crc32c) != crc {
+		return nil, ErrCorruptSnapshot
+	}
+
+	producers := map[int64]*ProducerStateEntry{}
+	var count int32
+	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
+		return nil, ErrCorruptSnapshot
+	}
+	for range count {
+		entry := &ProducerStateEntry{}
+		var batchCount int32
+		fields := []any{&entry.ProducerID, &entry.ProducerEpoch, &entry.CoordinatorEpoch, &entry.LastTimestamp, &entry.CurrentTxnFirstOffset, &batchCount}
+		for _, field := range fields {
+			if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+				return nil, ErrCorruptSnapshot
+			}
+		}
+		if batchCount < 0 || batchCount > MAX_BATCHES_PER_PRODUCER {
+			return nil, ErrCorruptSnapshot
+		}
+		entry.Batches = make([]BatchMetadata, batchCount)
+		if err := binary.Read(reader, binary.BigEndian, entry.Batches); err != nil {
+			return nil, ErrCorruptSnapshot
+		}
+		producers[entry.ProducerID] = entry
+	}
+	return producers, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var LogDir = "/tmp/kraft-combined-logs"
+
+var logs = map[string]*Log{}
+var logsLock sync.Mutex
+
+func partitionDir(topic string, partition int32) string {
+	return filepath.Join(LogDir, fmt.Sprintf("%s-%d", topic, partition))
+}
+
+// GetLog returns the log of a topic partition, opening or creating it on
+// first use.
+func GetLog(topic string, partition int32) (*Log, error) {
+	logsLock.Lock()
+	defer logsLock.Unlock()
+
+	dir := partitionDir(topic, partition)
+	if l, ok := logs[dir]; ok {
+		return l, nil
+	}
+	l, err := OpenLog(dir)
+	if err != nil {
+		return nil, err
+	}
+	logs[dir] = l
+	return l, nil
+}
+
+// LoadLogs opens the partition logs in the log directory, so that the state
+// of their producers is known from startup rather than only once each
+// partition is used again. The metadata log, which may share the directory,
+// is left to the quorum.
+func LoadLogs() error {
+	entries, err := os.ReadDir(LogDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, entry := range entries {
+		i := strings.LastIndex(entry.Name(), "-")
+		if !entry.IsDir() || i <= 0 {
+			continue
+		}
+		topic := entry.Name()[:i]
+		partition, err := strconv.ParseInt(entry.Name()[i+1:], 10, 32)
+		if err != nil || topic == "__cluster_metadata" {
+			continue
+		}
+		if _, err := GetLog(topic, int32(partition)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ProducerEpoch is the latest epoch the producer wrote with to any partition
+// of this broker, if it has written to one.
+func ProducerEpoch(producerId int64) (int16, bool) {
+	logsLock.Lock()
+	defer logsLock.Unlock()
+
+	epoch, found := int16(0), false
+	for _, l := range logs {
+		if logEpoch, ok := l.ProducerEpoch(producerId); ok && (!found || logEpoch > epoch) {
+			epoch, found = logEpoch, true
+		}
+	}
+	return epoch, found
+}
+
+func CloseAll() {
+	logsLock.Lock()
+	defer logsLock.Unlock()
+
+	for dir, l := range logs {
+		if err := l.Close(); err != nil {
+			log.Printf("Failed to close log %s: %s\n", dir, err.Error())
+		}
+		delete(logs, dir)
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+)
+
+const TXN_INDEX_FILE_SUFFIX = ".txnindex"
+const TXN_INDEX_VERSION int16 = 0
+const TXN_INDEX_ENTRY_SIZE = 2 + 8 + 8 + 8 + 8
+
+type AbortedTxn struct {
+	ProducerID       int64
+	FirstOffset      int64
+	LastOffset       int64
+	LastStableOffset int64
+}
+
+// TransactionIndex lists the transactions aborted by markers in one segment,
+// in the same layout as Kafka's .txnindex files.
+type TransactionIndex struct {
+	file    *os.File
+	entries []AbortedTxn
+}
+
+func openTransactionIndex(dir string, baseOffset int64) (*TransactionIndex, error) {
+	path := segmentFileName(dir, baseOffset, TXN_INDEX_FILE_SUFFIX)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	index := &TransactionIndex{}
+	reader := bytes.NewBuffer(data)
+	for reader.Len() >= TXN_INDEX_ENTRY_SIZE {
+		var version int16
+		var entry AbortedTxn
+		binary.Read(reader, binary.BigEndian, &version)
+		binary.Read(reader, binary.BigEndian, &entry)
+		index.entries = append(index.entries, entry)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(index.entries) * TXN_INDEX_ENTRY_SIZE)
+	if size != int64(len(data)) {
+		log.Printf("Truncating %s from %d to %d bytes\n", path, len(data), size)
+		if err := file.Truncate(size); err != nil {
+			return nil, err
+		}
+	}
+	index.file = file
+	return index, nil
+}
+
+func (idx *TransactionIndex) Append(entry AbortedTxn) error {
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, TXN_INDEX_VERSION)
+	binary.Write(&data, binary.BigEndian, entry)
+	if _, err := idx.file.WriteAt(data.Bytes(), int64(len(idx.entries)*TXN_INDEX_ENTRY_SIZE)); err != nil {
+		return err
+	}
+	idx.entries = append(idx.entries, entry)
+	return nil
+}
+
+func (idx *TransactionIndex) contains(producerId int64, lastOffset int64) bool {
+	for _, entry := range idx.entries {
+		if entry.ProducerID == producerId && entry.LastOffset == lastOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateTo drops the transactions that end at or after offset.
+func (idx *TransactionIndex) truncateTo(offset int64) error {
+	keep := len(idx.entries)
+	for keep > 0 && idx.entries[keep-1].LastOffset >= offset {
+		keep--
+	}
+	if err := idx.file.Truncate(int64(keep * TXN_INDEX_ENTRY_SIZE)); err != nil {
+		return err
+	}
+	idx.entries = idx.entries[:keep]
+	return nil
+}
+
+func (idx *TransactionIndex) Close() error {
+	return idx.file.Close()
+}
+
+func isAbortMarker(rawBatch []byte) bool {
+	batches, err := record.DecodeBatches(rawBatch)
+	if err != nil || len(batches) == 0 || !batches[0].IsControl() || len(batches[0].Records) == 0 {
+		return false
+	}
+	controlType, _, err := record.DecodeEndTxnMarker(batches[0].Records[0])
+	return err == nil && controlType == record.CONTROL_ABORT
+}
@@ -0,0 +1,528 @@
+package txn
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/producer"
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/replica"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+)
+
+const TRANSACTION_STATE_TOPIC = "__transaction_state"
+const COORDINATOR_EPOCH int32 = 0
+const MAX_TRANSACTION_TIMEOUT_MS int32 = 900000
+const TRANSACTIONAL_ID_EXPIRATION_MS int64 = 7 * 24 * 60 * 60 * 1000
+
+var AbortTimedOutTransactionsInterval = 10 * time.Second
+
+type TransactionState int8
+
+const (
+	EMPTY               TransactionState = 0
+	ONGOING             TransactionState = 1
+	PREPARE_COMMIT      TransactionState = 2
+	PREPARE_ABORT       TransactionState = 3
+	COMPLETE_COMMIT     TransactionState = 4
+	COMPLETE_ABORT      TransactionState = 5
+	DEAD                TransactionState = 6
+	PREPARE_EPOCH_FENCE TransactionState = 7
+)
+
+// Names as used by the admin client and kafka-transactions.sh
+func (s TransactionState) String() string {
+	switch s {
+	case EMPTY:
+		return "Empty"
+	case ONGOING:
+		return "Ongoing"
+	case PREPARE_COMMIT:
+		return "PrepareCommit"
+	case PREPARE_ABORT:
+		return "PrepareAbort"
+	case COMPLETE_COMMIT:
+		return "CompleteCommit"
+	case COMPLETE_ABORT:
+		return "CompleteAbort"
+	case DEAD:
+		return "Dead"
+	case PREPARE_EPOCH_FENCE:
+		return "PrepareEpochFence"
+	}
+	return "Unknown"
+}
+
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+type TransactionMetadata struct {
+	TransactionalID     string
+	ProducerID          int64
+	LastProducerID      int64
+	ProducerEpoch       int16
+	LastProducerEpoch   int16
+	TimeoutMs           int32
+	State               TransactionState
+	Partitions          map[TopicPartition]bool
+	StartTimestamp      int64
+	LastUpdateTimestamp int64
+}
+
+func newTransactionMetadata(transactionalId string) *TransactionMetadata {
+	return &TransactionMetadata{
+		TransactionalID:   transactionalId,
+		ProducerID:        record.NO_PRODUCER_ID,
+		LastProducerID:    record.NO_PRODUCER_ID,
+		ProducerEpoch:     record.NO_PRODUCER_EPOCH,
+		LastProducerEpoch: record.NO_PRODUCER_EPOCH,
+		Partitions:        map[TopicPartition]bool{},
+		StartTimestamp:    -1,
+	}
+}
+
+// TransactionCoordinator owns the state of every transactional ID. Each
+// change is written to the transaction state log before it is acknowledged.
+// Markers are written without holding mu, and markersInFlight keeps a
+// transaction's markers from being written by two callers at once.
+type TransactionCoordinator struct {
+	mu              sync.Mutex
+	stateLog        *storage.Log
+	transactions    map[string]*TransactionMetadata
+	markersInFlight map[string]bool
+}
+
+var coordinator *TransactionCoordinator
+
+func Coordinator() *TransactionCoordinator {
+	return coordinator
+}
+
+// LoadTransactionState replays the transaction state log, finishes any
+// transaction that was left half committed or aborted, and starts aborting
+// transactions that exceed their timeout.
+func LoadTransactionState() error {
+	stateLog, err := storage.GetLog(TRANSACTION_STATE_TOPIC, 0)
+	if err != nil {
+		return err
+	}
+	if err := loadOffsetStore(); err != nil {
+		return err
+	}
+
+	c := &TransactionCoordinator{stateLog: stateLog, transactions: map[string]*TransactionMetadata{}, markersInFlight: map[string]bool{}}
+	batches, err := readAll(stateLog)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		for _, rec := range batch.Records {
+			transactionalId, err := decodeTransactionLogKey(rec.Key)
+			if err != nil {
+				return err
+			}
+			if rec.Value == nil {
+				delete(c.transactions, transactionalId)
+				continue
+			}
+			m, err := decodeTransactionLogValue(transactionalId, rec.Value)
+			if err != nil {
+				return err
+			}
+			c.transactions[transactionalId] = m
+		}
+	}
+
+	for transactionalId := range c.transactions {
+		c.writeMarkers(transactionalId)
+	}
+
+	coordinator = c
+	go c.expireTransactions()
+	return nil
+}
+
+func (c *TransactionCoordinator) persist(m *TransactionMetadata) error {
+	m.LastUpdateTimestamp = time.Now().UnixMilli()
+	err := appendRecord(c.stateLog, encodeTransactionLogKey(m.TransactionalID), encodeTransactionLogValue(m), m.LastUpdateTimestamp)
+	if err != nil {
+		log.Printf("Failed to write transaction state of %s: %s\n", m.TransactionalID, err.Error())
+	}
+	return err
+}
+
+// bumpEpoch starts a new producer session, moving to a new producer ID once
+// the epoch is exhausted.
+func (c *TransactionCoordinator) bumpEpoch(m *TransactionMetadata) int16 {
+	m.LastProducerID, m.LastProducerEpoch = m.ProducerID, m.ProducerEpoch
+	if m.ProducerID != record.NO_PRODUCER_ID && m.ProducerEpoch < math.MaxInt16-1 {
+		m.ProducerEpoch++
+		return utils.NONE
+	}
+	manager, err := producer.DefaultProducerIdManager()
+	if err != nil {
+		return utils.UNKNOWN_SERVER_ERROR
+	}
+	id, err := manager.GenerateProducerId()
+	if err != nil {
+		return utils.UNKNOWN_SERVER_ERROR
+	}
+	m.ProducerID, m.ProducerEpoch = id, 0
+	return utils.NONE
+}
+
+func (c *TransactionCoordinator) InitProducerId(transactionalId string, timeoutMs int32, producerId int64, producerEpoch int16) (int64, int16, int16) {
+	if timeoutMs <= 0 || timeoutMs > MAX_TRANSACTION_TIMEOUT_MS {
+		return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.INVALID_TRANSACTION_TIMEOUT
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.transactions[transactionalId]
+	if !ok {
+		m = newTransactionMetadata(transactionalId)
+		c.transactions[transactionalId] = m
+	}
+
+	// A producer recovering from an error passes its current ID and epoch. A
+	// retry of a request that already bumped the epoch gets the same answer.
+	if producerId != record.NO_PRODUCER_ID {
+		if producerId == m.LastProducerID && producerEpoch == m.LastProducerEpoch && m.State == EMPTY {
+			return m.ProducerID, m.ProducerEpoch, utils.NONE
+		}
+		if producerId != m.ProducerID {
+			return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.INVALID_PRODUCER_ID_MAPPING
+		}
+		if producerEpoch != m.ProducerEpoch {
+			return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.PRODUCER_FENCED
+		}
+	}
+
+	// An ongoing transaction is aborted before the new session starts
+	if m.State == ONGOING {
+		if errorCode := c.fenceAndAbort(m); errorCode != utils.NONE {
+			return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, errorCode
+		}
+		c.mu.Unlock()
+		c.writeMarkers(transactionalId)
+		c.mu.Lock()
+	}
+	if m.State == PREPARE_COMMIT || m.State == PREPARE_ABORT {
+		return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.CONCURRENT_TRANSACTIONS
+	}
+
+	if errorCode := c.bumpEpoch(m); errorCode != utils.NONE {
+		return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, errorCode
+	}
+	m.TimeoutMs = timeoutMs
+	m.State = EMPTY
+	m.Partitions = map[TopicPartition]bool{}
+	m.StartTimestamp = -1
+	if err := c.persist(m); err != nil {
+		return record.NO_PRODUCER_ID, record.NO_PRODUCER_EPOCH, utils.COORDINATOR_NOT_AVAILABLE
+	}
+	return m.ProducerID, m.ProducerEpoch, utils.NONE
+}
+
+func (c *TransactionCoordinator) validateProducer(m *TransactionMetadata, producerId int64, producerEpoch int16) int16 {
+	if m == nil || m.ProducerID != producerId {
+		return utils.INVALID_PRODUCER_ID_MAPPING
+	}
+	if m.ProducerEpoch != producerEpoch {
+		return utils.PRODUCER_FENCED
+	}
+	return utils.NONE
+}
+
+func (c *TransactionCoordinator) AddPartitions(transactionalId string, producerId int64, producerEpoch int16, partitions []TopicPartition) map[TopicPartition]int16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := map[TopicPartition]int16{}
+	setAll := func(errorCode int16) map[TopicPartition]int16 {
+		for _, tp := range partitions {
+			results[tp] = errorCode
+		}
+		return results
+	}
+
+	m := c.transactions[transactionalId]
+	if errorCode := c.validateProducer(m, producerId, producerEpoch); errorCode != utils.NONE {
+		return setAll(errorCode)
+	}
+	if m.State == PREPARE_COMMIT || m.State == PREPARE_ABORT {
+		return setAll(utils.CONCURRENT_TRANSACTIONS)
+	}
+
+	unknown := false
+	for _, tp := range partitions {
+		if !partitionExists(tp) {
+			results[tp] = utils.UNKNOWN_TOPIC_OR_PARTITION
+			unknown = true
+		}
+	}
+	if unknown {
+		for _, tp := range partitions {
+			if _, ok := results[tp]; !ok {
+				results[tp] = utils.OPERATION_NOT_ATTEMPTED
+			}
+		}
+		return results
+	}
+
+	if errorCode := c.addPartitions(m, partitions); errorCode != utils.NONE {
+		return setAll(errorCode)
+	}
+	return setAll(utils.NONE)
+}
+
+func (c *TransactionCoordinator) addPartitions(m *TransactionMetadata, partitions []TopicPartition) int16 {
+	if m.State != ONGOING {
+		m.StartTimestamp = time.Now().UnixMilli()
+		m.Partitions = map[TopicPartition]bool{}
+	}
+	for _, tp := range partitions {
+		m.Partitions[tp] = true
+	}
+	m.State = ONGOING
+	if err := c.persist(m); err != nil {
+		return utils.COORDINATOR_NOT_AVAILABLE
+	}
+	return utils.NONE
+}
+
+// AddOffsets adds the offsets partition of the group to the transaction so
+// that offsets committed with TxnOffsetCommit become visible atomically with
+// the produced data.
+func (c *TransactionCoordinator) AddOffsets(transactionalId string, producerId int64, producerEpoch int16, groupId string) int16 {
+	if groupId == "" {
+		return utils.INVALID_GROUP_ID
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.transactions[transactionalId]
+	if errorCode := c.validateProducer(m, producerId, producerEpoch); errorCode != utils.NONE {
+		return errorCode
+	}
+	if m.State == PREPARE_COMMIT || m.State == PREPARE_ABORT {
+		return utils.CONCURRENT_TRANSACTIONS
+	}
+	return c.addPartitions(m, []TopicPartition{offsetsPartition})
+}
+
+func (c *TransactionCoordinator) EndTxn(transactionalId string, producerId int64, producerEpoch int16, commit bool) int16 {
+	errorCode := c.endTxn(transactionalId, producerId, producerEpoch, commit)
+	if errorCode == utils.NONE {
+		c.writeMarkers(transactionalId)
+	}
+	return errorCode
+}
+
+func (c *TransactionCoordinator) endTxn(transactionalId string, producerId int64, producerEpoch int16, commit bool) int16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.transactions[transactionalId]
+	if errorCode := c.validateProducer(m, producerId, producerEpoch); errorCode != utils.NONE {
+		return errorCode
+	}
+
+	switch m.State {
+	case ONGOING:
+		return c.prepareTransaction(m, commit)
+	case COMPLETE_COMMIT:
+		if commit {
+			return utils.NONE
+		}
+	case COMPLETE_ABORT:
+		if !commit {
+			return utils.NONE
+		}
+	case PREPARE_COMMIT, PREPARE_ABORT:
+		return utils.CONCURRENT_TRANSACTIONS
+	}
+	return utils.INVALID_TXN_STATE
+}
+
+// TxnOffsetCommit stages offsets for a group in the producer's transaction.
+// The group's offsets partition must have been added with AddOffsetsToTxn.
+func (c *TransactionCoordinator) TxnOffsetCommit(transactionalId string, groupId string, producerId int64, producerEpoch int16, offsets map[TopicPartition]OffsetAndMetadata) int16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.transactions[transactionalId]
+	if errorCode := c.validateProducer(m, producerId, producerEpoch); errorCode != utils.NONE {
+		return errorCode
+	}
+	if m.State != ONGOING || !m.Partitions[offsetsPartition] {
+		return utils.INVALID_TXN_STATE
+	}
+	if err := offsetStore.appendTransactional(producerId, producerEpoch, groupId, offsets); err != nil {
+		return utils.UNKNOWN_SERVER_ERROR
+	}
+	return utils.NONE
+}
+
+// prepareTransaction records the decision to commit or abort. The
+// transaction completes once writeMarkers has written a marker to each of its
+// partitions, so that a failure or restart in the middle finishes the job.
+func (c *TransactionCoordinator) prepareTransaction(m *TransactionMetadata, commit bool) int16 {
+	state := m.State
+	m.State = PREPARE_ABORT
+	if commit {
+		m.State = PREPARE_COMMIT
+	}
+	if err := c.persist(m); err != nil {
+		m.State = state
+		return utils.COORDINATOR_NOT_AVAILABLE
+	}
+	return utils.NONE
+}
+
+// writeMarkers writes the markers of a prepared transaction to the partitions
+// that have not taken theirs yet. Partitions leave the transaction as their
+// markers are written, and it completes once none are left. Those that fail
+// are retried by the next sweep for timed out transactions.
+func (c *TransactionCoordinator) writeMarkers(transactionalId string) {
+	c.mu.Lock()
+	m := c.transactions[transactionalId]
+	if m == nil || (m.State != PREPARE_COMMIT && m.State != PREPARE_ABORT) || c.markersInFlight[transactionalId] {
+		c.mu.Unlock()
+		return
+	}
+	c.markersInFlight[transactionalId] = true
+	commit := m.State == PREPARE_COMMIT
+	producerId, producerEpoch := m.ProducerID, m.ProducerEpoch
+	partitions := m.SortedPartitions()
+	c.mu.Unlock()
+
+	written := []TopicPartition{}
+	for _, tp := range partitions {
+		if err := WriteMarker(tp, producerId, producerEpoch, COORDINATOR_EPOCH, commit); err != nil {
+			log.Printf("Failed to write transaction marker to %s-%d: %s\n", tp.Topic, tp.Partition, err.Error())
+			continue
+		}
+		written = append(written, tp)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.markersInFlight, transactionalId)
+	if len(written) == 0 {
+		return
+	}
+	for _, tp := range written {
+		delete(m.Partitions, tp)
+	}
+	if len(m.Partitions) == 0 {
+		m.State = COMPLETE_ABORT
+		if commit {
+			m.State = COMPLETE_COMMIT
+		}
+	}
+	c.persist(m)
+}
+
+// fenceAndAbort bumps the epoch before aborting so that the current producer
+// can no longer write to the transaction.
+func (c *TransactionCoordinator) fenceAndAbort(m *TransactionMetadata) int16 {
+	if m.ProducerEpoch < math.MaxInt16-1 {
+		m.ProducerEpoch++
+	}
+	return c.prepareTransaction(m, false)
+}
+
+func (c *TransactionCoordinator) expireTransactions() {
+	ticker := time.NewTicker(AbortTimedOutTransactionsInterval)
+	for range ticker.C {
+		c.abortTimedOutTransactions(time.Now().UnixMilli())
+	}
+}
+
+// abortTimedOutTransactions aborts the transactions that exceed their
+// timeout, and writes the markers that prepared transactions still lack.
+func (c *TransactionCoordinator) abortTimedOutTransactions(now int64) {
+	prepared := []string{}
+	defer func() {
+		for _, transactionalId := range prepared {
+			c.writeMarkers(transactionalId)
+		}
+	}()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for transactionalId, m := range c.transactions {
+		switch m.State {
+		case ONGOING:
+			if m.StartTimestamp+int64(m.TimeoutMs) < now {
+				log.Printf("Aborting transaction %s after %d ms timeout\n", transactionalId, m.TimeoutMs)
+				if c.fenceAndAbort(m) == utils.NONE {
+					prepared = append(prepared, transactionalId)
+				}
+			}
+		case PREPARE_COMMIT, PREPARE_ABORT:
+			prepared = append(prepared, transactionalId)
+		case EMPTY, COMPLETE_COMMIT, COMPLETE_ABORT:
+			if m.LastUpdateTimestamp+TRANSACTIONAL_ID_EXPIRATION_MS < now {
+				if err := appendRecord(c.stateLog, encodeTransactionLogKey(transactionalId), nil, now); err == nil {
+					delete(c.transactions, transactionalId)
+				}
+			}
+		}
+	}
+}
+
+// WriteMarker ends a producer's transaction in one partition. Replicated
+// partitions only take markers on their leader.
+func WriteMarker(tp TopicPartition, producerId int64, producerEpoch int16, coordinatorEpoch int32, commit bool) error {
+	if err := appendMarker(tp, producerId, producerEpoch, coordinatorEpoch, commit); err != nil {
+		return err
+	}
+	if tp.Topic == CONSUMER_OFFSETS_TOPIC && offsetStore != nil {
+		offsetStore.completeTransaction(producerId, commit)
+	}
+	return nil
+}
+
+func appendMarker(tp TopicPartition, producerId int64, producerEpoch int16, coordinatorEpoch int32, commit bool) error {
+	leaderEpoch := int32(0)
+	if clusterTopic := metadata.GetClusterTopic(tp.Topic); clusterTopic.ErrorCode == utils.NONE {
+		if manager := replica.Manager(); manager != nil {
+			if p := manager.Partition(clusterTopic.TopicId, tp.Partition); p != nil {
+				return p.AppendControlAsLeader(producerId, producerEpoch, coordinatorEpoch, commit)
+			}
+		}
+		for _, partition := range clusterTopic.Partitions {
+			if partition.PartitionIndex == tp.Partition {
+				leaderEpoch = partition.LeaderEpoch
+			}
+		}
+	}
+	partitionLog, err := storage.GetLog(tp.Topic, tp.Partition)
+	if err != nil {
+		return err
+	}
+	return partitionLog.AppendControl(producerId, producerEpoch, coordinatorEpoch, commit, leaderEpoch)
+}
+
+func partitionExists(tp TopicPartition) bool {
+	clusterTopic := metadata.GetClusterTopic(tp.Topic)
+	if clusterTopic.ErrorCode != utils.NONE {
+		return false
+	}
+	for _, partition := range clusterTopic.Partitions {
+		if partition.PartitionIndex == tp.Partition {
+			return true
+		}
+	}
+	return false
+}
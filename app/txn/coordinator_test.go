@@ -0,0 +1,202 @@
+package txn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	metadata "github.com/codecrafters-io/kafka-starter-go/app/meta-data"
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+	"github.com/codecrafters-io/kafka-starter-go/app/utils"
+	"github.com/gofrs/uuid"
+)
+
+func setupCoordinator(t *testing.T) *TransactionCoordinator {
+	storage.LogDir = t.TempDir()
+	delta := metadata.NewMetadataDelta(metadata.EmptyImage())
+	topicId := uuid.Must(uuid.NewV4())
+	delta.Replay(&metadata.TopicRecord{Name: "foo", TopicID: topicId})
+	delta.Replay(&metadata.PartitionRecord{TopicID: topicId, Replicas: []int32{1}, Isr: []int32{1}, Leader: 1})
+	metadata.Publish(delta.Apply())
+	if err := LoadTransactionState(); err != nil {
+		t.Fatalf("Failed to load transaction state: %v", err)
+	}
+	return Coordinator()
+}
+
+func TestCommitTransaction(t *testing.T) {
+	c := setupCoordinator(t)
+	foo := TopicPartition{Topic: "foo", Partition: 0}
+
+	producerId, epoch, errorCode := c.InitProducerId("txn-1", 60000, -1, -1)
+	if errorCode != utils.NONE || epoch != 0 {
+		t.Fatalf("Unexpected InitProducerId result %d %d", epoch, errorCode)
+	}
+	results := c.AddPartitions("txn-1", producerId, epoch, []TopicPartition{foo, {Topic: "bar", Partition: 0}})
+	if results[foo] != utils.OPERATION_NOT_ATTEMPTED {
+		t.Errorf("Expected OPERATION_NOT_ATTEMPTED next to an unknown topic, got %d", results[foo])
+	}
+	if results := c.AddPartitions("txn-1", producerId, epoch, []TopicPartition{foo}); results[foo] != utils.NONE {
+		t.Fatalf("Failed to add partition: %d", results[foo])
+	}
+	if errorCode := c.AddOffsets("txn-1", producerId, epoch, "group"); errorCode != utils.NONE {
+		t.Fatalf("Failed to add offsets: %d", errorCode)
+	}
+	offsets := map[TopicPartition]OffsetAndMetadata{foo: {Offset: 42}}
+	if errorCode := c.TxnOffsetCommit("txn-1", "group", producerId, epoch, offsets); errorCode != utils.NONE {
+		t.Fatalf("Failed to commit offsets: %d", errorCode)
+	}
+	if _, ok := CommittedOffset("group", "foo", 0); ok {
+		t.Errorf("Offsets must not be visible before the transaction commits")
+	}
+
+	if errorCode := c.EndTxn("txn-1", producerId, epoch, true); errorCode != utils.NONE {
+		t.Fatalf("Failed to commit: %d", errorCode)
+	}
+	if offset, ok := CommittedOffset("group", "foo", 0); !ok || offset.Offset != 42 {
+		t.Errorf("Expected committed offset 42, got %v %v", offset, ok)
+	}
+
+	fooLog, _ := storage.GetLog("foo", 0)
+	data, _ := fooLog.Read(0, fooLog.LogEndOffset(), 1<<20)
+	batches, _ := record.DecodeBatches(data)
+	if len(batches) != 1 || !batches[0].IsControl() {
+		t.Fatalf("Expected a single control batch, got %v", batches)
+	}
+	if controlType, _, _ := record.DecodeEndTxnMarker(batches[0].Records[0]); controlType != record.CONTROL_COMMIT {
+		t.Errorf("Expected a commit marker")
+	}
+
+	// The state log and offsets survive a reload
+	storage.CloseAll()
+	if err := LoadTransactionState(); err != nil {
+		t.Fatalf("Failed to reload transaction state: %v", err)
+	}
+	if state := Coordinator().transactions["txn-1"].State; state != COMPLETE_COMMIT {
+		t.Errorf("Expected CompleteCommit after reload, got %s", state)
+	}
+	if offset, ok := CommittedOffset("group", "foo", 0); !ok || offset.Offset != 42 {
+		t.Errorf("Expected committed offset 42 after reload, got %v %v", offset, ok)
+	}
+}
+
+func TestAbortTimedOutTransaction(t *testing.T) {
+	c := setupCoordinator(t)
+	foo := TopicPartition{Topic: "foo", Partition: 0}
+
+	producerId, epoch, _ := c.InitProducerId("txn-2", 1000, -1, -1)
+	c.AddPartitions("txn-2", producerId, epoch, []TopicPartition{foo})
+	m := c.transactions["txn-2"]
+
+	c.abortTimedOutTransactions(m.StartTimestamp + 500)
+	if m.State != ONGOING {
+		t.Fatalf("Transaction aborted before its timeout")
+	}
+	c.abortTimedOutTransactions(m.StartTimestamp + 1001)
+	if m.State != COMPLETE_ABORT {
+		t.Fatalf("Expected CompleteAbort, got %s", m.State)
+	}
+	if errorCode := c.EndTxn("txn-2", producerId, epoch, true); errorCode != utils.PRODUCER_FENCED {
+		t.Errorf("Expected the timed out producer to be fenced, got %d", errorCode)
+	}
+}
+
+func TestRetryFailedMarkers(t *testing.T) {
+	c := setupCoordinator(t)
+	foo := TopicPartition{Topic: "foo", Partition: 0}
+
+	producerId, epoch, _ := c.InitProducerId("txn-3", 60000, -1, -1)
+	c.AddPartitions("txn-3", producerId, epoch, []TopicPartition{foo})
+	// A file in place of the partition directory fails the marker
+	blocker := filepath.Join(storage.LogDir, "foo-0")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if errorCode := c.EndTxn("txn-3", producerId, epoch, true); errorCode != utils.NONE {
+		t.Fatalf("Failed to commit: %d", errorCode)
+	}
+	m := c.transactions["txn-3"]
+	if m.State != PREPARE_COMMIT || !m.Partitions[foo] {
+		t.Fatalf("Expected PrepareCommit awaiting the marker of foo-0, got %s %v", m.State, m.Partitions)
+	}
+	if errorCode := c.EndTxn("txn-3", producerId, epoch, true); errorCode != utils.CONCURRENT_TRANSACTIONS {
+		t.Errorf("Expected CONCURRENT_TRANSACTIONS while markers are pending, got %d", errorCode)
+	}
+
+	os.Remove(blocker)
+	c.abortTimedOutTransactions(m.StartTimestamp)
+	if m.State != COMPLETE_COMMIT || len(m.Partitions) != 0 {
+		t.Fatalf("Expected CompleteCommit once the marker is written, got %s %v", m.State, m.Partitions)
+	}
+	fooLog, _ := storage.GetLog("foo", 0)
+	data, _ := fooLog.Read(0, fooLog.LogEndOffset(), 1<<20)
+	if batches, _ := record.DecodeBatches(data); len(batches) != 1 || !batches[0].IsControl() {
+		t.Fatalf("Expected the commit marker, got %v", batches)
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	c := setupCoordinator(t)
+	foo := TopicPartition{Topic: "foo", Partition: 0}
+
+	ongoingId, ongoingEpoch, _ := c.InitProducerId("ongoing", 60000, -1, -1)
+	c.AddPartitions("ongoing", ongoingId, ongoingEpoch, []TopicPartition{foo})
+	emptyId, _, _ := c.InitProducerId("empty", 60000, -1, -1)
+	start := c.transactions["ongoing"].StartTimestamp
+
+	listIds := func(transactions []TransactionMetadata) []string {
+		ids := []string{}
+		for _, m := range transactions {
+			ids = append(ids, m.TransactionalID)
+		}
+		return ids
+	}
+	tests := []struct {
+		states      []TransactionState
+		producerIds []int64
+		durationMs  int64
+		expected    []string
+	}{
+		{nil, nil, -1, []string{"empty", "ongoing"}},
+		{[]TransactionState{ONGOING}, nil, -1, []string{"ongoing"}},
+		{nil, []int64{emptyId}, -1, []string{"empty"}},
+		{[]TransactionState{ONGOING}, []int64{emptyId}, -1, []string{}},
+		{[]TransactionState{ONGOING}, nil, 5000, []string{}},
+	}
+	for _, test := range tests {
+		ids := listIds(c.ListTransactions(test.states, test.producerIds, test.durationMs, start+1000))
+		if len(ids) != len(test.expected) || (len(ids) > 0 && ids[0] != test.expected[0]) {
+			t.Errorf("Filters %v %v %d: expected %v, got %v", test.states, test.producerIds, test.durationMs, test.expected, ids)
+		}
+	}
+	if ids := listIds(c.ListTransactions([]TransactionState{ONGOING}, nil, 5000, start+6000)); len(ids) != 1 {
+		t.Errorf("Expected the hanging transaction past the duration filter, got %v", ids)
+	}
+
+	m, ok := c.DescribeTransaction("ongoing")
+	if !ok || m.State != ONGOING || len(m.SortedPartitions()) != 1 || m.SortedPartitions()[0] != foo {
+		t.Errorf("Unexpected description %+v %v", m, ok)
+	}
+	if _, ok := c.DescribeTransaction("missing"); ok {
+		t.Errorf("Expected an unknown transactional ID to be missing")
+	}
+}
+
+func TestLoadEmptyControlBatch(t *testing.T) {
+	setupCoordinator(t)
+	offsetsLog, err := storage.GetLog(CONSUMER_OFFSETS_TOPIC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := record.NewRecordBatch(nil)
+	batch.Attributes |= record.CONTROL_FLAG_MASK
+	batch.BaseOffset = offsetsLog.LogEndOffset()
+	raw, _ := batch.Encode()
+	if err := offsetsLog.AppendAsFollower(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadOffsetStore(); err != nil {
+		t.Fatalf("Expected an empty control batch to be skipped, got %v", err)
+	}
+}
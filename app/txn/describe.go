@@ -0,0 +1,76 @@
+package txn
+
+import (
+	"maps"
+	"slices"
+	"sort"
+)
+
+func ParseTransactionState(name string) (TransactionState, bool) {
+	for state := EMPTY; state <= PREPARE_EPOCH_FENCE; state++ {
+		if state.String() == name {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+func (m *TransactionMetadata) copy() TransactionMetadata {
+	copied := *m
+	copied.Partitions = maps.Clone(m.Partitions)
+	return copied
+}
+
+// SortedPartitions lists the partitions of the transaction ordered by topic
+// and partition.
+func (m *TransactionMetadata) SortedPartitions() []TopicPartition {
+	partitions := make([]TopicPartition, 0, len(m.Partitions))
+	for tp := range m.Partitions {
+		partitions = append(partitions, tp)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return partitions
+}
+
+func (c *TransactionCoordinator) DescribeTransaction(transactionalId string) (TransactionMetadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.transactions[transactionalId]
+	if !ok || m.State == DEAD {
+		return TransactionMetadata{}, false
+	}
+	return m.copy(), true
+}
+
+// ListTransactions returns the transactions matching every given filter. Empty
+// filters match everything, and a negative duration disables the check on
+// how long the transaction has been running.
+func (c *TransactionCoordinator) ListTransactions(states []TransactionState, producerIds []int64, durationMs int64, now int64) []TransactionMetadata {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	transactions := []TransactionMetadata{}
+	for _, m := range c.transactions {
+		if m.State == DEAD {
+			continue
+		}
+		if len(producerIds) > 0 && !slices.Contains(producerIds, m.ProducerID) {
+			continue
+		}
+		if len(states) > 0 && !slices.Contains(states, m.State) {
+			continue
+		}
+		if durationMs >= 0 && now-m.StartTimestamp <= durationMs {
+			continue
+		}
+		transactions = append(transactions, m.copy())
+	}
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].TransactionalID < transactions[j].TransactionalID })
+	return transactions
+}
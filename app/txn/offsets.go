@@ -0,0 +1,202 @@
+package txn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+)
+
+const CONSUMER_OFFSETS_TOPIC = "__consumer_offsets"
+const OFFSET_COMMIT_KEY_VERSION int16 = 1
+const OFFSET_COMMIT_VALUE_VERSION int16 = 3
+
+type GroupTopicPartition struct {
+	Group     string
+	Topic     string
+	Partition int32
+}
+
+type OffsetAndMetadata struct {
+	Offset          int64
+	LeaderEpoch     int32
+	Metadata        string
+	CommitTimestamp int64
+}
+
+// OffsetStore keeps the committed offsets of consumer groups. Offsets written
+// inside a transaction stay pending until the transaction's marker reaches
+// the offsets partition.
+type OffsetStore struct {
+	mu        sync.Mutex
+	log       *storage.Log
+	committed map[GroupTopicPartition]OffsetAndMetadata
+	pending   map[int64]map[GroupTopicPartition]OffsetAndMetadata
+}
+
+var offsetStore *OffsetStore
+
+// offsetsPartition holds the offsets of every group, as __consumer_offsets
+// has a single partition
+var offsetsPartition = TopicPartition{Topic: CONSUMER_OFFSETS_TOPIC, Partition: 0}
+
+func loadOffsetStore() error {
+	offsetsLog, err := storage.GetLog(offsetsPartition.Topic, offsetsPartition.Partition)
+	if err != nil {
+		return err
+	}
+	s := &OffsetStore{
+		log:       offsetsLog,
+		committed: map[GroupTopicPartition]OffsetAndMetadata{},
+		pending:   map[int64]map[GroupTopicPartition]OffsetAndMetadata{},
+	}
+
+	batches, err := readAll(offsetsLog)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		if batch.IsControl() {
+			if len(batch.Records) == 0 {
+				continue
+			}
+			controlType, _, err := record.DecodeEndTxnMarker(batch.Records[0])
+			if err == nil {
+				s.completeTransaction(batch.ProducerID, controlType == record.CONTROL_COMMIT)
+			}
+			continue
+		}
+		for _, rec := range batch.Records {
+			key, offset, err := decodeOffsetCommit(rec)
+			if err != nil {
+				continue
+			}
+			if batch.IsTransactional() {
+				s.stage(batch.ProducerID, key, offset)
+			} else {
+				s.committed[key] = offset
+			}
+		}
+	}
+
+	offsetStore = s
+	return nil
+}
+
+func CommittedOffset(group string, topic string, partition int32) (OffsetAndMetadata, bool) {
+	if offsetStore == nil {
+		return OffsetAndMetadata{}, false
+	}
+	offsetStore.mu.Lock()
+	defer offsetStore.mu.Unlock()
+	offset, ok := offsetStore.committed[GroupTopicPartition{Group: group, Topic: topic, Partition: partition}]
+	return offset, ok
+}
+
+func (s *OffsetStore) stage(producerId int64, key GroupTopicPartition, offset OffsetAndMetadata) {
+	if s.pending[producerId] == nil {
+		s.pending[producerId] = map[GroupTopicPartition]OffsetAndMetadata{}
+	}
+	s.pending[producerId][key] = offset
+}
+
+func (s *OffsetStore) appendTransactional(producerId int64, producerEpoch int16, groupId string, offsets map[TopicPartition]OffsetAndMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	records := []record.Record{}
+	for tp, offset := range offsets {
+		offset.CommitTimestamp = now
+		offsets[tp] = offset
+		key, value := encodeOffsetCommit(GroupTopicPartition{Group: groupId, Topic: tp.Topic, Partition: tp.Partition}, offset)
+		records = append(records, record.Record{Key: key, Value: value})
+	}
+	batch := record.NewRecordBatch(records)
+	batch.Attributes = record.TRANSACTIONAL_FLAG_MASK
+	batch.ProducerID = producerId
+	batch.ProducerEpoch = producerEpoch
+	batch.BaseTimestamp = now
+	batch.MaxTimestamp = now
+	raw, err := batch.Encode()
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.AppendAsLeader(raw, 0); err != nil {
+		return err
+	}
+
+	for tp, offset := range offsets {
+		s.stage(producerId, GroupTopicPartition{Group: groupId, Topic: tp.Topic, Partition: tp.Partition}, offset)
+	}
+	return nil
+}
+
+func (s *OffsetStore) completeTransaction(producerId int64, commit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if commit {
+		for key, offset := range s.pending[producerId] {
+			s.committed[key] = offset
+		}
+	}
+	delete(s.pending, producerId)
+}
+
+func encodeOffsetCommit(key GroupTopicPartition, offset OffsetAndMetadata) ([]byte, []byte) {
+	var keyData bytes.Buffer
+	binary.Write(&keyData, binary.BigEndian, OFFSET_COMMIT_KEY_VERSION)
+	writeString(&keyData, key.Group)
+	writeString(&keyData, key.Topic)
+	binary.Write(&keyData, binary.BigEndian, key.Partition)
+
+	var valueData bytes.Buffer
+	binary.Write(&valueData, binary.BigEndian, OFFSET_COMMIT_VALUE_VERSION)
+	binary.Write(&valueData, binary.BigEndian, offset.Offset)
+	binary.Write(&valueData, binary.BigEndian, offset.LeaderEpoch)
+	writeString(&valueData, offset.Metadata)
+	binary.Write(&valueData, binary.BigEndian, offset.CommitTimestamp)
+	return keyData.Bytes(), valueData.Bytes()
+}
+
+func decodeOffsetCommit(rec record.Record) (GroupTopicPartition, OffsetAndMetadata, error) {
+	key := GroupTopicPartition{}
+	offset := OffsetAndMetadata{}
+
+	keyData := bytes.NewBuffer(rec.Key)
+	var version int16
+	if err := binary.Read(keyData, binary.BigEndian, &version); err != nil || version != OFFSET_COMMIT_KEY_VERSION {
+		return key, offset, ErrCorruptTransactionLog
+	}
+	if err := readString(&key.Group, keyData); err != nil {
+		return key, offset, err
+	}
+	if err := readString(&key.Topic, keyData); err != nil {
+		return key, offset, err
+	}
+	if err := binary.Read(keyData, binary.BigEndian, &key.Partition); err != nil {
+		return key, offset, err
+	}
+
+	valueData := bytes.NewBuffer(rec.Value)
+	if err := binary.Read(valueData, binary.BigEndian, &version); err != nil {
+		return key, offset, err
+	}
+	if err := binary.Read(valueData, binary.BigEndian, &offset.Offset); err != nil {
+		return key, offset, err
+	}
+	if err := binary.Read(valueData, binary.BigEndian, &offset.LeaderEpoch); err != nil {
+		return key, offset, err
+	}
+	if err := readString(&offset.Metadata, valueData); err != nil {
+		return key, offset, err
+	}
+	if err := binary.Read(valueData, binary.BigEndian, &offset.CommitTimestamp); err != nil {
+		return key, offset, err
+	}
+	return key, offset, nil
+}
@@ -0,0 +1,158 @@
+package txn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/record"
+	"github.com/codecrafters-io/kafka-starter-go/app/storage"
+)
+
+const TRANSACTION_LOG_KEY_VERSION int16 = 0
+const TRANSACTION_LOG_VALUE_VERSION int16 = 0
+
+var ErrCorruptTransactionLog = errors.New("corrupt transaction log record")
+
+func writeString(data *bytes.Buffer, value string) {
+	binary.Write(data, binary.BigEndian, int16(len(value)))
+	data.WriteString(value)
+}
+
+func readString(dataField *string, data *bytes.Buffer) error {
+	var length int16
+	if err := binary.Read(data, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if length < 0 || int(length) > data.Len() {
+		return ErrCorruptTransactionLog
+	}
+	*dataField = string(data.Next(int(length)))
+	return nil
+}
+
+func encodeTransactionLogKey(transactionalId string) []byte {
+	var key bytes.Buffer
+	binary.Write(&key, binary.BigEndian, TRANSACTION_LOG_KEY_VERSION)
+	writeString(&key, transactionalId)
+	return key.Bytes()
+}
+
+// encodeTransactionLogValue writes version 0 of the TransactionLogValue
+// schema, grouping the partitions by topic.
+func encodeTransactionLogValue(m *TransactionMetadata) []byte {
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, TRANSACTION_LOG_VALUE_VERSION)
+	binary.Write(&value, binary.BigEndian, m.ProducerID)
+	binary.Write(&value, binary.BigEndian, m.ProducerEpoch)
+	binary.Write(&value, binary.BigEndian, m.TimeoutMs)
+	binary.Write(&value, binary.BigEndian, int8(m.State))
+
+	topics := map[string][]int32{}
+	for tp := range m.Partitions {
+		topics[tp.Topic] = append(topics[tp.Topic], tp.Partition)
+	}
+	topicNames := make([]string, 0, len(topics))
+	for topic := range topics {
+		topicNames = append(topicNames, topic)
+	}
+	sort.Strings(topicNames)
+
+	binary.Write(&value, binary.BigEndian, int32(len(topicNames)))
+	for _, topic := range topicNames {
+		writeString(&value, topic)
+		partitions := topics[topic]
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		binary.Write(&value, binary.BigEndian, int32(len(partitions)))
+		for _, partition := range partitions {
+			binary.Write(&value, binary.BigEndian, partition)
+		}
+	}
+
+	binary.Write(&value, binary.BigEndian, m.LastUpdateTimestamp)
+	binary.Write(&value, binary.BigEndian, m.StartTimestamp)
+	return value.Bytes()
+}
+
+func decodeTransactionLogKey(key []byte) (string, error) {
+	data := bytes.NewBuffer(key)
+	var version int16
+	if err := binary.Read(data, binary.BigEndian, &version); err != nil {
+		return "", ErrCorruptTransactionLog
+	}
+	var transactionalId string
+	if err := readString(&transactionalId, data); err != nil {
+		return "", ErrCorruptTransactionLog
+	}
+	return transactionalId, nil
+}
+
+func decodeTransactionLogValue(transactionalId string, value []byte) (*TransactionMetadata, error) {
+	data := bytes.NewBuffer(value)
+	m := newTransactionMetadata(transactionalId)
+
+	var version int16
+	var state int8
+	fields := []any{&version, &m.ProducerID, &m.ProducerEpoch, &m.TimeoutMs, &state}
+	for _, field := range fields {
+		if err := binary.Read(data, binary.BigEndian, field); err != nil {
+			return nil, ErrCorruptTransactionLog
+		}
+	}
+	m.State = TransactionState(state)
+
+	var topicCount int32
+	if err := binary.Read(data, binary.BigEndian, &topicCount); err != nil {
+		return nil, ErrCorruptTransactionLog
+	}
+	for range max(topicCount, 0) {
+		var topic string
+		var partitionCount int32
+		if err := readString(&topic, data); err != nil {
+			return nil, ErrCorruptTransactionLog
+		}
+		if err := binary.Read(data, binary.BigEndian, &partitionCount); err != nil {
+			return nil, ErrCorruptTransactionLog
+		}
+		for range max(partitionCount, 0) {
+			var partition int32
+			if err := binary.Read(data, binary.BigEndian, &partition); err != nil {
+				return nil, ErrCorruptTransactionLog
+			}
+			m.Partitions[TopicPartition{Topic: topic, Partition: partition}] = true
+		}
+	}
+
+	if err := binary.Read(data, binary.BigEndian, &m.LastUpdateTimestamp); err != nil {
+		return nil, ErrCorruptTransactionLog
+	}
+	if err := binary.Read(data, binary.BigEndian, &m.StartTimestamp); err != nil {
+		return nil, ErrCorruptTransactionLog
+	}
+	return m, nil
+}
+
+// appendRecord writes a single key/value to an internal log. A nil value is a
+// tombstone.
+func appendRecord(l *storage.Log, key []byte, value []byte, timestamp int64) error {
+	batch := record.NewRecordBatch([]record.Record{{Key: key, Value: value}})
+	batch.BaseTimestamp = timestamp
+	batch.MaxTimestamp = timestamp
+	raw, err := batch.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = l.AppendAsLeader(raw, 0)
+	return err
+}
+
+// readAll decodes every batch of an internal log from its start.
+func readAll(l *storage.Log) ([]*record.RecordBatch, error) {
+	data, err := l.Read(l.LogStartOffset(), l.LogEndOffset(), math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	return record.DecodeBatches(data)
+}
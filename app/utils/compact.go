@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/gofrs/uuid"
+)
+
+var ErrMalformedField = errors.New("malformed field")
+
+func ReadUVARINT(dataField *uint64, data *bytes.Buffer) error {
+	value, err := binary.ReadUvarint(data)
+	if err != nil {
+		return ErrMalformedField
+	}
+	*dataField = value
+	return nil
+}
+
+func ReadVARINT(dataField *int64, data *bytes.Buffer) error {
+	value, err := binary.ReadVarint(data)
+	if err != nil {
+		return ErrMalformedField
+	}
+	*dataField = value
+	return nil
+}
+
+// ReadCompactArrayLength stores -1 for a null array.
+func ReadCompactArrayLength(length *int, data *bytes.Buffer) error {
+	var value uint64
+	if err := ReadUVARINT(&value, data); err != nil {
+		return err
+	}
+	if value > uint64(data.Len())+1 {
+		return ErrMalformedField
+	}
+	*length = int(value) - 1
+	return nil
+}
+
+func ReadCompactBytes(dataField *[]byte, data *bytes.Buffer) error {
+	var length int
+	if err := ReadCompactArrayLength(&length, data); err != nil {
+		return err
+	}
+	if length < 0 {
+		*dataField = nil
+		return nil
+	}
+	value := make([]byte, length)
+	copy(value, data.Next(length))
+	*dataField = value
+	return nil
+}
+
+func ReadCompactString(dataField *string, data *bytes.Buffer) error {
+	var value []byte
+	if err := ReadCompactBytes(&value, data); err != nil {
+		return err
+	}
+	*dataField = string(value)
+	return nil
+}
+
+func ReadCompactNullableString(dataField **string, data *bytes.Buffer) error {
+	var value []byte
+	if err := ReadCompactBytes(&value, data); err != nil {
+		return err
+	}
+	if value == nil {
+		*dataField = nil
+		return nil
+	}
+	str := string(value)
+	*dataField = &str
+	return nil
+}
+
+func ReadUUID(dataField *uuid.UUID, data *bytes.Buffer) error {
+	id, err := uuid.FromBytes(data.Next(16))
+	if err != nil {
+		return ErrMalformedField
+	}
+	*dataField = id
+	return nil
+}
+
+func ReadINT32Array(dataField *[]int32, data *bytes.Buffer) error {
+	var length int
+	if err := ReadCompactArrayLength(&length, data); err != nil {
+		return err
+	}
+	values := make([]int32, 0, max(length, 0))
+	for range length {
+		var value int32
+		if err := binary.Read(data, binary.BigEndian, &value); err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	*dataField = values
+	return nil
+}
+
+func ReadINT64Array(dataField *[]int64, data *bytes.Buffer) error {
+	var length int
+	if err := ReadCompactArrayLength(&length, data); err != nil {
+		return err
+	}
+	values := make([]int64, 0, max(length, 0))
+	for range length {
+		var value int64
+		if err := binary.Read(data, binary.BigEndian, &value); err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	*dataField = values
+	return nil
+}
+
+func ReadCompactStringArray(dataField *[]string, data *bytes.Buffer) error {
+	var length int
+	if err := ReadCompactArrayLength(&length, data); err != nil {
+		return err
+	}
+	values := make([]string, 0, max(length, 0))
+	for range length {
+		var value string
+		if err := ReadCompactString(&value, data); err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	*dataField = values
+	return nil
+}
+
+func ReadUUIDArray(dataField *[]uuid.UUID, data *bytes.Buffer) error {
+	var length int
+	if err := ReadCompactArrayLength(&length, data); err != nil {
+		return err
+	}
+	values := make([]uuid.UUID, 0, max(length, 0))
+	for range length {
+		var value uuid.UUID
+		if err := ReadUUID(&value, data); err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	*dataField = values
+	return nil
+}
+
+// ReadTaggedFields returns the raw value of each tagged field by tag.
+func ReadTaggedFields(data *bytes.Buffer) (map[uint64][]byte, error) {
+	var count uint64
+	if err := ReadUVARINT(&count, data); err != nil {
+		return nil, err
+	}
+	fields := map[uint64][]byte{}
+	for range count {
+		var tag, size uint64
+		if err := ReadUVARINT(&tag, data); err != nil {
+			return nil, err
+		}
+		if err := ReadUVARINT(&size, data); err != nil {
+			return nil, err
+		}
+		if size > uint64(data.Len()) {
+			return nil, ErrMalformedField
+		}
+		fields[tag] = data.Next(int(size))
+	}
+	return fields, nil
+}
+
+func SkipTaggedFields(data *bytes.Buffer) error {
+	var count uint64
+	if err := ReadUVARINT(&count, data); err != nil {
+		return err
+	}
+	for range count {
+		var tag, size uint64
+		if err := ReadUVARINT(&tag, data); err != nil {
+			return err
+		}
+		if err := ReadUVARINT(&size, data); err != nil {
+			return err
+		}
+		if size > uint64(data.Len()) {
+			return ErrMalformedField
+		}
+		data.Next(int(size))
+	}
+	return nil
+}
+
+func WriteUVARINT(data *bytes.Buffer, value uint64) {
+	data.Write(binary.AppendUvarint(nil, value))
+}
+
+func WriteVARINT(data *bytes.Buffer, value int64) {
+	data.Write(binary.AppendVarint(nil, value))
+}
+
+// WriteCompactArrayLength writes a null array for a negative length.
+func WriteCompactArrayLength(data *bytes.Buffer, length int) {
+	WriteUVARINT(data, uint64(length+1))
+}
+
+func WriteCompactBytes(data *bytes.Buffer, value []byte) {
+	if value == nil {
+		WriteUVARINT(data, 0)
+		return
+	}
+	WriteUVARINT(data, uint64(len(value)+1))
+	data.Write(value)
+}
+
+func WriteCompactString(data *bytes.Buffer, value string) {
+	WriteUVARINT(data, uint64(len(value)+1))
+	data.WriteString(value)
+}
+
+func WriteCompactNullableString(data *bytes.Buffer, value *string) {
+	if value == nil {
+		WriteUVARINT(data, 0)
+		return
+	}
+	WriteCompactString(data, *value)
+}
+
+func WriteINT32Array(data *bytes.Buffer, values []int32) {
+	WriteCompactArrayLength(data, len(values))
+	for _, value := range values {
+		binary.Write(data, binary.BigEndian, value)
+	}
+}
+
+func WriteUUIDArray(data *bytes.Buffer, values []uuid.UUID) {
+	WriteCompactArrayLength(data, len(values))
+	for _, value := range values {
+		data.Write(value.Bytes())
+	}
+}
+
+func WriteCompactStringArray(data *bytes.Buffer, values []string) {
+	WriteCompactArrayLength(data, len(values))
+	for _, value := range values {
+		WriteCompactString(data, value)
+	}
+}
+
+// WriteTaggedFieldValues writes already encoded tagged fields in tag order.
+func WriteTaggedFieldValues(data *bytes.Buffer, fields map[uint64][]byte) {
+	tags := make([]uint64, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	WriteUVARINT(data, uint64(len(tags)))
+	for _, tag := range tags {
+		WriteUVARINT(data, tag)
+		WriteUVARINT(data, uint64(len(fields[tag])))
+		data.Write(fields[tag])
+	}
+}
+
+func WriteTaggedFields(data *bytes.Buffer) {
+	WriteUVARINT(data, 0)
+}
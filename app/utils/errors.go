@@ -0,0 +1,60 @@
+package utils
+
+const UNKNOWN_SERVER_ERROR int16 = -1
+const NONE int16 = 0
+const OFFSET_OUT_OF_RANGE int16 = 1
+const CORRUPT_MESSAGE int16 = 2
+const UNKNOWN_TOPIC_OR_PARTITION int16 = 3
+const NOT_LEADER_OR_FOLLOWER int16 = 6
+const REQUEST_TIMED_OUT int16 = 7
+const COORDINATOR_LOAD_IN_PROGRESS int16 = 14
+const COORDINATOR_NOT_AVAILABLE int16 = 15
+const NOT_COORDINATOR int16 = 16
+const NOT_ENOUGH_REPLICAS int16 = 19
+const NOT_ENOUGH_REPLICAS_AFTER_APPEND int16 = 20
+const INVALID_GROUP_ID int16 = 24
+const TOPIC_AUTHORIZATION_FAILED int16 = 29
+const GROUP_AUTHORIZATION_FAILED int16 = 30
+const CLUSTER_AUTHORIZATION_FAILED int16 = 31
+const UNSUPPORTED_SASL_MECHANISM int16 = 33
+const ILLEGAL_SASL_STATE int16 = 34
+const UNSUPPORTED_VERSION int16 = 35
+const INVALID_REPLICA_ASSIGNMENT int16 = 39
+const NOT_CONTROLLER int16 = 41
+const INVALID_REQUEST int16 = 42
+const UNSUPPORTED_FOR_MESSAGE_FORMAT int16 = 43
+const OUT_OF_ORDER_SEQUENCE_NUMBER int16 = 45
+const DUPLICATE_SEQUENCE_NUMBER int16 = 46
+const INVALID_PRODUCER_EPOCH int16 = 47
+const INVALID_TXN_STATE int16 = 48
+const INVALID_PRODUCER_ID_MAPPING int16 = 49
+const INVALID_TRANSACTION_TIMEOUT int16 = 50
+const CONCURRENT_TRANSACTIONS int16 = 51
+const TRANSACTIONAL_ID_AUTHORIZATION_FAILED int16 = 53
+const SECURITY_DISABLED int16 = 54
+const OPERATION_NOT_ATTEMPTED int16 = 55
+const SASL_AUTHENTICATION_FAILED int16 = 58
+const LISTENER_NOT_FOUND int16 = 72
+const FENCED_LEADER_EPOCH int16 = 74
+const UNKNOWN_LEADER_EPOCH int16 = 75
+const STALE_BROKER_EPOCH int16 = 77
+const PREFERRED_LEADER_NOT_AVAILABLE int16 = 80
+const ELIGIBLE_LEADERS_NOT_AVAILABLE int16 = 83
+const ELECTION_NOT_NEEDED int16 = 84
+const NO_REASSIGNMENT_IN_PROGRESS int16 = 85
+const PRODUCER_FENCED int16 = 90
+const RESOURCE_NOT_FOUND int16 = 91
+const DUPLICATE_RESOURCE int16 = 92
+const UNACCEPTABLE_CREDENTIAL int16 = 93
+const INCONSISTENT_VOTER_SET int16 = 94
+const INVALID_UPDATE_VERSION int16 = 95
+const SNAPSHOT_NOT_FOUND int16 = 98
+const POSITION_OUT_OF_RANGE int16 = 99
+const UNKNOWN_TOPIC_ID int16 = 100
+const DUPLICATE_BROKER_REGISTRATION int16 = 101
+const BROKER_ID_NOT_REGISTERED int16 = 102
+const INCONSISTENT_CLUSTER_ID int16 = 104
+const TRANSACTIONAL_ID_NOT_FOUND int16 = 105
+const INELIGIBLE_REPLICA int16 = 107
+const MISMATCHED_ENDPOINT_TYPE int16 = 114
+const UNSUPPORTED_ENDPOINT_TYPE int16 = 115
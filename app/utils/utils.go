@@ -8,6 +8,163 @@ import (
 const DESCRIBE_TOPIC_PARTITIONS = 0
 const API_VERSION = 4
 const FETCH = 16
+const PRODUCE = 9
+const INIT_PRODUCER_ID = 4
+const FIND_COORDINATOR = 4
+const ADD_PARTITIONS_TO_TXN = 3
+const ADD_OFFSETS_TO_TXN = 3
+const END_TXN = 3
+const WRITE_TXN_MARKERS = 1
+const TXN_OFFSET_COMMIT = 3
+const DESCRIBE_PRODUCERS = 0
+const DESCRIBE_TRANSACTIONS = 0
+const LIST_TRANSACTIONS = 1
+const VOTE = 0
+const BEGIN_QUORUM_EPOCH = 0
+const END_QUORUM_EPOCH = 0
+const DESCRIBE_QUORUM = 1
+const FETCH_SNAPSHOT = 0
+const METADATA = 12
+const BROKER_REGISTRATION = 3
+const BROKER_HEARTBEAT = 1
+const ALTER_PARTITION = 3
+const OFFSET_FOR_LEADER_EPOCH = 4
+const ELECT_LEADERS = 2
+const ALTER_PARTITION_REASSIGNMENTS = 0
+const LIST_PARTITION_REASSIGNMENTS = 0
+const DESCRIBE_CLUSTER = 1
+const DESCRIBE_ACLS = 3
+const CREATE_ACLS = 3
+const DELETE_ACLS = 3
+const SASL_HANDSHAKE = 1
+const SASL_AUTHENTICATE = 2
+const DESCRIBE_USER_SCRAM_CREDENTIALS = 0
+const ALTER_USER_SCRAM_CREDENTIALS = 0
+const DESCRIBE_CLIENT_QUOTAS = 1
+const ALTER_CLIENT_QUOTAS = 1
+
+const PRODUCE_KEY = 0
+const FETCH_KEY = 1
+const METADATA_KEY = 3
+const FIND_COORDINATOR_KEY = 10
+const SASL_HANDSHAKE_KEY = 17
+const API_VERSIONS_KEY = 18
+const INIT_PRODUCER_ID_KEY = 22
+const OFFSET_FOR_LEADER_EPOCH_KEY = 23
+const ADD_PARTITIONS_TO_TXN_KEY = 24
+const ADD_OFFSETS_TO_TXN_KEY = 25
+const END_TXN_KEY = 26
+const WRITE_TXN_MARKERS_KEY = 27
+const TXN_OFFSET_COMMIT_KEY = 28
+const DESCRIBE_ACLS_KEY = 29
+const CREATE_ACLS_KEY = 30
+const DELETE_ACLS_KEY = 31
+const SASL_AUTHENTICATE_KEY = 36
+const ELECT_LEADERS_KEY = 43
+const ALTER_PARTITION_REASSIGNMENTS_KEY = 45
+const LIST_PARTITION_REASSIGNMENTS_KEY = 46
+const DESCRIBE_CLIENT_QUOTAS_KEY = 48
+const ALTER_CLIENT_QUOTAS_KEY = 49
+const DESCRIBE_USER_SCRAM_CREDENTIALS_KEY = 50
+const ALTER_USER_SCRAM_CREDENTIALS_KEY = 51
+const VOTE_KEY = 52
+const BEGIN_QUORUM_EPOCH_KEY = 53
+const END_QUORUM_EPOCH_KEY = 54
+const DESCRIBE_QUORUM_KEY = 55
+const ALTER_PARTITION_KEY = 56
+const FETCH_SNAPSHOT_KEY = 59
+const DESCRIBE_CLUSTER_KEY = 60
+const DESCRIBE_PRODUCERS_KEY = 61
+const BROKER_REGISTRATION_KEY = 62
+const BROKER_HEARTBEAT_KEY = 63
+const DESCRIBE_TRANSACTIONS_KEY = 65
+const LIST_TRANSACTIONS_KEY = 66
+const DESCRIBE_TOPIC_PARTITIONS_KEY = 75
+
+var BrokerID int32 = 1
+var ClusterID = ""
+
+// AdvertisedListener is where clients that connect on the listener Name
+// reach this broker
+type AdvertisedListener struct {
+	Name string
+	Host string
+	Port int32
+}
+
+var AdvertisedListeners = []AdvertisedListener{{Name: "PLAINTEXT", Host: "localhost", Port: 9092}}
+
+// AdvertisedEndpoint is this broker's endpoint for a listener, or for its
+// first listener when none is named.
+func AdvertisedEndpoint(listenerName string) (AdvertisedListener, bool) {
+	for _, listener := range AdvertisedListeners {
+		if listener.Name == listenerName || listenerName == "" {
+			return listener, true
+		}
+	}
+	return AdvertisedListener{}, false
+}
+
+type ApiVersionRange struct {
+	Min uint16
+	Max uint16
+}
+
+var SUPPORTED_API_VERSIONS = map[uint16]ApiVersionRange{
+	PRODUCE_KEY:                         {Min: PRODUCE, Max: PRODUCE},
+	FETCH_KEY:                           {Min: 13, Max: 17},
+	METADATA_KEY:                        {Min: 9, Max: METADATA},
+	FIND_COORDINATOR_KEY:                {Min: FIND_COORDINATOR, Max: FIND_COORDINATOR},
+	SASL_HANDSHAKE_KEY:                  {Min: SASL_HANDSHAKE, Max: SASL_HANDSHAKE},
+	API_VERSIONS_KEY:                    {Min: 0, Max: API_VERSION},
+	INIT_PRODUCER_ID_KEY:                {Min: INIT_PRODUCER_ID, Max: INIT_PRODUCER_ID},
+	OFFSET_FOR_LEADER_EPOCH_KEY:         {Min: OFFSET_FOR_LEADER_EPOCH, Max: OFFSET_FOR_LEADER_EPOCH},
+	ADD_PARTITIONS_TO_TXN_KEY:           {Min: ADD_PARTITIONS_TO_TXN, Max: ADD_PARTITIONS_TO_TXN},
+	ADD_OFFSETS_TO_TXN_KEY:              {Min: ADD_OFFSETS_TO_TXN, Max: ADD_OFFSETS_TO_TXN},
+	END_TXN_KEY:                         {Min: END_TXN, Max: END_TXN},
+	WRITE_TXN_MARKERS_KEY:               {Min: WRITE_TXN_MARKERS, Max: WRITE_TXN_MARKERS},
+	TXN_OFFSET_COMMIT_KEY:               {Min: TXN_OFFSET_COMMIT, Max: TXN_OFFSET_COMMIT},
+	DESCRIBE_ACLS_KEY:                   {Min: 2, Max: DESCRIBE_ACLS},
+	CREATE_ACLS_KEY:                     {Min: 2, Max: CREATE_ACLS},
+	DELETE_ACLS_KEY:                     {Min: 2, Max: DELETE_ACLS},
+	SASL_AUTHENTICATE_KEY:               {Min: 0, Max: SASL_AUTHENTICATE},
+	ELECT_LEADERS_KEY:                   {Min: ELECT_LEADERS, Max: ELECT_LEADERS},
+	ALTER_PARTITION_REASSIGNMENTS_KEY:   {Min: ALTER_PARTITION_REASSIGNMENTS, Max: ALTER_PARTITION_REASSIGNMENTS},
+	LIST_PARTITION_REASSIGNMENTS_KEY:    {Min: LIST_PARTITION_REASSIGNMENTS, Max: LIST_PARTITION_REASSIGNMENTS},
+	DESCRIBE_CLIENT_QUOTAS_KEY:          {Min: DESCRIBE_CLIENT_QUOTAS, Max: DESCRIBE_CLIENT_QUOTAS},
+	ALTER_CLIENT_QUOTAS_KEY:             {Min: ALTER_CLIENT_QUOTAS, Max: ALTER_CLIENT_QUOTAS},
+	DESCRIBE_USER_SCRAM_CREDENTIALS_KEY: {Min: DESCRIBE_USER_SCRAM_CREDENTIALS, Max: DESCRIBE_USER_SCRAM_CREDENTIALS},
+	ALTER_USER_SCRAM_CREDENTIALS_KEY:    {Min: ALTER_USER_SCRAM_CREDENTIALS, Max: ALTER_USER_SCRAM_CREDENTIALS},
+	VOTE_KEY:                            {Min: VOTE, Max: VOTE},
+	BEGIN_QUORUM_EPOCH_KEY:              {Min: BEGIN_QUORUM_EPOCH, Max: BEGIN_QUORUM_EPOCH},
+	END_QUORUM_EPOCH_KEY:                {Min: END_QUORUM_EPOCH, Max: END_QUORUM_EPOCH},
+	DESCRIBE_QUORUM_KEY:                 {Min: 0, Max: DESCRIBE_QUORUM},
+	ALTER_PARTITION_KEY:                 {Min: 2, Max: ALTER_PARTITION},
+	FETCH_SNAPSHOT_KEY:                  {Min: FETCH_SNAPSHOT, Max: FETCH_SNAPSHOT},
+	DESCRIBE_CLUSTER_KEY:                {Min: 0, Max: DESCRIBE_CLUSTER},
+	DESCRIBE_PRODUCERS_KEY:              {Min: DESCRIBE_PRODUCERS, Max: DESCRIBE_PRODUCERS},
+	BROKER_REGISTRATION_KEY:             {Min: 0, Max: BROKER_REGISTRATION},
+	BROKER_HEARTBEAT_KEY:                {Min: 0, Max: BROKER_HEARTBEAT},
+	DESCRIBE_TRANSACTIONS_KEY:           {Min: DESCRIBE_TRANSACTIONS, Max: DESCRIBE_TRANSACTIONS},
+	LIST_TRANSACTIONS_KEY:               {Min: 0, Max: LIST_TRANSACTIONS},
+	DESCRIBE_TOPIC_PARTITIONS_KEY:       {Min: DESCRIBE_TOPIC_PARTITIONS, Max: DESCRIBE_TOPIC_PARTITIONS},
+}
+
+// FIRST_FLEXIBLE_VERSIONS lists the APIs whose older versions use the
+// non-flexible headers and encodings. Every other supported version is
+// flexible.
+var FIRST_FLEXIBLE_VERSIONS = map[uint16]uint16{
+	API_VERSIONS_KEY:       3,
+	SASL_HANDSHAKE_KEY:     2,
+	SASL_AUTHENTICATE_KEY:  2,
+	BEGIN_QUORUM_EPOCH_KEY: 1,
+	END_QUORUM_EPOCH_KEY:   1,
+}
+
+func IsFlexible(apiKey uint16, apiVersion uint16) bool {
+	firstFlexible, ok := FIRST_FLEXIBLE_VERSIONS[apiKey]
+	return !ok || apiVersion >= firstFlexible
+}
 
 func ReadUINT8(dataField *uint8, data *bytes.Buffer) error {
 	if err := binary.Read(data, binary.BigEndian, dataField); err != nil {
@@ -37,3 +194,58 @@ func ReadUINT64(dataField *uint64, data *bytes.Buffer) error {
 	}
 	return nil
 }
+
+func ReadString(dataField *string, data *bytes.Buffer) error {
+	var value *string
+	if err := ReadNullableString(&value, data); err != nil {
+		return err
+	}
+	if value != nil {
+		*dataField = *value
+	}
+	return nil
+}
+
+// ReadNullableString reads an INT16 length prefixed string, where a length
+// of -1 is null.
+func ReadNullableString(dataField **string, data *bytes.Buffer) error {
+	var length int16
+	if err := binary.Read(data, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if length < 0 {
+		*dataField = nil
+		return nil
+	}
+	if int(length) > data.Len() {
+		return ErrMalformedField
+	}
+	value := string(data.Next(int(length)))
+	*dataField = &value
+	return nil
+}
+
+func ReadArrayLength(length *int, data *bytes.Buffer) error {
+	var value int32
+	if err := binary.Read(data, binary.BigEndian, &value); err != nil {
+		return err
+	}
+	if int(value) > data.Len() {
+		return ErrMalformedField
+	}
+	*length = max(int(value), 0)
+	return nil
+}
+
+func WriteString(data *bytes.Buffer, value string) {
+	binary.Write(data, binary.BigEndian, int16(len(value)))
+	data.WriteString(value)
+}
+
+func WriteNullableString(data *bytes.Buffer, value *string) {
+	if value == nil {
+		binary.Write(data, binary.BigEndian, int16(-1))
+		return
+	}
+	WriteString(data, *value)
+}
@@ -10,4 +10,9 @@ module github.com/codecrafters-io/kafka-starter-go
 
 go 1.22
 
-require github.com/gofrs/uuid v4.4.0+incompatible
+require (
+	github.com/gofrs/uuid v4.4.0+incompatible
+	github.com/golang/snappy v1.0.0
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4/v4 v4.1.33
+)